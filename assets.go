@@ -0,0 +1,54 @@
+package rbxfile
+
+// RemapAssets calls fn with the URL of each non-empty Content value within the
+// tree, including the Content components of Font values, and Content values
+// within Optional values. If fn returns true, then the URL is replaced with
+// the returned URL. Returns the number of URLs replaced.
+//
+// Values are visited in the same order as WalkValues. Within a Font value,
+// Family is visited before CachedFaceId. A wrapped value, such as
+// rbxl.RawValue, is remapped as the value it wraps. If a URL within it is
+// replaced, then the property is set to the unwrapped value.
+func RemapAssets(root *Root, fn func(old string) (new string, changed bool)) int {
+	n := 0
+	remap := func(c ValueContent) ValueContent {
+		if len(c) == 0 {
+			return c
+		}
+		url, changed := fn(string(c))
+		if !changed {
+			return c
+		}
+		n++
+		return ValueContent(url)
+	}
+	WalkValues(root, func(inst *Instance, prop string, v Value) (Value, bool) {
+		w, ok := v.(interface{ Unwrap() Value })
+		if !ok {
+			return remapValue(v, remap), true
+		}
+		before := n
+		if u := remapValue(w.Unwrap(), remap); n != before {
+			return u, true
+		}
+		return v, true
+	})
+	return n
+}
+
+// remapValue returns v with each Content component passed through remap.
+func remapValue(v Value, remap func(ValueContent) ValueContent) Value {
+	switch v := v.(type) {
+	case ValueContent:
+		return remap(v)
+	case ValueFont:
+		v.Family = remap(v.Family)
+		v.CachedFaceId = remap(v.CachedFaceId)
+		return v
+	case ValueOptional:
+		if c, ok := v.Value().(ValueContent); ok {
+			return Some(remap(c))
+		}
+	}
+	return v
+}
@@ -0,0 +1,82 @@
+package rbxfile
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRemapAssets(t *testing.T) {
+	decal := NewInstance("Decal")
+	decal.Properties["Texture"] = ValueContent("rbxassetid://1")
+	decal.Properties["Name"] = ValueString("rbxassetid://1")
+	mesh := NewInstance("SpecialMesh")
+	mesh.Properties["MeshId"] = ValueContent("rbxassetid://2")
+	mesh.Properties["TextureId"] = ValueContent("")
+	mesh.Properties["Offset"] = None(TypeContent)
+	label := NewInstance("TextLabel")
+	label.Properties["FontFace"] = ValueFont{
+		Family:       ValueContent("rbxasset://fonts/families/Arial.json"),
+		CachedFaceId: ValueContent("rbxassetid://3"),
+	}
+	label.Properties["Image"] = Some(ValueContent("http://www.roblox.com/asset/?id=1"))
+	decal.Children = append(decal.Children, mesh, label)
+	root := &Root{Instances: []*Instance{decal}}
+
+	ids := map[string]string{"1": "10", "2": "20", "3": "30"}
+	var visited []string
+	n := RemapAssets(root, func(old string) (string, bool) {
+		visited = append(visited, old)
+		for _, prefix := range []string{"rbxassetid://", "http://www.roblox.com/asset/?id="} {
+			if id, ok := ids[strings.TrimPrefix(old, prefix)]; ok && strings.HasPrefix(old, prefix) {
+				return "rbxassetid://" + id, true
+			}
+		}
+		return old, false
+	})
+	if n != 4 {
+		t.Errorf("expected 4 changes, got %d", n)
+	}
+	if len(visited) != 5 {
+		t.Errorf("expected 5 URLs visited, got %q", visited)
+	}
+
+	for _, c := range []struct {
+		inst *Instance
+		prop string
+		want string
+	}{
+		{decal, "Texture", "rbxassetid://10"},
+		{decal, "Name", "rbxassetid://1"},
+		{mesh, "MeshId", "rbxassetid://20"},
+		{mesh, "TextureId", ""},
+		{mesh, "Offset", "nil"},
+		{label, "Image", "rbxassetid://10"},
+	} {
+		if got := c.inst.Properties[c.prop].String(); got != c.want {
+			t.Errorf("%s.%s: expected %q, got %q", c.inst.ClassName, c.prop, c.want, got)
+		}
+	}
+	font := label.Properties["FontFace"].(ValueFont)
+	if string(font.Family) != "rbxasset://fonts/families/Arial.json" || string(font.CachedFaceId) != "rbxassetid://30" {
+		t.Errorf("unexpected font %v", font)
+	}
+}
+
+func TestRemapAssetsSharedInstance(t *testing.T) {
+	// An instance that appears more than once is remapped only once.
+	decal := NewInstance("Decal")
+	decal.Properties["Texture"] = ValueContent("rbxassetid://1")
+	model := NewInstance("Model")
+	model.Children = []*Instance{decal, nil}
+	root := &Root{Instances: []*Instance{decal, model}}
+
+	n := RemapAssets(root, func(old string) (string, bool) {
+		return old + "0", true
+	})
+	if n != 1 {
+		t.Errorf("expected 1 change, got %d", n)
+	}
+	if got := decal.Properties["Texture"].String(); got != "rbxassetid://10" {
+		t.Errorf("expected rbxassetid://10, got %q", got)
+	}
+}
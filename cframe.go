@@ -0,0 +1,196 @@
+package rbxfile
+
+import (
+	"math"
+)
+
+func matrixFromID(i uint8) (m [9]float32) {
+	i--
+	// Ignore IDs that produce invalid matrices. 0, which is wrapped to 255,
+	// indicates a non-special CFrame, so it must also be ignored.
+	if i >= 35 || i/6%3 == i%3 {
+		return m
+	}
+	// Set directions of X and Y axes.
+	m[i/6%3*3] = 1 - float32(i/18*2)
+	m[i%6%3*3+1] = 1 - float32(i%6/3*2)
+	// Set Z axis to cross product of X and Y.
+	m[2] = m[3]*m[7] - m[4]*m[6]
+	m[5] = m[6]*m[1] - m[7]*m[0]
+	m[8] = m[0]*m[4] - m[1]*m[3]
+	return m
+}
+
+var _0 = float32(math.Copysign(0, -1))
+
+// cframeSpecialMatrix maps the ID of a special CFrame rotation to its rotation
+// matrix. There are 24 special rotations, being every combination of
+// axis-aligned X and Y directions, with IDs between 0x02 and 0x23.
+//
+// DIFF: Unspecified IDs produce either invalid matrices or garbage values, so
+// these are assumed to be undefined.
+var cframeSpecialMatrix = map[uint8][9]float32{
+	// i: m if m := matrixFromID(i); m != [9]float32{}
+	0x02: {+1, +0, +0, +0, +1, +0, +0, +0, +1},
+	0x03: {+1, +0, +0, +0, +0, -1, +0, +1, +0},
+	0x05: {+1, +0, +0, +0, -1, +0, +0, +0, -1},
+	0x06: {+1, +0, _0, +0, +0, +1, +0, -1, +0},
+	0x07: {+0, +1, +0, +1, +0, +0, +0, +0, -1},
+	0x09: {+0, +0, +1, +1, +0, +0, +0, +1, +0},
+	0x0A: {+0, -1, +0, +1, +0, _0, +0, +0, +1},
+	0x0C: {+0, +0, -1, +1, +0, +0, +0, -1, +0},
+	0x0D: {+0, +1, +0, +0, +0, +1, +1, +0, +0},
+	0x0E: {+0, +0, -1, +0, +1, +0, +1, +0, +0},
+	0x10: {+0, -1, +0, +0, +0, -1, +1, +0, +0},
+	0x11: {+0, +0, +1, +0, -1, +0, +1, +0, _0},
+	0x14: {-1, +0, +0, +0, +1, +0, +0, +0, -1},
+	0x15: {-1, +0, +0, +0, +0, +1, +0, +1, _0},
+	0x17: {-1, +0, +0, +0, -1, +0, +0, +0, +1},
+	0x18: {-1, +0, _0, +0, +0, -1, +0, -1, _0},
+	0x19: {+0, +1, _0, -1, +0, +0, +0, +0, +1},
+	0x1B: {+0, +0, -1, -1, +0, +0, +0, +1, +0},
+	0x1C: {+0, -1, _0, -1, +0, _0, +0, +0, -1},
+	0x1E: {+0, +0, +1, -1, +0, +0, +0, -1, +0},
+	0x1F: {+0, +1, +0, +0, +0, -1, -1, +0, +0},
+	0x20: {+0, +0, +1, +0, +1, _0, -1, +0, +0},
+	0x22: {+0, -1, +0, +0, +0, +1, -1, +0, +0},
+	0x23: {+0, +0, -1, +0, -1, _0, -1, +0, _0},
+}
+
+// cframeSpecialNumber is the inverse of cframeSpecialMatrix.
+var cframeSpecialNumber = map[[9]float32]uint8{
+	cframeSpecialMatrix[0x02]: 0x02,
+	cframeSpecialMatrix[0x03]: 0x03,
+	cframeSpecialMatrix[0x05]: 0x05,
+	cframeSpecialMatrix[0x06]: 0x06,
+	cframeSpecialMatrix[0x07]: 0x07,
+	cframeSpecialMatrix[0x09]: 0x09,
+	cframeSpecialMatrix[0x0A]: 0x0A,
+	cframeSpecialMatrix[0x0C]: 0x0C,
+	cframeSpecialMatrix[0x0D]: 0x0D,
+	cframeSpecialMatrix[0x0E]: 0x0E,
+	cframeSpecialMatrix[0x10]: 0x10,
+	cframeSpecialMatrix[0x11]: 0x11,
+	cframeSpecialMatrix[0x14]: 0x14,
+	cframeSpecialMatrix[0x15]: 0x15,
+	cframeSpecialMatrix[0x17]: 0x17,
+	cframeSpecialMatrix[0x18]: 0x18,
+	cframeSpecialMatrix[0x19]: 0x19,
+	cframeSpecialMatrix[0x1B]: 0x1B,
+	cframeSpecialMatrix[0x1C]: 0x1C,
+	cframeSpecialMatrix[0x1E]: 0x1E,
+	cframeSpecialMatrix[0x1F]: 0x1F,
+	cframeSpecialMatrix[0x20]: 0x20,
+	cframeSpecialMatrix[0x22]: 0x22,
+	cframeSpecialMatrix[0x23]: 0x23,
+}
+
+// CFrameSpecialMatrix returns the rotation matrix of the special CFrame
+// rotation identified by id. Special rotations are those whose axes are each
+// aligned to a world axis, and are used by the binary format to encode such
+// rotations in a single byte. Returns false if id does not identify a special
+// rotation.
+func CFrameSpecialMatrix(id uint8) (rot [9]float32, ok bool) {
+	rot, ok = cframeSpecialMatrix[id]
+	return rot, ok
+}
+
+// CFrameSpecialID returns the ID of the special CFrame rotation that matches
+// rot exactly. Returns false if rot is not a special rotation.
+func CFrameSpecialID(rot [9]float32) (id uint8, ok bool) {
+	id, ok = cframeSpecialNumber[rot]
+	return id, ok
+}
+
+// cframeEpsilon is the magnitude below which a computed rotation component is
+// treated as zero, so that axis-aligned rotations produce exact matrices.
+const cframeEpsilon = 1e-7
+
+type vec3 [3]float64
+
+func (a vec3) cross(b vec3) vec3 {
+	return vec3{
+		a[1]*b[2] - a[2]*b[1],
+		a[2]*b[0] - a[0]*b[2],
+		a[0]*b[1] - a[1]*b[0],
+	}
+}
+
+func (a vec3) unit() (u vec3, ok bool) {
+	m := math.Sqrt(a[0]*a[0] + a[1]*a[1] + a[2]*a[2])
+	if m < cframeEpsilon {
+		return a, false
+	}
+	return vec3{a[0] / m, a[1] / m, a[2] / m}, true
+}
+
+func toVec3(v ValueVector3) vec3 {
+	return vec3{float64(v.X), float64(v.Y), float64(v.Z)}
+}
+
+// rotationFromAxes returns a rotation matrix whose columns are x, y, and z.
+func rotationFromAxes(x, y, z vec3) (rot [9]float32) {
+	for i, v := range [9]float64{
+		x[0], y[0], z[0],
+		x[1], y[1], z[1],
+		x[2], y[2], z[2],
+	} {
+		if math.Abs(v) < cframeEpsilon {
+			v = 0
+		}
+		rot[i] = float32(v)
+	}
+	return rot
+}
+
+// CFrameLookAt returns a CFrame positioned at eye, and oriented so that its
+// look vector, the negated Z axis, points toward target. The X axis is
+// perpendicular to up, and the Y axis is perpendicular to the X and look
+// vectors, as with Roblox's CFrame.lookAt.
+//
+// If the look vector is parallel to up, then the X axis of the world, made
+// perpendicular to the look vector, is used as the X axis. If the look vector
+// is also parallel to the X axis of the world, then the Y axis of the world
+// crossed with the look direction is used instead. If eye and target are
+// equal, then the rotation is the identity.
+// Components that are nearly zero are rounded to zero, so that axis-aligned
+// inputs produce special rotations.
+func CFrameLookAt(eye, target, up ValueVector3) ValueCFrame {
+	cf := ValueCFrame{Position: eye, Rotation: [9]float32{1, 0, 0, 0, 1, 0, 0, 0, 1}}
+	e, t := toVec3(eye), toVec3(target)
+	z, ok := vec3{e[0] - t[0], e[1] - t[1], e[2] - t[2]}.unit()
+	if !ok {
+		return cf
+	}
+	x, ok := toVec3(up).cross(z).unit()
+	if !ok {
+		x, ok = vec3{1 - z[0]*z[0], -z[0] * z[1], -z[0] * z[2]}.unit()
+	}
+	if !ok {
+		x, _ = vec3{0, 1, 0}.cross(z).unit()
+	}
+	y := z.cross(x)
+	cf.Rotation = rotationFromAxes(x, y, z)
+	return cf
+}
+
+// CFrameFromAxisAngle returns a CFrame at the origin, rotated by angle radians
+// around axis. If axis has no length, then the rotation is the identity.
+// Components that are nearly zero are rounded to zero, so that rotations by
+// multiples of a right angle around a world axis produce special rotations.
+func CFrameFromAxisAngle(axis ValueVector3, angle float32) ValueCFrame {
+	cf := ValueCFrame{Rotation: [9]float32{1, 0, 0, 0, 1, 0, 0, 0, 1}}
+	a, ok := toVec3(axis).unit()
+	if !ok {
+		return cf
+	}
+	s, c := math.Sincos(float64(angle))
+	t := 1 - c
+	x, y, z := a[0], a[1], a[2]
+	cf.Rotation = rotationFromAxes(
+		vec3{t*x*x + c, t*x*y + s*z, t*x*z - s*y},
+		vec3{t*x*y - s*z, t*y*y + c, t*y*z + s*x},
+		vec3{t*x*z + s*y, t*y*z - s*x, t*z*z + c},
+	)
+	return cf
+}
@@ -0,0 +1,106 @@
+package rbxfile
+
+import (
+	"math"
+	"testing"
+)
+
+// checkOrthonormal reports an error if the columns of rot are not orthonormal
+// or do not form a right-handed basis.
+func checkOrthonormal(t *testing.T, name string, rot [9]float32) {
+	t.Helper()
+	col := func(i int) vec3 {
+		return vec3{float64(rot[i]), float64(rot[3+i]), float64(rot[6+i])}
+	}
+	dot := func(a, b vec3) float64 {
+		return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+	}
+	const tolerance = 1e-6
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			want := 0.0
+			if i == j {
+				want = 1
+			}
+			if d := dot(col(i), col(j)); math.Abs(d-want) > tolerance {
+				t.Errorf("%s: columns %d and %d have dot product %g, expected %g", name, i, j, d, want)
+			}
+		}
+	}
+	if d := dot(col(0).cross(col(1)), col(2)); math.Abs(d-1) > tolerance {
+		t.Errorf("%s: basis is not right-handed", name)
+	}
+}
+
+func TestCFrameLookAt(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		eye, target ValueVector3
+		up          ValueVector3
+		special     bool
+	}{
+		{name: "oblique", eye: ValueVector3{X: 1, Y: 2, Z: 3}, target: ValueVector3{X: -4, Y: 0.5, Z: 7}},
+		{name: "forward", eye: ValueVector3{}, target: ValueVector3{Z: -5}, special: true},
+		{name: "right", eye: ValueVector3{X: 1}, target: ValueVector3{X: 6}, special: true},
+		{name: "parallel", eye: ValueVector3{}, target: ValueVector3{Y: 10}, special: true},
+		{name: "equal", eye: ValueVector3{X: 2}, target: ValueVector3{X: 2}, special: true},
+		{name: "parallel oblique up", eye: ValueVector3{}, target: ValueVector3{X: 1, Y: 1}, up: ValueVector3{X: 2, Y: 2}},
+		{name: "parallel world X", eye: ValueVector3{}, target: ValueVector3{X: 3}, up: ValueVector3{X: 1}, special: true},
+		{name: "antiparallel world X", eye: ValueVector3{}, target: ValueVector3{X: -3}, up: ValueVector3{X: 1}, special: true},
+	} {
+		up := test.up
+		if up == (ValueVector3{}) {
+			up = ValueVector3{Y: 1}
+		}
+		cf := CFrameLookAt(test.eye, test.target, up)
+		checkOrthonormal(t, test.name, cf.Rotation)
+		if cf.Position != test.eye {
+			t.Errorf("%s: expected position %v, got %v", test.name, test.eye, cf.Position)
+		}
+		if _, ok := CFrameSpecialID(cf.Rotation); ok != test.special {
+			t.Errorf("%s: expected special rotation %t, got %t", test.name, test.special, ok)
+		}
+		if test.eye == test.target {
+			continue
+		}
+		// The look vector is the negated Z column.
+		look, _ := vec3{
+			float64(test.target.X - test.eye.X),
+			float64(test.target.Y - test.eye.Y),
+			float64(test.target.Z - test.eye.Z),
+		}.unit()
+		for i := 0; i < 3; i++ {
+			if d := -float64(cf.Rotation[3*i+2]) - look[i]; math.Abs(d) > 1e-6 {
+				t.Errorf("%s: look vector %d differs by %g", test.name, i, d)
+			}
+		}
+	}
+}
+
+func TestCFrameFromAxisAngle(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		axis    ValueVector3
+		angle   float32
+		special bool
+	}{
+		{name: "oblique", axis: ValueVector3{X: 1, Y: 1, Z: 0.5}, angle: 0.7},
+		{name: "quarter", axis: ValueVector3{Y: 1}, angle: math.Pi / 2, special: true},
+		{name: "half", axis: ValueVector3{Z: -2}, angle: math.Pi, special: true},
+		{name: "zero", axis: ValueVector3{}, angle: 1, special: true},
+	} {
+		cf := CFrameFromAxisAngle(test.axis, test.angle)
+		checkOrthonormal(t, test.name, cf.Rotation)
+		if _, ok := CFrameSpecialID(cf.Rotation); ok != test.special {
+			t.Errorf("%s: expected special rotation %t, got %t", test.name, test.special, ok)
+		}
+		// The axis is unchanged by the rotation.
+		a := toVec3(test.axis)
+		for i := 0; i < 3; i++ {
+			r := float64(cf.Rotation[3*i])*a[0] + float64(cf.Rotation[3*i+1])*a[1] + float64(cf.Rotation[3*i+2])*a[2]
+			if math.Abs(r-a[i]) > 1e-6 {
+				t.Errorf("%s: axis component %d rotated to %g", test.name, i, r)
+			}
+		}
+	}
+}
@@ -0,0 +1,243 @@
+// The rbxfile-gen command generates synthetic files that exercise edge cases of
+// the codecs.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/robloxapi/rbxfile"
+	"github.com/robloxapi/rbxfile/rbxl"
+	"github.com/robloxapi/rbxfile/rbxlx"
+)
+
+const usage = `usage: rbxfile-gen [-format FORMAT] [OUTPUT]
+
+Generates synthetic files that exercise edge cases of the codecs, writing each
+case to the OUTPUT directory. Each file is named after its case. The metadata
+of each file contains a "Case" entry with the name of the case, and a
+"Description" entry describing what the case exercises.
+
+OUTPUT is a path to a directory, which is created if it does not exist. If
+OUTPUT is unspecified, then the current directory is used. Warnings and errors
+are written to stderr. Exits with a non-zero status if any file could not be
+written.
+
+FORMAT is "rbxl", "rbxlx", or "all". Defaults to "all".
+`
+
+// genCase is a synthetic file.
+type genCase struct {
+	Name        string
+	Description string
+	Instances   func() []*rbxfile.Instance
+}
+
+func (c genCase) root() *rbxfile.Root {
+	return &rbxfile.Root{
+		Instances: c.Instances(),
+		Metadata: map[string]string{
+			"Case":        c.Name,
+			"Description": c.Description,
+		},
+	}
+}
+
+// newInstance returns an instance of the given class with the given
+// properties.
+func newInstance(class string, props map[string]rbxfile.Value) *rbxfile.Instance {
+	inst := rbxfile.NewInstance(class)
+	for name, value := range props {
+		inst.Properties[name] = value
+	}
+	return inst
+}
+
+var cases = []genCase{
+	{
+		Name:        "empty-sequences",
+		Description: "NumberSequence and ColorSequence properties with no keypoints, and with nil keypoints.",
+		Instances: func() []*rbxfile.Instance {
+			return []*rbxfile.Instance{
+				newInstance("ParticleEmitter", map[string]rbxfile.Value{
+					"Size":  rbxfile.ValueNumberSequence{},
+					"Color": rbxfile.ValueColorSequence{},
+				}),
+				newInstance("ParticleEmitter", map[string]rbxfile.Value{
+					"Size":  rbxfile.ValueNumberSequence(nil),
+					"Color": rbxfile.ValueColorSequence(nil),
+				}),
+			}
+		},
+	},
+	{
+		Name:        "long-strings",
+		Description: "String properties that are empty, 1 MiB long, and contain the end of a CDATA section, and a BinaryString property that contains every byte value.",
+		Instances: func() []*rbxfile.Instance {
+			var bytes [256]byte
+			for i := range bytes {
+				bytes[i] = byte(i)
+			}
+			return []*rbxfile.Instance{
+				newInstance("StringValue", map[string]rbxfile.Value{
+					"Value": rbxfile.ValueString(""),
+				}),
+				newInstance("StringValue", map[string]rbxfile.Value{
+					"Value": rbxfile.ValueString(strings.Repeat("a", 1<<20)),
+				}),
+				newInstance("BinaryStringValue", map[string]rbxfile.Value{
+					"Value": rbxfile.ValueBinaryString(bytes[:]),
+				}),
+				newInstance("StringValue", map[string]rbxfile.Value{
+					"Value": rbxfile.ValueString("]]>]]>"),
+				}),
+			}
+		},
+	},
+	{
+		Name:        "special-cframes",
+		Description: "A Part for each of the 24 special CFrame rotations, with the ID of the rotation in the Name property.",
+		Instances: func() []*rbxfile.Instance {
+			var insts []*rbxfile.Instance
+			for id := 0; id < 256; id++ {
+				rot, ok := rbxfile.CFrameSpecialMatrix(uint8(id))
+				if !ok {
+					continue
+				}
+				insts = append(insts, newInstance("Part", map[string]rbxfile.Value{
+					"Name":     rbxfile.ValueString(fmt.Sprintf("0x%02X", id)),
+					"CFrame":   rbxfile.ValueCFrame{Position: rbxfile.ValueVector3{X: float32(id)}, Rotation: rot},
+					"Anchored": rbxfile.ValueBool(true),
+				}))
+			}
+			return insts
+		},
+	},
+	{
+		Name:        "optional",
+		Description: "Optional CFrame properties with a value, without a value, and absent.",
+		Instances: func() []*rbxfile.Instance {
+			rot, _ := rbxfile.CFrameSpecialMatrix(0x02)
+			return []*rbxfile.Instance{
+				newInstance("Model", map[string]rbxfile.Value{
+					"WorldPivotData": rbxfile.Some(rbxfile.ValueCFrame{Position: rbxfile.ValueVector3{X: 1, Y: 2, Z: 3}, Rotation: rot}),
+				}),
+				newInstance("Model", map[string]rbxfile.Value{
+					"WorldPivotData": rbxfile.None(rbxfile.TypeCFrame),
+				}),
+				newInstance("Model", nil),
+			}
+		},
+	},
+	{
+		Name:        "shared-strings",
+		Description: "SharedString properties with identical values across instances, distinct values that differ only in the last byte, and an empty value.",
+		Instances: func() []*rbxfile.Instance {
+			var insts []*rbxfile.Instance
+			for _, s := range []string{"shared", "shared", "shareda", "sharedb", "shared", ""} {
+				insts = append(insts, newInstance("MeshPart", map[string]rbxfile.Value{
+					"PhysicalConfigData": rbxfile.ValueSharedString(s),
+				}))
+			}
+			return insts
+		},
+	},
+	{
+		Name:        "deep-nesting",
+		Description: "A chain of 1000 nested Folders, each referring to its parent through an ObjectValue.",
+		Instances: func() []*rbxfile.Instance {
+			root := newInstance("Folder", map[string]rbxfile.Value{
+				"Name": rbxfile.ValueString("0"),
+			})
+			parent := root
+			for i := 1; i < 1000; i++ {
+				child := newInstance("Folder", map[string]rbxfile.Value{
+					"Name": rbxfile.ValueString(fmt.Sprint(i)),
+				})
+				child.Children = append(child.Children, newInstance("ObjectValue", map[string]rbxfile.Value{
+					"Value": rbxfile.ValueReference{Instance: parent},
+				}))
+				parent.Children = append(parent.Children, child)
+				parent = child
+			}
+			return []*rbxfile.Instance{root}
+		},
+	},
+}
+
+type encodeFunc func(w io.Writer, root *rbxfile.Root) (warn, err error)
+
+// writeCase encodes c with encode to a file in dir named after c, with the
+// extension ext.
+func writeCase(dir, ext string, encode encodeFunc, c genCase) (warn, err error) {
+	path := filepath.Join(dir, c.Name+ext)
+	out, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create output: %w", err)
+	}
+	defer out.Close()
+	warn, err = encode(out, c.root())
+	if warn != nil {
+		warn = fmt.Errorf("%s: %w", path, warn)
+	}
+	if err != nil {
+		return warn, fmt.Errorf("encode %s: %w", path, err)
+	}
+	if err := out.Sync(); err != nil {
+		return warn, fmt.Errorf("sync output: %w", err)
+	}
+	return warn, nil
+}
+
+// encoders maps a file extension to the encoder of its format.
+var encoders = map[string]encodeFunc{
+	".rbxl":  rbxl.Encoder{Mode: rbxl.Place}.Encode,
+	".rbxlx": rbxlx.Encoder{}.Encode,
+}
+
+func main() {
+	var format string
+	flag.Usage = func() { fmt.Fprintf(flag.CommandLine.Output(), usage) }
+	flag.StringVar(&format, "format", "all", "")
+	flag.Parse()
+
+	dir := "."
+	if args := flag.Args(); len(args) >= 1 {
+		dir = args[0]
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fmt.Fprintln(os.Stderr, fmt.Errorf("create directory: %w", err))
+		os.Exit(1)
+	}
+
+	selected := encoders
+	switch format {
+	case "all":
+	case "rbxl", "rbxlx":
+		selected = map[string]encodeFunc{"." + format: encoders["."+format]}
+	default:
+		fmt.Fprintln(os.Stderr, fmt.Errorf("unknown format %q", format))
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, c := range cases {
+		for ext, encode := range selected {
+			warn, err := writeCase(dir, ext, encode, c)
+			if warn != nil {
+				fmt.Fprintln(os.Stderr, fmt.Errorf("warning: %w", warn))
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, fmt.Errorf("error: %w", err))
+				failed = true
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/robloxapi/rbxfile/rbxl"
+)
+
+func TestWriteCases(t *testing.T) {
+	dir := t.TempDir()
+	for _, c := range cases {
+		for ext, encode := range encoders {
+			warn, err := writeCase(dir, ext, encode, c)
+			if err != nil {
+				t.Fatalf("%s%s: %s", c.Name, ext, err)
+			}
+			if warn != nil {
+				t.Errorf("%s%s: unexpected warning: %s", c.Name, ext, warn)
+			}
+
+			// The binary decoder also decodes the XML format.
+			f, err := os.Open(filepath.Join(dir, c.Name+ext))
+			if err != nil {
+				t.Fatal(err)
+			}
+			root, warn, err := rbxl.Decoder{}.Decode(f)
+			f.Close()
+			if err != nil {
+				t.Fatalf("%s%s: decode error: %s", c.Name, ext, err)
+			}
+			if warn != nil {
+				t.Errorf("%s%s: unexpected decode warning: %s", c.Name, ext, warn)
+			}
+			if root.Metadata["Case"] != c.Name || root.Metadata["Description"] != c.Description {
+				t.Errorf("%s%s: unexpected metadata %v", c.Name, ext, root.Metadata)
+			}
+			if len(root.Instances) != len(c.Instances()) {
+				t.Errorf("%s%s: expected %d instances, got %d", c.Name, ext, len(c.Instances()), len(root.Instances))
+			}
+		}
+	}
+}
@@ -2,22 +2,28 @@
 package main
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"sort"
+	"strings"
 
 	"github.com/robloxapi/rbxfile"
 	"github.com/robloxapi/rbxfile/rbxl"
 )
 
-const usage = `usage: rbxfile-stat [INPUT] [OUTPUT]
+const usage = `usage: rbxfile-stat [-collections] [INPUT] [OUTPUT]
 
 Reads a RBXL, RBXM, RBXLX, or RBXMX file from INPUT, and writes to OUTPUT
 statistics for the file.
 
+If -collections is specified, then the AttributesSerialize and Tags properties
+are decoded, and the number of attributes and tags are reported instead of the
+lengths of the properties.
+
 INPUT and OUTPUT are paths to files. If INPUT is "-" or unspecified, then stdin
 is used. If OUTPUT is "-" or unspecified, then stdout is used. Warnings and
 errors are written to stderr.
@@ -69,6 +75,103 @@ type Stats struct {
 	OptionalTypeCount map[string]int `json:",omitempty"`
 
 	LargestProperties PropLenCount `json:",omitempty"`
+
+	// Number of attributes, if collections are decoded.
+	Attributes *CollectionStats `json:",omitempty"`
+
+	// Number of tags, if collections are decoded.
+	Tags *CollectionStats `json:",omitempty"`
+
+	// Compiled scripts, reported apart from the source of scripts.
+	Bytecode *BytecodeStats `json:",omitempty"`
+}
+
+// bytecodeProperty is the name of the property in which compiled script
+// bytecode is stored. The property is a BinaryString, but the binary format
+// stores it as a String, so it is matched by name regardless of string type.
+const bytecodeProperty = "Bytecode"
+
+// BytecodeStats contains stats for properties containing compiled script
+// bytecode.
+type BytecodeStats struct {
+	// Number of properties containing bytecode.
+	Count int
+
+	// Number of bytes overall.
+	Size int
+
+	// Number of bytecode properties per class.
+	ClassCount map[string]int
+}
+
+// CollectionStats contains stats for a property that contains a collection of
+// items, such as attributes or tags.
+type CollectionStats struct {
+	// Number of instances having at least one item.
+	InstanceCount int
+
+	// Number of items overall.
+	ItemCount int
+
+	// Largest number of items within a single instance.
+	MaxItemCount int
+
+	// Number of properties that could not be decoded.
+	InvalidCount int
+}
+
+func (c *CollectionStats) add(n int, ok bool) {
+	if !ok {
+		c.InvalidCount++
+		return
+	}
+	if n > 0 {
+		c.InstanceCount++
+	}
+	c.ItemCount += n
+	if n > c.MaxItemCount {
+		c.MaxItemCount = n
+	}
+}
+
+// stringValue returns the content of a string-like value.
+func stringValue(value rbxfile.Value) (s string, ok bool) {
+	switch value := value.(type) {
+	case rbxfile.ValueBinaryString:
+		return string(value), true
+	case rbxfile.ValueString:
+		return string(value), true
+	}
+	return "", false
+}
+
+// countAttributes returns the number of attributes encoded in b. Only the
+// header of the data is decoded.
+func countAttributes(b string) (n int, ok bool) {
+	if len(b) == 0 {
+		return 0, true
+	}
+	if len(b) < 4 {
+		return 0, false
+	}
+	count := binary.LittleEndian.Uint32([]byte(b[:4]))
+	// Each attribute has at least a key length and a type.
+	if uint64(count)*5 > uint64(len(b)-4) {
+		return 0, false
+	}
+	return int(count), true
+}
+
+// countTags returns the number of tags encoded in b, which is a list of names
+// separated by null bytes.
+func countTags(b string) int {
+	n := 0
+	for _, tag := range strings.Split(b, "\x00") {
+		if tag != "" {
+			n++
+		}
+	}
+	return n
 }
 
 const Okay = 0
@@ -104,7 +207,10 @@ func walk(instances []*rbxfile.Instance, cb func(inst *rbxfile.Instance, propert
 	return true
 }
 
-func (s *Stats) Fill(root *rbxfile.Root) {
+// Fill fills s with stats for root. If collections is true, then the
+// AttributesSerialize and Tags properties are decoded and counted instead of
+// being reported as properties.
+func (s *Stats) Fill(root *rbxfile.Root, collections bool) {
 	if root == nil {
 		return
 	}
@@ -148,11 +254,55 @@ func (s *Stats) Fill(root *rbxfile.Root) {
 		return Okay
 	})
 
+	if collections {
+		s.Attributes = &CollectionStats{}
+		s.Tags = &CollectionStats{}
+		walk(root.Instances, func(inst *rbxfile.Instance, property string, value rbxfile.Value) int {
+			if value == nil {
+				return Okay
+			}
+			switch property {
+			case "AttributesSerialize":
+				b, ok := stringValue(value)
+				n := 0
+				if ok {
+					n, ok = countAttributes(b)
+				}
+				s.Attributes.add(n, ok)
+			case "Tags":
+				b, ok := stringValue(value)
+				s.Tags.add(countTags(b), ok)
+			}
+			return Okay
+		})
+	}
+
+	s.Bytecode = nil
+	walk(root.Instances, func(inst *rbxfile.Instance, property string, value rbxfile.Value) int {
+		if property != bytecodeProperty {
+			return Okay
+		}
+		b, ok := stringValue(value)
+		if !ok {
+			return Okay
+		}
+		if s.Bytecode == nil {
+			s.Bytecode = &BytecodeStats{ClassCount: map[string]int{}}
+		}
+		s.Bytecode.Count++
+		s.Bytecode.Size += len(b)
+		s.Bytecode.ClassCount[inst.ClassName]++
+		return Okay
+	})
+
 	s.LargestProperties = PropLenCount{}
 	walk(root.Instances, func(inst *rbxfile.Instance, property string, value rbxfile.Value) int {
 		if value == nil {
 			return Okay
 		}
+		if collections && (property == "AttributesSerialize" || property == "Tags") {
+			return Okay
+		}
 		var n int
 		switch value := value.(type) {
 		case rbxfile.ValueBinaryString:
@@ -181,11 +331,25 @@ func (s *Stats) Fill(root *rbxfile.Root) {
 	})
 }
 
+// decode decodes r into a root, setting stats for the format. The binary
+// decoder detects the XML format and decodes it with the rbxlx package, in
+// which case stats.XML is set, and only the stats of the tree are available.
+// Warnings are written to stderr.
+func decode(r io.Reader, stats *rbxl.DecoderStats) (root *rbxfile.Root, err error) {
+	root, warn, err := rbxl.Decoder{Stats: stats}.Decode(r)
+	if warn != nil {
+		fmt.Fprintln(os.Stderr, fmt.Errorf("decode warning: %w", warn))
+	}
+	return root, err
+}
+
 func main() {
 	var input io.Reader = os.Stdin
 	var output io.Writer = os.Stdout
 
+	var collections bool
 	flag.Usage = func() { fmt.Fprintf(flag.CommandLine.Output(), usage) }
+	flag.BoolVar(&collections, "collections", false, "")
 	flag.Parse()
 	args := flag.Args()
 	if len(args) >= 1 && args[0] != "-" {
@@ -215,15 +379,13 @@ func main() {
 	}
 
 	var stats Stats
-	root, warn, err := rbxl.Decoder{Stats: &stats.Format}.Decode(input)
-	if warn != nil {
-		fmt.Fprintln(os.Stderr, fmt.Errorf("decode warning: %w", warn))
-	}
+	root, err := decode(input, &stats.Format)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, fmt.Errorf("decode error: %w", warn))
+		fmt.Fprintln(os.Stderr, fmt.Errorf("decode error: %w", err))
+		return
 	}
 
-	stats.Fill(root)
+	stats.Fill(root, collections)
 
 	je := json.NewEncoder(output)
 	je.SetEscapeHTML(false)
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/robloxapi/rbxfile"
+	"github.com/robloxapi/rbxfile/rbxl"
+)
+
+func TestFillBytecode(t *testing.T) {
+	root := &rbxfile.Root{}
+	for _, class := range []string{"Script", "LocalScript", "Script"} {
+		script := rbxfile.NewInstance(class)
+		script.Properties["Bytecode"] = rbxfile.ValueBinaryString("\x03\x01\x02")
+		root.Instances = append(root.Instances, script)
+	}
+	var buf bytes.Buffer
+	if _, err := (rbxl.Encoder{}).Encode(&buf, root); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+
+	var stats Stats
+	decoded, err := decode(&buf, &stats.Format)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	// Without a schema, the binary decoder produces a String.
+	if _, ok := decoded.Instances[0].Properties["Bytecode"].(rbxfile.ValueString); !ok {
+		t.Fatalf("expected String, got %T", decoded.Instances[0].Properties["Bytecode"])
+	}
+	stats.Fill(decoded, false)
+	b := stats.Bytecode
+	if b == nil {
+		t.Fatal("expected bytecode stats")
+	}
+	if b.Count != 3 || b.Size != 9 || b.ClassCount["Script"] != 2 || b.ClassCount["LocalScript"] != 1 {
+		t.Errorf("unexpected bytecode stats %+v", *b)
+	}
+}
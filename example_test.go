@@ -0,0 +1,146 @@
+package rbxfile_test
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/robloxapi/rbxfile"
+)
+
+func ExampleWalkValues() {
+	decal := rbxfile.NewInstance("Decal")
+	decal.Properties["Texture"] = rbxfile.ValueContent("rbxassetid://1234")
+	decal.Properties["Transparency"] = rbxfile.ValueFloat(0.5)
+	mesh := rbxfile.NewInstance("SpecialMesh")
+	mesh.Properties["MeshId"] = rbxfile.ValueContent("rbxassetid://1234")
+	mesh.Properties["TextureId"] = rbxfile.ValueContent("rbxassetid://5678")
+	decal.Children = append(decal.Children, mesh)
+	root := &rbxfile.Root{Instances: []*rbxfile.Instance{decal}}
+
+	// Remap asset 1234 to 4321, and remove references to asset 5678.
+	rbxfile.WalkValues(root, func(inst *rbxfile.Instance, prop string, v rbxfile.Value) (rbxfile.Value, bool) {
+		content, ok := v.(rbxfile.ValueContent)
+		if !ok {
+			return v, true
+		}
+		if strings.HasSuffix(string(content), "://5678") {
+			return nil, false
+		}
+		return rbxfile.ValueContent(strings.Replace(string(content), "://1234", "://4321", 1)), true
+	})
+
+	for _, inst := range []*rbxfile.Instance{decal, mesh} {
+		for _, prop := range inst.SortedProperties() {
+			fmt.Printf("%s.%s = %s\n", inst.ClassName, prop.Name, prop.Value)
+		}
+	}
+	// Output:
+	// Decal.Texture = rbxassetid://4321
+	// Decal.Transparency = 0.5
+	// SpecialMesh.MeshId = rbxassetid://4321
+}
+
+func ExampleRoot_Orphans() {
+	newInstance := func(class, name string) *rbxfile.Instance {
+		inst := rbxfile.NewInstance(class)
+		inst.Properties["Name"] = rbxfile.ValueString(name)
+		return inst
+	}
+	model := newInstance("Model", "Model")
+	part := newInstance("Part", "Part")
+	outside := newInstance("Part", "Outside")
+	inside := newInstance("ObjectValue", "Inside")
+	inside.Properties["Value"] = rbxfile.ValueReference{Instance: part}
+	first := newInstance("ObjectValue", "First")
+	first.Properties["Value"] = rbxfile.ValueReference{Instance: outside}
+	second := newInstance("ObjectValue", "Second")
+	second.Properties["Value"] = rbxfile.ValueReference{Instance: outside}
+	empty := newInstance("ObjectValue", "Empty")
+	empty.Properties["Value"] = rbxfile.ValueReference{}
+	image := newInstance("ImageLabel", "Image")
+	image.Properties["ImageContent"] = rbxfile.ValueContentObject{Instance: newInstance("EditableImage", "Editable")}
+	model.Children = append(model.Children, part, inside, first, second, empty, image)
+	root := &rbxfile.Root{Instances: []*rbxfile.Instance{model}}
+
+	for _, orphan := range root.Orphans() {
+		fmt.Println("orphan:", orphan.Properties["Name"])
+	}
+	// Output:
+	// orphan: Outside
+	// orphan: Editable
+}
+
+func ExampleRoot_Prune() {
+	newInstance := func(class, name string) *rbxfile.Instance {
+		inst := rbxfile.NewInstance(class)
+		inst.Properties["Name"] = rbxfile.ValueString(name)
+		return inst
+	}
+	model := newInstance("Model", "Model")
+	script := newInstance("Script", "Script")
+	part := newInstance("Part", "Part")
+	value := newInstance("ObjectValue", "Value")
+	value.Properties["Value"] = rbxfile.ValueReference{Instance: script}
+	script.Children = append(script.Children, part)
+	model.Children = append(model.Children, script, value)
+	root := &rbxfile.Root{Instances: []*rbxfile.Instance{model}}
+
+	n := root.Prune(func(inst *rbxfile.Instance) bool {
+		return inst.ClassName == "Script"
+	})
+
+	fmt.Println("removed:", n)
+	for _, child := range model.Children {
+		fmt.Println("child:", child.Properties["Name"])
+	}
+	fmt.Println("reference:", value.Properties["Value"].(rbxfile.ValueReference).Instance)
+	// Output:
+	// removed: 1
+	// child: Part
+	// child: Value
+	// reference: <nil>
+}
+
+func ExampleRoot_Flatten() {
+	newInstance := func(class, name string) *rbxfile.Instance {
+		inst := rbxfile.NewInstance(class)
+		inst.Properties["Name"] = rbxfile.ValueString(name)
+		return inst
+	}
+	workspace := newInstance("Workspace", "Workspace")
+	model := newInstance("Model", "Model")
+	part := newInstance("Part", "Part")
+	lighting := newInstance("Lighting", "Lighting")
+	model.Children = append(model.Children, part)
+	workspace.Children = append(workspace.Children, model, newInstance("Camera", "Camera"))
+	// The model appears twice, but is listed once.
+	lighting.Children = append(lighting.Children, model)
+	root := &rbxfile.Root{Instances: []*rbxfile.Instance{workspace, lighting}}
+
+	list, index := root.Flatten()
+	for i, inst := range list {
+		fmt.Println(i, inst.Properties["Name"])
+	}
+	fmt.Println("part:", index[part])
+	// Output:
+	// 0 Workspace
+	// 1 Model
+	// 2 Part
+	// 3 Camera
+	// 4 Lighting
+	// part: 2
+}
+
+func ExampleNewRect() {
+	rect := rbxfile.NewRect(10, 20, 110, 70)
+	fmt.Println(rect)
+	fmt.Println(rect.Width(), rect.Height(), rect.Area())
+
+	// The size of an inverted rectangle is negative.
+	inverted := rbxfile.NewRect(110, 20, 10, 70)
+	fmt.Println(inverted.Width(), inverted.Height(), inverted.Area())
+	// Output:
+	// 10, 20, 110, 70
+	// 100 50 5000
+	// -100 50 -5000
+}
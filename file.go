@@ -21,13 +21,17 @@
 // which provides an easy way to generate root structures.
 package rbxfile
 
+import "sort"
+
 // Root represents the root of an instance tree. Root is not itself an
 // instance, but a container for multiple root instances.
 type Root struct {
 	// Instances contains root instances contained in the tree.
 	Instances []*Instance
 
-	// Metadata contains metadata about the tree.
+	// Metadata contains metadata about the tree. Files written by Roblox
+	// contain entries such as ExplicitAutoJoints, but no entry records the
+	// version of Studio or the engine that wrote the file.
 	Metadata map[string]string
 }
 
@@ -66,6 +70,167 @@ func (root *Root) Copy() *Root {
 	return clone
 }
 
+// Orphans returns instances that are referred to by a Reference or
+// ContentObject property of an instance within the tree, but are not
+// themselves within the tree. Such references cannot be resolved when the root
+// is encoded, and would be encoded as nil. Each orphan is returned once, in the
+// order it is first encountered while traversing the tree.
+func (root *Root) Orphans() []*Instance {
+	tree := map[*Instance]struct{}{}
+	var walk func(insts []*Instance)
+	walk = func(insts []*Instance) {
+		for _, inst := range insts {
+			tree[inst] = struct{}{}
+			walk(inst.Children)
+		}
+	}
+	walk(root.Instances)
+
+	var orphans []*Instance
+	seen := map[*Instance]struct{}{}
+	var find func(insts []*Instance)
+	find = func(insts []*Instance) {
+		for _, inst := range insts {
+			for _, prop := range inst.SortedProperties() {
+				referent := referentOf(prop.Value)
+				if referent == nil {
+					continue
+				}
+				if _, ok := tree[referent]; ok {
+					continue
+				}
+				if _, ok := seen[referent]; ok {
+					continue
+				}
+				seen[referent] = struct{}{}
+				orphans = append(orphans, referent)
+			}
+			find(inst.Children)
+		}
+	}
+	find(root.Instances)
+	return orphans
+}
+
+// Flatten returns every instance within the tree in depth-first pre-order:
+// each instance of root.Instances, in order, followed by its descendants. Also
+// returns a map of each instance to its index within the list. An instance
+// that appears more than once in the tree is listed only once, at its first
+// appearance. Nil instances are skipped.
+//
+// The index of an instance is the reference number assigned to it by the
+// binary format encoder.
+func (root *Root) Flatten() ([]*Instance, map[*Instance]int) {
+	list := []*Instance{}
+	index := map[*Instance]int{}
+	var walk func(insts []*Instance)
+	walk = func(insts []*Instance) {
+		for _, inst := range insts {
+			if inst == nil {
+				continue
+			}
+			if _, ok := index[inst]; ok {
+				continue
+			}
+			index[inst] = len(list)
+			list = append(list, inst)
+			walk(inst.Children)
+		}
+	}
+	walk(root.Instances)
+	return list, index
+}
+
+// referentOf returns the instance referred to by v, if v is a ValueReference or
+// ValueContentObject.
+func referentOf(v Value) *Instance {
+	switch v := v.(type) {
+	case ValueReference:
+		return v.Instance
+	case ValueContentObject:
+		return v.Instance
+	}
+	return nil
+}
+
+// WalkValues calls fn for each property of each instance within the tree, in
+// depth-first order, and in order of property name within an instance. The
+// value returned by fn replaces the property. If fn returns false, then the
+// property is removed.
+//
+// Only the property values themselves are visited; the components of a value,
+// such as the keypoints of a sequence, are not visited individually. As with
+// Flatten, nil instances are skipped, and an instance that appears more than
+// once within the tree is visited only the first time.
+func WalkValues(root *Root, fn func(inst *Instance, prop string, v Value) (Value, bool)) {
+	visited := map[*Instance]struct{}{}
+	var walk func(insts []*Instance)
+	walk = func(insts []*Instance) {
+		for _, inst := range insts {
+			if inst == nil {
+				continue
+			}
+			if _, ok := visited[inst]; ok {
+				continue
+			}
+			visited[inst] = struct{}{}
+			for _, prop := range inst.SortedProperties() {
+				if v, ok := fn(inst, prop.Name, prop.Value); ok {
+					inst.Properties[prop.Name] = v
+				} else {
+					delete(inst.Properties, prop.Name)
+				}
+			}
+			walk(inst.Children)
+		}
+	}
+	walk(root.Instances)
+}
+
+// Prune removes each instance within the tree for which fn returns true. The
+// children of a removed instance take its place within the children of its
+// parent, or within the root instances. Each Reference or ContentObject
+// property within the remaining tree that refers to a removed instance is set
+// to nil. Returns the number of instances removed.
+func (root *Root) Prune(fn func(*Instance) bool) int {
+	removed := map[*Instance]struct{}{}
+	var prune func(insts []*Instance) []*Instance
+	prune = func(insts []*Instance) []*Instance {
+		result := make([]*Instance, 0, len(insts))
+		for _, inst := range insts {
+			remove := fn(inst)
+			inst.Children = prune(inst.Children)
+			if remove {
+				removed[inst] = struct{}{}
+				result = append(result, inst.Children...)
+				inst.Children = nil
+				continue
+			}
+			result = append(result, inst)
+		}
+		return result
+	}
+	root.Instances = prune(root.Instances)
+	if len(removed) == 0 {
+		return 0
+	}
+
+	WalkValues(root, func(inst *Instance, prop string, v Value) (Value, bool) {
+		switch ref := v.(type) {
+		case ValueReference:
+			if _, ok := removed[ref.Instance]; ok {
+				return ValueReference{}, true
+			}
+		case ValueContentObject:
+			if _, ok := removed[ref.Instance]; ok {
+				return ValueContentObject{}, true
+			}
+		}
+		return v, true
+	})
+	return len(removed)
+}
+
 // Instance represents a single Roblox instance.
 type Instance struct {
 	// ClassName indicates the instance's type.
@@ -86,6 +251,11 @@ type Instance struct {
 	// Children contains instances that are the children of the current
 	// instance. The user must take care not to introduce circular references.
 	Children []*Instance
+
+	// Metadata contains data associated with the instance that is not a
+	// property, such as content of a file that a decoder does not otherwise
+	// recognize. It may be nil.
+	Metadata map[string]string
 }
 
 // NewInstance creates a new Instance of a given class, and an optional
@@ -107,15 +277,30 @@ func (inst *Instance) copy(refs, crefs References, propRefs *[]PropRef) *Instanc
 		Children:   make([]*Instance, len(inst.Children)),
 		Properties: make(map[string]Value, len(inst.Properties)),
 	}
+	if inst.Metadata != nil {
+		clone.Metadata = make(map[string]string, len(inst.Metadata))
+		for key, value := range inst.Metadata {
+			clone.Metadata[key] = value
+		}
+	}
 	crefs[clone.Reference] = clone
 	for name, value := range inst.Properties {
-		if value, ok := value.(ValueReference); ok {
+		switch value := value.(type) {
+		case ValueReference:
 			*propRefs = append(*propRefs, PropRef{
 				Instance:  clone,
 				Property:  name,
 				Reference: refs.Get(value.Instance),
 			})
 			continue
+		case ValueContentObject:
+			*propRefs = append(*propRefs, PropRef{
+				Instance:      clone,
+				Property:      name,
+				Reference:     refs.Get(value.Instance),
+				ContentObject: true,
+			})
+			continue
 		}
 		clone.Properties[name] = value.Copy()
 	}
@@ -147,3 +332,34 @@ func (inst *Instance) Copy() *Instance {
 	}
 	return clone
 }
+
+// Property is a single named property of an instance.
+type Property struct {
+	Name  string
+	Value Value
+}
+
+// DroppedProperty describes a property that was dropped while decoding,
+// because its value could not be decoded.
+type DroppedProperty struct {
+	// Class is the ClassName of the instance that had the property.
+	Class string
+
+	// Property is the name of the property.
+	Property string
+
+	// Reason describes why the property was dropped.
+	Reason string
+}
+
+// SortedProperties returns the properties of the instance, sorted by name.
+func (inst *Instance) SortedProperties() []Property {
+	props := make([]Property, 0, len(inst.Properties))
+	for name, value := range inst.Properties {
+		props = append(props, Property{Name: name, Value: value})
+	}
+	sort.Slice(props, func(i, j int) bool {
+		return props[i].Name < props[j].Name
+	})
+	return props
+}
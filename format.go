@@ -0,0 +1,233 @@
+package rbxfile
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/robloxapi/rbxfile/errors"
+)
+
+// FormatEncoder encodes root according to a format, writing the result to w.
+type FormatEncoder func(w io.Writer, root *Root) (warn, err error)
+
+// formatEncoders maps the name of a format to its encoder.
+var formatEncoders = map[string]FormatEncoder{}
+
+// RegisterFormatEncoder registers enc as the default encoder for the format
+// of the given name. Format packages register themselves when imported; the
+// rbxl package registers "rbxl" and "rbxm", and the rbxlx package registers
+// "rbxlx".
+//
+// RegisterFormatEncoder is not safe to call concurrently with encoding, and
+// should be called during initialization.
+func RegisterFormatEncoder(name string, enc FormatEncoder) {
+	formatEncoders[name] = enc
+}
+
+// FormatDecoder decodes data from r according to a format.
+type FormatDecoder func(r io.Reader) (root *Root, warn, err error)
+
+// formatDecoders maps the name of a format to its decoder.
+var formatDecoders = map[string]FormatDecoder{}
+
+// RegisterFormatDecoder registers dec as the default decoder for the format
+// of the given name. Format packages register themselves when imported; the
+// rbxl package registers "rbxl", and the rbxlx package registers "rbxlx".
+//
+// RegisterFormatDecoder is not safe to call concurrently with decoding, and
+// should be called during initialization.
+func RegisterFormatDecoder(name string, dec FormatDecoder) {
+	formatDecoders[name] = dec
+}
+
+// errFormatNotRegistered indicates that a format has no registered encoder or
+// decoder.
+type errFormatNotRegistered string
+
+func (err errFormatNotRegistered) Error() string {
+	pkg := string(err)
+	if pkg == "rbxm" {
+		// The model format is provided by the rbxl package.
+		pkg = "rbxl"
+	}
+	return "format " + string(err) + " is not registered; import github.com/robloxapi/rbxfile/" + pkg
+}
+
+// countWriter counts the number of bytes written to an underlying writer.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (n int, err error) {
+	n, err = w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// encodeFormat encodes the root with the registered encoder of the given
+// format. Warnings are discarded.
+func (root *Root) encodeFormat(name string, w io.Writer) (n int64, err error) {
+	if w == nil {
+		return 0, errors.New("nil writer")
+	}
+	enc, ok := formatEncoders[name]
+	if !ok {
+		return 0, errFormatNotRegistered(name)
+	}
+	cw := &countWriter{w: w}
+	_, err = enc(cw, root)
+	return cw.n, err
+}
+
+// EncodeBinary encodes the root to w in the binary place format, using the
+// default options of the rbxl package. Returns the number of bytes written.
+//
+// The rbxl package must be imported for the format to be available.
+func (root *Root) EncodeBinary(w io.Writer) (n int64, err error) {
+	return root.encodeFormat("rbxl", w)
+}
+
+// EncodeXML encodes the root to w in the XML format, using the default
+// options of the rbxlx package. Returns the number of bytes written.
+//
+// The rbxlx package must be imported for the format to be available.
+func (root *Root) EncodeXML(w io.Writer) (n int64, err error) {
+	return root.encodeFormat("rbxlx", w)
+}
+
+// ErrEmptyPropertyName is a warning produced by decoders when a property has an
+// empty name.
+var ErrEmptyPropertyName = errors.New("property name is empty")
+
+// gzipSig is the signature of data compressed with gzip.
+const gzipSig = "\x1f\x8b"
+
+// DecodeGzip decodes the data read from r with dec. If the data begins with
+// the gzip signature, then it is first decompressed. Data without the
+// signature is decoded as-is.
+func DecodeGzip(r io.Reader, dec FormatDecoder) (root *Root, warn, err error) {
+	if r == nil {
+		return nil, nil, errors.New("nil reader")
+	}
+	br := bufio.NewReader(r)
+	if sig, _ := br.Peek(len(gzipSig)); string(sig) != gzipSig {
+		return dec(br)
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer zr.Close()
+	return dec(zr)
+}
+
+// EncodeGzip encodes root with enc, and writes the output to w compressed
+// with gzip. The result can be decoded with DecodeGzip.
+func EncodeGzip(w io.Writer, root *Root, enc FormatEncoder) (warn, err error) {
+	if w == nil {
+		return nil, errors.New("nil writer")
+	}
+	zw := gzip.NewWriter(w)
+	if warn, err = enc(zw, root); err != nil {
+		return warn, err
+	}
+	if err = zw.Close(); err != nil {
+		return warn, err
+	}
+	return warn, nil
+}
+
+// fileFormats maps a file extension to the name of the format used to encode
+// the file, and the name of the format used to decode the file.
+var fileFormats = map[string][2]string{
+	".rbxl":  {"rbxl", "rbxl"},
+	".rbxm":  {"rbxm", "rbxl"},
+	".rbxlx": {"rbxlx", "rbxlx"},
+	".rbxmx": {"rbxlx", "rbxlx"},
+}
+
+// sniffFormat returns the name of the decoding format indicated by the
+// signature at the start of r. Returns an empty string if the signature is not
+// recognized.
+func sniffFormat(r *bufio.Reader) string {
+	b, _ := r.Peek(512)
+	if bytes.HasPrefix(b, []byte("<roblox!")) {
+		return "rbxl"
+	}
+	b = bytes.TrimLeft(b, " \t\r\n\ufeff")
+	if bytes.HasPrefix(b, []byte("<roblox")) || bytes.HasPrefix(b, []byte("<?xml")) {
+		return "rbxlx"
+	}
+	return ""
+}
+
+// DecodeFile decodes the file at path, using the registered decoder of the
+// format of the file. The format is selected by the signature of the file,
+// falling back to the extension of path if the signature is not recognized.
+// If the signature conflicts with the extension, then the signature is used,
+// and a warning is emitted.
+//
+// Extensions .rbxl and .rbxm select the binary format, which requires the
+// rbxl package to be imported. Extensions .rbxlx and .rbxmx select the XML
+// format, which requires the rbxlx package to be imported.
+func DecodeFile(path string) (root *Root, warn, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	ext := strings.ToLower(filepath.Ext(path))
+	byExt := fileFormats[ext][1]
+	r := bufio.NewReader(f)
+	name := sniffFormat(r)
+	switch {
+	case name == "" && byExt == "":
+		return nil, nil, fmt.Errorf("unknown format of file %s", path)
+	case name == "":
+		name = byExt
+	case byExt != "" && name != byExt:
+		warn = fmt.Errorf("file %s has extension %s, but signature of format %s", path, ext, name)
+	}
+
+	dec, ok := formatDecoders[name]
+	if !ok {
+		return nil, warn, errFormatNotRegistered(name)
+	}
+	root, ws, err := dec(r)
+	return root, errors.Union(warn, ws), err
+}
+
+// EncodeFile encodes root to the file at path, using the registered encoder
+// of the format selected by the extension of path. The file is created if it
+// does not exist, and truncated otherwise. The root is encoded before the file
+// is opened, so an existing file is left unchanged if encoding fails.
+//
+// Extension .rbxl selects the binary place format, and .rbxm selects the
+// binary model format, which require the rbxl package to be imported.
+// Extensions .rbxlx and .rbxmx select the XML format, which requires the
+// rbxlx package to be imported.
+func EncodeFile(path string, root *Root) (warn, err error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	formats, ok := fileFormats[ext]
+	if !ok {
+		return nil, fmt.Errorf("unknown format of file extension %q", ext)
+	}
+	enc, ok := formatEncoders[formats[0]]
+	if !ok {
+		return nil, errFormatNotRegistered(formats[0])
+	}
+
+	var buf bytes.Buffer
+	if warn, err = enc(&buf, root); err != nil {
+		return warn, err
+	}
+	return warn, os.WriteFile(path, buf.Bytes(), 0666)
+}
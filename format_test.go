@@ -0,0 +1,119 @@
+package rbxfile_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/robloxapi/rbxfile"
+	"github.com/robloxapi/rbxfile/rbxl"
+	"github.com/robloxapi/rbxfile/rbxlx"
+)
+
+func TestEncodeDecodeFile(t *testing.T) {
+	dir := t.TempDir()
+	folder := rbxfile.NewInstance("Folder")
+	folder.Properties["Name"] = rbxfile.ValueString("Folder")
+	root := &rbxfile.Root{Instances: []*rbxfile.Instance{folder}}
+
+	for _, ext := range []string{".rbxl", ".rbxm", ".rbxlx", ".RBXMX"} {
+		path := filepath.Join(dir, "file"+ext)
+		if _, err := rbxfile.EncodeFile(path, root); err != nil {
+			t.Fatalf("%s: encode error: %s", ext, err)
+		}
+		got, warn, err := rbxfile.DecodeFile(path)
+		if err != nil {
+			t.Fatalf("%s: decode error: %s", ext, err)
+		}
+		if warn != nil {
+			t.Errorf("%s: unexpected warning: %s", ext, warn)
+		}
+		if len(got.Instances) != 1 || got.Instances[0].ClassName != "Folder" {
+			t.Fatalf("%s: unexpected tree", ext)
+		}
+		if v, ok := got.Instances[0].Properties["Name"].(rbxfile.ValueString); !ok || string(v) != "Folder" {
+			t.Errorf("%s: unexpected Name %#v", ext, got.Instances[0].Properties["Name"])
+		}
+	}
+
+	// The signature takes precedence over a conflicting extension.
+	data, err := os.ReadFile(filepath.Join(dir, "file.rbxm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "binary.rbxmx")
+	if err := os.WriteFile(path, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	got, warn, err := rbxfile.DecodeFile(path)
+	if err != nil {
+		t.Fatalf("conflict: decode error: %s", err)
+	}
+	if len(got.Instances) != 1 {
+		t.Errorf("conflict: unexpected tree")
+	}
+	if warn == nil || !strings.Contains(warn.Error(), "signature of format rbxl") {
+		t.Errorf("conflict: expected warning, got %v", warn)
+	}
+
+	// An existing file is unchanged when encoding fails.
+	path = filepath.Join(dir, "file.rbxm")
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rbxfile.EncodeFile(path, nil); err == nil {
+		t.Errorf("expected error for nil root")
+	}
+	if after, err := os.ReadFile(path); err != nil || string(after) != string(before) {
+		t.Errorf("file changed by failed encode")
+	}
+
+	// Unknown formats.
+	if _, err := rbxfile.EncodeFile(filepath.Join(dir, "file.txt"), root); err == nil {
+		t.Errorf("expected error for unknown extension")
+	}
+	path = filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(path, []byte("text"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := rbxfile.DecodeFile(path); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
+
+func TestEncodeFormat(t *testing.T) {
+	folder := rbxfile.NewInstance("Folder")
+	folder.Properties["Name"] = rbxfile.ValueString("Folder")
+	root := &rbxfile.Root{Instances: []*rbxfile.Instance{folder}}
+
+	for _, c := range []struct {
+		name   string
+		encode func(w io.Writer) (int64, error)
+		want   rbxfile.FormatEncoder
+	}{
+		{"binary", root.EncodeBinary, rbxl.Encoder{}.Encode},
+		{"xml", root.EncodeXML, rbxlx.Encoder{}.Encode},
+	} {
+		var got, want bytes.Buffer
+		n, err := c.encode(&got)
+		if err != nil {
+			t.Fatalf("%s: encode error: %s", c.name, err)
+		}
+		if n != int64(got.Len()) {
+			t.Errorf("%s: returned %d bytes, wrote %d", c.name, n, got.Len())
+		}
+		if _, err := c.want(&want, root); err != nil {
+			t.Fatalf("%s: package encode error: %s", c.name, err)
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Errorf("%s: output differs from the default encoder of the package", c.name)
+		}
+		if _, err := c.encode(nil); err == nil {
+			t.Errorf("%s: expected error for nil writer", c.name)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package rbxfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// ContentHash returns a hash of the content of the root. The hash is sensitive
+// to the structure of the tree, the class names of instances, and the names
+// and values of properties. It is invariant to the order of sibling instances,
+// to the order of properties, and to the reference strings of instances.
+// Metadata, Instance.Reference, and Instance.IsService do not affect the hash.
+//
+// The hash is computed as follows:
+//
+//  1. Each instance is given a shape, being the BLAKE2b-256 hash of its
+//     encoded content, where each Reference property is encoded as if it were
+//     nil, and the child list is the sorted shapes of the instance's children.
+//  2. Shapes are refined, so that instances with the same content, but with a
+//     different position or different references, are distinguished. In each
+//     round, the shape of an instance becomes the hash of its previous shape,
+//     the previous shape of its parent, the sorted previous shapes of its
+//     children, the previous shape of the referent of each Reference
+//     property, and the sorted previous shapes of the instances referring to
+//     it, along with the names of those properties. Rounds continue until the
+//     number of distinct shapes no longer increases.
+//  3. The root instances and the children of each instance are sorted by
+//     shape. Siblings that remain tied cannot be distinguished by content,
+//     position, or references, so their relative order does not affect the
+//     result.
+//  4. Each instance is numbered by its position in a pre-order traversal of
+//     the sorted tree, starting at 0.
+//  5. Each instance is encoded again, where each Reference property is encoded
+//     as the number of the referent, or -1 if the referent is nil or is not
+//     within the tree, and the child list is the encoded children in sorted
+//     order.
+//  6. The result is the BLAKE2b-256 hash of the encoded root instances, in
+//     sorted order.
+//
+// An instance is encoded as its class name, the number of properties, each
+// property sorted by name, the number of children, and each child.
+// Strings are encoded with a uint32 length prefix. A property is encoded as
+// its name followed by its value. A value is encoded as the name of its type,
+// followed by its fields in order of declaration. Numbers are encoded
+// little-endian at the size of the field, with floats encoded as their IEEE
+// 754 bits, where every NaN is encoded as the same NaN. Bools are encoded as a
+// byte that is 1 if true and 0 otherwise. Sequences are encoded with a uint32
+// length prefix. A ContentObject value is encoded in the same way as a
+// Reference value. An optional value is encoded as the name of its inner
+// type, followed by a byte that is 1 if a value is present and 0 otherwise,
+// followed by the value, if present. A value of a type unknown to this
+// package is encoded as its String representation.
+func (root *Root) ContentHash() [32]byte {
+	shapes := map[*Instance][32]byte{}
+	var shape func(inst *Instance) [32]byte
+	shape = func(inst *Instance) [32]byte {
+		children := make([][32]byte, len(inst.Children))
+		for i, child := range inst.Children {
+			children[i] = shape(child)
+		}
+		sortShapes(children)
+		var b []byte
+		b = appendInstanceHeader(b, inst, nil)
+		b = appendUint32(b, uint32(len(children)))
+		for _, child := range children {
+			b = append(b, child[:]...)
+		}
+		sum := blake2b.Sum256(b)
+		shapes[inst] = sum
+		return sum
+	}
+	for _, inst := range root.Instances {
+		shape(inst)
+	}
+	refineShapes(root, shapes)
+
+	sortByShape := func(insts []*Instance) []*Instance {
+		sorted := make([]*Instance, len(insts))
+		copy(sorted, insts)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			a, b := shapes[sorted[i]], shapes[sorted[j]]
+			return bytes.Compare(a[:], b[:]) < 0
+		})
+		return sorted
+	}
+
+	index := map[*Instance]int64{}
+	var number func(insts []*Instance)
+	number = func(insts []*Instance) {
+		for _, inst := range sortByShape(insts) {
+			index[inst] = int64(len(index))
+			number(inst.Children)
+		}
+	}
+	number(root.Instances)
+
+	var encode func(b []byte, insts []*Instance) []byte
+	encode = func(b []byte, insts []*Instance) []byte {
+		for _, inst := range sortByShape(insts) {
+			b = appendInstanceHeader(b, inst, index)
+			b = appendUint32(b, uint32(len(inst.Children)))
+			b = encode(b, inst.Children)
+		}
+		return b
+	}
+	return blake2b.Sum256(encode(nil, root.Instances))
+}
+
+// refineShapes refines the shape of each instance within the tree of root
+// according to its parent, children, and references, as described by
+// ContentHash.
+func refineShapes(root *Root, shapes map[*Instance][32]byte) {
+	insts, _ := root.Flatten()
+	parents := map[*Instance]*Instance{}
+	type incoming struct {
+		prop   string
+		source *Instance
+	}
+	referrers := map[*Instance][]incoming{}
+	for _, inst := range insts {
+		for _, child := range inst.Children {
+			parents[child] = inst
+		}
+		for _, prop := range inst.SortedProperties() {
+			if referent := referentOf(prop.Value); referent != nil {
+				referrers[referent] = append(referrers[referent], incoming{prop.Name, inst})
+			}
+		}
+	}
+
+	distinct := func() int {
+		set := map[[32]byte]struct{}{}
+		for _, inst := range insts {
+			set[shapes[inst]] = struct{}{}
+		}
+		return len(set)
+	}
+	count := distinct()
+	for {
+		next := make(map[*Instance][32]byte, len(insts))
+		for _, inst := range insts {
+			prev := shapes[inst]
+			b := append([]byte(nil), prev[:]...)
+			if parent, ok := parents[inst]; ok {
+				s := shapes[parent]
+				b = append(b, 1)
+				b = append(b, s[:]...)
+			} else {
+				b = append(b, 0)
+			}
+			children := make([][32]byte, len(inst.Children))
+			for i, child := range inst.Children {
+				children[i] = shapes[child]
+			}
+			sortShapes(children)
+			b = appendUint32(b, uint32(len(children)))
+			for _, child := range children {
+				b = append(b, child[:]...)
+			}
+			for _, prop := range inst.SortedProperties() {
+				switch prop.Value.(type) {
+				case ValueReference, ValueContentObject:
+				default:
+					continue
+				}
+				b = appendUint32(b, uint32(len(prop.Name)))
+				b = append(b, prop.Name...)
+				if s, ok := shapes[referentOf(prop.Value)]; ok {
+					b = append(b, 1)
+					b = append(b, s[:]...)
+				} else {
+					b = append(b, 0)
+				}
+			}
+			refs := make([][32]byte, 0, len(referrers[inst]))
+			for _, ref := range referrers[inst] {
+				s := shapes[ref.source]
+				refs = append(refs, blake2b.Sum256(append([]byte(ref.prop), s[:]...)))
+			}
+			sortShapes(refs)
+			b = appendUint32(b, uint32(len(refs)))
+			for _, ref := range refs {
+				b = append(b, ref[:]...)
+			}
+			next[inst] = blake2b.Sum256(b)
+		}
+		for inst, s := range next {
+			shapes[inst] = s
+		}
+		n := distinct()
+		if n <= count {
+			return
+		}
+		count = n
+	}
+}
+
+// sortShapes sorts a list of shapes in ascending order.
+func sortShapes(shapes [][32]byte) {
+	sort.Slice(shapes, func(i, j int) bool {
+		return bytes.Compare(shapes[i][:], shapes[j][:]) < 0
+	})
+}
+
+// appendInstanceHeader appends to b the class name and properties of inst, as
+// described by ContentHash. If index is nil, then references are encoded as
+// nil.
+func appendInstanceHeader(b []byte, inst *Instance, index map[*Instance]int64) []byte {
+	b = appendHashString(b, inst.ClassName)
+	props := inst.SortedProperties()
+	b = appendUint32(b, uint32(len(props)))
+	for _, prop := range props {
+		b = appendHashString(b, prop.Name)
+		b = appendHashValue(b, prop.Value, index)
+	}
+	return b
+}
+
+// appendHashValue appends to b the encoding of v, as described by
+// ContentHash.
+func appendHashValue(b []byte, v Value, index map[*Instance]int64) []byte {
+	b = appendHashString(b, v.Type().String())
+	switch v := v.(type) {
+	case ValueString:
+		return appendHashString(b, string(v))
+	case ValueBinaryString:
+		return appendHashString(b, string(v))
+	case ValueProtectedString:
+		return appendHashString(b, string(v))
+	case ValueContent:
+		return appendHashString(b, string(v))
+	case ValueSharedString:
+		return appendHashString(b, string(v))
+	case ValueBool:
+		return appendBool(b, bool(v))
+	case ValueInt:
+		return appendUint32(b, uint32(v))
+	case ValueInt64:
+		return appendUint64(b, uint64(v))
+	case ValueFloat:
+		return appendFloat32(b, float32(v))
+	case ValueDouble:
+		return appendFloat64(b, float64(v))
+	case ValueBrickColor:
+		return appendUint32(b, uint32(v))
+	case ValueToken:
+		return appendUint32(b, uint32(v))
+	case ValueSecurityCapabilities:
+		return appendUint64(b, uint64(v))
+	case ValueUDim:
+		return appendUDim(b, v)
+	case ValueUDim2:
+		return appendUDim(appendUDim(b, v.X), v.Y)
+	case ValueRay:
+		return appendVector3(appendVector3(b, v.Origin), v.Direction)
+	case ValueFaces:
+		for _, f := range [...]bool{v.Right, v.Top, v.Back, v.Left, v.Bottom, v.Front} {
+			b = appendBool(b, f)
+		}
+		return b
+	case ValueAxes:
+		return appendBool(appendBool(appendBool(b, v.X), v.Y), v.Z)
+	case ValueColor3:
+		return appendColor3(b, v)
+	case ValueVector2:
+		return appendVector2(b, v)
+	case ValueVector3:
+		return appendVector3(b, v)
+	case ValueCFrame:
+		b = appendVector3(b, v.Position)
+		for _, r := range v.Rotation {
+			b = appendFloat32(b, r)
+		}
+		return b
+	case ValueVector3int16:
+		return appendUint16(appendUint16(appendUint16(b, uint16(v.X)), uint16(v.Y)), uint16(v.Z))
+	case ValueVector2int16:
+		return appendUint16(appendUint16(b, uint16(v.X)), uint16(v.Y))
+	case ValueNumberSequence:
+		b = appendUint32(b, uint32(len(v)))
+		for _, k := range v {
+			b = appendFloat32(appendFloat32(appendFloat32(b, k.Time), k.Value), k.Envelope)
+		}
+		return b
+	case ValueColorSequence:
+		b = appendUint32(b, uint32(len(v)))
+		for _, k := range v {
+			b = appendFloat32(appendColor3(appendFloat32(b, k.Time), k.Value), k.Envelope)
+		}
+		return b
+	case ValueNumberRange:
+		return appendFloat32(appendFloat32(b, v.Min), v.Max)
+	case ValueRect:
+		return appendVector2(appendVector2(b, v.Min), v.Max)
+	case ValuePhysicalProperties:
+		b = appendBool(b, v.CustomPhysics)
+		for _, f := range [...]float32{v.Density, v.Friction, v.Elasticity, v.FrictionWeight, v.ElasticityWeight} {
+			b = appendFloat32(b, f)
+		}
+		return b
+	case ValueColor3uint8:
+		return append(b, v.R, v.G, v.B)
+	case ValueUniqueId:
+		return appendUint32(appendUint32(appendUint64(b, uint64(v.Random)), v.Time), v.Index)
+	case ValueFont:
+		b = appendHashString(b, string(v.Family))
+		b = appendUint16(b, uint16(v.Weight))
+		b = append(b, byte(v.Style))
+		return appendHashString(b, string(v.CachedFaceId))
+	case ValueReference, ValueContentObject:
+		i, ok := index[referentOf(v)]
+		if !ok {
+			i = -1
+		}
+		return appendUint64(b, uint64(i))
+	case ValueOptional:
+		b = appendHashString(b, v.ValueType().String())
+		if inner := v.Value(); inner != nil {
+			b = appendBool(b, true)
+			return appendHashValue(b, inner, index)
+		}
+		return appendBool(b, false)
+	default:
+		return appendHashString(b, v.String())
+	}
+}
+
+func appendHashString(b []byte, s string) []byte {
+	b = appendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func appendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+func appendUDim(b []byte, v ValueUDim) []byte {
+	return appendUint32(appendFloat32(b, v.Scale), uint32(v.Offset))
+}
+
+func appendColor3(b []byte, v ValueColor3) []byte {
+	return appendFloat32(appendFloat32(appendFloat32(b, v.R), v.G), v.B)
+}
+
+func appendVector2(b []byte, v ValueVector2) []byte {
+	return appendFloat32(appendFloat32(b, v.X), v.Y)
+}
+
+func appendVector3(b []byte, v ValueVector3) []byte {
+	return appendFloat32(appendFloat32(appendFloat32(b, v.X), v.Y), v.Z)
+}
+
+func appendFloat32(b []byte, v float32) []byte {
+	if v != v {
+		return appendUint32(b, 0x7FC00000)
+	}
+	return appendUint32(b, math.Float32bits(v))
+}
+
+func appendFloat64(b []byte, v float64) []byte {
+	if v != v {
+		return appendUint64(b, 0x7FF8000000000001)
+	}
+	return appendUint64(b, math.Float64bits(v))
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
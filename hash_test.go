@@ -0,0 +1,101 @@
+package rbxfile
+
+import (
+	"math"
+	"testing"
+)
+
+func TestContentHash(t *testing.T) {
+	named := func(class, name string) *Instance {
+		inst := NewInstance(class)
+		inst.Properties["Name"] = ValueString(name)
+		return inst
+	}
+
+	// Builds a tree in which two identical values refer to different parts.
+	// The order of the values and of the parts is swapped according to the
+	// arguments.
+	build := func(swapValues, swapParts bool, refs [2]string) *Root {
+		model := named("Model", "Model")
+		a := named("Part", "A")
+		b := named("Part", "B")
+		va := named("ObjectValue", "Value")
+		va.Properties["Value"] = ValueReference{Instance: a}
+		vb := named("ObjectValue", "Value")
+		vb.Properties["Value"] = ValueReference{Instance: b}
+		a.Reference = refs[0]
+		b.Reference = refs[1]
+		values := []*Instance{va, vb}
+		if swapValues {
+			values[0], values[1] = values[1], values[0]
+		}
+		parts := []*Instance{a, b}
+		if swapParts {
+			parts[0], parts[1] = parts[1], parts[0]
+		}
+		folder := named("Folder", "Values")
+		folder.Children = values
+		model.Children = append(parts, folder)
+		return &Root{Instances: []*Instance{model}}
+	}
+
+	want := build(false, false, [2]string{"RBX1", "RBX2"}).ContentHash()
+	for _, test := range []struct {
+		name       string
+		swapValues bool
+		swapParts  bool
+		refs       [2]string
+	}{
+		{"values swapped", true, false, [2]string{"RBX1", "RBX2"}},
+		{"parts swapped", false, true, [2]string{"RBX1", "RBX2"}},
+		{"both swapped", true, true, [2]string{"RBX1", "RBX2"}},
+		{"references changed", false, false, [2]string{GenerateReference(), GenerateReference()}},
+	} {
+		if got := build(test.swapValues, test.swapParts, test.refs).ContentHash(); got != want {
+			t.Errorf("%s: hash differs", test.name)
+		}
+	}
+
+	// Changing which part a value refers to changes the hash.
+	changed := build(false, false, [2]string{"RBX1", "RBX2"})
+	values := changed.Instances[0].Children[2].Children
+	values[1].Properties["Value"] = values[0].Properties["Value"]
+	if changed.ContentHash() == want {
+		t.Errorf("expected hash to differ when a reference changes")
+	}
+
+	// An absent optional value does not collide with a present value that
+	// has the same string representation.
+	none := named("Model", "Model")
+	none.Properties["Value"] = None(TypeString)
+	some := named("Model", "Model")
+	some.Properties["Value"] = Some(ValueString(None(TypeString).String()))
+	if (&Root{Instances: []*Instance{none}}).ContentHash() == (&Root{Instances: []*Instance{some}}).ContentHash() {
+		t.Errorf("expected None and Some to hash differently")
+	}
+
+	hashOf := func(v Value) [32]byte {
+		inst := named("Model", "Model")
+		inst.Properties["Value"] = v
+		return (&Root{Instances: []*Instance{inst}}).ContentHash()
+	}
+
+	// Fields omitted from the String representation affect the hash.
+	font := ValueFont{Family: ValueContent("rbxasset://fonts/families/Arial.json")}
+	cached := font
+	cached.CachedFaceId = ValueContent("rbxasset://fonts/arial.ttf")
+	if font.String() != cached.String() {
+		t.Fatalf("expected fonts to have the same String representation")
+	}
+	if hashOf(font) == hashOf(cached) {
+		t.Errorf("expected CachedFaceId to affect the hash")
+	}
+
+	// Every NaN hashes the same.
+	if hashOf(ValueFloat(math.Float32frombits(0x7FC00001))) != hashOf(ValueFloat(math.NaN())) {
+		t.Errorf("expected NaN floats to hash the same")
+	}
+	if hashOf(ValueDouble(math.Float64frombits(0xFFF8000000001234))) != hashOf(ValueDouble(math.NaN())) {
+		t.Errorf("expected NaN doubles to hash the same")
+	}
+}
@@ -0,0 +1,47 @@
+// The meshdata package decodes the header of the binary data stored in the
+// PhysicsData and MeshData properties of MeshParts and unions.
+//
+// Such data starts with the 6-byte signature "CSGPHS", followed by a
+// little-endian uint32 version. The layout of the content that follows is not
+// documented, and differs between versions, so it is exposed as raw bytes
+// rather than interpreted. Data that does not start with the signature, which
+// may be the case for MeshData, is rejected.
+package meshdata
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/robloxapi/rbxfile"
+)
+
+// Signature is the signature at the start of the data.
+const Signature = "CSGPHS"
+
+// MeshData is the decoded header of mesh or physics data.
+type MeshData struct {
+	// Version is the version of the data.
+	Version uint32
+
+	// Content is the raw bytes following the version.
+	Content []byte
+}
+
+// ErrSignature indicates that the data does not start with Signature, or is
+// too short to contain a version.
+var ErrSignature = errors.New("invalid signature")
+
+// Decode decodes the header of v. Returns ErrSignature if v does not start
+// with Signature followed by a version. Because the layout of the content is
+// not known, no version is rejected.
+func Decode(v rbxfile.ValueBinaryString) (*MeshData, error) {
+	b := []byte(v)
+	if len(b) < len(Signature)+4 || string(b[:len(Signature)]) != Signature {
+		return nil, ErrSignature
+	}
+	i := len(Signature)
+	return &MeshData{
+		Version: binary.LittleEndian.Uint32(b[i:]),
+		Content: b[i+4 : len(b) : len(b)],
+	}, nil
+}
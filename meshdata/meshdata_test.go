@@ -0,0 +1,36 @@
+package meshdata
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/robloxapi/rbxfile"
+)
+
+var decodeTests = []struct {
+	name string
+	data string
+	want *MeshData
+	err  error
+}{
+	{"empty content", "CSGPHS\x00\x00\x00\x00", &MeshData{Version: 0, Content: []byte{}}, nil},
+	{"content", "CSGPHS\x06\x00\x00\x00\x02\x00abc", &MeshData{Version: 6, Content: []byte("\x02\x00abc")}, nil},
+	{"any version", "CSGPHS\xff\x00\x00\x00x", &MeshData{Version: 255, Content: []byte("x")}, nil},
+	{"short", "CSGPH", nil, ErrSignature},
+	{"no version", "CSGPHS\x03\x00", nil, ErrSignature},
+	{"signature", "BLOCK\x00\x00\x00\x00\x00", nil, ErrSignature},
+}
+
+func TestDecode(t *testing.T) {
+	for _, test := range decodeTests {
+		got, err := Decode(rbxfile.ValueBinaryString(test.data))
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.err, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expected %#v, got %#v", test.name, test.want, got)
+		}
+	}
+}
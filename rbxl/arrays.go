@@ -31,11 +31,13 @@ func (err errInvalidType) Error() string {
 
 type indexError struct {
 	Index int
-	Cause error
+	// Offset is the byte offset of the value within the array data.
+	Offset int
+	Cause  error
 }
 
 func (err indexError) Error() string {
-	return fmt.Sprintf("#%d: %s", err.Index, err.Cause)
+	return fmt.Sprintf("#%d at offset %d: %s", err.Index, err.Offset, err.Cause)
 }
 
 func (err indexError) Unwrap() error {
@@ -152,7 +154,7 @@ func arrayFromBytes(b []byte, a array) (n int, err error) {
 		v := newValue(a.Type())
 		nn, err := v.FromBytes(b)
 		if err != nil {
-			return n, indexError{Index: i, Cause: err}
+			return n, indexError{Index: i, Offset: n, Cause: err}
 		}
 		n += nn
 		b = b[nn:]
@@ -324,6 +326,11 @@ func newArray(t typeID, n int) array {
 		return make(arrayUniqueId, n)
 	case typeFont:
 		return make(arrayFont, n)
+	case typeSecurityCapabilities:
+		return make(arraySecurityCapabilities, n)
+	}
+	if t.custom() {
+		return arrayCustom{id: t, values: make([]valueCustom, n)}
 	}
 	return nil
 }
@@ -798,6 +805,8 @@ func (a arrayVector3) Interleaved() {}
 
 ////////////////////////////////////////////////////////////////////////////////
 
+// arrayVector2int16 is not interleaved. Each value is written as two
+// consecutive little-endian int16 components, as Roblox does.
 type arrayVector2int16 []valueVector2int16
 
 func (arrayVector2int16) Type() typeID {
@@ -878,7 +887,7 @@ func (a arrayCFrame) FromBytes(b []byte) (n int, err error) {
 		var cond byte
 		cond, b, _, err = checkLengthCond(&a[i], b)
 		if err != nil {
-			return n, indexError{Index: i, Cause: err}
+			return n, indexError{Index: i, Offset: n, Cause: err}
 		}
 		n += zCFrameSp
 		a[i].Special = cond
@@ -900,7 +909,7 @@ func (a arrayCFrame) FromBytes(b []byte) (n int, err error) {
 		var v valueVector3
 		nn, err := v.FromBytes(b)
 		if err != nil {
-			return n, indexError{Index: i, Cause: err}
+			return n, indexError{Index: i, Offset: n, Cause: err}
 		}
 		n += nn
 		b = b[nn:]
@@ -957,7 +966,7 @@ func (a arrayCFrameQuat) FromBytes(b []byte) (n int, err error) {
 		var cond byte
 		cond, b, _, err = checkLengthCond(&a[i], b)
 		if err != nil {
-			return n, indexError{Index: i, Cause: err}
+			return n, indexError{Index: i, Offset: n, Cause: err}
 		}
 		n += zCFrameQuatSp
 		a[i].Special = cond
@@ -978,7 +987,7 @@ func (a arrayCFrameQuat) FromBytes(b []byte) (n int, err error) {
 		var v valueVector3
 		nn, err := v.FromBytes(b)
 		if err != nil {
-			return n, indexError{Index: i, Cause: err}
+			return n, indexError{Index: i, Offset: n, Cause: err}
 		}
 		n += nn
 		b = b[nn:]
@@ -1068,7 +1077,7 @@ func (a arrayReference) FromBytes(b []byte) (n int, err error) {
 		var v valueReference
 		nn, err := v.FromBytes(b)
 		if err != nil {
-			return n, indexError{Index: i, Cause: err}
+			return n, indexError{Index: i, Offset: n, Cause: err}
 		}
 		n += nn
 		b = b[nn:]
@@ -1084,6 +1093,8 @@ func (a arrayReference) Interleaved() {}
 
 ////////////////////////////////////////////////////////////////////////////////
 
+// arrayVector3int16 is not interleaved. Each value is written as three
+// consecutive little-endian int16 components, as Roblox does.
 type arrayVector3int16 []valueVector3int16
 
 func (arrayVector3int16) Type() typeID {
@@ -1566,3 +1577,37 @@ func (a arrayFont) Bytes(b []byte) []byte {
 }
 
 ////////////////////////////////////////////////////////////////////////////////
+
+type arraySecurityCapabilities []valueSecurityCapabilities
+
+func (arraySecurityCapabilities) Type() typeID {
+	return typeSecurityCapabilities
+}
+
+func (a arraySecurityCapabilities) Len() int {
+	return len(a)
+}
+
+func (a arraySecurityCapabilities) Get(i int) value {
+	v := a[i]
+	return &v
+}
+
+func (a arraySecurityCapabilities) Set(i int, v value) {
+	a[i] = *v.(*valueSecurityCapabilities)
+}
+
+func (a arraySecurityCapabilities) BytesLen() int {
+	return len(a) * zSecurityCapabilities
+}
+
+func (a arraySecurityCapabilities) Bytes(b []byte) []byte {
+	for _, v := range a {
+		b = v.Bytes(b)
+	}
+	return b
+}
+
+func (a arraySecurityCapabilities) Interleaved() {}
+
+////////////////////////////////////////////////////////////////////////////////
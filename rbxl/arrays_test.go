@@ -0,0 +1,54 @@
+package rbxl
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/robloxapi/rbxfile"
+)
+
+func TestArrayUDim(t *testing.T) {
+	// The scales of each value are written before the offsets, and the bytes
+	// of each field are interleaved across values.
+	a := arrayUDim{{Scale: 1, Offset: 0x01020304}, {Scale: 0.5, Offset: -1}}
+	want, _ := hex.DecodeString("7f7e0000000000000200040006000801")
+	got, err := arrayToBytes(nil, a)
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("expected bytes %x, got %x", want, got)
+	}
+	b := newArray(a.Type(), a.Len())
+	if _, err := arrayFromBytes(want, b); err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if !reflect.DeepEqual(b, a) {
+		t.Errorf("expected decoded array %#v, got %#v", a, b)
+	}
+}
+
+func TestEncodeUDims(t *testing.T) {
+	root := &rbxfile.Root{}
+	for i := 0; i < 5; i++ {
+		corner := rbxfile.NewInstance("UICorner")
+		corner.Properties["CornerRadius"] = rbxfile.ValueUDim{Scale: float32(i) / 4, Offset: int32(i*1000 - 2000)}
+		root.Instances = append(root.Instances, corner)
+	}
+	root.Instances[0].Properties["CornerRadius"] = rbxfile.ValueUDim{Scale: -1, Offset: math.MinInt32}
+	root.Instances[1].Properties["CornerRadius"] = rbxfile.ValueUDim{Scale: 1e30, Offset: math.MaxInt32}
+	buf := encodeRoot(t, Encoder{}, root)
+	got, _, err := Decoder{}.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	for i, inst := range got.Instances {
+		want := root.Instances[i].Properties["CornerRadius"]
+		if v := inst.Properties["CornerRadius"]; v != want {
+			t.Errorf("instance %d: expected %v, got %v", i, want, v)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package rbxl
 
 import (
+	"bytes"
 	"fmt"
+	"reflect"
 	"sort"
+	"time"
 
 	"github.com/robloxapi/rbxfile"
 	"github.com/robloxapi/rbxfile/errors"
@@ -13,6 +16,101 @@ import (
 // codec as closely as possible.
 type robloxCodec struct {
 	Mode Mode
+
+	// PreserveRaw sets whether decoded property values are wrapped in a
+	// RawValue.
+	PreserveRaw bool
+
+	// VerifySharedStrings sets whether the hash of each decoded shared string
+	// is verified against its value.
+	VerifySharedStrings bool
+
+	// MaxDepth is the maximum depth of the decoded instance tree. If 0, then
+	// there is no limit.
+	MaxDepth int
+
+	// Dropped, if not nil, receives each property that is dropped while
+	// decoding.
+	Dropped *[]rbxfile.DroppedProperty
+
+	// DropUnnamed sets whether properties with an empty name are dropped.
+	DropUnnamed bool
+
+	// ClassRemap maps the class name of a decoded instance to a new class
+	// name.
+	ClassRemap map[string]string
+
+	// Instances, if not nil, receives each decoded instance, keyed by its
+	// reference number.
+	Instances map[int32]*rbxfile.Instance
+
+	// Deadline, if not zero, is the time after which decoding fails with
+	// ErrTimeout. It is checked before each chunk is decoded.
+	Deadline time.Time
+
+	// PreserveServices sets whether the IsService flag of instances is
+	// encoded in Model mode.
+	PreserveServices bool
+
+	// OmitCompressionMetadata sets whether the MetadataCompression entry is
+	// excluded from the encoded metadata.
+	OmitCompressionMetadata bool
+
+	// EndContent is the content of the encoded END chunk. If nil, then
+	// `</roblox>` is used.
+	EndContent []byte
+
+	// PropertyOrder, if not nil, reorders the alphabetically sorted names of
+	// the properties of a class.
+	PropertyOrder func(class string, names []string)
+
+	// Defaults, if not nil, returns the default value of a property of a
+	// class. A property is not encoded if every instance of the class has the
+	// default value.
+	Defaults func(class, prop string) (rbxfile.Value, bool)
+
+	// ExcludeMetadata sets whether the META chunk is omitted.
+	ExcludeMetadata bool
+
+	// MetadataPosition determines where the META chunk is written.
+	MetadataPosition MetadataPosition
+
+	// groupByMap sets whether instances are always grouped by class through a
+	// map while encoding, bypassing the path for trees of a single class.
+	groupByMap bool
+}
+
+// RawValue wraps a property value decoded from the binary format, retaining
+// the exact binary representation of the value. When encoded by an Encoder,
+// the original representation is written instead of Value, so that the
+// encoded bytes are identical to the decoded bytes. Other encoders, such as
+// that of the rbxlx package, encode the value returned by Unwrap.
+//
+// Value may be inspected normally, but modifying it has no effect on the
+// encoded result. To change the property, replace the RawValue with a plain
+// value.
+type RawValue struct {
+	rbxfile.Value
+
+	raw value
+}
+
+// Copy returns a copy of the value, retaining the raw representation.
+func (v RawValue) Copy() rbxfile.Value {
+	return RawValue{Value: v.Value.Copy(), raw: v.raw}
+}
+
+// Unwrap returns the wrapped value.
+func (v RawValue) Unwrap() rbxfile.Value {
+	return v.Value
+}
+
+// Bytes returns the binary representation of the value.
+func (v RawValue) Bytes() []byte {
+	if v.raw == nil {
+		return nil
+	}
+	return v.raw.Bytes(nil)
 }
 
 // Reference value indicating a nil instance.
@@ -36,13 +134,30 @@ func (err errBounds) Error() string {
 	return fmt.Sprintf("%s %d out of bounds [0,%d]", err.Kind, err.Index, err.Bounds)
 }
 
+// drop reports a property dropped while decoding.
+func (c robloxCodec) drop(class, prop, reason string) {
+	if c.Dropped != nil {
+		*c.Dropped = append(*c.Dropped, rbxfile.DroppedProperty{Class: class, Property: prop, Reason: reason})
+	}
+}
+
 func (c robloxCodec) Decode(model *formatModel) (root *rbxfile.Root, warn, err error) {
+	root = new(rbxfile.Root)
+	if warn, err = c.DecodeInto(model, root); err != nil {
+		return nil, warn, err
+	}
+	return root, warn, nil
+}
+
+// DecodeInto decodes model into root, reusing the Instances slice and Metadata
+// map of root, as well as the instances of its tree.
+func (c robloxCodec) DecodeInto(model *formatModel, root *rbxfile.Root) (warn, err error) {
 	if model == nil {
 		panic("formatModel is nil")
 	}
 	var warns errors.Errors
 
-	root = new(rbxfile.Root)
+	free := resetRoot(root)
 
 	instLookup := make(map[int32]*rbxfile.Instance, model.InstanceCount+1)
 	instLookup[nilInstance] = nil
@@ -51,26 +166,41 @@ func (c robloxCodec) Decode(model *formatModel) (root *rbxfile.Root, warn, err e
 
 loop:
 	for ic, chunk := range model.Chunks {
+		if !c.Deadline.IsZero() && time.Now().After(c.Deadline) {
+			return warns.Return(), chunkError(ic, chunk, ErrTimeout)
+		}
 		switch chunk := chunk.(type) {
 		case *chunkInstance:
 			if chunk.ClassID < 0 || uint32(chunk.ClassID) >= model.ClassCount {
-				return nil, warns.Return(), chunkError(ic, chunk, errBounds{Kind: "class index", Index: chunk.ClassID, Bounds: model.ClassCount})
+				return warns.Return(), chunkError(ic, chunk, errBounds{Kind: "class index", Index: chunk.ClassID, Bounds: model.ClassCount})
 			}
 			// No error if ClassCount > actual count.
 
 			if chunk.IsService && len(chunk.InstanceIDs) != len(chunk.GetService) {
-				return nil, warns.Return(), chunkError(ic, chunk, fmt.Errorf("GetService array length does not equal InstanceIDs array length"))
+				return warns.Return(), chunkError(ic, chunk, fmt.Errorf("GetService array length does not equal InstanceIDs array length"))
+			}
+			className := chunk.ClassName
+			if to, ok := c.ClassRemap[className]; ok {
+				warns = append(warns, ClassRemapError{From: className, To: to})
+				className = to
 			}
 
 			for i, ref := range chunk.InstanceIDs {
 				if ref < 0 || uint32(ref) >= model.InstanceCount {
-					return nil, warns.Return(), chunkError(ic, chunk, errBounds{Kind: "instance id", Index: ref, Bounds: model.InstanceCount})
+					return warns.Return(), chunkError(ic, chunk, errBounds{Kind: "instance id", Index: ref, Bounds: model.InstanceCount})
 				}
 				// No error if InstanceCount > actual count.
 
-				inst := rbxfile.NewInstance(chunk.ClassName)
+				var inst *rbxfile.Instance
+				if n := len(free); n > 0 {
+					inst = free[n-1]
+					free = free[:n-1]
+					inst.ClassName = className
+				} else {
+					inst = rbxfile.NewInstance(className)
+				}
 				if _, ok := instLookup[ref]; ok {
-					return nil, warns.Return(), chunkError(ic, chunk, fmt.Errorf("duplicate instance id: %d", ref))
+					return warns.Return(), chunkError(ic, chunk, fmt.Errorf("duplicate instance id: %d", ref))
 				}
 
 				if chunk.IsService && chunk.GetService[i] == 1 {
@@ -78,15 +208,18 @@ loop:
 				}
 
 				instLookup[ref] = inst
+				if c.Instances != nil {
+					c.Instances[ref] = inst
+				}
 			}
 
 			if c, ok := model.groupLookup[chunk.ClassID]; !ok || c != chunk {
-				return nil, warns.Return(), chunkError(ic, chunk, fmt.Errorf("invalid class index: %d", chunk.ClassID))
+				return warns.Return(), chunkError(ic, chunk, fmt.Errorf("invalid class index: %d", chunk.ClassID))
 			}
 
 		case *chunkProperty:
 			if chunk.ClassID < 0 || uint32(chunk.ClassID) >= model.ClassCount {
-				return nil, warns.Return(), chunkError(ic, chunk, errBounds{Kind: "class index", Index: chunk.ClassID, Bounds: model.ClassCount})
+				return warns.Return(), chunkError(ic, chunk, errBounds{Kind: "class index", Index: chunk.ClassID, Bounds: model.ClassCount})
 			}
 			// No error if TypeCount > actual count.
 
@@ -96,14 +229,23 @@ loop:
 				continue
 			}
 
+			if chunk.PropertyName == "" {
+				warns = append(warns, chunkError(ic, chunk, ErrEmptyPropertyName))
+				if c.DropUnnamed {
+					c.drop(instChunk.ClassName, chunk.PropertyName, "empty name")
+					continue
+				}
+			}
+
 			if chunk.Properties == nil {
 				warns = chunkWarn(warns, ic, chunk, "no value type")
+				c.drop(instChunk.ClassName, chunk.PropertyName, "no value type")
 				continue
 			}
 
 			length := chunk.Properties.Len()
 			if length != len(instChunk.InstanceIDs) {
-				return nil, warns.Return(), chunkError(ic, chunk, fmt.Errorf("length of properties array (%d) does not equal length of class array (%d)", length, len(instChunk.InstanceIDs)))
+				return warns.Return(), chunkError(ic, chunk, fmt.Errorf("length of properties array (%d) does not equal length of class array (%d)", length, len(instChunk.InstanceIDs)))
 			}
 
 			switch props := chunk.Properties.(type) {
@@ -138,27 +280,30 @@ loop:
 					bvalue := props.Get(i)
 					inst := instLookup[instChunk.InstanceIDs[i]]
 					value := decodeValue(bvalue)
+					if c.PreserveRaw && value != nil {
+						value = RawValue{Value: value, raw: bvalue}
+					}
 					inst.Properties[chunk.PropertyName] = value
 				}
 			}
 
 		case *chunkParent:
-			if chunk.Version != 0 {
-				return nil, warns.Return(), chunkError(ic, chunk, fmt.Errorf("unrecognized parent link format %d", chunk.Version))
+			if _, ok := parentLinkDecoders[chunk.Version]; !ok || chunk.raw != nil {
+				return warns.Return(), chunkError(ic, chunk, fmt.Errorf("unrecognized parent link format %d", chunk.Version))
 			}
 
 			if len(chunk.Parents) != len(chunk.Children) {
-				return nil, warns.Return(), chunkError(ic, chunk, errParentArray{Children: len(chunk.Children), Parent: len(chunk.Parents)})
+				return warns.Return(), chunkError(ic, chunk, errParentArray{Children: len(chunk.Children), Parent: len(chunk.Parents)})
 			}
 
 			for i, ref := range chunk.Children {
 				if ref < 0 || uint32(ref) >= model.InstanceCount {
-					return nil, warns.Return(), chunkError(ic, chunk, errBounds{Kind: "child id", Index: ref, Bounds: model.InstanceCount})
+					return warns.Return(), chunkError(ic, chunk, errBounds{Kind: "child id", Index: ref, Bounds: model.InstanceCount})
 				}
 
 				child := instLookup[ref]
 				if child == nil {
-					warns = chunkWarn(warns, ic, chunk, "child #%d: id %d does not exist", i, ref)
+					warns = append(warns, chunkError(ic, chunk, ReferenceError{Index: i, Ref: ref}))
 					continue
 				}
 
@@ -185,15 +330,154 @@ loop:
 			}
 
 		case *chunkSharedStrings:
+			if chunk.unknown {
+				warns = chunkWarn(warns, ic, chunk, "unrecognized shared string format %d, chunk ignored", chunk.Version)
+				continue
+			}
 			// TODO: How are multiple chunks handled (overwrite or append)?
 			sharedStrings = chunk.Values
+			if c.VerifySharedStrings {
+				for i, ss := range chunk.Values {
+					if !ss.verifyHash() {
+						warns = chunkWarn(warns, ic, chunk, "shared string #%d: hash does not match value", i)
+					}
+				}
+			}
+
+		case *chunkErrored:
+			// The error was emitted as a warning while reading the chunk.
+			if prop, ok := chunk.chunk.(*chunkProperty); ok && prop.PropertyName != "" {
+				if instChunk, ok := model.groupLookup[prop.ClassID]; ok {
+					c.drop(instChunk.ClassName, prop.PropertyName, chunk.Cause.Error())
+				}
+			}
 
 		case *chunkEnd:
 			break loop
 		}
 	}
 
-	return root, warns.Return(), nil
+	if c.MaxDepth > 0 {
+		if err := checkDepth(root.Instances, c.MaxDepth); err != nil {
+			return warns.Return(), err
+		}
+	}
+
+	return warns.Return(), nil
+}
+
+// checkDepth returns an error if the tree of insts is deeper than max, where
+// insts have a depth of 1. The tree is traversed without recursion, so that a
+// deep tree cannot exhaust the stack.
+func checkDepth(insts []*rbxfile.Instance, max int) error {
+	type entry struct {
+		inst  *rbxfile.Instance
+		depth int
+	}
+	stack := make([]entry, 0, len(insts))
+	for _, inst := range insts {
+		stack = append(stack, entry{inst, 1})
+	}
+	for len(stack) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if e.depth > max {
+			return errMaxDepth{Limit: max}
+		}
+		for _, child := range e.inst.Children {
+			stack = append(stack, entry{child, e.depth + 1})
+		}
+	}
+	return nil
+}
+
+// resetRoot clears the content of root, retaining allocated memory. Returns
+// each distinct instance of the tree of root, cleared so that it can be reused
+// as a new instance with an empty Properties map.
+func resetRoot(root *rbxfile.Root) (free []*rbxfile.Instance) {
+	stack := append([]*rbxfile.Instance{}, root.Instances...)
+	for len(stack) > 0 {
+		inst := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		// A cleared instance has an empty class name, so an instance that
+		// appears more than once in the tree is returned only once. An
+		// instance that already has an empty class name is not reused.
+		if inst == nil || inst.ClassName == "" {
+			continue
+		}
+		stack = append(stack, inst.Children...)
+
+		inst.ClassName = ""
+		inst.Reference = ""
+		inst.IsService = false
+		if inst.Properties == nil {
+			inst.Properties = make(map[string]rbxfile.Value, 0)
+		}
+		for k := range inst.Properties {
+			delete(inst.Properties, k)
+		}
+		for i := range inst.Children {
+			inst.Children[i] = nil
+		}
+		inst.Children = inst.Children[:0]
+		inst.Metadata = nil
+		free = append(free, inst)
+	}
+
+	for i := range root.Instances {
+		root.Instances[i] = nil
+	}
+	root.Instances = root.Instances[:0]
+	for k := range root.Metadata {
+		delete(root.Metadata, k)
+	}
+	return free
+}
+
+// EncodeValue returns the binary representation of v, as it appears within the
+// values of a PROP chunk, before interleaving. The string types String,
+// BinaryString, ProtectedString, and Content share one representation. A
+// RawValue returns its retained representation.
+//
+// Returns an error for Reference, ContentObject, SharedString, and Optional
+// values, which are encoded relative to other values of a chunk or file, and
+// for values of types not supported by the format.
+func EncodeValue(v rbxfile.Value) ([]byte, error) {
+	if v == nil {
+		return nil, errors.New("nil value")
+	}
+	bv := encodeValue(v)
+	if bv == nil {
+		return nil, fmt.Errorf("cannot encode %s value", v.Type())
+	}
+	return bv.Bytes(nil), nil
+}
+
+// DecodeValue decodes a value of type t from the beginning of b, which has the
+// form returned by EncodeValue. Returns the value, and the number of bytes
+// read from b.
+//
+// Returns an error for the Reference, SharedString, and Optional types, and for
+// types not supported by the format.
+func DecodeValue(t rbxfile.Type, b []byte) (v rbxfile.Value, n int, err error) {
+	var bv value
+	switch id := fromValueType(t); id {
+	case typeReference, typeSharedString, typeOptional:
+	default:
+		bv = newValue(id)
+	}
+	if bv == nil {
+		return nil, 0, fmt.Errorf("cannot decode %s value", t)
+	}
+	if n, err = bv.FromBytes(b); err != nil {
+		return nil, n, ValueError{Type: byte(bv.Type()), Cause: err}
+	}
+	v = decodeValue(bv)
+	if v.Type() != t {
+		// Only the string types share a representation.
+		v, _, _ = coerceValue(v, t)
+	}
+	return v, n, nil
 }
 
 // decodeValue converts a Value to a rbxfile.Value. Returns nil if the value
@@ -299,7 +583,7 @@ func decodeValue(val value) rbxfile.Value {
 		}
 
 		if value.Special != 0 {
-			cf.Rotation = cframeSpecialMatrix[value.Special]
+			cf.Rotation, _ = rbxfile.CFrameSpecialMatrix(value.Special)
 		}
 
 		return cf
@@ -316,7 +600,7 @@ func decodeValue(val value) rbxfile.Value {
 		}
 
 		if v.Special != 0 {
-			cf.Rotation = cframeSpecialMatrix[v.Special]
+			cf.Rotation, _ = rbxfile.CFrameSpecialMatrix(v.Special)
 		}
 
 		return cf
@@ -411,6 +695,15 @@ func decodeValue(val value) rbxfile.Value {
 			CachedFaceId: rbxfile.ValueContent(value.CachedFaceId),
 		}
 
+	case *valueSecurityCapabilities:
+		return rbxfile.ValueSecurityCapabilities(*value)
+
+	case *valueCustom:
+		if value.v == nil {
+			return nil
+		}
+		return value.v
+
 	default:
 		return nil
 	}
@@ -424,91 +717,139 @@ type sharedEntry struct {
 type sharedMap map[[16]byte]sharedEntry
 
 func (c robloxCodec) Encode(root *rbxfile.Root) (model *formatModel, warn, err error) {
-	if root == nil {
-		return nil, nil, errors.New("Root is nil")
-	}
-
 	model = new(formatModel)
-	var warns errors.Errors
-
-	// A list of instances in the tree. The index serves as the instance's
-	// reference number.
-	instList := make([]*rbxfile.Instance, 0)
-
-	// A map used to ensure that an instance is counted only once. Also used
-	// to link valueReferences.
-	refs := map[*rbxfile.Instance]int{
-		nil: nilInstance,
+	warn, err = c.EncodeChunks(root,
+		func(classCount, instanceCount uint32) error {
+			model.ClassCount = classCount
+			model.InstanceCount = instanceCount
+			return nil
+		},
+		func(chunk chunk) error {
+			model.Chunks = append(model.Chunks, chunk)
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, warn, err
 	}
+	return model, warn, nil
+}
 
-	// Set of shared strings mapped to indexes.
-	sharedStrings := sharedMap{}
-
-	// Recursively finds and adds instances.
-	var addInstance func(inst *rbxfile.Instance)
-	addInstance = func(inst *rbxfile.Instance) {
-		if _, ok := refs[inst]; ok {
-			// Ignore the instance if it has already been read.
-			return
+// singleClass returns whether insts is not empty, and each instance has the
+// same ClassName.
+func singleClass(insts []*rbxfile.Instance) bool {
+	if len(insts) == 0 {
+		return false
+	}
+	className := insts[0].ClassName
+	for _, inst := range insts[1:] {
+		if inst.ClassName != className {
+			return false
 		}
+	}
+	return true
+}
 
-		// Reference number should match position in list.
-		refs[inst] = len(instList)
-		instList = append(instList, inst)
+// addToInstChunk adds inst, with reference number ref, to chunk.
+func (c robloxCodec) addToInstChunk(chunk *chunkInstance, ref int, inst *rbxfile.Instance) {
+	chunk.InstanceIDs = append(chunk.InstanceIDs, int32(ref))
 
-		for _, child := range inst.Children {
-			addInstance(child)
-		}
+	if (c.Mode == Place || c.PreserveServices) && inst.IsService {
+		chunk.IsService = true
+		chunk.GetService = append(chunk.GetService, 1)
+	} else {
+		chunk.GetService = append(chunk.GetService, 0)
 	}
+}
 
+// propPlan describes a property chunk to be encoded.
+type propPlan struct {
+	chunk      *chunkProperty
+	propType   typeID
+	optionType typeID
+}
+
+// EncodeChunks encodes root into chunks, in the order they appear in the
+// file. header is called with the number of classes and instances before any
+// chunk is produced. emit is called with each chunk as it is produced; the
+// chunk is not retained by the codec after emit returns. An error returned by
+// header or emit stops the encoding and is returned.
+// referenceList returns a list of the instances in the tree of root, where the
+// index of an instance serves as its reference number, along with a map of each
+// instance to its reference number. The map also maps nil to nilInstance.
+//
+// Instances are numbered in depth-first order, with a parent numbered before
+// its children. An instance that appears more than once in the tree is
+// numbered only once, at its first appearance.
+func referenceList(root *rbxfile.Root) (instList []*rbxfile.Instance, refs map[*rbxfile.Instance]int) {
 	// For RBXL, each instance in the Root is an instance in the DataModel.
 	// For RBXM, each instance in the Root is an instance in the selection.
-	for _, inst := range root.Instances {
-		addInstance(inst)
+	instList, refs = root.Flatten()
+	// Also used to link valueReferences.
+	refs[nil] = nilInstance
+	return instList, refs
+}
+
+func (c robloxCodec) EncodeChunks(root *rbxfile.Root, header func(classCount, instanceCount uint32) error, emit func(chunk) error) (warn, err error) {
+	if root == nil {
+		return nil, errors.New("Root is nil")
 	}
 
-	// Group instances of the same ClassName into single chunks.
-	instChunkMap := map[string]*chunkInstance{}
-	for ref, inst := range instList {
+	var warns errors.Errors
 
-		chunk, ok := instChunkMap[inst.ClassName]
-		if !ok {
-			chunk = &chunkInstance{
-				compressed:  true,
-				ClassName:   inst.ClassName,
-				InstanceIDs: []int32{},
-			}
-			instChunkMap[inst.ClassName] = chunk
+	instList, refs := referenceList(root)
+
+	// Group instances of the same ClassName into single chunks.
+	var instChunkList sortInstChunks
+	if !c.groupByMap && singleClass(instList) {
+		// Every instance has the same class, so the one chunk can be built
+		// directly.
+		chunk := &chunkInstance{
+			compressed:  true,
+			ClassName:   instList[0].ClassName,
+			InstanceIDs: make([]int32, 0, len(instList)),
+			GetService:  make([]byte, 0, len(instList)),
 		}
+		for ref, inst := range instList {
+			c.addToInstChunk(chunk, ref, inst)
+		}
+		instChunkList = sortInstChunks{chunk}
+	} else {
+		instChunkMap := map[string]*chunkInstance{}
+		for ref, inst := range instList {
 
-		chunk.InstanceIDs = append(chunk.InstanceIDs, int32(ref))
+			chunk, ok := instChunkMap[inst.ClassName]
+			if !ok {
+				chunk = &chunkInstance{
+					compressed:  true,
+					ClassName:   inst.ClassName,
+					InstanceIDs: []int32{},
+				}
+				instChunkMap[inst.ClassName] = chunk
+			}
 
-		if c.Mode == Place && inst.IsService {
-			chunk.IsService = true
-			chunk.GetService = append(chunk.GetService, 1)
-		} else {
-			chunk.GetService = append(chunk.GetService, 0)
+			c.addToInstChunk(chunk, ref, inst)
 		}
-	}
 
-	// Sort chunks by ClassName.
-	instChunkList := make(sortInstChunks, len(instChunkMap))
-	if len(instChunkMap) > 0 {
-		classID := 0
-		for _, chunk := range instChunkMap {
-			instChunkList[classID] = chunk
-			classID++
-		}
+		// Sort chunks by ClassName.
+		instChunkList = make(sortInstChunks, len(instChunkMap))
+		if len(instChunkMap) > 0 {
+			classID := 0
+			for _, chunk := range instChunkMap {
+				instChunkList[classID] = chunk
+				classID++
+			}
 
-		sort.Sort(instChunkList)
+			sort.Sort(instChunkList)
+		}
 	}
 
-	// Make property chunks.
-	propChunkList := []*chunkProperty{}
+	// Determine property chunks.
+	propPlans := make([][]propPlan, len(instChunkList))
 	for i, instChunk := range instChunkList {
 		instChunk.ClassID = int32(i)
 
-		propChunkMap := map[string]*chunkProperty{}
+		propChunkMap := map[string]*propPlan{}
 		// Populate propChunkMap.
 		for _, ref := range instChunk.InstanceIDs {
 			for name := range instList[ref].Properties {
@@ -516,20 +857,34 @@ func (c robloxCodec) Encode(root *rbxfile.Root) (model *formatModel, warn, err e
 					// A chunk of the property name already exists.
 					continue
 				}
-				propChunkMap[name] = &chunkProperty{
+				propChunkMap[name] = &propPlan{chunk: &chunkProperty{
 					compressed:   true,
 					ClassID:      instChunk.ClassID,
 					PropertyName: name,
+				}}
+			}
+		}
+
+		// Omit properties that have the default value in every instance.
+		if c.Defaults != nil {
+			for name := range propChunkMap {
+				if def, ok := c.Defaults(instChunk.ClassName, name); ok && allDefault(instList, instChunk.InstanceIDs, name, def) {
+					delete(propChunkMap, name)
 				}
 			}
 		}
 
 		// Check to see if all existing properties types match.
 	checkPropType:
-		for name, propChunk := range propChunkMap {
+		for name, plan := range propChunkMap {
 			var instRef int32 = nilInstance
 			propType := typeInvalid
 			optionType := typeInvalid
+			// The types of the first value. Values of other types that
+			// share the same data type are encoded as the data type, and
+			// are reported.
+			var valueType, innerType rbxfile.Type
+			var coerced errors.Errors
 			for _, ref := range instChunk.InstanceIDs {
 				inst := instList[ref]
 				prop, ok := inst.Properties[name]
@@ -540,7 +895,8 @@ func (c robloxCodec) Encode(root *rbxfile.Root) (model *formatModel, warn, err e
 					// Set data type to the first valid property.
 					propType = fromValueType(prop.Type())
 					if propType == typeInvalid {
-						warns = chunkWarn(warns, i, instChunk, "unknown type %d for property %s.%s in instance #%d, chunk skipped", byte(prop.Type()), instList[instRef].ClassName, name, instRef)
+						delete(propChunkMap, name)
+						warns = chunkWarn(warns, i, instChunk, "unknown type %d for property %s.%s in instance #%d, chunk skipped", byte(prop.Type()), instList[ref].ClassName, name, ref)
 						continue checkPropType
 					}
 					if c.Mode != Place && propType == typeUniqueId {
@@ -551,10 +907,13 @@ func (c robloxCodec) Encode(root *rbxfile.Root) (model *formatModel, warn, err e
 					if opt, ok := prop.(rbxfile.ValueOptional); ok {
 						optionType = fromValueType(opt.ValueType())
 						if optionType == typeInvalid {
-							warns = chunkWarn(warns, i, instChunk, "unknown type %d for optional in property %s.%s in instance #%d, chunk skipped", byte(opt.ValueType()), instList[instRef].ClassName, name, instRef)
+							delete(propChunkMap, name)
+							warns = chunkWarn(warns, i, instChunk, "unknown type %d for optional in property %s.%s in instance #%d, chunk skipped", byte(opt.ValueType()), instList[ref].ClassName, name, ref)
 							continue checkPropType
 						}
+						innerType = opt.ValueType()
 					}
+					valueType = prop.Type()
 					instRef = ref
 					continue
 				}
@@ -562,94 +921,149 @@ func (c robloxCodec) Encode(root *rbxfile.Root) (model *formatModel, warn, err e
 					// If at least one property type does not match with the
 					// rest, then stop.
 					delete(propChunkMap, name)
-					warns = chunkWarn(warns, i, instChunk, "mismatched types %s and %s for property %s.%s, chunk skipped", t, propType, instList[instRef].ClassName, name)
+					warns = append(warns, chunkError(i, instChunk, TypeMismatchError{Class: instList[instRef].ClassName, Property: name, Type: prop.Type(), Expected: valueType}))
 					continue checkPropType
 				}
 				if propType == typeOptional {
 					if opt, ok := prop.(rbxfile.ValueOptional); ok {
 						if t := fromValueType(opt.ValueType()); t != optionType {
-							warns = chunkWarn(warns, i, instChunk, "mismatched optional types %s and %s for property %s.%s, chunk skipped", t, optionType, instList[instRef].ClassName, name)
+							delete(propChunkMap, name)
+							warns = append(warns, chunkError(i, instChunk, TypeMismatchError{Class: instList[instRef].ClassName, Property: name, Optional: true, Type: opt.ValueType(), Expected: innerType}))
 							continue checkPropType
 						}
+						if t := opt.ValueType(); t != innerType {
+							coerced = chunkWarn(coerced, i, instChunk, "property %s.%s in instance #%d has optional type %s, which differs from optional type %s of instance #%d", inst.ClassName, name, ref, t, innerType, instRef)
+						}
 					}
+				} else if t := prop.Type(); t != valueType {
+					coerced = chunkWarn(coerced, i, instChunk, "property %s.%s in instance #%d has type %s, which differs from type %s of instance #%d", inst.ClassName, name, ref, t, valueType, instRef)
 				}
 			}
+			warns = append(warns, coerced...)
 			// Because propChunkMap was populated from InstanceIDs, propType
 			// should always be a valid value by this point.
-			if propType == typeOptional {
-				propChunk.Properties = &arrayOptional{
-					Values:  newArray(optionType, len(instChunk.InstanceIDs)),
-					Present: make(arrayBool, len(instChunk.InstanceIDs)),
-				}
-			} else {
-				propChunk.Properties = newArray(propType, len(instChunk.InstanceIDs))
-			}
+			plan.propType = propType
+			plan.optionType = optionType
 		}
 
 		// Sort the chunks by PropertyName.
-		propChunks := make(sortPropChunks, len(propChunkMap))
-		if len(propChunkMap) > 0 {
-			i := 0
-			for _, chunk := range propChunkMap {
-				propChunks[i] = chunk
-				i++
+		plans := make(sortPropPlans, 0, len(propChunkMap))
+		for _, plan := range propChunkMap {
+			plans = append(plans, *plan)
+		}
+		sort.Sort(plans)
+		if c.PropertyOrder != nil {
+			var ok bool
+			if plans, ok = c.orderPropPlans(instChunk.ClassName, plans); !ok {
+				warns = chunkWarn(warns, i, instChunk, "invalid property order for class %s, sorted by name", instChunk.ClassName)
 			}
-
-			sort.Sort(propChunks)
 		}
+		propPlans[i] = plans
+	}
 
-		// Set the values for each property chunk.
-		for _, propChunk := range propChunks {
-			for i, ref := range instChunk.InstanceIDs {
-				inst := instList[ref]
-
-				var bvalue value
-				if value, ok := inst.Properties[propChunk.PropertyName]; ok {
-					switch value := value.(type) {
-					case rbxfile.ValueReference:
-						// Convert an instance reference to a reference number.
-						ref, ok := refs[value.Instance]
-						if !ok {
-							// References that map to some instance not under the
-							// Root should be nil.
-							ref = nilInstance
-						}
-
-						v := int32(ref)
-						bvalue = (*valueReference)(&v)
-					case rbxfile.ValueSharedString:
-						// TODO: verify that strings are compared by hash.
-						sum := blake2b.Sum256([]byte(value))
-						var hash [16]byte
-						copy(hash[:], sum[:])
-						entry, ok := sharedStrings[hash]
-						if !ok {
-							entry.index = len(sharedStrings)
+	// Set of shared strings mapped to indexes. Shared strings are collected
+	// before any property chunk is made, because the shared string chunk
+	// precedes the property chunks.
+	sharedStrings := sharedMap{}
+	for i, instChunk := range instChunkList {
+		for _, plan := range propPlans[i] {
+			if plan.propType != typeSharedString {
+				continue
+			}
+			for _, ref := range instChunk.InstanceIDs {
+				value, ok := instList[ref].Properties[plan.chunk.PropertyName].(rbxfile.ValueSharedString)
+				if !ok {
+					continue
+				}
+				// TODO: verify that strings are compared by hash.
+				sum := blake2b.Sum256([]byte(value))
+				var hash [16]byte
+				copy(hash[:], sum[:])
+				if _, ok := sharedStrings[hash]; !ok {
+					sharedStrings[hash] = sharedEntry{
+						index: len(sharedStrings),
+						value: sharedString{
 							// No longer used; Roblox encodes with zeros.
-							entry.value.Hash = [16]byte{}
-							entry.value.Value = []byte(value)
-							sharedStrings[hash] = entry
-						}
-						index := uint32(entry.index)
-						bvalue = (*valueSharedString)(&index)
-					case rbxfile.ValueOptional:
-						propChunk.Properties.Set(i, encodeValue(value.Value()))
-						continue
-					default:
-						bvalue = encodeValue(value)
+							Hash:  [16]byte{},
+							Value: []byte(value),
+						},
 					}
 				}
+			}
+		}
+	}
 
-				if bvalue == nil || bvalue.Type() != propChunk.Properties.Type() {
-					// Use default value for DataType.
-					bvalue = newValue(propChunk.Properties.Type())
-				}
+	if err := header(uint32(len(instChunkList)), uint32(len(instList))); err != nil {
+		return warns.Return(), err
+	}
 
-				propChunk.Properties.Set(i, bvalue)
+	var metaChunk *chunkMeta
+	metaCount := len(root.Metadata)
+	if _, ok := root.Metadata[MetadataCompression]; ok && c.OmitCompressionMetadata {
+		metaCount--
+	}
+	if metaCount > 0 && !c.ExcludeMetadata {
+		// TODO: verify that chunk is omitted when zero values are encoded, and
+		// is not based on format (RBXM vs RBXL).
+		metaChunk = &chunkMeta{
+			compressed: true,
+			Values:     make([][2]string, 0, metaCount),
+		}
+		for key, value := range root.Metadata {
+			if key == MetadataCompression && c.OmitCompressionMetadata {
+				continue
 			}
+			metaChunk.Values = append(metaChunk.Values, [2]string{key, value})
+		}
+		sort.Sort(sortMetaData(metaChunk.Values))
+	}
+	if metaChunk != nil && c.MetadataPosition != MetadataLast {
+		if err := emit(metaChunk); err != nil {
+			return warns.Return(), err
 		}
+	}
 
-		propChunkList = append(propChunkList, propChunks...)
+	if len(sharedStrings) > 0 {
+		chunk := chunkSharedStrings{
+			compressed: true,
+			Version:    0,
+			Values:     make([]sharedString, len(sharedStrings)),
+		}
+		for _, entry := range sharedStrings {
+			chunk.Values[entry.index] = entry.value
+		}
+		if err := emit(&chunk); err != nil {
+			return warns.Return(), err
+		}
+	}
+
+	for _, chunk := range instChunkList {
+		if err := emit(chunk); err != nil {
+			return warns.Return(), err
+		}
+	}
+
+	// Make property chunks.
+	for i, instChunk := range instChunkList {
+		for j, plan := range propPlans[i] {
+			propChunk := plan.chunk
+			if plan.propType == typeOptional {
+				propChunk.Properties = &arrayOptional{
+					Values:  newArray(plan.optionType, len(instChunk.InstanceIDs)),
+					Present: make(arrayBool, len(instChunk.InstanceIDs)),
+				}
+			} else {
+				propChunk.Properties = newArray(plan.propType, len(instChunk.InstanceIDs))
+			}
+			c.setPropertyValues(propChunk, instChunk, instList, refs, sharedStrings)
+			err := emit(propChunk)
+			// Release the chunk so that only one property chunk is retained
+			// while streaming.
+			propPlans[i][j].chunk = nil
+			if err != nil {
+				return warns.Return(), err
+			}
+		}
 	}
 
 	// Make parent chunk.
@@ -685,58 +1099,88 @@ func (c robloxCodec) Encode(root *rbxfile.Root) (model *formatModel, warn, err e
 	// Make end chunk.
 	endChunk := &chunkEnd{
 		compressed: false,
-		Content:    []byte("</roblox>"),
+		Content:    c.EndContent,
 	}
-
-	// Make FormatModel.
-	model.ClassCount = uint32(len(instChunkList))
-	model.InstanceCount = uint32(len(instList))
-
-	chunkLength := len(instChunkList) + len(propChunkList) + 1
-	if len(root.Metadata) > 0 {
-		chunkLength++
-	}
-	if len(sharedStrings) > 0 {
-		chunkLength++
+	if endChunk.Content == nil {
+		endChunk.Content = []byte("</roblox>")
 	}
-	model.Chunks = make([]chunk, 0, chunkLength)
 
-	if len(root.Metadata) > 0 {
-		// TODO: verify that chunk is omitted when zero values are encoded, and
-		// is not based on format (RBXM vs RBXL).
-		chunk := chunkMeta{
-			compressed: true,
-			Values:     make([][2]string, 0, len(root.Metadata)),
-		}
-		for key, value := range root.Metadata {
-			chunk.Values = append(chunk.Values, [2]string{key, value})
+	if err := emit(parentChunk); err != nil {
+		return warns.Return(), err
+	}
+	if metaChunk != nil && c.MetadataPosition == MetadataLast {
+		if err := emit(metaChunk); err != nil {
+			return warns.Return(), err
 		}
-		sort.Sort(sortMetaData(chunk.Values))
-		model.Chunks = append(model.Chunks, &chunk)
+	}
+	if err := emit(endChunk); err != nil {
+		return warns.Return(), err
 	}
 
-	if len(sharedStrings) > 0 {
-		chunk := chunkSharedStrings{
-			compressed: true,
-			Version:    0,
-			Values:     make([]sharedString, len(sharedStrings)),
+	return warns.Return(), nil
+}
+
+// setPropertyValues sets the values of propChunk from the properties of the
+// instances in instChunk.
+func (c robloxCodec) setPropertyValues(propChunk *chunkProperty, instChunk *chunkInstance, instList []*rbxfile.Instance, refs map[*rbxfile.Instance]int, sharedStrings sharedMap) {
+	for i, ref := range instChunk.InstanceIDs {
+		inst := instList[ref]
+
+		var bvalue value
+		if value, ok := inst.Properties[propChunk.PropertyName]; ok {
+			switch value := value.(type) {
+			case rbxfile.ValueReference, rbxfile.ValueContentObject:
+				// Convert an instance reference to a reference number.
+				var target *rbxfile.Instance
+				if v, ok := value.(rbxfile.ValueReference); ok {
+					target = v.Instance
+				} else {
+					target = value.(rbxfile.ValueContentObject).Instance
+				}
+				ref, ok := refs[target]
+				if !ok {
+					// References that map to some instance not under the
+					// Root should be nil.
+					ref = nilInstance
+				}
+
+				v := int32(ref)
+				bvalue = (*valueReference)(&v)
+			case rbxfile.ValueSharedString:
+				// Entries were collected by EncodeChunks.
+				sum := blake2b.Sum256([]byte(value))
+				var hash [16]byte
+				copy(hash[:], sum[:])
+				index := uint32(sharedStrings[hash].index)
+				bvalue = (*valueSharedString)(&index)
+			case rbxfile.ValueOptional:
+				// A missing inner value is encoded as not present. An
+				// inner value that cannot be encoded is present with
+				// the default value of the type.
+				inner := value.Value()
+				if inner == nil {
+					propChunk.Properties.Set(i, nil)
+					continue
+				}
+				values := propChunk.Properties.(*arrayOptional).Values
+				bvalue = encodeValue(inner)
+				if bvalue == nil || bvalue.Type() != values.Type() {
+					bvalue = newValue(values.Type())
+				}
+				propChunk.Properties.Set(i, bvalue)
+				continue
+			default:
+				bvalue = encodeValue(value)
+			}
 		}
-		for _, entry := range sharedStrings {
-			chunk.Values[entry.index] = entry.value
+
+		if bvalue == nil || bvalue.Type() != propChunk.Properties.Type() {
+			// Use default value for DataType.
+			bvalue = newValue(propChunk.Properties.Type())
 		}
-		model.Chunks = append(model.Chunks, &chunk)
-	}
 
-	for _, chunk := range instChunkList {
-		model.Chunks = append(model.Chunks, chunk)
-	}
-	for _, chunk := range propChunkList {
-		model.Chunks = append(model.Chunks, chunk)
+		propChunk.Properties.Set(i, bvalue)
 	}
-	model.Chunks = append(model.Chunks, parentChunk)
-	model.Chunks = append(model.Chunks, endChunk)
-
-	return model, warns.Return(), nil
 }
 
 type sortInstChunks []*chunkInstance
@@ -751,15 +1195,61 @@ func (c sortInstChunks) Swap(i, j int) {
 	c[i], c[j] = c[j], c[i]
 }
 
-type sortPropChunks []*chunkProperty
+// allDefault returns whether each instance of refs has property name with a
+// value equal to def.
+func allDefault(instList []*rbxfile.Instance, refs []int32, name string, def rbxfile.Value) bool {
+	for _, ref := range refs {
+		v, ok := instList[ref].Properties[name]
+		if !ok || !equalValue(v, def) {
+			return false
+		}
+	}
+	return true
+}
 
-func (c sortPropChunks) Len() int {
+// equalValue returns whether a and b are equal. Values are compared by their
+// binary representation, if they have one, so that values such as negative
+// and positive zero are distinct.
+func equalValue(a, b rbxfile.Value) bool {
+	if ea, eb := encodeValue(a), encodeValue(b); ea != nil && eb != nil {
+		return ea.Type() == eb.Type() && bytes.Equal(ea.Bytes(nil), eb.Bytes(nil))
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// orderPropPlans reorders plans, which are sorted by name, according to the
+// PropertyOrder of the codec. Returns plans unchanged and false if the order
+// is not a permutation of the names of plans.
+func (c robloxCodec) orderPropPlans(class string, plans sortPropPlans) (sortPropPlans, bool) {
+	names := make([]string, len(plans))
+	index := make(map[string]int, len(plans))
+	for i, plan := range plans {
+		names[i] = plan.chunk.PropertyName
+		index[plan.chunk.PropertyName] = i
+	}
+	c.PropertyOrder(class, names)
+	ordered := make(sortPropPlans, 0, len(plans))
+	for _, name := range names {
+		i, ok := index[name]
+		if !ok {
+			// Unknown or repeated name.
+			return plans, false
+		}
+		delete(index, name)
+		ordered = append(ordered, plans[i])
+	}
+	return ordered, true
+}
+
+type sortPropPlans []propPlan
+
+func (c sortPropPlans) Len() int {
 	return len(c)
 }
-func (c sortPropChunks) Less(i, j int) bool {
-	return c[i].PropertyName < c[j].PropertyName
+func (c sortPropPlans) Less(i, j int) bool {
+	return c[i].chunk.PropertyName < c[j].chunk.PropertyName
 }
-func (c sortPropChunks) Swap(i, j int) {
+func (c sortPropPlans) Swap(i, j int) {
 	c[i], c[j] = c[j], c[i]
 }
 
@@ -790,6 +1280,12 @@ func (c sortMetaData) Swap(i, j int) {
 // information in order to encode, return nil.
 func encodeValue(val rbxfile.Value) value {
 	switch value := val.(type) {
+	case RawValue:
+		if value.raw == nil {
+			return encodeValue(value.Value)
+		}
+		return value.raw
+
 	case rbxfile.ValueString:
 		v := make([]byte, len(value))
 		copy(v, value)
@@ -884,7 +1380,7 @@ func encodeValue(val rbxfile.Value) value {
 			},
 		}
 
-		if s, ok := cframeSpecialNumber[value.Rotation]; ok {
+		if s, ok := rbxfile.CFrameSpecialID(value.Rotation); ok {
 			cf.Special = s
 		} else {
 			cf.Rotation = value.Rotation
@@ -994,6 +1490,16 @@ func encodeValue(val rbxfile.Value) value {
 			CachedFaceId: valueString(value.CachedFaceId),
 		}
 
+	case rbxfile.ValueSecurityCapabilities:
+		return (*valueSecurityCapabilities)(&value)
+
+	case CustomValueEncoder:
+		id := customTypeOf(value.Type())
+		if id == typeInvalid {
+			return nil
+		}
+		return &valueCustom{id: id, raw: value.Bytes(nil), v: value}
+
 	default:
 		return nil
 	}
@@ -0,0 +1,188 @@
+package rbxl
+
+import (
+	"bufio"
+	"fmt"
+
+	"github.com/robloxapi/rbxfile"
+)
+
+// CustomValue is a value of a type that is not natively supported by the
+// binary codec. A CustomValue is decoded from the bytes of a single value, and
+// is emitted as-is in the decoded instance.
+type CustomValue interface {
+	rbxfile.Value
+
+	// FromBytes decodes the value from the beginning of b. Returns an error if
+	// the value could not be decoded. Otherwise, returns the number of bytes
+	// read from b.
+	FromBytes(b []byte) (n int, err error)
+}
+
+// CustomValueEncoder is a CustomValue that can also be encoded.
+type CustomValueEncoder interface {
+	CustomValue
+
+	// Bytes encodes the value, appending it to b. Returns the extended
+	// buffer. The result must be readable by FromBytes.
+	Bytes(b []byte) []byte
+}
+
+// customValueTypes maps a type identifier to a function that returns a new
+// value of the type.
+var customValueTypes = map[typeID]func() CustomValue{}
+
+// RegisterValueType registers a custom value type for the given type
+// identifier, allowing properties of an otherwise unknown type to be decoded.
+// For each value of the type, factory is called to create a new CustomValue,
+// which then decodes itself from the bytes of the value. Values of the type
+// are not interleaved. Panics if id is zero or the identifier of a built-in
+// type, or if factory is nil.
+//
+// If the values returned by factory implement CustomValueEncoder, then the
+// encoder writes properties holding values of the same rbxfile.Type as the
+// custom type id. Such a Type should not be one already supported by the
+// codec. Properties of custom values that cannot be encoded are skipped by the
+// encoder with a warning.
+//
+// RegisterValueType is not safe to call concurrently with decoding, and should
+// be called during initialization.
+func RegisterValueType(id byte, factory func() CustomValue) {
+	t := typeID(id)
+	if t == typeInvalid || t.builtin() {
+		panic(fmt.Sprintf("rbxl: cannot register built-in type %s", t))
+	}
+	if factory == nil {
+		panic("rbxl: nil factory")
+	}
+	customValueTypes[t] = factory
+}
+
+// builtin returns whether t is a type natively supported by the codec.
+func (t typeID) builtin() bool {
+	return typeString <= t && t <= typeSecurityCapabilities && t != typeSignedString
+}
+
+// custom returns whether t is a registered custom type.
+func (t typeID) custom() bool {
+	_, ok := customValueTypes[t]
+	return ok
+}
+
+// customTypeOf returns the identifier of the registered custom type that
+// encodes values of type t, or typeInvalid if there is no such type. If
+// several identifiers match, the lowest is returned.
+func customTypeOf(t rbxfile.Type) typeID {
+	id := typeInvalid
+	for c, factory := range customValueTypes {
+		v := factory()
+		if _, ok := v.(CustomValueEncoder); !ok || v.Type() != t {
+			continue
+		}
+		if id == typeInvalid || c < id {
+			id = c
+		}
+	}
+	return id
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// valueCustom wraps a CustomValue, retaining the bytes from which it was
+// decoded.
+type valueCustom struct {
+	id  typeID
+	raw []byte
+	v   CustomValue
+}
+
+func (v valueCustom) Type() typeID {
+	return v.id
+}
+
+func (v valueCustom) BytesLen() int {
+	return len(v.bytes())
+}
+
+func (v valueCustom) Bytes(b []byte) []byte {
+	return append(b, v.bytes()...)
+}
+
+// bytes returns the encoded value. An empty value is encoded as the value
+// returned by the factory of the type.
+func (v valueCustom) bytes() []byte {
+	if v.raw != nil || v.v != nil {
+		return v.raw
+	}
+	if factory := customValueTypes[v.id]; factory != nil {
+		if cv, ok := factory().(CustomValueEncoder); ok {
+			return cv.Bytes(nil)
+		}
+	}
+	return nil
+}
+
+func (v *valueCustom) FromBytes(b []byte) (n int, err error) {
+	factory := customValueTypes[v.id]
+	if factory == nil {
+		return 0, errUnknownType(v.id)
+	}
+	cv := factory()
+	if n, err = cv.FromBytes(b); err != nil {
+		return n, err
+	}
+	if n < 0 || n > len(b) {
+		return 0, fmt.Errorf("custom type %s: read %d bytes of %d", v.id, n, len(b))
+	}
+	v.raw = make([]byte, n)
+	copy(v.raw, b[:n])
+	v.v = cv
+	return n, nil
+}
+
+func (v valueCustom) Dump(w *bufio.Writer, indent int) {
+	if v.v == nil {
+		w.WriteString("<nil>")
+		return
+	}
+	w.WriteString(v.v.String())
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type arrayCustom struct {
+	id     typeID
+	values []valueCustom
+}
+
+func (a arrayCustom) Type() typeID {
+	return a.id
+}
+
+func (a arrayCustom) Len() int {
+	return len(a.values)
+}
+
+func (a arrayCustom) Get(i int) value {
+	v := a.values[i]
+	return &v
+}
+
+func (a arrayCustom) Set(i int, v value) {
+	a.values[i] = *v.(*valueCustom)
+}
+
+func (a arrayCustom) BytesLen() int {
+	var n int
+	for _, v := range a.values {
+		n += v.BytesLen()
+	}
+	return n
+}
+
+func (a arrayCustom) Bytes(b []byte) []byte {
+	for _, v := range a.values {
+		b = v.Bytes(b)
+	}
+	return b
+}
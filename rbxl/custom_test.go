@@ -0,0 +1,150 @@
+package rbxl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/robloxapi/rbxfile"
+)
+
+// typeCustomPair is the rbxfile.Type of customPair, which is not a type known
+// to rbxfile.
+const typeCustomPair rbxfile.Type = 200
+
+// customPair is a custom value of two little-endian 16-bit integers.
+type customPair struct{ A, B uint16 }
+
+func (customPair) Type() rbxfile.Type     { return typeCustomPair }
+func (v customPair) String() string       { return fmt.Sprintf("%d, %d", v.A, v.B) }
+func (v *customPair) Copy() rbxfile.Value { c := *v; return &c }
+func (v customPair) Bytes(b []byte) []byte {
+	return append(b, byte(v.A), byte(v.A>>8), byte(v.B), byte(v.B>>8))
+}
+func (v *customPair) FromBytes(b []byte) (n int, err error) {
+	if len(b) < 4 {
+		return 0, errors.New("expected 4 bytes")
+	}
+	v.A = binary.LittleEndian.Uint16(b[0:2])
+	v.B = binary.LittleEndian.Uint16(b[2:4])
+	return 4, nil
+}
+
+// customDecodeOnly is a custom value that cannot be encoded.
+type customDecodeOnly struct{ pair customPair }
+
+func (customDecodeOnly) Type() rbxfile.Type     { return typeCustomPair + 1 }
+func (v customDecodeOnly) String() string       { return v.pair.String() }
+func (v *customDecodeOnly) Copy() rbxfile.Value { c := *v; return &c }
+func (v *customDecodeOnly) FromBytes(b []byte) (n int, err error) {
+	return v.pair.FromBytes(b)
+}
+
+// registerCustom registers factory for id for the duration of the test.
+func registerCustom(t *testing.T, id byte, factory func() CustomValue) {
+	t.Helper()
+	RegisterValueType(id, factory)
+	t.Cleanup(func() { delete(customValueTypes, typeID(id)) })
+}
+
+func TestRegisterValueTypePanics(t *testing.T) {
+	factory := func() CustomValue { return &customPair{} }
+	for _, test := range []struct {
+		name    string
+		id      byte
+		factory func() CustomValue
+	}{
+		{"zero", 0, factory},
+		{"string", byte(typeString), factory},
+		{"security capabilities", byte(typeSecurityCapabilities), factory},
+		{"nil factory", 0xF0, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected panic")
+				}
+			}()
+			RegisterValueType(test.id, test.factory)
+		})
+	}
+	if typeID(0xF0).custom() {
+		t.Error("nil factory was registered")
+	}
+
+	// Signed strings are not supported by the codec, so the id is free.
+	registerCustom(t, byte(typeSignedString), factory)
+	if !typeSignedString.custom() {
+		t.Error("expected SignedString id to be registered")
+	}
+}
+
+func TestCustomValue(t *testing.T) {
+	registerCustom(t, 0xF0, func() CustomValue { return &customPair{} })
+
+	root := &rbxfile.Root{}
+	for i := 0; i < 3; i++ {
+		inst := rbxfile.NewInstance("Part")
+		inst.Properties["Pair"] = &customPair{A: uint16(i), B: 0x1234}
+		root.Instances = append(root.Instances, inst)
+	}
+	// The missing property is encoded as the value returned by the factory.
+	root.Instances = append(root.Instances, rbxfile.NewInstance("Part"))
+
+	var buf bytes.Buffer
+	if warn, err := (Encoder{}).Encode(&buf, root); err != nil || warn != nil {
+		t.Fatalf("encode: unexpected error: %v, %v", warn, err)
+	}
+	got, warn, err := Decoder{}.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil || warn != nil {
+		t.Fatalf("decode: unexpected error: %v, %v", warn, err)
+	}
+	if len(got.Instances) != 4 {
+		t.Fatalf("expected 4 instances, got %d", len(got.Instances))
+	}
+	for i, inst := range got.Instances {
+		want := customPair{A: uint16(i), B: 0x1234}
+		if i == 3 {
+			want = customPair{}
+		}
+		if v, ok := inst.Properties["Pair"].(*customPair); !ok || *v != want {
+			t.Errorf("instance %d: expected %v, got %#v", i, want, inst.Properties["Pair"])
+		}
+	}
+
+	// Unregistered types cannot be decoded.
+	delete(customValueTypes, 0xF0)
+	got, warn, err = Decoder{}.Decode(bytes.NewReader(buf.Bytes()))
+	if err == nil && warn == nil {
+		t.Error("unregistered: expected error")
+	}
+	if err == nil {
+		if _, ok := got.Instances[0].Properties["Pair"]; ok {
+			t.Error("unregistered: unexpected property")
+		}
+	}
+}
+
+func TestCustomValueDecodeOnly(t *testing.T) {
+	registerCustom(t, 0xF1, func() CustomValue { return &customDecodeOnly{} })
+
+	inst := rbxfile.NewInstance("Part")
+	inst.Properties["Pair"] = &customDecodeOnly{customPair{A: 1, B: 2}}
+	var buf bytes.Buffer
+	warn, err := (Encoder{}).Encode(&buf, &rbxfile.Root{Instances: []*rbxfile.Instance{inst}})
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	if warn == nil {
+		t.Error("expected warning for custom value that cannot be encoded")
+	}
+	got, _, err := Decoder{}.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if _, ok := got.Instances[0].Properties["Pair"]; ok {
+		t.Error("unexpected property")
+	}
+}
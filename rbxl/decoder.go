@@ -2,7 +2,14 @@ package rbxl
 
 import (
 	"bytes"
+	"encoding/binary"
 	"io"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/anaminus/parse"
 	"github.com/robloxapi/rbxfile"
@@ -18,6 +25,44 @@ type DecoderStats struct {
 	InstanceCount uint32         // Number of instances reported by the header.
 	Chunks        int            // Total number of chunks.
 	ChunkTypes    map[string]int // Number of chunks per signature.
+
+	// Sizes of chunk payloads per signature.
+	ChunkSizes map[string]*ChunkSizeStats
+
+	// Number of property chunks per data type.
+	PropertyTypes map[string]int
+}
+
+// ChunkSizeStats contains the accumulated payload sizes of a type of chunk.
+type ChunkSizeStats struct {
+	Compressed   int64   // Number of payload bytes as stored in the file.
+	Decompressed int64   // Number of payload bytes declared by chunk headers.
+	Ratio        float64 // Ratio of Compressed to Decompressed.
+}
+
+// addChunk accumulates the stats of a raw chunk.
+func (s *DecoderStats) addChunk(c *rawChunk) {
+	if s == nil {
+		return
+	}
+	name := sig(c.signature).String()
+	if s.ChunkTypes == nil {
+		s.ChunkTypes = map[string]int{}
+	}
+	s.ChunkTypes[name]++
+	if s.ChunkSizes == nil {
+		s.ChunkSizes = map[string]*ChunkSizeStats{}
+	}
+	size := s.ChunkSizes[name]
+	if size == nil {
+		size = &ChunkSizeStats{}
+		s.ChunkSizes[name] = size
+	}
+	size.Compressed += int64(c.size)
+	size.Decompressed += int64(c.length)
+	if size.Decompressed > 0 {
+		size.Ratio = float64(size.Compressed) / float64(size.Decompressed)
+	}
 }
 
 // Decoder decodes a stream of bytes into an rbxfile.Root.
@@ -27,40 +72,748 @@ type Decoder struct {
 
 	// If NoXML is true, then the decoder will not attempt to decode the legacy
 	// XML format for backward compatibility.
+	//
+	// Data in the XML format is decoded by an rbxlx.Decoder that receives
+	// ClassRemap, Dropped, and DropUnnamedProperties. The decoded tree is
+	// then checked against MaxDepth, and processed by options such as Schema
+	// and PropertyFilter, as with the binary format. Options that concern the
+	// structure of the binary format, such as PreserveRawValues, do not apply.
 	NoXML bool
 
 	// If not nil, stats will be set while decoding.
 	Stats *DecoderStats
+
+	// TokenFromInt, if not nil, is called for each Int property of a decoded
+	// instance. If it returns true, then the property is reinterpreted as a
+	// Token. This accommodates files that stored enum properties as integers.
+	// A warning is emitted for each reinterpreted property.
+	TokenFromInt func(class, prop string) bool
+
+	// Schema, if not nil, maps a class name to the declared types of its
+	// properties, such as those from an API dump. When a decoded property has
+	// a type that differs from its declared type, then the value is converted
+	// to the declared type, if a conversion exists. Supported conversions are
+	// between Float and Double, Int and Int64, and String and Content, and
+	// from String to BinaryString and ProtectedString, from Reference to
+	// ContentObject, and from Int and Int64 to Token, which accommodates enum
+	// properties stored as integers. A
+	// warning is emitted for each conversion to Token, and for each
+	// conversion that loses precision.
+	Schema map[string]map[string]rbxfile.Type
+
+	// Defaults, if not nil, maps a class name to the default values of its
+	// properties. Each instance that lacks a property of its class is given a
+	// copy of the default value, which restores properties omitted by an
+	// Encoder with OmitDefaults and the same default values. Unlike
+	// Encoder.Defaults, the values are given as a map, because the names of
+	// omitted properties are not recorded in the file. Defaults are added
+	// before Schema and other processing are applied.
+	Defaults map[string]map[string]rbxfile.Value
+
+	// StringValidation determines how String, Content, and ProtectedString
+	// properties that are not valid UTF-8 are decoded. BinaryString
+	// properties are exempt. Validation occurs after Schema is applied.
+	// Because the binary format has only one string type, binary data, such
+	// as that of a BinaryString property, is decoded as a String, unless
+	// Schema declares the property as a BinaryString.
+	StringValidation StringValidation
+
+	// CanonicalizeFloats sets whether decoded Float and Double properties are
+	// normalized, so that values that are equal, or are both NaN, have the
+	// same bits. Negative zero becomes positive zero, and each NaN becomes the
+	// quiet NaN with an empty payload. Decoding and encoding already preserves
+	// the exact bits of a value; this instead ensures that the encoding of
+	// such values does not depend on how they were produced. Normalization
+	// occurs before TokenFromInt and Schema are applied.
+	CanonicalizeFloats bool
+
+	// RejectLossyCoercion sets whether a conversion by Schema that loses
+	// precision, such as narrowing a Double to a Float, causes decoding to
+	// fail instead of emitting a warning.
+	RejectLossyCoercion bool
+
+	// PreserveRawValues sets whether each decoded property value is wrapped in
+	// a RawValue, which retains the exact binary representation of the value.
+	// Does not apply to Reference, SharedString, and Optional values, or to
+	// the legacy XML format. Options that convert values, such as Schema, apply
+	// to the wrapped value, and a converted value is not wrapped.
+	PreserveRawValues bool
+
+	// VerifySharedStringHashes sets whether the hash of each shared string is
+	// verified against its value. A warning is emitted for each mismatch,
+	// which may indicate a corrupt or modified file. The value is used
+	// regardless.
+	VerifySharedStringHashes bool
+
+	// PropertyFilter, if not nil, is called for each property of a decoded
+	// instance, after TokenFromInt and Schema have been applied. The returned
+	// value replaces the property. If false is returned, then the property is
+	// removed. Because the binary format has only one string type, string
+	// properties, such as ProtectedString, are passed as String values.
+	PropertyFilter func(class, prop string, v rbxfile.Value) (rbxfile.Value, bool)
+
+	// MaxEndContentSize is the maximum size, in bytes, of the content of the
+	// END chunk. Legitimate content is only `</roblox>`, so a large END chunk
+	// indicates a malformed file. If 0, then a limit of 4096 bytes is used.
+	// If negative, then there is no limit.
+	MaxEndContentSize int
+
+	// MaxDecompressedBytes, if greater than 0, is the maximum total size, in
+	// bytes, of the uncompressed payloads of the chunks of a file. Decoding
+	// fails with a DecompressedSizeError once the declared length of a chunk
+	// would exceed the limit, before the payload is allocated. This guards
+	// against untrusted data in which small chunks declare very large lengths.
+	MaxDecompressedBytes int64
+
+	// Timeout, if greater than 0, is the maximum duration of reading and
+	// decoding the chunks of a file. The duration is checked whenever data is
+	// read, before each chunk is parsed and again before it is decoded into
+	// instances, and before each instance is post-processed by options such as
+	// Schema. Once exceeded, decoding fails with an error that wraps
+	// ErrTimeout.
+	//
+	// The duration is not checked within a single chunk, and a read that
+	// blocks is not interrupted. Data in the legacy XML format is decoded by
+	// the rbxlx package, which does not check the duration; only reading the
+	// data is limited, and the duration is checked again once decoding
+	// finishes.
+	Timeout time.Duration
+
+	// RejectLargeEndChunk determines how an END chunk that exceeds
+	// MaxEndContentSize is handled. If true, then decoding fails. If false,
+	// then uncompressed content is truncated to the limit, compressed content
+	// is discarded, and a warning is emitted.
+	RejectLargeEndChunk bool
+
+	// MaxDepth is the maximum depth of the decoded instance tree, where
+	// top-level instances have a depth of 1. If greater than 0, then decoding
+	// fails when the linked tree exceeds the limit. Parent links are
+	// processed without recursion, so deep trees within the limit, or with no
+	// limit, do not exhaust the stack while decoding.
+	MaxDepth int
+
+	// Dropped, if not nil, receives each property that is dropped while
+	// decoding, such as the properties of a chunk with an unknown value type.
+	// Because properties are stored per class, one entry is reported for each
+	// class and property, rather than for each instance.
+	Dropped *[]rbxfile.DroppedProperty
+
+	// DropUnnamedProperties sets whether properties with an empty name are
+	// dropped. Such properties appear only in hand-crafted or corrupted files.
+	// If false, they are decoded under the empty key of the Properties of each
+	// instance. In either case, ErrEmptyPropertyName is emitted as a warning.
+	DropUnnamedProperties bool
+
+	// RecordCompression sets whether the compression method of the file is
+	// recorded in the MetadataCompression entry of the decoded root. The entry
+	// is CompressionLZ4 if any chunk is compressed, and CompressionNone
+	// otherwise. An existing entry is overwritten. Not recorded for the legacy
+	// XML format. See Encoder.CompressionMetadata.
+	RecordCompression bool
+
+	// Parallelism is the maximum number of chunks that are decompressed
+	// concurrently. If greater than 1, then chunks are read serially, and
+	// decompressed by separate goroutines, after which they are decoded in
+	// order. The result is identical to that of serial decoding, except that
+	// every chunk is read and decompressed before any are decoded, so the
+	// entire decompressed content of the file is held in memory at once.
+	Parallelism int
+
+	// CaptureRawOnError sets whether the compressed payload of a chunk that
+	// fails to decompress is retained by the resulting CompressionError, so
+	// that it can be inspected.
+	CaptureRawOnError bool
+
+	// ClassRemap, if not nil, maps the class name of a decoded instance to a
+	// new class name, such as to replace a deprecated class. Properties are
+	// left as-is, and TokenFromInt, Schema, and PropertyFilter receive the new
+	// class name. A warning is emitted once for each remapped class.
+	ClassRemap map[string]string
+
+	// structureOnly sets whether only the chunks required by DecodeStructure
+	// are decoded.
+	structureOnly bool
+
+	// keepStored sets whether the compressed payload of each chunk is
+	// retained.
+	keepStored bool
+
+	// budget, if not nil, is the remaining number of payload bytes allowed by
+	// MaxDecompressedBytes. It is shared by the chunks of one file.
+	budget *int64
+
+	// deadline, if not zero, is the time after which decoding fails, as
+	// determined by Timeout.
+	deadline time.Time
+
+	// instances, if not nil, receives each decoded instance, keyed by its
+	// reference number.
+	instances map[int32]*rbxfile.Instance
+}
+
+// recordCompression sets the MetadataCompression entry of root according to
+// the chunks of f, if enabled.
+func (d Decoder) recordCompression(f *formatModel, root *rbxfile.Root) {
+	if !d.RecordCompression {
+		return
+	}
+	method := CompressionNone
+	for _, chunk := range f.Chunks {
+		if chunk.Compressed() {
+			method = CompressionLZ4
+			break
+		}
+	}
+	if root.Metadata == nil {
+		root.Metadata = make(map[string]string, 1)
+	}
+	root.Metadata[MetadataCompression] = method
+}
+
+// defaultMaxEndContentSize is the limit of the END chunk content used when
+// Decoder.MaxEndContentSize is 0.
+const defaultMaxEndContentSize = 4096
+
+// newRawChunk returns a rawChunk configured with the limits of the decoder.
+func (d Decoder) newRawChunk() *rawChunk {
+	c := &rawChunk{endReject: d.RejectLargeEndChunk, captureRaw: d.CaptureRawOnError, keepStored: d.keepStored, budget: d.budget, budgetLimit: d.MaxDecompressedBytes}
+	switch {
+	case d.MaxEndContentSize == 0:
+		c.endLimit = defaultMaxEndContentSize
+	case d.MaxEndContentSize > 0:
+		c.endLimit = uint32(d.MaxEndContentSize)
+	}
+	return c
+}
+
+// postDecode applies post-processing to a decoded root.
+func (d Decoder) postDecode(root *rbxfile.Root) (warn, err error) {
+	if d.TokenFromInt == nil && d.Schema == nil && d.Defaults == nil && d.PropertyFilter == nil && !d.CanonicalizeFloats && d.StringValidation == StringPassthrough {
+		return nil, nil
+	}
+	var warns errors.Errors
+	// Traverse without recursion, so that a deep tree cannot exhaust the
+	// stack.
+	stack := make([]*rbxfile.Instance, 0, len(root.Instances))
+	for i := len(root.Instances) - 1; i >= 0; i-- {
+		stack = append(stack, root.Instances[i])
+	}
+	for len(stack) > 0 {
+		if d.expired() {
+			return warns.Return(), CodecError{Cause: ErrTimeout}
+		}
+		inst := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for name, def := range d.Defaults[inst.ClassName] {
+			if _, ok := inst.Properties[name]; !ok {
+				inst.Properties[name] = def.Copy()
+			}
+		}
+		for _, prop := range inst.SortedProperties() {
+			value := prop.Value
+			// Post-processing applies to the underlying value of a RawValue,
+			// which is rewrapped only if left unchanged.
+			raw, isRaw := value.(RawValue)
+			if isRaw {
+				value = raw.Value
+			}
+			if d.CanonicalizeFloats {
+				value = canonicalFloat(value)
+			}
+			if v, ok := value.(rbxfile.ValueInt); ok && d.TokenFromInt != nil && d.TokenFromInt(inst.ClassName, prop.Name) {
+				value = rbxfile.ValueToken(uint32(v))
+				warns = append(warns, TokenFromIntError{Class: inst.ClassName, Property: prop.Name, From: rbxfile.TypeInt})
+			} else if typ, ok := d.Schema[inst.ClassName][prop.Name]; ok && value.Type() != typ {
+				if v, lossy, ok := coerceValue(value, typ); ok {
+					value = v
+					if typ == rbxfile.TypeToken {
+						warns = append(warns, TokenFromIntError{Class: inst.ClassName, Property: prop.Name, From: prop.Value.Type()})
+					}
+					if lossy {
+						err := LossyCoercionError{Class: inst.ClassName, Property: prop.Name, From: prop.Value.Type(), To: typ}
+						if d.RejectLossyCoercion {
+							return warns.Return(), CodecError{Cause: err}
+						}
+						warns = append(warns, err)
+					}
+				}
+			}
+			if d.StringValidation != StringPassthrough {
+				v, ok := validateString(value, d.StringValidation == StringReplace)
+				if !ok {
+					return warns.Return(), CodecError{Cause: InvalidUTF8Error{Class: inst.ClassName, Property: prop.Name}}
+				}
+				value = v
+			}
+			if isRaw && reflect.DeepEqual(value, raw.Value) {
+				value = raw
+			}
+			if d.PropertyFilter != nil {
+				v, ok := d.PropertyFilter(inst.ClassName, prop.Name, value)
+				if !ok {
+					delete(inst.Properties, prop.Name)
+					continue
+				}
+				value = v
+			}
+			inst.Properties[prop.Name] = value
+		}
+		for i := len(inst.Children) - 1; i >= 0; i-- {
+			stack = append(stack, inst.Children[i])
+		}
+	}
+	return warns.Return(), nil
+}
+
+// StringValidation determines how strings that are not valid UTF-8 are
+// decoded.
+type StringValidation uint8
+
+const (
+	StringPassthrough StringValidation = iota // Strings are decoded as-is.
+	StringReplace                             // Each run of invalid bytes is replaced with U+FFFD.
+	StringReject                              // Decoding fails.
+)
+
+// validateString validates v if it is a String, Content, or ProtectedString.
+// If replace is true, then invalid bytes are replaced. Otherwise, ok is false
+// if v is invalid.
+func validateString(v rbxfile.Value, replace bool) (r rbxfile.Value, ok bool) {
+	var s string
+	switch v := v.(type) {
+	case rbxfile.ValueString:
+		s = string(v)
+	case rbxfile.ValueContent:
+		s = string(v)
+	case rbxfile.ValueProtectedString:
+		s = string(v)
+	default:
+		return v, true
+	}
+	if utf8.ValidString(s) {
+		return v, true
+	}
+	if !replace {
+		return v, false
+	}
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	switch v.(type) {
+	case rbxfile.ValueString:
+		return rbxfile.ValueString(s), true
+	case rbxfile.ValueContent:
+		return rbxfile.ValueContent(s), true
+	default:
+		return rbxfile.ValueProtectedString(s), true
+	}
+}
+
+// canonicalFloat returns v with a canonical representation if v is a Float or
+// Double. Otherwise, v is returned unchanged.
+func canonicalFloat(v rbxfile.Value) rbxfile.Value {
+	switch f := v.(type) {
+	case rbxfile.ValueFloat:
+		if f != f {
+			return rbxfile.ValueFloat(math.Float32frombits(0x7FC00000))
+		}
+		if f == 0 {
+			return rbxfile.ValueFloat(0)
+		}
+	case rbxfile.ValueDouble:
+		if f != f {
+			return rbxfile.ValueDouble(math.Float64frombits(0x7FF8000000000000))
+		}
+		if f == 0 {
+			return rbxfile.ValueDouble(0)
+		}
+	}
+	return v
+}
+
+// coerceValue converts v to a value of type t. lossy is true if the
+// conversion loses information. ok is false if no conversion exists.
+func coerceValue(v rbxfile.Value, t rbxfile.Type) (c rbxfile.Value, lossy, ok bool) {
+	switch v := v.(type) {
+	case rbxfile.ValueDouble:
+		if t == rbxfile.TypeFloat {
+			c := rbxfile.ValueFloat(v)
+			return c, float64(c) != float64(v) && v == v, true
+		}
+	case rbxfile.ValueFloat:
+		if t == rbxfile.TypeDouble {
+			return rbxfile.ValueDouble(v), false, true
+		}
+	case rbxfile.ValueInt:
+		switch t {
+		case rbxfile.TypeInt64:
+			return rbxfile.ValueInt64(v), false, true
+		case rbxfile.TypeToken:
+			return rbxfile.ValueToken(uint32(v)), v < 0, true
+		}
+	case rbxfile.ValueInt64:
+		switch t {
+		case rbxfile.TypeInt:
+			c := rbxfile.ValueInt(v)
+			return c, int64(c) != int64(v), true
+		case rbxfile.TypeToken:
+			c := rbxfile.ValueToken(v)
+			return c, int64(c) != int64(v), true
+		}
+	case rbxfile.ValueString:
+		switch t {
+		case rbxfile.TypeContent:
+			return rbxfile.ValueContent(v), false, true
+		case rbxfile.TypeBinaryString:
+			return rbxfile.ValueBinaryString(v), false, true
+		case rbxfile.TypeProtectedString:
+			return rbxfile.ValueProtectedString(v), false, true
+		}
+	case rbxfile.ValueContent:
+		if t == rbxfile.TypeString {
+			return rbxfile.ValueString(v), false, true
+		}
+	case rbxfile.ValueReference:
+		if t == rbxfile.TypeContentObject {
+			return rbxfile.ValueContentObject(v), false, true
+		}
+	}
+	return nil, false, false
 }
 
 // Decode reads data from r and decodes it into root according to the rbxl
 // format.
+//
+// If the data ends after a complete chunk without an END chunk, then the
+// chunks read so far are decoded, and ErrEndChunkMissing is emitted as a
+// warning.
 func (d Decoder) Decode(r io.Reader) (root *rbxfile.Root, warn, err error) {
+	root = new(rbxfile.Root)
+	if warn, err = d.DecodeInto(r, root); err != nil {
+		return nil, warn, err
+	}
+	return root, warn, nil
+}
+
+// DecodeGzip is like Decode, but the data is first decompressed if it begins
+// with the gzip signature. This is for files that have been compressed as a
+// whole, in addition to the compression of individual chunks. Data without
+// the signature is decoded as-is.
+func (d Decoder) DecodeGzip(r io.Reader) (root *rbxfile.Root, warn, err error) {
+	return rbxfile.DecodeGzip(r, d.Decode)
+}
+
+// DecodeStructure is like Decode, but decodes only the structure of the
+// instance tree: the ClassName, IsService, and Name property of each instance,
+// and the parent of each instance. All other properties and metadata are
+// discarded. Chunks that are not needed are decompressed, but not parsed,
+// making this faster and lighter than Decode.
+//
+// Data in the legacy XML format is fully decoded, after which other
+// properties are removed.
+func (d Decoder) DecodeStructure(r io.Reader) (root *rbxfile.Root, warn, err error) {
+	d.structureOnly = true
+	if root, warn, err = d.Decode(r); err != nil {
+		return nil, warn, err
+	}
+	// Clear whatever remains, such as from the XML format.
+	root.Metadata = nil
+	stack := append([]*rbxfile.Instance(nil), root.Instances...)
+	for len(stack) > 0 {
+		inst := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for name := range inst.Properties {
+			if name != "Name" {
+				delete(inst.Properties, name)
+			}
+		}
+		stack = append(stack, inst.Children...)
+	}
+	return root, warn, nil
+}
+
+// structureChunk returns whether a chunk is required by DecodeStructure.
+func structureChunk(c *rawChunk) bool {
+	switch c.signature {
+	case sigMETA, sigSSTR:
+		return false
+	case sigPROP:
+		// Skip the class ID, then compare the property name.
+		const name = "Name"
+		p := c.payload
+		return len(p) >= 8+len(name) &&
+			binary.LittleEndian.Uint32(p[4:8]) == uint32(len(name)) &&
+			string(p[8:8+len(name)]) == name
+	}
+	return true
+}
+
+// DecodeInto is like Decode, but decodes into the provided root, which is
+// reset beforehand. The Instances slice and Metadata map of root are reused,
+// as are the instances of the tree of root, along with their Properties maps
+// and Children slices. This reduces allocations when many files are decoded
+// in sequence. Because instances are reused, instances and values of the
+// previous content of root must not be retained by the caller. If an error is
+// returned, the content of root is unspecified.
+//
+// Data in the legacy XML format is decoded into new instances, which are then
+// placed in the Instances slice of root.
+func (d Decoder) DecodeInto(r io.Reader, root *rbxfile.Root) (warn, err error) {
+	_, warn, err = d.decodeInto(r, root)
+	return warn, err
+}
+
+// decodeXML decodes r in the legacy XML format, with the options of the
+// decoder that apply to the format.
+func (d Decoder) decodeXML(r io.Reader) (root *rbxfile.Root, warn, err error) {
+	root, warn, err = rbxlx.Decoder{
+		ClassRemap:            d.ClassRemap,
+		Dropped:               d.Dropped,
+		DropUnnamedProperties: d.DropUnnamedProperties,
+	}.Decode(r)
+	if d.expired() {
+		// The XML parser may report the interrupted read as a syntax error.
+		return nil, warn, XMLError{Cause: ErrTimeout}
+	}
+	if err != nil {
+		return nil, warn, XMLError{Cause: err}
+	}
+	if d.MaxDepth > 0 {
+		if err := checkDepth(root.Instances, d.MaxDepth); err != nil {
+			return nil, warn, err
+		}
+	}
+	return root, warn, nil
+}
+
+// codec returns a codec configured by the decoder.
+func (d Decoder) codec() robloxCodec {
+	return robloxCodec{
+		Mode:                d.Mode,
+		PreserveRaw:         d.PreserveRawValues,
+		VerifySharedStrings: d.VerifySharedStringHashes,
+		MaxDepth:            d.MaxDepth,
+		Dropped:             d.Dropped,
+		DropUnnamed:         d.DropUnnamedProperties,
+		ClassRemap:          d.ClassRemap,
+		Instances:           d.instances,
+		Deadline:            d.deadline,
+	}
+}
+
+// decodeInto implements DecodeInto, also returning the decoded format model.
+// The model is nil if the data is in the legacy XML format.
+func (d Decoder) decodeInto(r io.Reader, root *rbxfile.Root) (f *formatModel, warn, err error) {
 	if r == nil {
 		return nil, nil, errors.New("nil reader")
 	}
+	if root == nil {
+		return nil, nil, errors.New("nil root")
+	}
 
+	d = d.withDeadline()
 	f, buf, w, err := d.decode(r, false)
 	warn = errors.Union(warn, w)
 	if err != nil {
 		return nil, warn, err
 	}
 	if buf != nil {
-		root, warn, err = rbxlx.Decoder{}.Decode(buf)
+		xmlRoot, w, err := d.decodeXML(buf)
+		warn = errors.Union(warn, w)
+		if err != nil {
+			return nil, warn, err
+		}
+		resetRoot(root)
+		root.Instances = append(root.Instances, xmlRoot.Instances...)
+		if root.Metadata == nil {
+			root.Metadata = xmlRoot.Metadata
+		} else {
+			for k, v := range xmlRoot.Metadata {
+				root.Metadata[k] = v
+			}
+		}
+		w, err = d.postDecode(root)
+		warn = errors.Union(warn, w)
 		if err != nil {
-			return nil, warn, XMLError{Cause: err}
+			return nil, warn, err
 		}
-		return root, warn, nil
+		return nil, warn, nil
 	}
 
 	// Run codec.
-	codec := robloxCodec{Mode: d.Mode}
-	root, w, err = codec.Decode(f)
+	w, err = d.codec().DecodeInto(f, root)
 	warn = errors.Union(warn, w)
 	if err != nil {
 		return nil, warn, err
 	}
-	return root, warn, nil
+	d.recordCompression(f, root)
+	w, err = d.postDecode(root)
+	warn = errors.Union(warn, w)
+	if err != nil {
+		return nil, warn, err
+	}
+	return f, warn, nil
+}
+
+// DecodeResult contains a decoded root, along with information about the
+// format from which it was decoded.
+type DecodeResult struct {
+	Root *rbxfile.Root
+
+	// XML is whether the data was in the legacy XML format. If so, then the
+	// remaining fields are zero.
+	XML bool
+
+	// Version is the version of the format.
+	Version uint16
+
+	// Mode is the inferred kind of file. The file is inferred to be a place if
+	// any instance chunk has service flags, and a model otherwise.
+	Mode Mode
+
+	// Chunks describes each chunk, in the order they appear.
+	Chunks []ChunkInfo
+}
+
+// ChunkInfo describes a chunk within the binary format.
+type ChunkInfo struct {
+	// Signature is the signature of the chunk.
+	Signature string
+
+	// Compressed is whether the payload of the chunk is compressed.
+	Compressed bool
+
+	// The remaining fields are set only for PROP chunks.
+
+	// Property is the name of the property.
+	Property string
+
+	// DataTypeName is the name of the data type of the values, such as
+	// "Vector3". For optional values, the name of the inner type is prefixed
+	// with "Optional". The name is "Invalid" if the chunk has no values.
+	DataTypeName string
+
+	// DataType is the type of the values. For optional values, DataType is
+	// the inner type.
+	DataType rbxfile.Type
+
+	// SizeKind describes how the size of each value is determined, and is one
+	// of "constant", "array", "conditional", "optional", "other", or
+	// "invalid".
+	SizeKind string
+}
+
+// DecodeFull is like Decode, but also returns information about the format of
+// the data.
+func (d Decoder) DecodeFull(r io.Reader) (result DecodeResult, warn, err error) {
+	root := new(rbxfile.Root)
+	f, warn, err := d.decodeInto(r, root)
+	if err != nil {
+		return result, warn, err
+	}
+	result.Root = root
+	if f == nil {
+		result.XML = true
+		return result, warn, nil
+	}
+	result.Version = f.Version
+	result.Mode = Model
+	result.Chunks = make([]ChunkInfo, len(f.Chunks))
+	for i, chunk := range f.Chunks {
+		info := ChunkInfo{
+			Signature:  chunk.Signature().String(),
+			Compressed: chunk.Compressed(),
+		}
+		switch chunk := chunk.(type) {
+		case *chunkInstance:
+			if chunk.IsService {
+				result.Mode = Place
+			}
+		case *chunkProperty:
+			info.Property = chunk.PropertyName
+			info.DataTypeName, info.DataType = chunk.DataType()
+			info.SizeKind = chunk.SizeKind()
+		}
+		result.Chunks[i] = info
+	}
+	return result, warn, nil
+}
+
+// DecodeAll reads data from r and decodes a sequence of concatenated binary
+// models, returning a root for each. After the END chunk of a model, if any
+// bytes remain, they must begin with the signature of another binary model.
+//
+// If an error occurs, the roots decoded before the error are returned along
+// with it. A DataError has its Offset adjusted to be relative to the start of
+// r.
+//
+// If the legacy XML format is detected at the start of the data, then it is
+// decoded as a single root. XML is not permitted in subsequent models.
+func (d Decoder) DecodeAll(r io.Reader) (roots []*rbxfile.Root, warn, err error) {
+	if r == nil {
+		return nil, nil, errors.New("nil reader")
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var offset int64
+	for len(data) > 0 {
+		if len(roots) > 0 {
+			// Only the first model may be XML.
+			d.NoXML = true
+		}
+		// Each model is given its own deadline.
+		d := d.withDeadline()
+		f, buf, w, err := d.decode(bytes.NewReader(data), false)
+		warn = errors.Union(warn, w)
+		if err != nil {
+			if e, ok := err.(DataError); ok {
+				e.Offset += offset
+				err = e
+			}
+			return roots, warn, err
+		}
+		if buf != nil {
+			root, w, err := d.decodeXML(buf)
+			warn = errors.Union(warn, w)
+			if err != nil {
+				return roots, warn, err
+			}
+			w, err = d.postDecode(root)
+			warn = errors.Union(warn, w)
+			if err != nil {
+				return roots, warn, err
+			}
+			return append(roots, root), warn, nil
+		}
+
+		root, w, err := d.codec().Decode(f)
+		warn = errors.Union(warn, w)
+		if err != nil {
+			return roots, warn, err
+		}
+		d.recordCompression(f, root)
+		w, err = d.postDecode(root)
+		warn = errors.Union(warn, w)
+		if err != nil {
+			return roots, warn, err
+		}
+		roots = append(roots, root)
+
+		offset += int64(len(data) - len(f.Trailing))
+		data = f.Trailing
+	}
+	return roots, warn, nil
+}
+
+// DecodeAll decodes a sequence of concatenated binary models from r with a
+// Decoder in Model mode, as described by Decoder.DecodeAll. If an error
+// occurs, the roots decoded before the error are returned along with it.
+// Warnings are discarded; use Decoder.DecodeAll to receive them.
+func DecodeAll(r io.Reader) ([]*rbxfile.Root, error) {
+	roots, _, err := Decoder{Mode: Model}.DecodeAll(r)
+	return roots, err
 }
 
 // Decompress reencodes the compressed chunks of the binary format as
@@ -86,94 +839,143 @@ func (d Decoder) Decompress(w io.Writer, r io.Reader) (warn, err error) {
 	return warn, err
 }
 
-func decodeError(r *parse.BinaryReader, err error) error {
-	r.Add(0, err)
-	err = r.Err()
+// Header contains the fields of the header of the binary format.
+type Header struct {
+	// Version indicates the version of the format.
+	Version uint16
+
+	// ClassCount is the number of unique classes in the file.
+	ClassCount uint32
+
+	// InstanceCount is the number of unique instances in the file.
+	InstanceCount uint32
+
+	// Reserved is the content of the reserved bytes, which are expected to be
+	// zero. Roblox writes only zeros; the bytes do not record the version of
+	// Studio or the engine that wrote the file.
+	Reserved [8]byte
+}
+
+// ReadHeader reads only the header of the binary format from r, without
+// reading any chunks. Unlike Decode, an unrecognized version or non-zero
+// reserved bytes do not produce an error.
+//
+// Returns ErrXML if the data is in the legacy XML format.
+func ReadHeader(r io.Reader) (h Header, err error) {
+	if r == nil {
+		return h, errors.New("nil reader")
+	}
+	h, xml, err := readHeader(parse.NewBinaryReader(r))
 	if err != nil {
-		return DataError{Offset: r.N(), Cause: err}
+		return h, err
 	}
-	return nil
+	if xml != nil {
+		return h, ErrXML
+	}
+	return h, nil
 }
 
-// decode parses the format. If the XML format is detected, then decode returns
-// a non-nil Reader with the original content, ready to be parsed by an XML
-// format decoder.
-func (d Decoder) decode(r io.Reader, dcomp bool) (f *formatModel, o io.Reader, warn, err error) {
-	f = &formatModel{}
-	fr := parse.NewBinaryReader(r)
-
+// readHeader reads the header of the binary format from fr. If the signature
+// indicates the legacy XML format, then the rest of the header is not read,
+// and xml contains the bytes read from fr.
+func readHeader(fr *parse.BinaryReader) (h Header, xml []byte, err error) {
 	// Check signature.
 	signature := make([]byte, len(robloxSig+binaryMarker))
 	if fr.Bytes(signature) {
-		return f, nil, nil, decodeError(fr, nil)
+		return h, nil, decodeError(fr, nil)
 	}
 	if !bytes.Equal(signature[:len(robloxSig)], []byte(robloxSig)) {
-		return f, nil, nil, decodeError(fr, errInvalidSig)
+		return h, nil, decodeError(fr, errInvalidSig)
 	}
 
 	// Check for legacy XML.
 	if !bytes.Equal(signature[len(robloxSig):], []byte(binaryMarker)) {
-		if d.Stats != nil {
-			d.Stats.XML = true
-		}
-		if d.NoXML {
-			return nil, nil, nil, decodeError(fr, errInvalidSig)
-		} else {
-			// Reconstruct original reader.
-			return nil, io.MultiReader(bytes.NewReader(signature), r), nil, nil
-		}
+		return h, signature, nil
 	}
 
 	// Check header magic.
 	header := make([]byte, len(binaryHeader))
 	if fr.Bytes(header) {
-		return nil, nil, nil, decodeError(fr, nil)
+		return h, nil, decodeError(fr, nil)
 	}
 	if !bytes.Equal(header, []byte(binaryHeader)) {
-		return nil, nil, nil, decodeError(fr, errors.New("the file header is corrupted"))
+		return h, nil, decodeError(fr, errors.New("the file header is corrupted"))
 	}
 
-	// Check version.
-	if fr.Number(&f.Version) {
-		return nil, nil, nil, decodeError(fr, nil)
+	if fr.Number(&h.Version) {
+		return h, nil, decodeError(fr, nil)
 	}
-	if d.Stats != nil {
-		d.Stats.Version = f.Version
+	if fr.Number(&h.ClassCount) {
+		return h, nil, decodeError(fr, nil)
 	}
-	if f.Version != 0 {
-		return nil, nil, nil, decodeError(fr, errUnrecognizedVersion(f.Version))
+	if fr.Number(&h.InstanceCount) {
+		return h, nil, decodeError(fr, nil)
 	}
+	if fr.Bytes(h.Reserved[:]) {
+		return h, nil, decodeError(fr, nil)
+	}
+	return h, nil, nil
+}
 
-	// Get Class count.
-	if fr.Number(&f.ClassCount) {
-		return nil, nil, nil, decodeError(fr, nil)
+func decodeError(r *parse.BinaryReader, err error) error {
+	r.Add(0, err)
+	err = r.Err()
+	if err != nil {
+		return DataError{Offset: r.N(), Cause: err}
 	}
-	if d.Stats != nil {
-		d.Stats.ClassCount = f.ClassCount
+	return nil
+}
+
+// decode parses the format. If the XML format is detected, then decode returns
+// a non-nil Reader with the original content, ready to be parsed by an XML
+// format decoder.
+func (d Decoder) decode(r io.Reader, dcomp bool) (f *formatModel, o io.Reader, warn, err error) {
+	d = d.withDeadline()
+	if !d.deadline.IsZero() {
+		r = &timeoutReader{r: r, deadline: d.deadline}
 	}
-	f.groupLookup = make(map[int32]*chunkInstance, f.ClassCount)
+	f = &formatModel{}
+	fr := parse.NewBinaryReader(r)
 
-	// Get Instance count.
-	if fr.Number(&f.InstanceCount) {
-		return nil, nil, nil, decodeError(fr, nil)
+	h, xml, err := readHeader(fr)
+	if err != nil {
+		return nil, nil, nil, err
 	}
+	if xml != nil {
+		if d.Stats != nil {
+			d.Stats.XML = true
+		}
+		if d.NoXML {
+			return nil, nil, nil, decodeError(fr, errInvalidSig)
+		}
+		// Reconstruct original reader.
+		return nil, io.MultiReader(bytes.NewReader(xml), r), nil, nil
+	}
+	f.Version = h.Version
+	f.ClassCount = h.ClassCount
+	f.InstanceCount = h.InstanceCount
 	if d.Stats != nil {
-		d.Stats.InstanceCount = f.InstanceCount
+		d.Stats.Version = h.Version
+		d.Stats.ClassCount = h.ClassCount
+		d.Stats.InstanceCount = h.InstanceCount
 	}
-
-	// Check reserved bytes.
-	var reserved [8]byte
-	if fr.Bytes(reserved[:]) {
-		return nil, nil, nil, decodeError(fr, nil)
+	if f.Version != 0 {
+		return nil, nil, nil, decodeError(fr, errUnrecognizedVersion(f.Version))
 	}
+	f.groupLookup = make(map[int32]*chunkInstance, f.ClassCount)
+
 	var warns errors.Errors
-	if reserved != [8]byte{} {
-		warns = append(warns, errReserve{Offset: fr.N() - int64(len(reserved)), Bytes: reserved[:]})
+	if h.Reserved != [8]byte{} {
+		warns = append(warns, ReserveError{Offset: fr.N() - int64(len(h.Reserved)), Bytes: h.Reserved[:]})
 	}
 
 	// Decode chunks.
+	if d.MaxDecompressedBytes > 0 {
+		budget := d.MaxDecompressedBytes
+		d.budget = &budget
+	}
 	if dcomp {
-		if err = d.decompressChunks(f, fr); err != nil {
+		if err = d.decompressChunks(f, fr, &warns); err != nil {
 			return nil, nil, warns.Return(), err
 		}
 	} else {
@@ -182,6 +984,11 @@ func (d Decoder) decode(r io.Reader, dcomp bool) (f *formatModel, o io.Reader, w
 		}
 	}
 
+	// A missing END chunk leaves the reader at EOF, with no trailing content.
+	if fr.Err() == io.EOF {
+		return f, nil, warns.Return(), nil
+	}
+
 	// Handle trailing content.
 	f.Trailing, _ = fr.All()
 
@@ -191,100 +998,231 @@ func (d Decoder) decode(r io.Reader, dcomp bool) (f *formatModel, o io.Reader, w
 	return f, nil, warns.Return(), nil
 }
 
+// timeoutReader wraps a Reader, failing with ErrTimeout once the deadline has
+// passed.
+type timeoutReader struct {
+	r        io.Reader
+	deadline time.Time
+}
+
+func (r *timeoutReader) Read(p []byte) (n int, err error) {
+	if time.Now().After(r.deadline) {
+		return 0, ErrTimeout
+	}
+	return r.r.Read(p)
+}
+
+// withDeadline returns the decoder with a deadline determined by Timeout, if
+// a deadline has not already been set.
+func (d Decoder) withDeadline() Decoder {
+	if d.Timeout > 0 && d.deadline.IsZero() {
+		d.deadline = time.Now().Add(d.Timeout)
+	}
+	return d
+}
+
+// expired returns whether the deadline of the decoder has passed.
+func (d Decoder) expired() bool {
+	return !d.deadline.IsZero() && time.Now().After(d.deadline)
+}
+
+// endOfChunks returns whether reading a chunk from fr failed because the data
+// ended at start, the boundary of the previous chunk. Such data is treated as
+// a file whose END chunk is missing.
+func endOfChunks(fr *parse.BinaryReader, start int64) bool {
+	return fr.Err() == io.EOF && fr.N() == start
+}
+
 func (d Decoder) decodeChunks(f *formatModel, fr *parse.BinaryReader, warns *errors.Errors) (err error) {
+	if d.Parallelism > 1 {
+		return d.decodeChunksParallel(f, fr, warns)
+	}
 	for i := 0; ; i++ {
-		rawChunk := new(rawChunk)
+		rawChunk := d.newRawChunk()
+		start := fr.N()
 		if rawChunk.Decode(fr) {
+			if endOfChunks(fr, start) {
+				*warns = warns.Append(ErrEndChunkMissing)
+				return nil
+			}
 			return decodeError(fr, nil)
 		}
-		if d.Stats != nil {
-			if d.Stats.ChunkTypes == nil {
-				d.Stats.ChunkTypes = map[string]int{}
-			}
-			d.Stats.ChunkTypes[sig(rawChunk.signature).String()]++
-		}
-
-		var n int64
-		var err error
-		var chunk chunk
-		payload := bytes.NewReader(rawChunk.payload)
-		switch rawChunk.signature {
-		case sigMETA:
-			ch := chunkMeta{}
-			n, err = ch.Decode(payload)
-			chunk = &ch
-		case sigSSTR:
-			ch := chunkSharedStrings{}
-			n, err = ch.Decode(payload)
-			chunk = &ch
-		case sigINST:
-			ch := chunkInstance{}
-			n, err = ch.Decode(payload)
-			chunk = &ch
-			if err == nil {
-				f.groupLookup[ch.ClassID] = &ch
-			}
-		case sigPROP:
-			ch := chunkProperty{}
-			n, err = ch.Decode(payload, f.groupLookup)
-			chunk = &ch
-		case sigPRNT:
-			ch := chunkParent{}
-			n, err = ch.Decode(payload)
-			chunk = &ch
-		case sigEND:
-			ch := chunkEnd{}
-			n, err = ch.Decode(payload)
-			chunk = &ch
-		default:
-			chunk = &chunkUnknown{rawChunk: *rawChunk}
-			*warns = warns.Append(ChunkError{Index: i, Sig: sig(rawChunk.signature), Cause: errUnknownChunkSig})
+		if d.expired() {
+			return decodeError(fr, ErrTimeout)
 		}
-
-		chunk.SetCompressed(bool(rawChunk.compressed))
-
-		if err != nil {
-			*warns = warns.Append(ChunkError{Index: i, Sig: sig(rawChunk.signature), Cause: err})
-			f.Chunks = append(f.Chunks, &chunkErrored{
-				chunk:  chunk,
-				Offset: n,
-				Cause:  err,
-				Bytes:  rawChunk.payload,
-			})
-			continue
+		if d.decodeChunk(f, i, rawChunk, warns) {
+			break
 		}
+	}
+	return nil
+}
 
-		f.Chunks = append(f.Chunks, chunk)
-		if d.Stats != nil {
-			d.Stats.Chunks++
+// decodeChunksParallel is like decodeChunks, but decompresses chunks
+// concurrently. Chunks are read from fr serially, and then processed in order
+// once all have been decompressed.
+func (d Decoder) decodeChunksParallel(f *formatModel, fr *parse.BinaryReader, warns *errors.Errors) (err error) {
+	type pendingChunk struct {
+		chunk  *rawChunk
+		offset int64
+		err    error
+	}
+	var chunks []*pendingChunk
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, d.Parallelism)
+	// firstError returns the error of the earliest chunk that failed to
+	// decompress, if any.
+	firstError := func() error {
+		wg.Wait()
+		for _, p := range chunks {
+			if p.err != nil {
+				return DataError{Offset: p.offset, Cause: p.err}
+			}
 		}
-
-		if chunk, ok := chunk.(*chunkEnd); ok {
-			if chunk.Compressed() {
-				*warns = warns.Append(errEndChunkCompressed)
+		return nil
+	}
+	missingEnd := false
+	for {
+		rawChunk := d.newRawChunk()
+		start := fr.N()
+		if rawChunk.read(fr) {
+			if endOfChunks(fr, start) {
+				missingEnd = true
+				break
 			}
-			if !bytes.Equal(chunk.Content, []byte("</roblox>")) {
-				*warns = warns.Append(errEndChunkContent)
+			if err := firstError(); err != nil {
+				return err
 			}
+			return decodeError(fr, nil)
+		}
+		p := &pendingChunk{chunk: rawChunk, offset: fr.N()}
+		chunks = append(chunks, p)
+		if rawChunk.pending != nil {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer wg.Done()
+				p.err = p.chunk.decompress()
+				<-sem
+			}()
+		}
+		if rawChunk.signature == sigEND {
 			break
 		}
 	}
-
+	if err := firstError(); err != nil {
+		return err
+	}
+	for i, p := range chunks {
+		if d.expired() {
+			return DataError{Offset: p.offset, Cause: ErrTimeout}
+		}
+		if d.decodeChunk(f, i, p.chunk, warns) {
+			break
+		}
+	}
+	if missingEnd {
+		*warns = warns.Append(ErrEndChunkMissing)
+	}
 	return nil
 }
 
-func (d Decoder) decompressChunks(f *formatModel, fr *parse.BinaryReader) (err error) {
+// decodeChunk decodes the payload of the ith chunk of a file, and adds it to
+// f. Returns true if the chunk ends the file.
+func (d Decoder) decodeChunk(f *formatModel, i int, rawChunk *rawChunk, warns *errors.Errors) (end bool) {
+	d.Stats.addChunk(rawChunk)
+	if rawChunk.truncated {
+		*warns = warns.Append(ChunkError{Index: i, Sig: sig(rawChunk.signature), Cause: EndChunkSizeError{Size: rawChunk.endSize, Limit: rawChunk.endLimit}})
+	}
+	if d.structureOnly && !structureChunk(rawChunk) {
+		return false
+	}
+
+	var n int64
+	var err error
+	var chunk chunk
+	payload := bytes.NewReader(rawChunk.payload)
+	switch rawChunk.signature {
+	case sigMETA:
+		ch := chunkMeta{}
+		n, err = ch.Decode(payload)
+		chunk = &ch
+	case sigSSTR:
+		ch := chunkSharedStrings{}
+		n, err = ch.Decode(payload)
+		chunk = &ch
+	case sigINST:
+		ch := chunkInstance{}
+		n, err = ch.Decode(payload)
+		chunk = &ch
+		if err == nil {
+			f.groupLookup[ch.ClassID] = &ch
+		}
+	case sigPROP:
+		ch := chunkProperty{}
+		n, err = ch.Decode(payload, f.groupLookup)
+		chunk = &ch
+		if err == nil && d.Stats != nil {
+			if d.Stats.PropertyTypes == nil {
+				d.Stats.PropertyTypes = map[string]int{}
+			}
+			name, _ := ch.DataType()
+			d.Stats.PropertyTypes[name]++
+		}
+	case sigPRNT:
+		ch := chunkParent{}
+		n, err = ch.Decode(payload)
+		chunk = &ch
+	case sigEND:
+		ch := chunkEnd{}
+		n, err = ch.Decode(payload)
+		chunk = &ch
+	default:
+		chunk = &chunkUnknown{rawChunk: *rawChunk}
+		*warns = warns.Append(ChunkError{Index: i, Sig: sig(rawChunk.signature), Cause: ErrUnknownChunk})
+	}
+
+	chunk.SetCompressed(bool(rawChunk.compressed))
+
+	if err != nil {
+		*warns = warns.Append(ChunkError{Index: i, Sig: sig(rawChunk.signature), Cause: err})
+		f.Chunks = append(f.Chunks, &chunkErrored{
+			chunk:  chunk,
+			Offset: n,
+			Cause:  err,
+			Bytes:  rawChunk.payload,
+		})
+		return false
+	}
+
+	f.Chunks = append(f.Chunks, chunk)
+	if d.Stats != nil {
+		d.Stats.Chunks++
+	}
+
+	if chunk, ok := chunk.(*chunkEnd); ok {
+		if chunk.Compressed() {
+			*warns = warns.Append(ErrEndChunkCompressed)
+		}
+		if !bytes.Equal(chunk.Content, []byte("</roblox>")) {
+			*warns = warns.Append(ErrEndChunkContent)
+		}
+		return true
+	}
+	return false
+}
+
+func (d Decoder) decompressChunks(f *formatModel, fr *parse.BinaryReader, warns *errors.Errors) (err error) {
 	for i := 0; ; i++ {
-		rawChunk := new(rawChunk)
+		rawChunk := d.newRawChunk()
+		start := fr.N()
 		if rawChunk.Decode(fr) {
-			return decodeError(fr, nil)
-		}
-		if d.Stats != nil {
-			if d.Stats.ChunkTypes == nil {
-				d.Stats.ChunkTypes = map[string]int{}
+			if endOfChunks(fr, start) {
+				*warns = warns.Append(ErrEndChunkMissing)
+				return nil
 			}
-			d.Stats.ChunkTypes[sig(rawChunk.signature).String()]++
+			return decodeError(fr, nil)
 		}
+		d.Stats.addChunk(rawChunk)
 
 		chunk := &chunkUnknown{rawChunk: *rawChunk}
 		chunk.SetCompressed(bool(rawChunk.compressed))
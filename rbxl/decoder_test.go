@@ -0,0 +1,1410 @@
+package rbxl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robloxapi/rbxfile"
+	rbxerrors "github.com/robloxapi/rbxfile/errors"
+	"github.com/robloxapi/rbxfile/rbxlx"
+)
+
+func TestDecodeDeepTree(t *testing.T) {
+	const depth = 100000
+	top := rbxfile.NewInstance("Folder")
+	parent := top
+	for i := 1; i < depth; i++ {
+		child := rbxfile.NewInstance("Folder")
+		parent.Children = append(parent.Children, child)
+		parent = child
+	}
+
+	buf := encodeRoot(t, Encoder{}, &rbxfile.Root{Instances: []*rbxfile.Instance{top}})
+
+	root, _, err := Decoder{MaxDepth: depth}.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	n := 0
+	for insts := root.Instances; len(insts) > 0; insts = insts[0].Children {
+		n++
+	}
+	if n != depth {
+		t.Errorf("expected depth %d, got %d", depth, n)
+	}
+
+	_, _, err = Decoder{MaxDepth: depth - 1}.Decode(bytes.NewReader(buf))
+	if !errors.As(err, &errMaxDepth{}) {
+		t.Errorf("expected max depth error, got %v", err)
+	}
+}
+
+func TestDecodeDropped(t *testing.T) {
+	inst := rbxfile.NewInstance("Part")
+	inst.Properties["Name"] = rbxfile.ValueString("Part")
+	b := encodeRoot(t, Encoder{Uncompressed: true}, &rbxfile.Root{Instances: []*rbxfile.Instance{inst}})
+
+	// Replace the value type of the property chunk with an unknown type.
+	i := bytes.Index(b, []byte("PROP"))
+	if i < 0 {
+		t.Fatal("no property chunk")
+	}
+	i += chunkHeaderSize + 4 + 4 + len("Name")
+	b[i] = 0xFF
+
+	var dropped []rbxfile.DroppedProperty
+	root, _, err := Decoder{Dropped: &dropped}.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if _, ok := root.Instances[0].Properties["Name"]; ok {
+		t.Error("expected property to be dropped")
+	}
+	if len(dropped) != 1 || dropped[0].Class != "Part" || dropped[0].Property != "Name" || dropped[0].Reason == "" {
+		t.Errorf("unexpected dropped properties: %v", dropped)
+	}
+}
+
+func TestDecodeSchema(t *testing.T) {
+	inst := rbxfile.NewInstance("Part")
+	inst.Properties["FloatToDouble"] = rbxfile.ValueFloat(0.5)
+	inst.Properties["DoubleToFloat"] = rbxfile.ValueDouble(0.25)
+	inst.Properties["IntToInt64"] = rbxfile.ValueInt(-3)
+	inst.Properties["Int64ToInt"] = rbxfile.ValueInt64(7)
+	inst.Properties["ToContent"] = rbxfile.ValueString("rbxassetid://1")
+	inst.Properties["ToBinary"] = rbxfile.ValueString("\x00\x01")
+	inst.Properties["ToProtected"] = rbxfile.ValueString("print()")
+	inst.Properties["Unsupported"] = rbxfile.ValueBool(true)
+	inst.Properties["Undeclared"] = rbxfile.ValueFloat(1)
+	buf := encodeRoot(t, Encoder{}, &rbxfile.Root{Instances: []*rbxfile.Instance{inst}})
+	schema := map[string]map[string]rbxfile.Type{
+		"Part": {
+			"FloatToDouble": rbxfile.TypeDouble,
+			"DoubleToFloat": rbxfile.TypeFloat,
+			"IntToInt64":    rbxfile.TypeInt64,
+			"Int64ToInt":    rbxfile.TypeInt,
+			"ToContent":     rbxfile.TypeContent,
+			"ToBinary":      rbxfile.TypeBinaryString,
+			"ToProtected":   rbxfile.TypeProtectedString,
+			"Unsupported":   rbxfile.TypeVector3,
+		},
+		// Other classes are not affected.
+		"Model": {"Undeclared": rbxfile.TypeDouble},
+	}
+	root, warn, err := Decoder{Schema: schema}.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if warn != nil {
+		t.Errorf("unexpected warning: %s", warn)
+	}
+	want := map[string]rbxfile.Value{
+		"FloatToDouble": rbxfile.ValueDouble(0.5),
+		"DoubleToFloat": rbxfile.ValueFloat(0.25),
+		"IntToInt64":    rbxfile.ValueInt64(-3),
+		"Int64ToInt":    rbxfile.ValueInt(7),
+		"ToContent":     rbxfile.ValueContent("rbxassetid://1"),
+		"ToBinary":      rbxfile.ValueBinaryString("\x00\x01"),
+		"ToProtected":   rbxfile.ValueProtectedString("print()"),
+		"Unsupported":   rbxfile.ValueBool(true),
+		"Undeclared":    rbxfile.ValueFloat(1),
+	}
+	if got := root.Instances[0].Properties; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %#v, got %#v", want, got)
+	}
+}
+
+func TestCoerceValue(t *testing.T) {
+	inst := rbxfile.NewInstance("Part")
+	for _, test := range []struct {
+		v     rbxfile.Value
+		t     rbxfile.Type
+		want  rbxfile.Value
+		lossy bool
+	}{
+		{rbxfile.ValueFloat(0.5), rbxfile.TypeDouble, rbxfile.ValueDouble(0.5), false},
+		{rbxfile.ValueDouble(0.5), rbxfile.TypeFloat, rbxfile.ValueFloat(0.5), false},
+		{rbxfile.ValueDouble(0.1), rbxfile.TypeFloat, rbxfile.ValueFloat(0.1), true},
+		{rbxfile.ValueInt(-3), rbxfile.TypeInt64, rbxfile.ValueInt64(-3), false},
+		{rbxfile.ValueInt64(7), rbxfile.TypeInt, rbxfile.ValueInt(7), false},
+		{rbxfile.ValueInt64(1 << 40), rbxfile.TypeInt, rbxfile.ValueInt(0), true},
+		{rbxfile.ValueInt(2), rbxfile.TypeToken, rbxfile.ValueToken(2), false},
+		{rbxfile.ValueInt(-1), rbxfile.TypeToken, rbxfile.ValueToken(0xFFFFFFFF), true},
+		{rbxfile.ValueInt64(3), rbxfile.TypeToken, rbxfile.ValueToken(3), false},
+		{rbxfile.ValueInt64(-1), rbxfile.TypeToken, rbxfile.ValueToken(0xFFFFFFFF), true},
+		{rbxfile.ValueString("rbxassetid://1"), rbxfile.TypeContent, rbxfile.ValueContent("rbxassetid://1"), false},
+		{rbxfile.ValueString("\x00\x01"), rbxfile.TypeBinaryString, rbxfile.ValueBinaryString("\x00\x01"), false},
+		{rbxfile.ValueString("print()"), rbxfile.TypeProtectedString, rbxfile.ValueProtectedString("print()"), false},
+		{rbxfile.ValueContent("rbxassetid://1"), rbxfile.TypeString, rbxfile.ValueString("rbxassetid://1"), false},
+		{rbxfile.ValueReference{Instance: inst}, rbxfile.TypeContentObject, rbxfile.ValueContentObject{Instance: inst}, false},
+	} {
+		got, lossy, ok := coerceValue(test.v, test.t)
+		if !ok {
+			t.Errorf("%s to %s: expected conversion", test.v.Type(), test.t)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) || lossy != test.lossy {
+			t.Errorf("%s to %s: expected %#v (lossy %t), got %#v (lossy %t)", test.v.Type(), test.t, test.want, test.lossy, got, lossy)
+		}
+	}
+
+	// Types without a conversion are rejected, including the reverse of
+	// one-way conversions.
+	for _, test := range []struct {
+		v rbxfile.Value
+		t rbxfile.Type
+	}{
+		{rbxfile.ValueBool(true), rbxfile.TypeVector3},
+		{rbxfile.ValueBinaryString("a"), rbxfile.TypeString},
+		{rbxfile.ValueToken(1), rbxfile.TypeInt},
+		{rbxfile.ValueContentObject{Instance: inst}, rbxfile.TypeReference},
+	} {
+		if got, _, ok := coerceValue(test.v, test.t); ok {
+			t.Errorf("%s to %s: unexpected conversion to %#v", test.v.Type(), test.t, got)
+		}
+	}
+}
+
+func TestDecodeNarrowing(t *testing.T) {
+	inst := rbxfile.NewInstance("Part")
+	inst.Properties["Exact"] = rbxfile.ValueDouble(0.5)
+	inst.Properties["Inexact"] = rbxfile.ValueDouble(0.1)
+	buf := encodeRoot(t, Encoder{}, &rbxfile.Root{Instances: []*rbxfile.Instance{inst}})
+	schema := map[string]map[string]rbxfile.Type{
+		"Part": {"Exact": rbxfile.TypeFloat, "Inexact": rbxfile.TypeFloat},
+	}
+
+	root, warn, err := Decoder{Schema: schema}.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	props := root.Instances[0].Properties
+	if v, ok := props["Exact"].(rbxfile.ValueFloat); !ok || v != 0.5 {
+		t.Errorf("Exact: unexpected value %#v", props["Exact"])
+	}
+	if v, ok := props["Inexact"].(rbxfile.ValueFloat); !ok || v != rbxfile.ValueFloat(0.1) {
+		t.Errorf("Inexact: unexpected value %#v", props["Inexact"])
+	}
+	want := LossyCoercionError{Class: "Part", Property: "Inexact", From: rbxfile.TypeDouble, To: rbxfile.TypeFloat}
+	if errs, ok := warn.(rbxerrors.Errors); !ok || len(errs) != 1 || errs[0] != want {
+		t.Errorf("expected narrowing warning, got %v", warn)
+	}
+
+	_, _, err = Decoder{Schema: schema, RejectLossyCoercion: true}.Decode(bytes.NewReader(buf))
+	if !errors.As(err, &LossyCoercionError{}) {
+		t.Errorf("expected narrowing error, got %v", err)
+	}
+}
+
+func TestDecodeCanonicalizeFloats(t *testing.T) {
+	floatNaN := rbxfile.ValueFloat(math.Float32frombits(0xFFC01234))
+	doubleNaN := rbxfile.ValueDouble(math.Float64frombits(0xFFF8000000001234))
+	inst := rbxfile.NewInstance("Part")
+	inst.Properties["FloatZero"] = rbxfile.ValueFloat(math.Copysign(0, -1))
+	inst.Properties["FloatNaN"] = floatNaN
+	inst.Properties["DoubleZero"] = rbxfile.ValueDouble(math.Copysign(0, -1))
+	inst.Properties["DoubleNaN"] = doubleNaN
+	buf := encodeRoot(t, Encoder{}, &rbxfile.Root{Instances: []*rbxfile.Instance{inst}})
+
+	bits := func(v rbxfile.Value) uint64 {
+		switch v := v.(type) {
+		case rbxfile.ValueFloat:
+			return uint64(math.Float32bits(float32(v)))
+		case rbxfile.ValueDouble:
+			return math.Float64bits(float64(v))
+		}
+		t.Fatalf("unexpected value %#v", v)
+		return 0
+	}
+
+	// Without the option, exact bits are preserved.
+	root, _, err := Decoder{}.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	for name, value := range inst.Properties {
+		if got, want := bits(root.Instances[0].Properties[name]), bits(value); got != want {
+			t.Errorf("%s: expected bits %#x, got %#x", name, want, got)
+		}
+	}
+
+	root, _, err = Decoder{CanonicalizeFloats: true}.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	for name, want := range map[string]uint64{
+		"FloatZero":  0,
+		"FloatNaN":   0x7FC00000,
+		"DoubleZero": 0,
+		"DoubleNaN":  0x7FF8000000000000,
+	} {
+		if got := bits(root.Instances[0].Properties[name]); got != want {
+			t.Errorf("%s: expected canonical bits %#x, got %#x", name, want, got)
+		}
+	}
+}
+
+// emptyGroupFile contains an instance group for Folder with no instances, and
+// a property chunk with no values for the group.
+const emptyGroupFile = "<roblox!\x89\xff\r\n\x1a\n\x00\x00\x02\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00" +
+	"INST\x00\x00\x00\x00\x13\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x06\x00\x00\x00Folder\x00\x00\x00\x00\x00" +
+	"PROP\x00\x00\x00\x00\r\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x04\x00\x00\x00Name\x01" +
+	"INST\x00\x00\x00\x00\x16\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x05\x00\x00\x00Model\x00\x01\x00\x00\x00\x00\x00\x00\x00" +
+	"PROP\x00\x00\x00\x00\x16\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x04\x00\x00\x00Name\x01\x05\x00\x00\x00Model" +
+	"PRNT\x00\x00\x00\x00\r\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01" +
+	"END\x00\x00\x00\x00\x00\t\x00\x00\x00\x00\x00\x00\x00</roblox>"
+
+func TestDecodeEmptyGroup(t *testing.T) {
+	root, warn, err := Decoder{}.Decode(bytes.NewReader([]byte(emptyGroupFile)))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if warn != nil {
+		t.Errorf("unexpected warning: %s", warn)
+	}
+	if len(root.Instances) != 1 || root.Instances[0].ClassName != "Model" {
+		t.Fatalf("unexpected instances %v", root.Instances)
+	}
+
+	buf := encodeRoot(t, Encoder{Mode: Model, Uncompressed: true}, root)
+	m, _, err := Decoder{}.DecodeRaw(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("raw decode error: %s", err)
+	}
+	if m.Header.ClassCount != 1 {
+		t.Errorf("expected 1 class, got %d", m.Header.ClassCount)
+	}
+	for _, chunk := range m.Chunks {
+		if bytes.Contains(chunk.Payload, []byte("Folder")) {
+			t.Errorf("empty group was encoded in %s chunk", chunk.Signature[:])
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	buf := encodeRoot(b, Encoder{Uncompressed: true}, newEncodeTestRoot(10000))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Decoder{}.Decode(bytes.NewReader(buf))
+	}
+}
+
+func BenchmarkDecodeInto(b *testing.B) {
+	buf := encodeRoot(b, Encoder{Uncompressed: true}, newEncodeTestRoot(10000))
+	root := &rbxfile.Root{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Decoder{}.DecodeInto(bytes.NewReader(buf), root)
+	}
+}
+
+func TestDecodeInto(t *testing.T) {
+	small := encodeRoot(t, Encoder{}, newEncodeTestRoot(5))
+	large := encodeRoot(t, Encoder{}, newEncodeTestRoot(20))
+
+	root := &rbxfile.Root{}
+	var prev map[*rbxfile.Instance]bool
+	for i, buf := range [][]byte{large, small, large} {
+		want, _, err := Decoder{}.Decode(bytes.NewReader(buf))
+		if err != nil {
+			t.Fatalf("%d: decode error: %s", i, err)
+		}
+		if _, err := (Decoder{}).DecodeInto(bytes.NewReader(buf), root); err != nil {
+			t.Fatalf("%d: decode into error: %s", i, err)
+		}
+		// Compare encodings, which do not distinguish between nil and empty
+		// Children slices.
+		var got, exp bytes.Buffer
+		if _, err := (Encoder{Uncompressed: true}).Encode(&got, root); err != nil {
+			t.Fatalf("%d: encode error: %s", i, err)
+		}
+		if _, err := (Encoder{Uncompressed: true}).Encode(&exp, want); err != nil {
+			t.Fatalf("%d: encode error: %s", i, err)
+		}
+		if !bytes.Equal(got.Bytes(), exp.Bytes()) {
+			t.Errorf("%d: decoded root differs from Decode", i)
+		}
+
+		// Instances of the previous tree are reused.
+		insts := map[*rbxfile.Instance]bool{}
+		reused := 0
+		var walk func([]*rbxfile.Instance)
+		walk = func(children []*rbxfile.Instance) {
+			for _, inst := range children {
+				insts[inst] = true
+				if prev[inst] {
+					reused++
+				}
+				walk(inst.Children)
+			}
+		}
+		walk(root.Instances)
+		if prev != nil && reused != len(insts) && reused != len(prev) {
+			t.Errorf("%d: reused %d of %d instances", i, reused, len(prev))
+		}
+		prev = insts
+	}
+}
+
+func BenchmarkDecodeParallel(b *testing.B) {
+	buf := encodeRoot(b, Encoder{}, newEncodeTestRoot(100000))
+	for _, n := range []int{1, 4} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			// Only the format is decoded, excluding the codec, which is not
+			// affected by parallelism.
+			for i := 0; i < b.N; i++ {
+				Decoder{Parallelism: n}.decode(bytes.NewReader(buf), false)
+			}
+		})
+	}
+}
+
+func BenchmarkDecodeStructure(b *testing.B) {
+	buf := encodeRoot(b, Encoder{Uncompressed: true}, newEncodeTestRoot(10000))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Decoder{}.DecodeStructure(bytes.NewReader(buf))
+	}
+}
+
+func TestDecodeStructure(t *testing.T) {
+	buf := encodeRoot(t, Encoder{}, newEncodeTestRoot(10))
+	root, _, err := Decoder{}.DecodeStructure(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if root.Metadata != nil {
+		t.Errorf("unexpected metadata %v", root.Metadata)
+	}
+	if len(root.Instances) != 1 || len(root.Instances[0].Children) != 10 {
+		t.Fatalf("unexpected tree")
+	}
+	part := root.Instances[0].Children[3]
+	if part.ClassName != "Part" || len(part.Children) != 1 || part.Children[0].ClassName != "ObjectValue" {
+		t.Errorf("unexpected instance %s with %d children", part.ClassName, len(part.Children))
+	}
+	if name, ok := part.Properties["Name"].(rbxfile.ValueString); len(part.Properties) != 1 || !ok || string(name) != "3" {
+		t.Errorf("unexpected properties %v", part.Properties)
+	}
+}
+
+func TestDecodeClassRemap(t *testing.T) {
+	hint := rbxfile.NewInstance("Hint")
+	hint.Properties["Text"] = rbxfile.ValueString("hello")
+	value := rbxfile.NewInstance("ObjectValue")
+	value.Properties["Value"] = rbxfile.ValueReference{Instance: hint}
+	hint.Children = append(hint.Children, value)
+	buf := encodeRoot(t, Encoder{}, &rbxfile.Root{Instances: []*rbxfile.Instance{hint}})
+
+	root, warn, err := Decoder{ClassRemap: map[string]string{"Hint": "Message"}}.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	want := ClassRemapError{From: "Hint", To: "Message"}
+	if errs, ok := warn.(rbxerrors.Errors); !ok || len(errs) != 1 || errs[0] != want {
+		t.Errorf("expected remap warning, got %v", warn)
+	}
+	msg := root.Instances[0]
+	if msg.ClassName != "Message" {
+		t.Errorf("expected Message, got %s", msg.ClassName)
+	}
+	if text, ok := msg.Properties["Text"].(rbxfile.ValueString); !ok || string(text) != "hello" {
+		t.Errorf("unexpected Text %#v", msg.Properties["Text"])
+	}
+	if len(msg.Children) != 1 || msg.Children[0].ClassName != "ObjectValue" {
+		t.Fatalf("unexpected children")
+	}
+	if ref, ok := msg.Children[0].Properties["Value"].(rbxfile.ValueReference); !ok || ref.Instance != msg {
+		t.Errorf("reference does not refer to remapped instance")
+	}
+}
+
+func TestDecodeStringValidation(t *testing.T) {
+	inst := rbxfile.NewInstance("Part")
+	inst.Properties["Name"] = rbxfile.ValueString("a\xffb\xfe\xfdc")
+	inst.Properties["Valid"] = rbxfile.ValueString("héllo")
+	inst.Properties["Data"] = rbxfile.ValueBinaryString("\x00\xff\x80")
+	buf := encodeRoot(t, Encoder{}, &rbxfile.Root{Instances: []*rbxfile.Instance{inst}})
+	schema := map[string]map[string]rbxfile.Type{"Part": {"Data": rbxfile.TypeBinaryString}}
+
+	root, _, err := Decoder{Schema: schema}.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if name := root.Instances[0].Properties["Name"].(rbxfile.ValueString); string(name) != "a\xffb\xfe\xfdc" {
+		t.Errorf("passthrough: unexpected Name %q", name)
+	}
+
+	root, _, err = Decoder{Schema: schema, StringValidation: StringReplace}.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	props := root.Instances[0].Properties
+	if name := props["Name"].(rbxfile.ValueString); string(name) != "a�b�c" {
+		t.Errorf("replace: unexpected Name %q", name)
+	}
+	if valid := props["Valid"].(rbxfile.ValueString); string(valid) != "héllo" {
+		t.Errorf("replace: unexpected Valid %q", valid)
+	}
+	if data, ok := props["Data"].(rbxfile.ValueBinaryString); !ok || string(data) != "\x00\xff\x80" {
+		t.Errorf("replace: unexpected Data %#v", props["Data"])
+	}
+
+	_, _, err = Decoder{Schema: schema, StringValidation: StringReject}.Decode(bytes.NewReader(buf))
+	if !errors.As(err, &InvalidUTF8Error{}) {
+		t.Errorf("expected invalid UTF-8 error, got %v", err)
+	}
+}
+
+func TestDecodeCaptureRawOnError(t *testing.T) {
+	raw := []byte("\xff\xff\xff\xff")
+	file := "<roblox!\x89\xff\r\n\x1a\n\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00" +
+		"INST\x04\x00\x00\x00\x64\x00\x00\x00\x00\x00\x00\x00" + string(raw)
+
+	for _, capture := range []bool{false, true} {
+		_, _, err := Decoder{CaptureRawOnError: capture}.Decode(strings.NewReader(file))
+		var cerr CompressionError
+		if !errors.As(err, &cerr) {
+			t.Fatalf("expected compression error, got %v", err)
+		}
+		if capture && !bytes.Equal(cerr.Raw, raw) {
+			t.Errorf("expected raw bytes %q, got %q", raw, cerr.Raw)
+		}
+		if !capture && cerr.Raw != nil {
+			t.Errorf("unexpected raw bytes %q", cerr.Raw)
+		}
+	}
+}
+
+func TestDecodeParallel(t *testing.T) {
+	buf := encodeRoot(t, Encoder{}, newEncodeTestRoot(1000))
+	var want, got bytes.Buffer
+	root, _, err := Decoder{}.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	Encoder{}.Encode(&want, root)
+	root, _, err = Decoder{Parallelism: 4}.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("parallel decode error: %s", err)
+	}
+	Encoder{}.Encode(&got, root)
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Errorf("parallel decode differs from serial decode")
+	}
+
+	// The first chunk fails to decompress, and the file is truncated after
+	// the second.
+	file := "<roblox!\x89\xff\r\n\x1a\n\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00" +
+		"INST\x04\x00\x00\x00\x64\x00\x00\x00\x00\x00\x00\x00\xff\xff\xff\xff" +
+		"PROP\x00\x00\x00\x00\x10\x00\x00\x00\x00\x00\x00\x00"
+	_, _, err = Decoder{Parallelism: 4}.Decode(strings.NewReader(file))
+	if !errors.As(err, &CompressionError{}) {
+		t.Errorf("expected compression error, got %v", err)
+	}
+}
+
+// intEnumFile contains a Part with the enum properties Material and Shape
+// stored as Int and Int64 rather than Token.
+const intEnumFile = "<roblox!\x89\xff\r\n\x1a\n\x00\x00\x01\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00" +
+	"INST\x00\x00\x00\x00\x15\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x04\x00\x00\x00Part\x00\x01\x00\x00\x00\x00\x00\x00\x00" +
+	"PROP\x00\x00\x00\x00\x15\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x08\x00\x00\x00Material\x03\x00\x00\x02\x00" +
+	"PROP\x00\x00\x00\x00\x16\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x05\x00\x00\x00Shape\x1b\x00\x00\x00\x00\x00\x00\x00\x02" +
+	"PRNT\x00\x00\x00\x00\r\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01" +
+	"END\x00\x00\x00\x00\x00\t\x00\x00\x00\x00\x00\x00\x00</roblox>"
+
+func TestDecodeIntEnum(t *testing.T) {
+	schema := map[string]map[string]rbxfile.Type{
+		"Part": {"Material": rbxfile.TypeToken, "Shape": rbxfile.TypeToken},
+	}
+	root, warn, err := Decoder{Schema: schema}.Decode(strings.NewReader(intEnumFile))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	props := root.Instances[0].Properties
+	if v, ok := props["Material"].(rbxfile.ValueToken); !ok || v != 256 {
+		t.Errorf("Material: unexpected value %#v", props["Material"])
+	}
+	if v, ok := props["Shape"].(rbxfile.ValueToken); !ok || v != 1 {
+		t.Errorf("Shape: unexpected value %#v", props["Shape"])
+	}
+	want := rbxerrors.Errors{
+		TokenFromIntError{Class: "Part", Property: "Material", From: rbxfile.TypeInt},
+		TokenFromIntError{Class: "Part", Property: "Shape", From: rbxfile.TypeInt64},
+	}
+	if errs, ok := warn.(rbxerrors.Errors); !ok || len(errs) != 2 || errs[0] != want[0] || errs[1] != want[1] {
+		t.Errorf("expected %v, got %v", want, warn)
+	}
+}
+
+// capabilitiesFile is a hand-written file containing a sandboxed Model with
+// the Capabilities property enabling RunClientScript, CreateInstances and
+// Basic. The Capabilities and Sandboxed properties are laid out as this
+// package encodes them.
+const capabilitiesFile = "<roblox!\x89\xff\r\n\x1a\n\x00\x00\x01\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00" +
+	"INST\x00\x00\x00\x00\x16\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x05\x00\x00\x00Model\x00\x01\x00\x00\x00\x00\x00\x00\x00" +
+	"PROP\x00\x00\x00\x00\x1d\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x0c\x00\x00\x00Capabilities\x21\x00\x00\x00\x00\x00\x00\x00\xc1" +
+	"PROP\x00\x00\x00\x00\x13\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x09\x00\x00\x00Sandboxed\x02\x01" +
+	"PRNT\x00\x00\x00\x00\r\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01" +
+	"END\x00\x00\x00\x00\x00\t\x00\x00\x00\x00\x00\x00\x00</roblox>"
+
+func TestDecodeSecurityCapabilities(t *testing.T) {
+	root, warn, err := Decoder{}.Decode(strings.NewReader(capabilitiesFile))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if warn != nil {
+		t.Errorf("unexpected warning: %s", warn)
+	}
+	caps, ok := root.Instances[0].Properties["Capabilities"].(rbxfile.ValueSecurityCapabilities)
+	if !ok {
+		t.Fatalf("unexpected Capabilities %#v", root.Instances[0].Properties["Capabilities"])
+	}
+	for _, c := range []rbxfile.SecurityCapability{
+		rbxfile.SecurityCapabilityRunClientScript,
+		rbxfile.SecurityCapabilityCreateInstances,
+		rbxfile.SecurityCapabilityBasic,
+	} {
+		if !caps.Has(c) {
+			t.Errorf("expected capability %s", c)
+		}
+	}
+	if caps.Has(rbxfile.SecurityCapabilityRunServerScript) {
+		t.Errorf("unexpected capability %s", rbxfile.SecurityCapabilityRunServerScript)
+	}
+	if s := rbxfile.SecurityCapabilityBasic.String(); s != "Basic" {
+		t.Errorf("expected name Basic, got %q", s)
+	}
+
+	buf := encodeRoot(t, Encoder{Mode: Model, Uncompressed: true}, root)
+	if string(buf) != capabilitiesFile {
+		t.Errorf("re-encoded file does not match fixture:\n%q", buf)
+	}
+}
+
+// rawCFrameFile contains a Part with an identity CFrame stored as a full
+// rotation matrix rather than a special rotation ID.
+const rawCFrameFile = "<roblox!\x89\xff\r\n\x1a\n\x00\x00\x01\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00" +
+	"INST\x00\x00\x00\x00\x15\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x04\x00\x00\x00Part\x00\x01\x00\x00\x00\x00\x00\x00\x00" +
+	"PROP\x00\x00\x00\x00\x40\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x06\x00\x00\x00CFrame\x10\x00\x00\x00\x80\x3f\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80\x3f\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80\x3f\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00" +
+	"PRNT\x00\x00\x00\x00\r\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01" +
+	"END\x00\x00\x00\x00\x00\t\x00\x00\x00\x00\x00\x00\x00</roblox>"
+
+func TestDecodePreserveRawValues(t *testing.T) {
+	for _, preserve := range []bool{false, true} {
+		root, _, err := Decoder{PreserveRawValues: preserve}.Decode(strings.NewReader(rawCFrameFile))
+		if err != nil {
+			t.Fatalf("preserve %t: decode error: %s", preserve, err)
+		}
+		_, raw := root.Instances[0].Properties["CFrame"].(RawValue)
+		if raw != preserve {
+			t.Errorf("preserve %t: unexpected CFrame %#v", preserve, root.Instances[0].Properties["CFrame"])
+		}
+		var buf bytes.Buffer
+		if _, err := (Encoder{Mode: Model, Uncompressed: true}).Encode(&buf, root); err != nil {
+			t.Fatalf("preserve %t: encode error: %s", preserve, err)
+		}
+		// Without a RawValue, the encoder selects the special rotation ID.
+		if identical := buf.String() == rawCFrameFile; identical != preserve {
+			t.Errorf("preserve %t: re-encoded file identical: %t", preserve, identical)
+		}
+
+		buf.Reset()
+		if _, err := (rbxlx.Encoder{}).Encode(&buf, root); err != nil {
+			t.Fatalf("preserve %t: rbxlx encode error: %s", preserve, err)
+		}
+		if !strings.Contains(buf.String(), `<CoordinateFrame name="CFrame">`) {
+			t.Errorf("preserve %t: CFrame not encoded by rbxlx:\n%s", preserve, buf.String())
+		}
+	}
+
+	// Converted values are not wrapped.
+	schema := map[string]map[string]rbxfile.Type{"Part": {"Material": rbxfile.TypeToken}}
+	root, _, err := Decoder{PreserveRawValues: true, Schema: schema, TokenFromInt: func(class, prop string) bool {
+		return prop == "Shape"
+	}}.Decode(strings.NewReader(intEnumFile))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	props := root.Instances[0].Properties
+	if v, ok := props["Material"].(rbxfile.ValueToken); !ok || v != 256 {
+		t.Errorf("Material: unexpected value %#v", props["Material"])
+	}
+	if v, ok := props["Shape"].(RawValue); !ok || v.Value != rbxfile.ValueInt64(1) {
+		t.Errorf("Shape: unexpected value %#v", props["Shape"])
+	}
+}
+
+func TestRemapAssetsRawValues(t *testing.T) {
+	root := &rbxfile.Root{}
+	for _, url := range []string{"rbxassetid://1", "rbxassetid://2"} {
+		label := rbxfile.NewInstance("TextLabel")
+		label.Properties["FontFace"] = rbxfile.ValueFont{Family: rbxfile.ValueContent(url)}
+		root.Instances = append(root.Instances, label)
+	}
+	buf := encodeRoot(t, Encoder{}, root)
+	root, _, err := Decoder{PreserveRawValues: true}.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+
+	n := rbxfile.RemapAssets(root, func(old string) (string, bool) {
+		return "rbxassetid://10", old == "rbxassetid://1"
+	})
+	if n != 1 {
+		t.Errorf("expected 1 change, got %d", n)
+	}
+	// A remapped value replaces the raw value, which no longer represents it.
+	if v, ok := root.Instances[0].Properties["FontFace"].(rbxfile.ValueFont); !ok || string(v.Family) != "rbxassetid://10" {
+		t.Errorf("expected remapped font, got %#v", root.Instances[0].Properties["FontFace"])
+	}
+	if v, ok := root.Instances[1].Properties["FontFace"].(RawValue); !ok || string(v.Value.(rbxfile.ValueFont).Family) != "rbxassetid://2" {
+		t.Errorf("expected unchanged raw value, got %#v", root.Instances[1].Properties["FontFace"])
+	}
+}
+
+func TestDecodeVerifySharedStringHashes(t *testing.T) {
+	root := &rbxfile.Root{}
+	part := rbxfile.NewInstance("Part")
+	part.Properties["PhysicalConfigData"] = rbxfile.ValueSharedString("config")
+	root.Instances = append(root.Instances, part)
+	file := encodeRoot(t, Encoder{Mode: Model, Uncompressed: true}, root)
+	// Replace the hash of the first shared string, which follows the chunk
+	// header, version, and count.
+	i := bytes.Index(file, []byte("SSTR"))
+	if i < 0 {
+		t.Fatalf("missing SSTR chunk")
+	}
+	copy(file[i+chunkHeaderSize+8:], bytes.Repeat([]byte{0xFF}, 16))
+
+	for _, verify := range []bool{false, true} {
+		got, warn, err := Decoder{VerifySharedStringHashes: verify}.Decode(bytes.NewReader(file))
+		if err != nil {
+			t.Fatalf("verify %t: decode error: %s", verify, err)
+		}
+		if v, ok := got.Instances[0].Properties["PhysicalConfigData"].(rbxfile.ValueSharedString); !ok || string(v) != "config" {
+			t.Errorf("verify %t: unexpected value %#v", verify, v)
+		}
+		mismatch := warn != nil && strings.Contains(warn.Error(), "hash does not match value")
+		if mismatch != verify {
+			t.Errorf("verify %t: unexpected warning %v", verify, warn)
+		}
+	}
+}
+
+func TestDecodePropertyFilter(t *testing.T) {
+	root := &rbxfile.Root{}
+	for _, name := range []string{"A", "B"} {
+		script := rbxfile.NewInstance("Script")
+		script.Properties["Name"] = rbxfile.ValueString(name)
+		script.Properties["Source"] = rbxfile.ValueProtectedString("print('" + name + "')")
+		root.Instances = append(root.Instances, script)
+	}
+	buf := encodeRoot(t, Encoder{Mode: Model}, root)
+
+	// The binary format stores ProtectedString as String, so a Schema is used
+	// to recover the type.
+	d := Decoder{
+		Schema: map[string]map[string]rbxfile.Type{"Script": {"Source": rbxfile.TypeProtectedString}},
+		PropertyFilter: func(class, prop string, v rbxfile.Value) (rbxfile.Value, bool) {
+			switch v := v.(type) {
+			case rbxfile.ValueProtectedString:
+				return nil, false
+			case rbxfile.ValueString:
+				return rbxfile.ValueString(class + "." + string(v)), true
+			}
+			return v, true
+		},
+	}
+	got, _, err := d.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	for i, inst := range got.Instances {
+		if _, ok := inst.Properties["Source"]; ok {
+			t.Errorf("instance %d: expected Source to be removed", i)
+		}
+		want := "Script." + string(root.Instances[i].Properties["Name"].(rbxfile.ValueString))
+		if v, ok := inst.Properties["Name"].(rbxfile.ValueString); !ok || string(v) != want {
+			t.Errorf("instance %d: expected Name %q, got %#v", i, want, inst.Properties["Name"])
+		}
+	}
+}
+
+// sharedStringChunks contains the content of an SSTR chunk for each version,
+// including unknown versions with and without content.
+var sharedStringChunks = []struct {
+	name    string
+	content string
+	unknown bool
+}{
+	{name: "version 0", content: "\x00\x00\x00\x00\x01\x00\x00\x00" + strings.Repeat("\x00", 16) + "\x06\x00\x00\x00config"},
+	{name: "version 1", content: "\x01\x00\x00\x00\x01\x00\x00\x00config", unknown: true},
+	{name: "version 1 empty", content: "\x01\x00\x00\x00", unknown: true},
+}
+
+func TestDecodeSharedStringVersions(t *testing.T) {
+	root := &rbxfile.Root{}
+	part := rbxfile.NewInstance("Part")
+	part.Properties["PhysicalConfigData"] = rbxfile.ValueSharedString("config")
+	root.Instances = append(root.Instances, part)
+	file := encodeRoot(t, Encoder{Mode: Model, Uncompressed: true}, root)
+	i := bytes.Index(file, []byte("SSTR"))
+	if i < 0 {
+		t.Fatalf("missing SSTR chunk")
+	}
+	end := i + chunkHeaderSize + int(binary.LittleEndian.Uint32(file[i+8:]))
+
+	for _, test := range sharedStringChunks {
+		var chunk chunkSharedStrings
+		if _, err := chunk.Decode(strings.NewReader(test.content)); err != nil {
+			t.Fatalf("%s: chunk decode error: %s", test.name, err)
+		}
+		if chunk.unknown != test.unknown {
+			t.Errorf("%s: expected unknown %t, got %t", test.name, test.unknown, chunk.unknown)
+		}
+		var content bytes.Buffer
+		if _, err := chunk.WriteTo(&content); err != nil {
+			t.Fatalf("%s: chunk encode error: %s", test.name, err)
+		}
+		if content.String() != test.content {
+			t.Errorf("%s: chunk encoded as %q", test.name, content.String())
+		}
+
+		// Replace the SSTR chunk of the file.
+		var header [chunkHeaderSize]byte
+		copy(header[:], "SSTR")
+		binary.LittleEndian.PutUint32(header[8:], uint32(len(test.content)))
+		f := append(append(append(append([]byte{}, file[:i]...), header[:]...), test.content...), file[end:]...)
+		_, warn, _ := Decoder{}.Decode(bytes.NewReader(f))
+		warned := warn != nil && strings.Contains(warn.Error(), "unrecognized shared string format 1")
+		if warned != test.unknown {
+			t.Errorf("%s: unexpected warning %v", test.name, warn)
+		}
+	}
+}
+
+func TestDecodeTokenFromInt(t *testing.T) {
+	var calls []string
+	d := Decoder{TokenFromInt: func(class, prop string) bool {
+		calls = append(calls, class+"."+prop)
+		return prop == "Material"
+	}}
+	root, warn, err := d.Decode(strings.NewReader(intEnumFile))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	props := root.Instances[0].Properties
+	if v, ok := props["Material"].(rbxfile.ValueToken); !ok || v != 256 {
+		t.Errorf("Material: unexpected value %#v", props["Material"])
+	}
+	// Only Int properties are passed to the hook.
+	if v, ok := props["Shape"].(rbxfile.ValueInt64); !ok || v != 1 {
+		t.Errorf("Shape: unexpected value %#v", props["Shape"])
+	}
+	if len(calls) != 1 || calls[0] != "Part.Material" {
+		t.Errorf("unexpected calls %v", calls)
+	}
+	want := TokenFromIntError{Class: "Part", Property: "Material", From: rbxfile.TypeInt}
+	if errs, ok := warn.(rbxerrors.Errors); !ok || len(errs) != 1 || errs[0] != want {
+		t.Errorf("expected %v, got %v", want, warn)
+	}
+}
+
+func TestDecodePropertyValueError(t *testing.T) {
+	m := RawModel{
+		Header: Header{ClassCount: 1, InstanceCount: 2},
+		Chunks: []RawChunk{
+			NewRawChunk("INST", false, []byte("\x00\x00\x00\x00\x04\x00\x00\x00Part\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02")),
+			// The second string claims 5 bytes but only has 2.
+			NewRawChunk("PROP", false, []byte("\x00\x00\x00\x00\x04\x00\x00\x00Name\x01\x01\x00\x00\x00A\x05\x00\x00\x00ab")),
+			NewEndChunk(),
+		},
+	}
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+	_, warn, err := Decoder{}.Decode(&buf)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	errs, ok := warn.(rbxerrors.Errors)
+	if !ok || len(errs) != 1 {
+		t.Fatalf("expected one warning, got %v", warn)
+	}
+	var perr errPropertyValue
+	if !errors.As(errs[0], &perr) {
+		t.Fatalf("expected property value error, got %v", errs[0])
+	}
+	if perr.Property != "Name" || perr.Index != 1 || perr.Offset != 18 {
+		t.Errorf("unexpected error %+v", perr)
+	}
+	const want = `#1 "PROP" chunk: property "Name", instance #1 at payload offset 18: `
+	if msg := errs[0].Error(); !strings.Contains(msg, want) {
+		t.Errorf("expected message containing %q, got %q", want, msg)
+	}
+}
+
+// unrecognizedVersionFile has a header with version 1, followed by chunks of
+// the current format.
+const unrecognizedVersionFile = "<roblox!\x89\xff\r\n\x1a\n\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00" +
+	"END\x00\x00\x00\x00\x00\t\x00\x00\x00\x00\x00\x00\x00</roblox>"
+
+func TestDecodeUnrecognizedVersion(t *testing.T) {
+	_, _, err := Decoder{}.Decode(strings.NewReader(unrecognizedVersionFile))
+	if !errors.Is(err, ErrUnrecognizedVersion) {
+		t.Fatalf("expected unrecognized version error, got %v", err)
+	}
+	if !errors.Is(err, errUnrecognizedVersion(1)) {
+		t.Errorf("expected version 1, got %v", err)
+	}
+}
+
+func TestReadHeader(t *testing.T) {
+	buf := encodeRoot(t, Encoder{}, newEncodeTestRoot(2))
+	h, err := ReadHeader(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("read error: %s", err)
+	}
+	if want := (Header{Version: 0, ClassCount: 3, InstanceCount: 5}); h != want {
+		t.Errorf("expected header %+v, got %+v", want, h)
+	}
+
+	// The version and reserved bytes are reported, not validated.
+	h, err = ReadHeader(strings.NewReader(unrecognizedVersionFile))
+	if err != nil {
+		t.Fatalf("version: read error: %s", err)
+	}
+	if h.Version != 1 {
+		t.Errorf("version: expected 1, got %d", h.Version)
+	}
+	reserved := []byte(unrecognizedVersionFile)
+	copy(reserved[len(robloxSig+binaryMarker+binaryHeader)+10:], "\x01\x02")
+	h, err = ReadHeader(bytes.NewReader(reserved))
+	if err != nil {
+		t.Fatalf("reserved: read error: %s", err)
+	}
+	if h.Reserved != [8]byte{1, 2} {
+		t.Errorf("reserved: unexpected bytes %v", h.Reserved)
+	}
+
+	var xml bytes.Buffer
+	if _, err := (rbxlx.Encoder{}).Encode(&xml, newEncodeTestRoot(1)); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	if _, err := ReadHeader(&xml); !errors.Is(err, ErrXML) {
+		t.Errorf("xml: expected ErrXML, got %v", err)
+	}
+	if _, err := ReadHeader(strings.NewReader("<rodlox!\x89\xff\r\n\x1a\n")); err == nil || errors.Is(err, ErrXML) {
+		t.Errorf("signature: expected error, got %v", err)
+	}
+	if _, err := ReadHeader(bytes.NewReader(buf[:20])); err == nil {
+		t.Error("truncated: expected error")
+	}
+}
+
+func TestRegisterParentLinkDecoder(t *testing.T) {
+	model := rbxfile.NewInstance("Model")
+	model.Children = append(model.Children, rbxfile.NewInstance("Folder"))
+	buf := encodeRoot(t, Encoder{Mode: Model}, &rbxfile.Root{Instances: []*rbxfile.Instance{model}})
+	m, _, err := Decoder{}.DecodeRaw(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+
+	// Version 1 is a hypothetical format of plain child-parent pairs.
+	var pairs [][2]int32
+	for i, chunk := range m.Chunks {
+		if string(chunk.Signature[:]) != "PRNT" {
+			continue
+		}
+		var c chunkParent
+		if _, err := c.Decode(bytes.NewReader(chunk.Payload)); err != nil {
+			t.Fatalf("decode parent chunk: %s", err)
+		}
+		payload := []byte{1}
+		put := func(v uint32) {
+			payload = append(payload, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+		}
+		put(uint32(len(c.Children)))
+		for j := range c.Children {
+			pairs = append(pairs, [2]int32{c.Children[j], c.Parents[j]})
+			put(uint32(c.Children[j]))
+			put(uint32(c.Parents[j]))
+		}
+		m.Chunks[i] = NewRawChunk("PRNT", false, payload)
+	}
+	var out bytes.Buffer
+	if _, err := m.WriteTo(&out); err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+	file := out.Bytes()
+
+	// An unregistered version is an error.
+	if _, _, err := (Decoder{}).Decode(bytes.NewReader(file)); err == nil {
+		t.Fatal("expected error for unregistered version")
+	}
+
+	var calls int
+	RegisterParentLinkDecoder(1, func(r io.Reader) (children, parents []int32, err error) {
+		calls++
+		var n uint32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return nil, nil, err
+		}
+		links := make([][2]int32, n)
+		if err := binary.Read(r, binary.LittleEndian, links); err != nil {
+			return nil, nil, err
+		}
+		for _, pair := range links {
+			children = append(children, pair[0])
+			parents = append(parents, pair[1])
+		}
+		return children, parents, nil
+	})
+	t.Cleanup(func() { RegisterParentLinkDecoder(1, nil) })
+
+	root, _, err := Decoder{}.Decode(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if calls != 1 || len(pairs) != 2 {
+		t.Errorf("expected 1 call for 2 links, got %d calls for %v", calls, pairs)
+	}
+	if len(root.Instances) != 1 || root.Instances[0].ClassName != "Model" ||
+		len(root.Instances[0].Children) != 1 || root.Instances[0].Children[0].ClassName != "Folder" {
+		t.Errorf("unexpected tree %v", root.Instances)
+	}
+}
+
+func TestDecodeWarningTypes(t *testing.T) {
+	var prnt bytes.Buffer
+	parents := chunkParent{Children: []int32{0}, Parents: []int32{nilInstance}}
+	if _, err := parents.WriteTo(&prnt); err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+	m := RawModel{
+		Header: Header{InstanceCount: 1, Reserved: [8]byte{1}},
+		Chunks: []RawChunk{
+			NewRawChunk("ABCD", false, nil),
+			NewRawChunk("PRNT", false, prnt.Bytes()),
+			NewRawChunk("END", false, []byte("</roblox><!-- -->")),
+		},
+	}
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+	_, warn, err := Decoder{MaxEndContentSize: 9}.Decode(&buf)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	warns, _ := warn.(rbxerrors.Errors)
+	if len(warns) != 4 {
+		t.Fatalf("expected 4 warnings, got %v", warn)
+	}
+
+	var reserve ReserveError
+	if !errors.As(warns[0], &reserve) || reserve.Offset != 24 || !bytes.Equal(reserve.Bytes, m.Header.Reserved[:]) {
+		t.Errorf("expected reserve warning, got %v", warns[0])
+	}
+	var chunkErr ChunkError
+	if !errors.Is(warns[1], ErrUnknownChunk) || !errors.As(warns[1], &chunkErr) || chunkErr.Index != 0 {
+		t.Errorf("expected unknown chunk warning, got %v", warns[1])
+	}
+	var size EndChunkSizeError
+	if !errors.As(warns[2], &size) || size != (EndChunkSizeError{Size: 17, Limit: 9}) {
+		t.Errorf("expected end chunk size warning, got %v", warns[2])
+	}
+	var ref ReferenceError
+	if !errors.As(warns[3], &ref) || ref != (ReferenceError{Index: 0, Ref: 0}) {
+		t.Errorf("expected reference warning, got %v", warns[3])
+	}
+	if s := warns[3].Error(); s != `#1 "PRNT" chunk: child #0: id 0 does not exist` {
+		t.Errorf("unexpected reference warning message %q", s)
+	}
+}
+
+func TestDecodeRejectLargeEndChunk(t *testing.T) {
+	for _, compressed := range []bool{false, true} {
+		m := RawModel{Chunks: []RawChunk{NewRawChunk("END", compressed, []byte("</roblox><!-- -->"))}}
+		var buf bytes.Buffer
+		if _, err := m.WriteTo(&buf); err != nil {
+			t.Fatalf("write error: %s", err)
+		}
+		file := buf.Bytes()
+
+		// The compressed content of the chunk is larger than the content.
+		stored := uint32(17)
+		if compressed {
+			stored = binary.LittleEndian.Uint32(file[headerSize+4:])
+		}
+
+		_, _, err := Decoder{MaxEndContentSize: 9, RejectLargeEndChunk: true}.Decode(bytes.NewReader(file))
+		var size EndChunkSizeError
+		if !errors.As(err, &size) || size != (EndChunkSizeError{Size: stored, Limit: 9}) {
+			t.Errorf("compressed %t: expected end chunk size error, got %v", compressed, err)
+		}
+
+		// Content within the limit is not rejected.
+		if _, _, err := (Decoder{MaxEndContentSize: int(stored), RejectLargeEndChunk: true}).Decode(bytes.NewReader(file)); err != nil {
+			t.Errorf("compressed %t: unexpected error within limit: %s", compressed, err)
+		}
+		// Without rejection, the chunk is truncated with a warning.
+		_, warn, err := Decoder{MaxEndContentSize: 9}.Decode(bytes.NewReader(file))
+		if err != nil {
+			t.Errorf("compressed %t: unexpected error without rejection: %s", compressed, err)
+		}
+		if !errors.As(warn, &size) {
+			if errs, ok := warn.(rbxerrors.Errors); !ok || !hasError(errs, &size) {
+				t.Errorf("compressed %t: expected end chunk size warning, got %v", compressed, warn)
+			}
+		}
+	}
+}
+
+// hasError returns whether an error in errs matches target, as by errors.As.
+func hasError(errs rbxerrors.Errors, target interface{}) bool {
+	for _, err := range errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDecoderStatsChunkSizes(t *testing.T) {
+	file := encodeRoot(t, Encoder{}, newEncodeTestRoot(10))
+	// Append an END chunk larger than the limit, after the existing one.
+	end := RawModel{Chunks: []RawChunk{NewRawChunk("END", false, []byte("</roblox><!-- -->"))}}
+	var endBuf bytes.Buffer
+	if _, err := end.WriteTo(&endBuf); err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+	file = append(file[:len(file)-chunkHeaderSize-len("</roblox>")], endBuf.Bytes()[headerSize:]...)
+
+	// Sum the lengths declared by each chunk header.
+	want := map[string]*ChunkSizeStats{}
+	for b := file[headerSize:]; len(b) > 0; {
+		name := sig(binary.LittleEndian.Uint32(b)).String()
+		compressed := binary.LittleEndian.Uint32(b[4:])
+		decompressed := binary.LittleEndian.Uint32(b[8:])
+		stored := compressed
+		if stored == 0 {
+			stored = decompressed
+		}
+		if want[name] == nil {
+			want[name] = &ChunkSizeStats{}
+		}
+		want[name].Compressed += int64(stored)
+		want[name].Decompressed += int64(decompressed)
+		b = b[chunkHeaderSize+int(stored):]
+	}
+	for _, size := range want {
+		size.Ratio = float64(size.Compressed) / float64(size.Decompressed)
+	}
+
+	var stats DecoderStats
+	if _, _, err := (Decoder{Stats: &stats, MaxEndContentSize: 9}).Decode(bytes.NewReader(file)); err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if !reflect.DeepEqual(stats.ChunkSizes, want) {
+		for name, size := range stats.ChunkSizes {
+			t.Logf("%s: got %+v, expected %+v", name, size, want[name])
+		}
+		t.Error("unexpected chunk sizes")
+	}
+	if end := stats.ChunkSizes["END."]; end == nil || end.Decompressed != 17 {
+		t.Errorf("expected declared size of truncated END chunk, got %+v", end)
+	}
+}
+
+func TestDecodeMissingEnd(t *testing.T) {
+	buf := encodeRoot(t, Encoder{Uncompressed: true}, newEncodeTestRoot(10))
+	m, _, err := Decoder{}.DecodeRaw(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("raw decode error: %s", err)
+	}
+	m.Chunks = m.Chunks[:len(m.Chunks)-1]
+	var stripped bytes.Buffer
+	if _, err := m.WriteTo(&stripped); err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+
+	for _, d := range []Decoder{{}, {Parallelism: 4}} {
+		root, warn, err := d.Decode(bytes.NewReader(stripped.Bytes()))
+		if err != nil {
+			t.Fatalf("parallelism %d: decode error: %s", d.Parallelism, err)
+		}
+		if errs, ok := warn.(rbxerrors.Errors); !ok || len(errs) != 1 || errs[0] != ErrEndChunkMissing {
+			t.Errorf("parallelism %d: expected missing end warning, got %v", d.Parallelism, warn)
+		}
+		if n := len(root.Instances); n != 1 || len(root.Instances[0].Children) != 10 {
+			t.Errorf("parallelism %d: unexpected tree", d.Parallelism)
+		}
+	}
+
+	raw, warn, err := Decoder{}.DecodeRaw(bytes.NewReader(stripped.Bytes()))
+	if err != nil {
+		t.Fatalf("raw decode error: %s", err)
+	}
+	if errs, ok := warn.(rbxerrors.Errors); !ok || len(errs) != 1 || errs[0] != ErrEndChunkMissing {
+		t.Errorf("expected raw missing end warning, got %v", warn)
+	}
+	if len(raw.Chunks) != len(m.Chunks) {
+		t.Errorf("expected %d raw chunks, got %d", len(m.Chunks), len(raw.Chunks))
+	}
+
+	// Data that ends within a chunk is still an error.
+	if _, _, err := (Decoder{}).Decode(bytes.NewReader(stripped.Bytes()[:stripped.Len()-1])); err == nil {
+		t.Errorf("expected error for truncated chunk")
+	}
+}
+
+func TestDecodeMaxDecompressedBytes(t *testing.T) {
+	// A compressed chunk of 4 bytes that declares a payload of 2 GiB.
+	const bomb = "<roblox!\x89\xff\r\n\x1a\n\x00\x00\x01\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00" +
+		"INST\x04\x00\x00\x00\x00\x00\x00\x80\x00\x00\x00\x00\x00\x00\x00\x00"
+	for _, d := range []Decoder{{MaxDecompressedBytes: 1 << 20}, {MaxDecompressedBytes: 1 << 20, Parallelism: 4}} {
+		_, _, err := d.Decode(strings.NewReader(bomb))
+		var sizeErr DecompressedSizeError
+		if !errors.As(err, &sizeErr) || sizeErr != (DecompressedSizeError{Size: 1 << 31, Limit: 1 << 20}) {
+			t.Errorf("parallelism %d: expected size error, got %v", d.Parallelism, err)
+		}
+	}
+
+	// The limit applies to the total across chunks.
+	buf := encodeRoot(t, Encoder{}, newEncodeTestRoot(10))
+	m, _, err := Decoder{}.DecodeRaw(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("raw decode error: %s", err)
+	}
+	var total int64
+	for _, chunk := range m.Chunks {
+		total += int64(len(chunk.Payload))
+	}
+	if _, _, err := (Decoder{MaxDecompressedBytes: total}).Decode(bytes.NewReader(buf)); err != nil {
+		t.Errorf("decode error at limit: %s", err)
+	}
+	if _, _, err := (Decoder{MaxDecompressedBytes: total - 1}).Decode(bytes.NewReader(buf)); !errors.Is(err, ErrDecompressedSize) {
+		t.Errorf("expected size error below limit, got %v", err)
+	}
+}
+
+// slowReader reads a few bytes at a time, pausing before each read.
+type slowReader struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (r slowReader) Read(p []byte) (n int, err error) {
+	time.Sleep(r.delay)
+	if len(p) > 16 {
+		p = p[:16]
+	}
+	return r.r.Read(p)
+}
+
+func TestDecodeTimeout(t *testing.T) {
+	buf := encodeRoot(t, Encoder{}, newEncodeTestRoot(10))
+	for _, d := range []Decoder{{Timeout: 20 * time.Millisecond}, {Timeout: 20 * time.Millisecond, Parallelism: 4}} {
+		r := slowReader{r: bytes.NewReader(buf), delay: time.Millisecond}
+		start := time.Now()
+		_, _, err := d.Decode(r)
+		if !errors.Is(err, ErrTimeout) {
+			t.Errorf("parallelism %d: expected timeout error, got %v", d.Parallelism, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("parallelism %d: timeout fired after %s", d.Parallelism, elapsed)
+		}
+	}
+
+	// Decoding that finishes in time is unaffected.
+	if _, _, err := (Decoder{Timeout: time.Minute}).Decode(bytes.NewReader(buf)); err != nil {
+		t.Errorf("decode error: %s", err)
+	}
+
+	// The deadline is checked after the data has been read.
+	f, _, _, err := Decoder{}.decode(bytes.NewReader(buf), false)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	past := time.Now().Add(-time.Second)
+	if _, _, err := (robloxCodec{Deadline: past}).Decode(f); !errors.Is(err, ErrTimeout) {
+		t.Errorf("codec: expected timeout error, got %v", err)
+	}
+	root, _, err := Decoder{}.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if _, err := (Decoder{CanonicalizeFloats: true, deadline: past}).postDecode(root); !errors.Is(err, ErrTimeout) {
+		t.Errorf("post-processing: expected timeout error, got %v", err)
+	}
+
+	// Reading the XML format is limited.
+	var xml bytes.Buffer
+	if _, err := (rbxlx.Encoder{}).Encode(&xml, newEncodeTestRoot(10)); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	r := slowReader{r: bytes.NewReader(xml.Bytes()), delay: time.Millisecond}
+	if _, _, err := (Decoder{Timeout: 20 * time.Millisecond}).Decode(r); !errors.Is(err, ErrTimeout) {
+		t.Errorf("xml: expected timeout error, got %v", err)
+	}
+}
+
+func TestDecodeEmptyPropertyName(t *testing.T) {
+	part := rbxfile.NewInstance("Part")
+	part.Properties["Name"] = rbxfile.ValueString("Part")
+	part.Properties[""] = rbxfile.ValueString("unnamed")
+	buf := encodeRoot(t, Encoder{}, &rbxfile.Root{Instances: []*rbxfile.Instance{part}})
+
+	for _, drop := range []bool{false, true} {
+		var dropped []rbxfile.DroppedProperty
+		root, warn, err := Decoder{DropUnnamedProperties: drop, Dropped: &dropped}.Decode(bytes.NewReader(buf))
+		if err != nil {
+			t.Fatalf("drop %t: decode error: %s", drop, err)
+		}
+		var chunkErr ChunkError
+		if errs, ok := warn.(rbxerrors.Errors); !ok || len(errs) != 1 || !errors.As(errs[0], &chunkErr) || chunkErr.Cause != ErrEmptyPropertyName {
+			t.Errorf("drop %t: expected empty name warning, got %v", drop, warn)
+		}
+		v, ok := root.Instances[0].Properties[""]
+		if drop {
+			if ok || len(dropped) != 1 || dropped[0].Property != "" {
+				t.Errorf("drop %t: expected property to be dropped", drop)
+			}
+		} else if !ok || v.String() != "unnamed" {
+			t.Errorf("drop %t: expected unnamed property, got %v", drop, v)
+		}
+		if root.Instances[0].Properties["Name"].String() != "Part" {
+			t.Errorf("drop %t: expected Name property", drop)
+		}
+	}
+}
+
+func TestDecodeAll(t *testing.T) {
+	a := encodeRoot(t, Encoder{Mode: Model}, newEncodeTestRoot(2))
+	b := encodeRoot(t, Encoder{Mode: Model}, newEncodeTestRoot(3))
+	data := append(append([]byte{}, a...), b...)
+
+	roots, warn, err := Decoder{Mode: Model}.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if warn != nil {
+		t.Errorf("unexpected warning: %s", warn)
+	}
+	if len(roots) != 2 || len(roots[0].Instances[0].Children) != 2 || len(roots[1].Instances[0].Children) != 3 {
+		t.Fatalf("unexpected roots")
+	}
+
+	// Roots decoded before an error are returned with the error, and the
+	// offset of the error is relative to the start of the data.
+	corrupt := append(append([]byte{}, data...), "<roblox!garbage"...)
+	roots, _, err = Decoder{Mode: Model}.DecodeAll(bytes.NewReader(corrupt))
+	if len(roots) != 2 {
+		t.Errorf("expected 2 roots before error, got %d", len(roots))
+	}
+	var dataErr DataError
+	if !errors.As(err, &dataErr) || dataErr.Offset < int64(len(data)) {
+		t.Errorf("expected data error after offset %d, got %v", len(data), err)
+	}
+
+	// Warnings from the header of each model are returned.
+	reserved := append([]byte{}, a...)
+	reserved[len(robloxSig+binaryMarker+binaryHeader)+2+4+4] = 1
+	_, warn, err = Decoder{Mode: Model}.DecodeAll(bytes.NewReader(append(reserved, b...)))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if warns, _ := warn.(rbxerrors.Errors); len(warns) != 1 || !errors.As(warns[0], new(ReserveError)) {
+		t.Errorf("expected reserve warning, got %v", warn)
+	}
+
+	// The package-level function discards warnings.
+	roots, err = DecodeAll(bytes.NewReader(append(reserved, b...)))
+	if err != nil || len(roots) != 2 {
+		t.Errorf("expected 2 roots without error, got %d, %v", len(roots), err)
+	}
+	roots, err = DecodeAll(bytes.NewReader(corrupt))
+	if len(roots) != 2 || !errors.As(err, &dataErr) {
+		t.Errorf("expected 2 roots with data error, got %d, %v", len(roots), err)
+	}
+}
+
+func TestDecodeXMLOptions(t *testing.T) {
+	const file = `<roblox version="4">
+	<Item class="Model" referent="RBX0">
+		<Properties>
+			<string name="">unnamed</string>
+		</Properties>
+		<Item class="Part" referent="RBX1">
+			<Properties></Properties>
+		</Item>
+	</Item>
+</roblox>`
+
+	var dropped []rbxfile.DroppedProperty
+	root, _, err := Decoder{Dropped: &dropped, DropUnnamedProperties: true}.Decode(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if _, ok := root.Instances[0].Properties[""]; ok {
+		t.Errorf("unnamed property not dropped")
+	}
+	if len(dropped) != 1 || dropped[0].Class != "Model" || dropped[0].Property != "" {
+		t.Errorf("unexpected dropped properties %+v", dropped)
+	}
+
+	if _, _, err := (Decoder{MaxDepth: 1}).Decode(strings.NewReader(file)); !errors.As(err, new(errMaxDepth)) {
+		t.Errorf("expected depth error, got %v", err)
+	}
+	if _, _, err := (Decoder{MaxDepth: 2}).Decode(strings.NewReader(file)); err != nil {
+		t.Errorf("decode error within depth: %s", err)
+	}
+}
+
+func TestDecodeFullChunkInfo(t *testing.T) {
+	buf := encodeRoot(t, Encoder{}, newEncodeTestRoot(2))
+	result, _, err := Decoder{}.DecodeFull(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	want := map[string]ChunkInfo{
+		"Name":           {DataTypeName: "String", DataType: rbxfile.TypeString, SizeKind: "array"},
+		"Size":           {DataTypeName: "Vector3", DataType: rbxfile.TypeVector3, SizeKind: "constant"},
+		"WorldPivotData": {DataTypeName: "OptionalCFrame", DataType: rbxfile.TypeCFrame, SizeKind: "optional"},
+	}
+	for _, info := range result.Chunks {
+		if info.Signature != "PROP" {
+			if info.Property != "" || info.SizeKind != "" {
+				t.Errorf("%s chunk has property info %+v", info.Signature, info)
+			}
+			continue
+		}
+		w, ok := want[info.Property]
+		if !ok {
+			continue
+		}
+		delete(want, info.Property)
+		if info.DataTypeName != w.DataTypeName || info.DataType != w.DataType || info.SizeKind != w.SizeKind {
+			t.Errorf("%s: expected %+v, got %+v", info.Property, w, info)
+		}
+	}
+	for name := range want {
+		t.Errorf("%s: no PROP chunk", name)
+	}
+}
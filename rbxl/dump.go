@@ -78,6 +78,12 @@ func dumpChunk(w *bufio.Writer, indent, i int, chunk chunk, classes map[int32]*c
 	case *chunkSharedStrings:
 		dumpNewline(w, indent+1)
 		fmt.Fprintf(w, "Version: %d", chunk.Version)
+		if chunk.unknown {
+			dumpNewline(w, indent+1)
+			w.WriteString("Content: ")
+			dumpBytes(w, indent+1, chunk.raw)
+			break
+		}
 		dumpNewline(w, indent+1)
 		fmt.Fprintf(w, "Values: (count:%d) {", len(chunk.Values))
 		for i, s := range chunk.Values {
@@ -137,9 +143,10 @@ func dumpChunk(w *bufio.Writer, indent, i int, chunk chunk, classes map[int32]*c
 		dumpString(w, indent+1, chunk.PropertyName)
 		if chunk.Properties != nil {
 			t := chunk.Properties.Type()
+			name, _ := chunk.DataType()
 			length := chunk.Properties.Len()
 			dumpNewline(w, indent+1)
-			fmt.Fprintf(w, "Properties: (count:%d, (type:%d) %s) ", length, t, t.String())
+			fmt.Fprintf(w, "Properties: (count:%d, (type:%d) %s) ", length, t, name)
 			if a, ok := chunk.Properties.(arrayDumper); ok {
 				a.Dump(w, indent+1)
 			} else {
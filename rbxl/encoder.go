@@ -2,6 +2,7 @@ package rbxl
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 
 	"github.com/anaminus/parse"
@@ -15,24 +16,294 @@ type Encoder struct {
 	Mode Mode
 
 	// Uncompressed sets whether compression is forcibly disabled for all
-	// chunks.
+	// chunks. Each chunk is written with a compressed length of 0. The result
+	// is identical to that of passing a compressed encoding of the same root
+	// to Decoder.Decompress.
 	Uncompressed bool
+
+	// ValidateSequences sets whether NumberSequence and ColorSequence values
+	// are validated before encoding. If true, an invalid sequence causes
+	// encoding to fail.
+	ValidateSequences bool
+
+	// PreserveServices sets whether the IsService flag of instances is encoded
+	// when Mode is Model. By default, services are only encoded in Place mode,
+	// and every instance of a model is encoded as a regular instance.
+	//
+	// This is useful when a subtree of a place is converted to a model, but
+	// still requires service semantics. Note that Roblox does not expect
+	// services within a model, and may reject or misinterpret such a file.
+	// Decoding the result with this package retains the flags.
+	PreserveServices bool
+
+	// CompressionMetadata sets whether the MetadataCompression entry of the
+	// root determines the compression of the file. If the entry is
+	// CompressionNone, then compression is disabled as with Uncompressed. If
+	// the entry is CompressionLZ4, then chunks are compressed as usual. If the
+	// entry is absent, then the configuration of the encoder applies. An
+	// unknown value emits a warning, and the configuration of the encoder
+	// applies. The entry itself is not encoded.
+	CompressionMetadata bool
+
+	// EndContent is the content of the END chunk, which follows the end of the
+	// data. If nil, then the standard content, `</roblox>`, is used. Other
+	// content, such as provenance data, emits a warning. Note that decoders
+	// may limit the size of the content; see Decoder.MaxEndContentSize.
+	EndContent []byte
+
+	// PropertyOrder, if not nil, determines the order of the property chunks
+	// of each class, such as to replicate the order written by Studio. It is
+	// called for each class with the names of the encoded properties, sorted
+	// alphabetically, and reorders names in place. If the result is not a
+	// permutation of the given names, then a warning is emitted, and the
+	// alphabetical order is used.
+	PropertyOrder func(class string, names []string)
+
+	// OmitDefaults sets whether properties that have their default value, as
+	// returned by Defaults, are omitted from the file. A property is omitted
+	// when every instance of the class has the default value. Because the
+	// format stores a value of a property for every instance of a class, the
+	// property is encoded for all instances if any has another value. Values
+	// are compared by their binary representation.
+	//
+	// Omitted properties are restored by decoding with the same default values
+	// in Decoder.Defaults.
+	OmitDefaults bool
+
+	// Defaults returns the default value of property prop of class, such as
+	// from an API dump, and whether the property has a default. It is called
+	// only when OmitDefaults is set.
+	Defaults func(class, prop string) (rbxfile.Value, bool)
+
+	// ExcludeMetadata sets whether the META chunk is omitted, discarding the
+	// Metadata of the root. Decoding a file without a META chunk produces a
+	// root with nil Metadata.
+	ExcludeMetadata bool
+
+	// MetadataPosition determines where the META chunk is written. By
+	// default, it is written first, as Roblox does. The chunk is decoded
+	// regardless of its position.
+	MetadataPosition MetadataPosition
+}
+
+// withMetadata returns the encoder configured by the metadata of root.
+func (e Encoder) withMetadata(root *rbxfile.Root) (enc Encoder, warn error) {
+	if !e.CompressionMetadata {
+		return e, nil
+	}
+	method, ok := root.Metadata[MetadataCompression]
+	if !ok {
+		return e, nil
+	}
+	switch method {
+	case CompressionLZ4:
+		e.Uncompressed = false
+	case CompressionNone:
+		e.Uncompressed = true
+	default:
+		return e, errUnknownCompression(method)
+	}
+	return e, nil
+}
+
+// codec returns a codec configured by the encoder.
+func (e Encoder) codec() robloxCodec {
+	c := robloxCodec{
+		Mode:                    e.Mode,
+		PreserveServices:        e.PreserveServices,
+		OmitCompressionMetadata: e.CompressionMetadata,
+		EndContent:              e.EndContent,
+		PropertyOrder:           e.PropertyOrder,
+		ExcludeMetadata:         e.ExcludeMetadata,
+		MetadataPosition:        e.MetadataPosition,
+	}
+	if e.OmitDefaults {
+		c.Defaults = e.Defaults
+	}
+	return c
 }
 
 // Encode formats root according to the rbxl format, and writers it to w.
+// Instances are given the reference numbers returned by AssignReferences.
+// A ValueContentObject property is encoded as a Reference to its instance,
+// which is decoded as a ValueReference unless Decoder.Schema declares the
+// property as a ContentObject.
 func (e Encoder) Encode(w io.Writer, root *rbxfile.Root) (warn, err error) {
 	if w == nil {
 		return nil, errors.New("nil writer")
 	}
 
-	codec := robloxCodec{Mode: e.Mode}
+	if e.ValidateSequences {
+		if err := validateSequences(root.Instances); err != nil {
+			return nil, CodecError{Cause: err}
+		}
+	}
+
+	e, warn = e.withMetadata(root)
+	codec := e.codec()
 	f, ws, err := codec.Encode(root)
 	warn = errors.Union(warn, ws)
 	if err != nil {
 		return warn, CodecError{Cause: err}
 	}
 
-	return e.encode(w, f, false)
+	ws, err = e.encode(w, f, false)
+	return errors.Union(warn, ws), err
+}
+
+// EncodeGzip is like Encode, but the output is compressed as a whole with gzip,
+// in addition to the compression of individual chunks. The result can be
+// decoded with Decoder.DecodeGzip.
+func (e Encoder) EncodeGzip(w io.Writer, root *rbxfile.Root) (warn, err error) {
+	return rbxfile.EncodeGzip(w, root, e.Encode)
+}
+
+// errStreamWrite indicates that writing a chunk failed while streaming. The
+// actual error is retained by the writer.
+var errStreamWrite = errors.New("stream write failed")
+
+// EncodeStream formats root according to the rbxl format, and writes it to w.
+// The output is identical to that of Encode.
+//
+// Rather than building every chunk before writing, each chunk is written as
+// soon as it is made, so that the values of only one property chunk are held
+// in memory at a time. Instances are still grouped before any chunk is
+// written.
+//
+// Because chunks are written as they are made, an error may occur after part
+// of the output has already been written to w. In this case, the output is
+// truncated, and should be discarded by the caller. To avoid writing partial
+// output, use Encode instead.
+func (e Encoder) EncodeStream(w io.Writer, root *rbxfile.Root) (warn, err error) {
+	if w == nil {
+		return nil, errors.New("nil writer")
+	}
+
+	if e.ValidateSequences {
+		if err := validateSequences(root.Instances); err != nil {
+			return nil, CodecError{Cause: err}
+		}
+	}
+
+	e, warn = e.withMetadata(root)
+	fw := parse.NewBinaryWriter(w)
+	codec := e.codec()
+	ws, err := codec.EncodeChunks(root,
+		func(classCount, instanceCount uint32) error {
+			if writeHeader(fw, 0, classCount, instanceCount) {
+				return errStreamWrite
+			}
+			return nil
+		},
+		func(chunk chunk) error {
+			if end, ok := chunk.(*chunkEnd); ok && !bytes.Equal(end.Content, []byte("</roblox>")) {
+				warn = errors.Union(warn, ErrEndChunkContent)
+			}
+			if e.writeChunk(fw, chunk) {
+				return errStreamWrite
+			}
+			return nil
+		},
+	)
+	warn = errors.Union(warn, ws)
+	if err == errStreamWrite {
+		return warn, encodeError(fw, nil)
+	}
+	if err != nil {
+		return warn, CodecError{Cause: err}
+	}
+	return warn, encodeError(fw, nil)
+}
+
+// validateSequences validates each sequence value within insts and their
+// descendants, returning the first error.
+func validateSequences(insts []*rbxfile.Instance) error {
+	for _, inst := range insts {
+		for name, value := range inst.Properties {
+			var err error
+			switch value := value.(type) {
+			case rbxfile.ValueNumberSequence:
+				err = value.Validate()
+			case rbxfile.ValueColorSequence:
+				err = value.Validate()
+			}
+			if err != nil {
+				return fmt.Errorf("%s.%s: %w", inst.ClassName, name, err)
+			}
+		}
+		if err := validateSequences(inst.Children); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// headerSize is the number of bytes occupied by the file header.
+const headerSize = len(robloxSig+binaryMarker+binaryHeader) + 2 + 4 + 4 + 8
+
+// chunkHeaderSize is the number of bytes occupied by the header of a raw
+// chunk.
+const chunkHeaderSize = 4 + 4 + 4 + 4
+
+// EstimateSize returns the number of bytes that an Encoder with default
+// options would write when encoding root. Instances and properties are grouped
+// into chunks in the same way as Encode, and the size is the sum of the header
+// of the file and of each chunk, and the length of each payload. Payloads are
+// not compressed, and no bytes are written.
+//
+// The result is exact for uncompressed output, as written with Uncompressed.
+// Compressed output is usually smaller. Returns 0 if root cannot be encoded.
+func EstimateSize(root *rbxfile.Root) int64 {
+	f, _, err := Encoder{}.codec().Encode(root)
+	if err != nil {
+		return 0
+	}
+	n := int64(headerSize)
+	for _, chunk := range f.Chunks {
+		c, err := chunk.WriteTo(io.Discard)
+		if err != nil {
+			return 0
+		}
+		n += chunkHeaderSize + c
+	}
+	return n + int64(len(f.Trailing))
+}
+
+// AssignReferences returns the reference number of each instance within the
+// tree of root, as assigned by Encode. Instances are numbered from 0 in
+// depth-first order: each instance of root.Instances, in order, followed by its
+// descendants, with a parent numbered before its children. An instance that
+// appears more than once in the tree is numbered only once, at its first
+// appearance.
+func AssignReferences(root *rbxfile.Root) map[*rbxfile.Instance]int32 {
+	if root == nil {
+		return nil
+	}
+	instList, _ := referenceList(root)
+	refs := make(map[*rbxfile.Instance]int32, len(instList))
+	for ref, inst := range instList {
+		refs[inst] = int32(ref)
+	}
+	return refs
+}
+
+// CompressedSize returns the length of payload as the content of a chunk
+// compressed with method, which is CompressionLZ4 or CompressionNone. The
+// payload is compressed in the same way as by Encode, so the result is exact.
+// The result excludes the chunk header.
+func CompressedSize(payload []byte, method string) (int, error) {
+	switch method {
+	case CompressionLZ4:
+		compressed, err := compressPayload(payload)
+		if err != nil {
+			return 0, err
+		}
+		return len(compressed), nil
+	case CompressionNone:
+		return len(payload), nil
+	default:
+		return 0, errUnknownCompression(method)
+	}
 }
 
 func encodeError(w *parse.BinaryWriter, err error) error {
@@ -47,64 +318,72 @@ func encodeError(w *parse.BinaryWriter, err error) error {
 	return nil
 }
 
-func (e Encoder) encode(w io.Writer, f *formatModel, dcomp bool) (warn, err error) {
-	var warns errors.Errors
-
-	fw := parse.NewBinaryWriter(w)
-
+// writeHeader writes the file header to fw. Returns true if an error
+// occurred.
+func writeHeader(fw *parse.BinaryWriter, version uint16, classCount, instanceCount uint32) (failed bool) {
 	if fw.Bytes([]byte(robloxSig + binaryMarker + binaryHeader)) {
-		return warns.Return(), encodeError(fw, nil)
+		return true
 	}
-
-	if fw.Number(f.Version) {
-		return warns.Return(), encodeError(fw, nil)
+	if fw.Number(version) {
+		return true
+	}
+	if fw.Number(classCount) {
+		return true
 	}
+	if fw.Number(instanceCount) {
+		return true
+	}
+	// reserved
+	return fw.Number(uint64(0))
+}
 
-	if fw.Number(f.ClassCount) {
-		return warns.Return(), encodeError(fw, nil)
+// writeChunk writes chunk to fw as a raw chunk, compressing it unless
+// compression is disabled. Returns true if an error occurred.
+func (e Encoder) writeChunk(fw *parse.BinaryWriter, chunk chunk) (failed bool) {
+	rawChunk := new(rawChunk)
+	rawChunk.signature = uint32(chunk.Signature())
+	if !e.Uncompressed {
+		rawChunk.compressed = compressed(chunk.Compressed())
 	}
 
-	if fw.Number(f.InstanceCount) {
-		return warns.Return(), encodeError(fw, nil)
+	buf := new(bytes.Buffer)
+	if fw.Add(chunk.WriteTo(buf)) {
+		return true
 	}
 
-	// reserved
-	if fw.Number(uint64(0)) {
+	rawChunk.payload = buf.Bytes()
+
+	return rawChunk.WriteTo(fw)
+}
+
+func (e Encoder) encode(w io.Writer, f *formatModel, dcomp bool) (warn, err error) {
+	var warns errors.Errors
+
+	fw := parse.NewBinaryWriter(w)
+
+	if writeHeader(fw, f.Version, f.ClassCount, f.InstanceCount) {
 		return warns.Return(), encodeError(fw, nil)
 	}
 
 	for i, chunk := range f.Chunks {
 		if !validChunk(chunk.Signature()) && !dcomp {
-			warns = append(warns, ChunkError{Index: i, Sig: chunk.Signature(), Cause: errUnknownChunkSig})
+			warns = append(warns, ChunkError{Index: i, Sig: chunk.Signature(), Cause: ErrUnknownChunk})
 		}
 		if endChunk, ok := chunk.(*chunkEnd); ok {
 			if !e.Uncompressed && endChunk.Compressed() {
-				warns = append(warns, errEndChunkCompressed)
+				warns = append(warns, ErrEndChunkCompressed)
 			}
 
 			if !bytes.Equal(endChunk.Content, []byte("</roblox>")) && !dcomp {
-				warns = append(warns, errEndChunkContent)
+				warns = append(warns, ErrEndChunkContent)
 			}
 
 			if i != len(f.Chunks)-1 && !dcomp {
-				warns = append(warns, errEndChunkNotLast)
+				warns = append(warns, ErrEndChunkNotLast)
 			}
 		}
 
-		rawChunk := new(rawChunk)
-		rawChunk.signature = uint32(chunk.Signature())
-		if !e.Uncompressed {
-			rawChunk.compressed = compressed(chunk.Compressed())
-		}
-
-		buf := new(bytes.Buffer)
-		if fw.Add(chunk.WriteTo(buf)) {
-			return warns.Return(), encodeError(fw, nil)
-		}
-
-		rawChunk.payload = buf.Bytes()
-
-		if rawChunk.WriteTo(fw) {
+		if e.writeChunk(fw, chunk) {
 			return warns.Return(), encodeError(fw, nil)
 		}
 	}
@@ -0,0 +1,963 @@
+package rbxl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/robloxapi/rbxfile"
+	"github.com/robloxapi/rbxfile/errors"
+	"github.com/robloxapi/rbxfile/rbxlx"
+)
+
+// newEncodeTestRoot returns a root containing n instances with a variety of
+// property types.
+func newEncodeTestRoot(n int) *rbxfile.Root {
+	root := &rbxfile.Root{Metadata: map[string]string{"ExplicitAutoJoints": "true"}}
+	model := rbxfile.NewInstance("Model")
+	model.Properties["Name"] = rbxfile.ValueString("Model")
+	model.Properties["WorldPivotData"] = rbxfile.None(rbxfile.TypeCFrame)
+	root.Instances = append(root.Instances, model)
+	for i := 0; i < n; i++ {
+		part := rbxfile.NewInstance("Part")
+		part.Properties["Name"] = rbxfile.ValueString(fmt.Sprint(i))
+		part.Properties["Size"] = rbxfile.ValueVector3{X: float32(i), Y: 1, Z: 2}
+		part.Properties["Transparency"] = rbxfile.ValueFloat(0.5)
+		part.Properties["PhysicalConfigData"] = rbxfile.ValueSharedString(fmt.Sprint(i % 3))
+		value := rbxfile.NewInstance("ObjectValue")
+		value.Properties["Value"] = rbxfile.ValueReference{Instance: model}
+		part.Children = append(part.Children, value)
+		model.Children = append(model.Children, part)
+	}
+	return root
+}
+
+// encodeRoot returns the result of encoding root with e, failing the test on
+// error.
+func encodeRoot(tb testing.TB, e Encoder, root *rbxfile.Root) []byte {
+	tb.Helper()
+	var buf bytes.Buffer
+	if _, err := e.Encode(&buf, root); err != nil {
+		tb.Fatalf("encode error: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestEncodeStream(t *testing.T) {
+	root := newEncodeTestRoot(100)
+	for _, e := range []Encoder{{Mode: Place}, {Mode: Model}, {Uncompressed: true}} {
+		var want, got bytes.Buffer
+		if _, err := e.Encode(&want, root); err != nil {
+			t.Fatalf("encode error: %s", err)
+		}
+		if _, err := e.EncodeStream(&got, root); err != nil {
+			t.Fatalf("stream encode error: %s", err)
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Errorf("%+v: streamed output differs from encoded output", e)
+		}
+	}
+}
+
+func TestEncodeUncompressed(t *testing.T) {
+	root := newEncodeTestRoot(20)
+	compressed := encodeRoot(t, Encoder{}, root)
+	uncompressed := encodeRoot(t, Encoder{Uncompressed: true}, root)
+	if bytes.Equal(compressed, uncompressed) {
+		t.Fatal("expected encodings to differ")
+	}
+
+	want, _, err := Decoder{}.Decode(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("compressed decode error: %s", err)
+	}
+	result, _, err := Decoder{}.DecodeFull(bytes.NewReader(uncompressed))
+	if err != nil {
+		t.Fatalf("uncompressed decode error: %s", err)
+	}
+	for _, chunk := range result.Chunks {
+		if chunk.Compressed {
+			t.Errorf("chunk %s is compressed", chunk.Signature)
+		}
+	}
+	if !reflect.DeepEqual(result.Root, want) {
+		t.Error("uncompressed encoding decodes to a different root")
+	}
+}
+
+func TestCompressionMetadata(t *testing.T) {
+	for _, method := range []string{CompressionNone, CompressionLZ4} {
+		buf := encodeRoot(t, Encoder{Uncompressed: method == CompressionNone}, newEncodeTestRoot(10))
+		root, _, err := Decoder{RecordCompression: true}.Decode(bytes.NewReader(buf))
+		if err != nil {
+			t.Fatalf("decode error: %s", err)
+		}
+		if got := root.Metadata[MetadataCompression]; got != method {
+			t.Errorf("recorded %q, expected %q", got, method)
+		}
+
+		var out bytes.Buffer
+		if _, err := (Encoder{CompressionMetadata: true}).Encode(&out, root); err != nil {
+			t.Fatalf("reencode error: %s", err)
+		}
+		result, _, err := Decoder{}.DecodeFull(&out)
+		if err != nil {
+			t.Fatalf("redecode error: %s", err)
+		}
+		if _, ok := result.Root.Metadata[MetadataCompression]; ok {
+			t.Errorf("%s: compression entry was encoded", method)
+		}
+		for _, chunk := range result.Chunks {
+			if chunk.Signature == "END." {
+				continue
+			}
+			if chunk.Compressed != (method == CompressionLZ4) {
+				t.Errorf("%s: chunk %s has compression %t", method, chunk.Signature, chunk.Compressed)
+			}
+		}
+	}
+
+	root := &rbxfile.Root{Metadata: map[string]string{MetadataCompression: "zstd"}}
+	warn, err := Encoder{CompressionMetadata: true}.Encode(io.Discard, root)
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	if errs, ok := warn.(errors.Errors); !ok || len(errs) != 1 || errs[0] != errUnknownCompression("zstd") {
+		t.Errorf("expected unknown compression warning, got %v", warn)
+	}
+}
+
+func TestEncodeEndContent(t *testing.T) {
+	content := []byte("</roblox><!-- provenance -->")
+	root := newEncodeTestRoot(10)
+	e := Encoder{EndContent: content}
+	var buf, stream bytes.Buffer
+	warn, err := e.Encode(&buf, root)
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	if errs, ok := warn.(errors.Errors); !ok || len(errs) != 1 || errs[0] != ErrEndChunkContent {
+		t.Errorf("expected end content warning, got %v", warn)
+	}
+	warn, err = e.EncodeStream(&stream, root)
+	if err != nil {
+		t.Fatalf("stream encode error: %s", err)
+	}
+	if errs, ok := warn.(errors.Errors); !ok || len(errs) != 1 || errs[0] != ErrEndChunkContent {
+		t.Errorf("expected stream end content warning, got %v", warn)
+	}
+	if !bytes.Equal(stream.Bytes(), buf.Bytes()) {
+		t.Errorf("streamed output differs from encoded output")
+	}
+
+	m, _, err := Decoder{}.DecodeRaw(&buf)
+	if err != nil {
+		t.Fatalf("raw decode error: %s", err)
+	}
+	if end := m.Chunks[len(m.Chunks)-1]; !bytes.Equal(end.Payload, content) {
+		t.Errorf("expected end content %q, got %q", content, end.Payload)
+	}
+
+	if warn, _ := (Encoder{}).Encode(io.Discard, root); warn != nil {
+		t.Errorf("unexpected warning for default content: %s", warn)
+	}
+}
+
+// heapSampler is a writer that discards its input while sampling the growth of
+// the heap over a baseline.
+type heapSampler struct {
+	before uint64
+	peak   uint64
+	n      int
+}
+
+func heapAlloc() uint64 {
+	var m runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+func (s *heapSampler) Write(p []byte) (int, error) {
+	// The heap is sampled periodically while encoding.
+	if s.n++; s.n%16 == 0 {
+		s.sample()
+	}
+	return len(p), nil
+}
+
+func (s *heapSampler) sample() {
+	if h := heapAlloc(); h > s.before && h-s.before > s.peak {
+		s.peak = h - s.before
+	}
+}
+
+// benchmarkEncodePeak reports the peak memory of encoding root with encode, in
+// addition to the time and allocations of each iteration.
+func benchmarkEncodePeak(b *testing.B, root *rbxfile.Root, encode func(w io.Writer, root *rbxfile.Root) (warn, err error)) {
+	s := &heapSampler{before: heapAlloc()}
+	encode(s, root)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		encode(io.Discard, root)
+	}
+	b.ReportMetric(float64(s.peak), "peak-B")
+}
+
+func BenchmarkEncode(b *testing.B) {
+	benchmarkEncodePeak(b, newEncodeTestRoot(10000), Encoder{}.Encode)
+}
+
+// BenchmarkEncodeStream compares the peak memory of EncodeStream with that of
+// BenchmarkEncode.
+func BenchmarkEncodeStream(b *testing.B) {
+	benchmarkEncodePeak(b, newEncodeTestRoot(10000), Encoder{}.EncodeStream)
+}
+
+// newSingleClassRoot returns a tree of n Parts.
+func newSingleClassRoot(n int) *rbxfile.Root {
+	root := &rbxfile.Root{}
+	model := rbxfile.NewInstance("Part")
+	root.Instances = append(root.Instances, model)
+	for i := 1; i < n; i++ {
+		part := rbxfile.NewInstance("Part")
+		part.Properties["Name"] = rbxfile.ValueString(fmt.Sprint(i))
+		part.Properties["Size"] = rbxfile.ValueVector3{X: float32(i), Y: 1, Z: 2}
+		model.Children = append(model.Children, part)
+	}
+	return root
+}
+
+func TestEncodeSingleClass(t *testing.T) {
+	root := newSingleClassRoot(100)
+	root.Instances[0].IsService = true
+	for _, codec := range []robloxCodec{{Mode: Place}, {Mode: Model}} {
+		want, _, err := robloxCodec{Mode: codec.Mode, groupByMap: true}.Encode(root)
+		if err != nil {
+			t.Fatalf("encode error: %s", err)
+		}
+		got, _, err := codec.Encode(root)
+		if err != nil {
+			t.Fatalf("encode error: %s", err)
+		}
+		var wantBuf, gotBuf bytes.Buffer
+		if _, err := (Encoder{}).encode(&wantBuf, want, false); err != nil {
+			t.Fatalf("write error: %s", err)
+		}
+		if _, err := (Encoder{}).encode(&gotBuf, got, false); err != nil {
+			t.Fatalf("write error: %s", err)
+		}
+		if !bytes.Equal(gotBuf.Bytes(), wantBuf.Bytes()) {
+			t.Errorf("mode %d: single-class output differs from general output", codec.Mode)
+		}
+	}
+}
+
+func BenchmarkEncodeSingleClass(b *testing.B) {
+	root := newSingleClassRoot(50000)
+	for _, bench := range []struct {
+		name  string
+		codec robloxCodec
+	}{
+		{"Fast", robloxCodec{}},
+		{"General", robloxCodec{groupByMap: true}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				bench.codec.Encode(root)
+			}
+		})
+	}
+}
+
+func TestEncodePropertyOrder(t *testing.T) {
+	root := newEncodeTestRoot(2)
+	propNames := func(e Encoder) (names []string, warn error) {
+		model, warn, err := e.codec().Encode(root)
+		if err != nil {
+			t.Fatalf("encode error: %s", err)
+		}
+		for _, chunk := range model.Chunks {
+			if chunk, ok := chunk.(*chunkProperty); ok && chunk.ClassID == 2 {
+				names = append(names, chunk.PropertyName)
+			}
+		}
+		return names, warn
+	}
+
+	// Classes are sorted by name, so Part has ID 2.
+	want := []string{"Name", "PhysicalConfigData", "Size", "Transparency"}
+	if got, _ := propNames(Encoder{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected default order %v, got %v", want, got)
+	}
+
+	var classes []string
+	sizeFirst := func(class string, names []string) {
+		classes = append(classes, class)
+		for i, name := range names {
+			if name == "Size" {
+				copy(names[1:i+1], names[:i])
+				names[0] = name
+			}
+		}
+	}
+	want = []string{"Size", "Name", "PhysicalConfigData", "Transparency"}
+	got, warn := propNames(Encoder{PropertyOrder: sizeFirst})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected custom order %v, got %v", want, got)
+	}
+	if warn != nil {
+		t.Errorf("unexpected warning: %s", warn)
+	}
+	if want := []string{"Model", "ObjectValue", "Part"}; !reflect.DeepEqual(classes, want) {
+		t.Errorf("expected classes %v, got %v", want, classes)
+	}
+
+	want = []string{"Name", "PhysicalConfigData", "Size", "Transparency"}
+	got, warn = propNames(Encoder{PropertyOrder: func(class string, names []string) {
+		if class == "Part" {
+			names[0] = names[1]
+		}
+	}})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected default order for invalid order %v, got %v", want, got)
+	}
+	if warn == nil {
+		t.Errorf("expected warning for invalid order")
+	}
+}
+
+func TestEncodeMixedPropertyTypes(t *testing.T) {
+	root := &rbxfile.Root{}
+	for i, v := range []rbxfile.Value{
+		rbxfile.ValueString("a"),
+		rbxfile.ValueContent("rbxassetid://1"),
+		rbxfile.ValueString("b"),
+		rbxfile.ValueProtectedString("c"),
+	} {
+		inst := rbxfile.NewInstance("Part")
+		inst.Properties["Text"] = v
+		if i%2 == 0 {
+			inst.Properties["Size"] = rbxfile.ValueVector3{}
+		} else {
+			inst.Properties["Size"] = rbxfile.ValueVector2{}
+		}
+		root.Instances = append(root.Instances, inst)
+	}
+	model, warn, err := robloxCodec{}.Encode(root)
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	want := map[string]bool{
+		`#0 "INST" chunk: mismatched types Vector2 and Vector3 for property Part.Size, chunk skipped`:                                true,
+		`#0 "INST" chunk: property Part.Text in instance #1 has type Content, which differs from type String of instance #0`:         true,
+		`#0 "INST" chunk: property Part.Text in instance #3 has type ProtectedString, which differs from type String of instance #0`: true,
+	}
+	errs, _ := warn.(errors.Errors)
+	if len(errs) != len(want) {
+		t.Fatalf("expected %d warnings, got %v", len(want), warn)
+	}
+	var mismatch TypeMismatchError
+	for _, err := range errs {
+		if !want[err.Error()] {
+			t.Errorf("unexpected warning %q", err)
+		}
+		if err, ok := err.(ChunkError); ok {
+			if cause, ok := err.Cause.(TypeMismatchError); ok {
+				mismatch = cause
+			}
+		}
+	}
+	if mismatch != (TypeMismatchError{Class: "Part", Property: "Size", Type: rbxfile.TypeVector2, Expected: rbxfile.TypeVector3}) {
+		t.Errorf("unexpected type mismatch warning %#v", mismatch)
+	}
+	var props []string
+	for _, chunk := range model.Chunks {
+		if chunk, ok := chunk.(*chunkProperty); ok {
+			props = append(props, chunk.PropertyName)
+		}
+	}
+	if !reflect.DeepEqual(props, []string{"Text"}) {
+		t.Errorf("unexpected property chunks %v", props)
+	}
+}
+
+func TestCompressedSize(t *testing.T) {
+	buf := encodeRoot(t, Encoder{}, newEncodeTestRoot(100))
+	m, _, err := Decoder{}.DecodeRaw(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("raw decode error: %s", err)
+	}
+
+	// Read the compressed length of each chunk from the encoded file.
+	b := buf[headerSize:]
+	for _, c := range m.Chunks {
+		compressedLength := int(binary.LittleEndian.Uint32(b[4:8]))
+		method, want := CompressionLZ4, compressedLength
+		if !c.Compressed {
+			method, want = CompressionNone, len(c.Payload)
+		}
+		got, err := CompressedSize(c.Payload, method)
+		if err != nil {
+			t.Fatalf("%s: compressed size error: %s", c.Signature, err)
+		}
+		if got != want {
+			t.Errorf("%s: expected size %d, got %d", c.Signature, want, got)
+		}
+		b = b[chunkHeaderSize+compressedLength:]
+		if compressedLength == 0 {
+			b = b[len(c.Payload):]
+		}
+	}
+
+	if _, err := CompressedSize(nil, "zstd"); err != errUnknownCompression("zstd") {
+		t.Errorf("expected unknown compression error, got %v", err)
+	}
+}
+
+func TestAssignReferences(t *testing.T) {
+	root := newEncodeTestRoot(3)
+	refs := AssignReferences(root)
+	model := root.Instances[0]
+	want := map[*rbxfile.Instance]int32{model: 0}
+	for i, part := range model.Children {
+		want[part] = int32(1 + i*2)
+		want[part.Children[0]] = int32(2 + i*2)
+	}
+	if !reflect.DeepEqual(refs, want) {
+		t.Fatalf("unexpected references %v", refs)
+	}
+
+	// The numbering matches the references of the encoded file.
+	f, _, err := robloxCodec{}.Encode(root)
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	instList := make([]*rbxfile.Instance, len(refs))
+	parents := map[int32]int32{}
+	for inst, ref := range refs {
+		instList[ref] = inst
+		if _, ok := parents[ref]; !ok {
+			parents[ref] = nilInstance
+		}
+		for _, child := range inst.Children {
+			parents[refs[child]] = ref
+		}
+	}
+	for _, chunk := range f.Chunks {
+		switch chunk := chunk.(type) {
+		case *chunkInstance:
+			for _, ref := range chunk.InstanceIDs {
+				if instList[ref].ClassName != chunk.ClassName {
+					t.Errorf("instance #%d: expected class %s, got %s", ref, instList[ref].ClassName, chunk.ClassName)
+				}
+			}
+		case *chunkParent:
+			for i, ref := range chunk.Children {
+				if parent := parents[ref]; chunk.Parents[i] != parent {
+					t.Errorf("instance #%d: expected parent #%d, got #%d", ref, parent, chunk.Parents[i])
+				}
+			}
+		}
+	}
+}
+
+func TestGzip(t *testing.T) {
+	root := newEncodeTestRoot(10)
+	var zipped bytes.Buffer
+	plain := encodeRoot(t, Encoder{}, root)
+	if _, err := (Encoder{}).EncodeGzip(&zipped, root); err != nil {
+		t.Fatalf("gzip encode error: %s", err)
+	}
+	if !bytes.HasPrefix(zipped.Bytes(), []byte("\x1f\x8b")) {
+		t.Fatalf("expected gzip signature")
+	}
+
+	want, _, err := Decoder{}.Decode(bytes.NewReader(plain))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	for _, data := range [][]byte{zipped.Bytes(), plain} {
+		got, _, err := Decoder{}.DecodeGzip(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("gzip decode error: %s", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("decoded root differs")
+		}
+	}
+
+	if _, _, err := (Decoder{}).DecodeGzip(bytes.NewReader([]byte("\x1f\x8bcorrupt"))); err == nil {
+		t.Errorf("expected error for corrupt gzip data")
+	}
+}
+
+func TestEncodeDefaults(t *testing.T) {
+	defaults := map[string]map[string]rbxfile.Value{
+		"Part": {
+			"Anchored":     rbxfile.ValueBool(false),
+			"Size":         rbxfile.ValueVector3{X: 4, Y: 1, Z: 2},
+			"Transparency": rbxfile.ValueFloat(0),
+			"Reflectance":  rbxfile.ValueFloat(0),
+		},
+	}
+	root := &rbxfile.Root{}
+	for i := 0; i < 3; i++ {
+		part := rbxfile.NewInstance("Part")
+		part.Properties["Name"] = rbxfile.ValueString(fmt.Sprint(i))
+		part.Properties["Anchored"] = rbxfile.ValueBool(false)
+		part.Properties["Size"] = rbxfile.ValueVector3{X: 4, Y: 1, Z: 2}
+		// Differs in one instance.
+		part.Properties["Transparency"] = rbxfile.ValueFloat(i / 2)
+		// Negative zero differs from the default.
+		part.Properties["Reflectance"] = rbxfile.ValueFloat(math.Copysign(0, -1))
+		root.Instances = append(root.Instances, part)
+	}
+
+	lookup := func(class, prop string) (rbxfile.Value, bool) {
+		v, ok := defaults[class][prop]
+		return v, ok
+	}
+	model, _, err := robloxCodec{Defaults: lookup}.Encode(root)
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	var props []string
+	for _, chunk := range model.Chunks {
+		if chunk, ok := chunk.(*chunkProperty); ok {
+			props = append(props, chunk.PropertyName)
+		}
+	}
+	if !reflect.DeepEqual(props, []string{"Name", "Reflectance", "Transparency"}) {
+		t.Errorf("unexpected property chunks %v", props)
+	}
+
+	// Defaults are consulted only with OmitDefaults.
+	buf := encodeRoot(t, Encoder{Defaults: lookup}, root)
+	decoded, _, err := Decoder{}.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if _, ok := decoded.Instances[0].Properties["Anchored"]; !ok {
+		t.Errorf("expected Anchored without OmitDefaults")
+	}
+
+	buf = encodeRoot(t, Encoder{OmitDefaults: true, Defaults: lookup}, root)
+	decoded, _, err = Decoder{Defaults: defaults}.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	for i, inst := range decoded.Instances {
+		if !reflect.DeepEqual(inst.Properties, root.Instances[i].Properties) {
+			t.Errorf("instance %d: expected properties %v, got %v", i, root.Instances[i].Properties, inst.Properties)
+		}
+	}
+
+	decoded, _, err = Decoder{}.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if _, ok := decoded.Instances[0].Properties["Anchored"]; ok {
+		t.Errorf("expected Anchored to be omitted")
+	}
+}
+
+func TestEncodeMetadataPosition(t *testing.T) {
+	root := newEncodeTestRoot(2)
+	for _, test := range []struct {
+		name    string
+		encoder Encoder
+		first   string
+		last    string
+		meta    bool
+	}{
+		{"first", Encoder{}, "META", "PRNT", true},
+		{"last", Encoder{MetadataPosition: MetadataLast}, "SSTR", "META", true},
+		{"excluded", Encoder{ExcludeMetadata: true}, "SSTR", "PRNT", false},
+		{"excluded last", Encoder{ExcludeMetadata: true, MetadataPosition: MetadataLast}, "SSTR", "PRNT", false},
+	} {
+		var buf bytes.Buffer
+		if _, err := test.encoder.Encode(&buf, root); err != nil {
+			t.Fatalf("%s: encode error: %s", test.name, err)
+		}
+		result, warn, err := Decoder{}.DecodeFull(&buf)
+		if err != nil {
+			t.Fatalf("%s: decode error: %s", test.name, err)
+		}
+		if warn != nil {
+			t.Errorf("%s: unexpected warning: %s", test.name, warn)
+		}
+		chunks := result.Chunks
+		if n := len(chunks); n < 3 || chunks[0].Signature != test.first || chunks[n-2].Signature != test.last || chunks[n-1].Signature != "END." {
+			t.Errorf("%s: unexpected chunk order %v", test.name, chunks)
+		}
+		if test.meta {
+			if !reflect.DeepEqual(result.Root.Metadata, root.Metadata) {
+				t.Errorf("%s: expected metadata %v, got %v", test.name, root.Metadata, result.Root.Metadata)
+			}
+		} else if result.Root.Metadata != nil {
+			t.Errorf("%s: expected no metadata, got %v", test.name, result.Root.Metadata)
+		}
+	}
+}
+
+// bytecodeScript returns a script carrying compiled bytecode, which contains
+// null bytes and is not valid UTF-8.
+func bytecodeScript() *rbxfile.Instance {
+	script := rbxfile.NewInstance("ModuleScript")
+	script.Properties["Name"] = rbxfile.ValueString("Module")
+	script.Properties["Source"] = rbxfile.ValueProtectedString("return 'hello'")
+	script.Properties["Bytecode"] = rbxfile.ValueBinaryString("\x05\x03\x00print\x00hello\xff\xfe\x1b\x00\x00\x01")
+	return script
+}
+
+func TestEncodeBytecode(t *testing.T) {
+	root := &rbxfile.Root{Instances: []*rbxfile.Instance{bytecodeScript()}}
+	want := root.Instances[0].Properties
+	file := encodeRoot(t, Encoder{}, root)
+
+	// The format does not distinguish string types, so the bytes are
+	// preserved, but the type is not.
+	got, warn, err := Decoder{}.Decode(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if warn != nil {
+		t.Errorf("unexpected warning: %s", warn)
+	}
+	if v := got.Instances[0].Properties["Bytecode"]; v.String() != want["Bytecode"].String() {
+		t.Errorf("expected Bytecode %q, got %q", want["Bytecode"], v)
+	}
+
+	// A schema restores the type.
+	schema := map[string]map[string]rbxfile.Type{
+		"ModuleScript": {
+			"Source":   rbxfile.TypeProtectedString,
+			"Bytecode": rbxfile.TypeBinaryString,
+		},
+	}
+	got, _, err = Decoder{Schema: schema}.Decode(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if !reflect.DeepEqual(got.Instances[0].Properties, want) {
+		t.Errorf("expected properties %#v, got %#v", want, got.Instances[0].Properties)
+	}
+}
+
+func TestEncodeOptionalMixed(t *testing.T) {
+	// Instances of one class share a PROP chunk, in which each instance may
+	// or may not have a value.
+	root := &rbxfile.Root{}
+	for i := 0; i < 4; i++ {
+		model := rbxfile.NewInstance("Model")
+		if i%2 == 0 {
+			model.Properties["WorldPivotData"] = rbxfile.Some(rbxfile.ValueCFrame{
+				Position: rbxfile.ValueVector3{X: float32(i), Y: 1, Z: 2},
+				Rotation: [9]float32{1, 0, 0, 0, 1, 0, 0, 0, 1},
+			})
+		} else {
+			model.Properties["WorldPivotData"] = rbxfile.None(rbxfile.TypeCFrame)
+		}
+		root.Instances = append(root.Instances, model)
+	}
+	buf := encodeRoot(t, Encoder{}, root)
+	var stats DecoderStats
+	got, warn, err := Decoder{Stats: &stats}.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if warn != nil {
+		t.Errorf("unexpected warning: %s", warn)
+	}
+	if n := stats.PropertyTypes["OptionalCFrame"]; n != 1 {
+		t.Errorf("expected 1 optional CFrame chunk, got %d", n)
+	}
+	for i, inst := range got.Instances {
+		want := root.Instances[i].Properties["WorldPivotData"]
+		if v := inst.Properties["WorldPivotData"]; !reflect.DeepEqual(v, want) {
+			t.Errorf("instance %d: expected %v, got %v", i, want, v)
+		}
+	}
+}
+
+func TestEncodeOptionalSkipped(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		values []rbxfile.Value
+		warn   string
+	}{
+		{
+			name: "mismatched",
+			values: []rbxfile.Value{
+				rbxfile.Some(rbxfile.ValueCFrame{}),
+				rbxfile.Some(rbxfile.ValueVector3{}),
+			},
+			warn: "mismatched optional types",
+		},
+		{
+			name: "unknown",
+			values: []rbxfile.Value{
+				rbxfile.None(rbxfile.TypeInvalid),
+				rbxfile.None(rbxfile.TypeInvalid),
+			},
+			warn: "unknown type",
+		},
+	} {
+		root := &rbxfile.Root{}
+		for _, v := range test.values {
+			model := rbxfile.NewInstance("Model")
+			model.Properties["Name"] = rbxfile.ValueString("Model")
+			model.Properties["WorldPivotData"] = v
+			root.Instances = append(root.Instances, model)
+		}
+		var buf bytes.Buffer
+		warn, err := (Encoder{}).Encode(&buf, root)
+		if err != nil {
+			t.Fatalf("%s: encode error: %s", test.name, err)
+		}
+		if warn == nil || !strings.Contains(warn.Error(), test.warn) {
+			t.Errorf("%s: expected %q warning, got %v", test.name, test.warn, warn)
+		}
+		got, _, err := Decoder{}.Decode(&buf)
+		if err != nil {
+			t.Fatalf("%s: decode error: %s", test.name, err)
+		}
+		for i, inst := range got.Instances {
+			if _, ok := inst.Properties["WorldPivotData"]; ok {
+				t.Errorf("%s: instance %d: expected WorldPivotData to be skipped", test.name, i)
+			}
+			if v, ok := inst.Properties["Name"].(rbxfile.ValueString); !ok || string(v) != "Model" {
+				t.Errorf("%s: instance %d: unexpected Name %v", test.name, i, inst.Properties["Name"])
+			}
+		}
+	}
+}
+
+func TestEncodeUniqueId(t *testing.T) {
+	const file = `<roblox version="4">
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<UniqueId name="UniqueId">44b188dace632b4702e9c68d004815fc</UniqueId>
+		</Properties>
+	</Item>
+</roblox>`
+	root, _, err := rbxlx.Decoder{}.Decode(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("rbxlx decode error: %s", err)
+	}
+	want := rbxfile.ValueUniqueId{Random: 0x44b188dace632b47, Time: 0x02e9c68d, Index: 0x004815fc}
+	if v := root.Instances[0].Properties["UniqueId"]; v != want {
+		t.Fatalf("unexpected rbxlx UniqueId %#v", v)
+	}
+
+	for _, mode := range []Mode{Place, Model} {
+		var buf bytes.Buffer
+		if _, err := (Encoder{Mode: mode}).Encode(&buf, root); err != nil {
+			t.Fatalf("mode %d: encode error: %s", mode, err)
+		}
+		got, _, err := Decoder{Mode: mode}.Decode(&buf)
+		if err != nil {
+			t.Fatalf("mode %d: decode error: %s", mode, err)
+		}
+		v, ok := got.Instances[0].Properties["UniqueId"]
+		switch mode {
+		case Place:
+			if v != want {
+				t.Errorf("place: expected UniqueId %#v, got %#v", want, v)
+			}
+		case Model:
+			// UniqueId is omitted from the model format.
+			if ok {
+				t.Errorf("model: unexpected UniqueId %#v", v)
+			}
+		}
+	}
+}
+
+func TestEncodeInt16Vectors(t *testing.T) {
+	root := &rbxfile.Root{}
+	for i := 0; i < 5; i++ {
+		part := rbxfile.NewInstance("Part")
+		n := int16(i*1000 - 2000)
+		part.Properties["V2"] = rbxfile.ValueVector2int16{X: n, Y: -n}
+		part.Properties["V3"] = rbxfile.ValueVector3int16{X: n, Y: 32767, Z: -32768}
+		root.Instances = append(root.Instances, part)
+	}
+	buf := encodeRoot(t, Encoder{}, root)
+	got, _, err := Decoder{}.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	for i, inst := range got.Instances {
+		if !reflect.DeepEqual(inst.Properties, root.Instances[i].Properties) {
+			t.Errorf("instance %d: expected %v, got %v", i, root.Instances[i].Properties, inst.Properties)
+		}
+	}
+}
+
+func TestEncodePreserveServices(t *testing.T) {
+	root := &rbxfile.Root{}
+	workspace := rbxfile.NewInstance("Workspace")
+	workspace.IsService = true
+	root.Instances = append(root.Instances, workspace, rbxfile.NewInstance("Folder"))
+
+	for _, preserve := range []bool{false, true} {
+		var buf bytes.Buffer
+		if _, err := (Encoder{Mode: Model, PreserveServices: preserve}).Encode(&buf, root); err != nil {
+			t.Fatalf("preserve %t: encode error: %s", preserve, err)
+		}
+		f, _, _, err := Decoder{Mode: Model}.decode(bytes.NewReader(buf.Bytes()), false)
+		if err != nil {
+			t.Fatalf("preserve %t: decode error: %s", preserve, err)
+		}
+		for _, chunk := range f.Chunks {
+			chunk, ok := chunk.(*chunkInstance)
+			if !ok {
+				continue
+			}
+			isService := preserve && chunk.ClassName == "Workspace"
+			if chunk.IsService != isService {
+				t.Errorf("preserve %t: %s: expected IsService %t", preserve, chunk.ClassName, isService)
+			}
+			if isService && !reflect.DeepEqual(chunk.GetService, []byte{1}) {
+				t.Errorf("preserve %t: %s: unexpected GetService %v", preserve, chunk.ClassName, chunk.GetService)
+			}
+		}
+
+		got, _, err := Decoder{Mode: Model}.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("preserve %t: decode error: %s", preserve, err)
+		}
+		if got.Instances[0].IsService != preserve || got.Instances[1].IsService {
+			t.Errorf("preserve %t: unexpected service flags", preserve)
+		}
+	}
+}
+
+func TestEstimateSize(t *testing.T) {
+	for _, n := range []int{0, 1, 20} {
+		root := newEncodeTestRoot(n)
+		var buf bytes.Buffer
+		if _, err := (Encoder{Uncompressed: true}).Encode(&buf, root); err != nil {
+			t.Fatalf("%d: encode error: %s", n, err)
+		}
+		if got := EstimateSize(root); got != int64(buf.Len()) {
+			t.Errorf("%d: expected size %d, got %d", n, buf.Len(), got)
+		}
+	}
+}
+
+func TestEncodeValidateSequences(t *testing.T) {
+	valid := rbxfile.ValueNumberSequence{{Time: 0, Value: 1}, {Time: 0.5, Value: 2}, {Time: 1, Value: 3}}
+	for _, test := range []struct {
+		value rbxfile.Value
+		valid bool
+	}{
+		{valid, true},
+		{rbxfile.ValueColorSequence{{Time: 0}, {Time: 1}}, true},
+		{rbxfile.ValueNumberSequence{}, false},
+		{rbxfile.ValueNumberSequence(nil), false},
+		{rbxfile.ValueColorSequence{}, false},
+		{rbxfile.ValueColorSequence(nil), false},
+		{rbxfile.ValueNumberSequence{{Time: 0}}, false},
+		{rbxfile.ValueColorSequence{{Time: 0}}, false},
+		{rbxfile.ValueNumberSequence{{Time: 0.1}, {Time: 1}}, false},
+		{rbxfile.ValueNumberSequence{{Time: 0}, {Time: 0.9}}, false},
+		{rbxfile.ValueColorSequence{{Time: 0}, {Time: 0.6}, {Time: 0.4}, {Time: 1}}, false},
+	} {
+		inst := rbxfile.NewInstance("ParticleEmitter")
+		inst.Properties["Size"] = test.value
+		child := rbxfile.NewInstance("Folder")
+		child.Properties["Sequence"] = test.value
+		inst.Children = append(inst.Children, child)
+		root := &rbxfile.Root{Instances: []*rbxfile.Instance{inst}}
+
+		if err := test.value.(interface{ Validate() error }).Validate(); (err == nil) != test.valid {
+			t.Errorf("%#v: Validate returned %v", test.value, err)
+		}
+		_, err := (Encoder{ValidateSequences: true}).Encode(io.Discard, root)
+		if test.valid && err != nil {
+			t.Errorf("%v: unexpected error: %s", test.value, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%v: expected error", test.value)
+		}
+		// Sequences are not validated by default.
+		if _, err := (Encoder{}).Encode(io.Discard, root); err != nil {
+			t.Errorf("%v: unexpected error without validation: %s", test.value, err)
+		}
+	}
+}
+
+func TestEncodeEmptySequences(t *testing.T) {
+	root := &rbxfile.Root{}
+	for _, values := range [][2]rbxfile.Value{
+		{rbxfile.ValueNumberSequence(nil), rbxfile.ValueColorSequence(nil)},
+		{rbxfile.ValueNumberSequence{}, rbxfile.ValueColorSequence{}},
+	} {
+		inst := rbxfile.NewInstance("ParticleEmitter")
+		inst.Properties["Size"] = values[0]
+		inst.Properties["Color"] = values[1]
+		root.Instances = append(root.Instances, inst)
+	}
+	buf := encodeRoot(t, Encoder{}, root)
+	got, _, err := Decoder{}.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	for i, inst := range got.Instances {
+		if v, ok := inst.Properties["Size"].(rbxfile.ValueNumberSequence); !ok || v == nil || len(v) != 0 {
+			t.Errorf("instance %d: expected empty NumberSequence, got %#v", i, inst.Properties["Size"])
+		}
+		if v, ok := inst.Properties["Color"].(rbxfile.ValueColorSequence); !ok || v == nil || len(v) != 0 {
+			t.Errorf("instance %d: expected empty ColorSequence, got %#v", i, inst.Properties["Color"])
+		}
+	}
+}
+
+func TestEncodeContentObject(t *testing.T) {
+	root := &rbxfile.Root{}
+	target := rbxfile.NewInstance("Folder")
+	image := rbxfile.NewInstance("ImageLabel")
+	image.Properties["ImageContent"] = rbxfile.ValueContentObject{Instance: target}
+	root.Instances = append(root.Instances, target, image)
+	for _, encode := range []func(io.Writer, *rbxfile.Root) (error, error){Encoder{}.Encode, Encoder{}.EncodeStream} {
+		var buf bytes.Buffer
+		warn, err := encode(&buf, root)
+		if err != nil {
+			t.Fatalf("encode error: %s", err)
+		}
+		if warn != nil {
+			t.Errorf("unexpected warning: %s", warn)
+		}
+		data := buf.String()
+
+		// Without a schema, the value is decoded as a Reference.
+		got, _, err := Decoder{}.Decode(strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("decode error: %s", err)
+		}
+		if v, ok := got.Instances[1].Properties["ImageContent"].(rbxfile.ValueReference); !ok || v.Instance != got.Instances[0] {
+			t.Errorf("expected Reference to Folder, got %#v", got.Instances[1].Properties["ImageContent"])
+		}
+
+		schema := map[string]map[string]rbxfile.Type{"ImageLabel": {"ImageContent": rbxfile.TypeContentObject}}
+		got, _, err = Decoder{Schema: schema}.Decode(strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("decode error: %s", err)
+		}
+		if v, ok := got.Instances[1].Properties["ImageContent"].(rbxfile.ValueContentObject); !ok || v.Instance != got.Instances[0] {
+			t.Errorf("expected ContentObject of Folder, got %#v", got.Instances[1].Properties["ImageContent"])
+		}
+	}
+}
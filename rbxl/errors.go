@@ -5,22 +5,46 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+
+	"github.com/robloxapi/rbxfile"
 )
 
+// Indicates an unexpected file signature.
+var errInvalidSig = errors.New("invalid signature")
+
+// The following errors are produced as warnings. Each may be detected with
+// errors.Is. Warnings about a particular chunk are wrapped in a ChunkError.
 var (
-	// Indicates an unexpected file signature.
-	errInvalidSig = errors.New("invalid signature")
-	// Indicates a chunk signature not known by the codec.
-	errUnknownChunkSig = errors.New("unknown chunk signature")
-	// Indicates that the end chunk is compressed, where it is expected to be
-	// uncompressed.
-	errEndChunkCompressed = errors.New("end chunk is compressed")
-	// Indicates unexpected content within the end chunk.
-	errEndChunkContent = errors.New("end chunk content is not `</roblox>`")
-	// Indicates that there are additional chunks that follow the end chunk.
-	errEndChunkNotLast = errors.New("end chunk is not the last chunk")
+	// ErrUnknownChunk indicates a chunk signature not known by the codec.
+	ErrUnknownChunk = errors.New("unknown chunk signature")
+	// ErrEndChunkCompressed indicates that the end chunk is compressed, where
+	// it is expected to be uncompressed.
+	ErrEndChunkCompressed = errors.New("end chunk is compressed")
+	// ErrEndChunkContent indicates unexpected content within the end chunk.
+	ErrEndChunkContent = errors.New("end chunk content is not `</roblox>`")
+	// ErrEndChunkNotLast indicates that there are additional chunks that
+	// follow the end chunk.
+	ErrEndChunkNotLast = errors.New("end chunk is not the last chunk")
+	// ErrEndChunkMissing indicates that the data ended after a complete chunk
+	// without an end chunk.
+	ErrEndChunkMissing = errors.New("end chunk is missing")
+	// ErrEmptyPropertyName indicates a property chunk whose property name is
+	// empty. It is the same as rbxfile.ErrEmptyPropertyName, which is also
+	// produced by the rbxlx package.
+	ErrEmptyPropertyName = rbxfile.ErrEmptyPropertyName
 )
 
+// ErrUnrecognizedVersion indicates that the header of the binary format has a
+// version other than 0. Every binary file written by Roblox, including the
+// earliest, has version 0, so such a file is of a format that this package
+// does not support. It may be detected with errors.Is, so that the file can be
+// handled by other means.
+var ErrUnrecognizedVersion = errors.New("unrecognized version")
+
+// ErrTimeout indicates that decoding took longer than the Timeout of the
+// decoder.
+var ErrTimeout = errors.New("decoding timed out")
+
 // errUnrecognizedVersion indicates a format version not recognized by the
 // codec.
 type errUnrecognizedVersion uint16
@@ -29,6 +53,10 @@ func (err errUnrecognizedVersion) Error() string {
 	return fmt.Sprintf("unrecognized version %d", err)
 }
 
+func (err errUnrecognizedVersion) Unwrap() error {
+	return ErrUnrecognizedVersion
+}
+
 // errUnknownType indicates a property data type not known by the codec.
 type errUnknownType typeID
 
@@ -36,19 +64,153 @@ func (err errUnknownType) Error() string {
 	return fmt.Sprintf("unknown data type 0x%X", byte(err))
 }
 
-// errReserve indicates an unexpected value for bytes that are presumed to be
-// reserved.
-type errReserve struct {
+// EndChunkSizeError is a warning indicating that the content of the end chunk
+// exceeds the limit of the decoder.
+type EndChunkSizeError struct {
+	// Size is the length of the content, or the length of the compressed
+	// content if it is greater.
+	Size  uint32
+	Limit uint32
+}
+
+func (err EndChunkSizeError) Error() string {
+	return fmt.Sprintf("end chunk content size %d exceeds limit %d", err.Size, err.Limit)
+}
+
+// ErrDecompressedSize indicates that the payloads of the chunks of a file
+// exceed the MaxDecompressedBytes of the decoder. It may be detected with
+// errors.Is.
+var ErrDecompressedSize = errors.New("decompressed size exceeds limit")
+
+// DecompressedSizeError is an error indicating that the payload of a chunk
+// would cause the total size of the payloads of a file to exceed the limit of
+// the decoder. It wraps ErrDecompressedSize.
+type DecompressedSizeError struct {
+	// Size is the declared uncompressed length of the chunk.
+	Size uint32
+	// Limit is the MaxDecompressedBytes of the decoder.
+	Limit int64
+}
+
+func (err DecompressedSizeError) Error() string {
+	return fmt.Sprintf("chunk payload size %d exceeds total decompressed limit %d", err.Size, err.Limit)
+}
+
+func (err DecompressedSizeError) Unwrap() error {
+	return ErrDecompressedSize
+}
+
+// errMaxDepth indicates that the instance tree exceeds the maximum depth of
+// the decoder.
+type errMaxDepth struct {
+	Limit int
+}
+
+func (err errMaxDepth) Error() string {
+	return fmt.Sprintf("instance tree exceeds maximum depth %d", err.Limit)
+}
+
+// InvalidUTF8Error is an error indicating that a string property is not valid
+// UTF-8, produced when the StringValidation of a Decoder is StringReject.
+type InvalidUTF8Error struct {
+	Class    string
+	Property string
+}
+
+func (err InvalidUTF8Error) Error() string {
+	return fmt.Sprintf("property %s.%s is not valid UTF-8", err.Class, err.Property)
+}
+
+// errPropertyValue indicates that the values of a property chunk could not be
+// decoded.
+type errPropertyValue struct {
+	Property string
+	// Index is the index of the failing value within the instance group, or
+	// -1 if unknown.
+	Index int
+	// Offset is the byte offset within the chunk payload of the failing
+	// value, or of the first value if Index is unknown.
+	Offset int
+	Cause  error
+}
+
+func (err errPropertyValue) Error() string {
+	if err.Index < 0 {
+		return fmt.Sprintf("property %q at payload offset %d: %s", err.Property, err.Offset, err.Cause)
+	}
+	return fmt.Sprintf("property %q, instance #%d at payload offset %d: %s", err.Property, err.Index, err.Offset, err.Cause)
+}
+
+func (err errPropertyValue) Unwrap() error {
+	return err.Cause
+}
+
+// ClassRemapError is a warning indicating that the class of decoded instances
+// was remapped by the ClassRemap of a Decoder.
+type ClassRemapError struct {
+	From, To string
+}
+
+func (err ClassRemapError) Error() string {
+	return fmt.Sprintf("remapped class %s to %s", err.From, err.To)
+}
+
+// errUnknownCompression indicates an unrecognized compression method, such as
+// a value of the MetadataCompression entry.
+type errUnknownCompression string
+
+func (err errUnknownCompression) Error() string {
+	return fmt.Sprintf("unknown compression method %q", string(err))
+}
+
+// ReserveError is a warning indicating an unexpected value for bytes that are
+// presumed to be reserved.
+type ReserveError struct {
 	// Offset marks the location of the reserved bytes.
 	Offset int64
 	// Bytes is the unexpected content of the reserved bytes.
 	Bytes []byte
 }
 
-func (err errReserve) Error() string {
+func (err ReserveError) Error() string {
 	return fmt.Sprintf("unexpected content for reserved bytes near %d: % 02X", err.Offset, err.Bytes)
 }
 
+// ReferenceError is a warning indicating that a PRNT chunk refers to an
+// instance that does not exist.
+type ReferenceError struct {
+	// Index is the position of the reference within the chunk.
+	Index int
+	// Ref is the reference number of the missing instance.
+	Ref int32
+}
+
+func (err ReferenceError) Error() string {
+	return fmt.Sprintf("child #%d: id %d does not exist", err.Index, err.Ref)
+}
+
+// TypeMismatchError is a warning indicating that the values of a property
+// within a group of instances have types that cannot be written to the same
+// PROP chunk. The property is not written.
+type TypeMismatchError struct {
+	Class    string
+	Property string
+	// Optional is whether the mismatch is between the inner types of Optional
+	// values.
+	Optional bool
+	// Type is the type of the mismatched value.
+	Type rbxfile.Type
+	// Expected is the type of the first value of the property.
+	Expected rbxfile.Type
+}
+
+func (err TypeMismatchError) Error() string {
+	if err.Optional {
+		return fmt.Sprintf("mismatched optional types %s and %s for property %s.%s, chunk skipped", err.Type, err.Expected, err.Class, err.Property)
+	}
+	return fmt.Sprintf("mismatched types %s and %s for property %s.%s, chunk skipped", err.Type, err.Expected, err.Class, err.Property)
+}
+
 type errParentArray struct {
 	Children int
 	Parent   int
@@ -58,6 +220,32 @@ func (err errParentArray) Error() string {
 	return fmt.Sprintf("length of parents array (%d) does not match length of children array (%d)", err.Parent, err.Children)
 }
 
+// TokenFromIntError is a warning indicating that an Int or Int64 property was
+// reinterpreted as a Token.
+type TokenFromIntError struct {
+	Class    string
+	Property string
+	// From is the type of the original value.
+	From rbxfile.Type
+}
+
+func (err TokenFromIntError) Error() string {
+	return fmt.Sprintf("reinterpreted %s property %s.%s as Token", err.From, err.Class, err.Property)
+}
+
+// LossyCoercionError is a warning indicating that a property was converted by
+// the Schema of a Decoder to a type that could not represent its value
+// exactly.
+type LossyCoercionError struct {
+	Class    string
+	Property string
+	From, To rbxfile.Type
+}
+
+func (err LossyCoercionError) Error() string {
+	return fmt.Sprintf("converting property %s.%s from %s to %s loses precision", err.Class, err.Property, err.From, err.To)
+}
+
 // ErrXML indicates the unexpected detection of the legacy XML format.
 var ErrXML = errors.New("unexpected XML format")
 
@@ -109,6 +297,27 @@ func (err CodecError) Unwrap() error {
 	return err.Cause
 }
 
+// CompressionError indicates that the payload of a chunk could not be
+// decompressed.
+type CompressionError struct {
+	// Sig is the signature of the chunk.
+	Sig sig
+
+	// Raw is the compressed payload of the chunk, as stored in the file. Set
+	// only if Decoder.CaptureRawOnError is true.
+	Raw []byte
+
+	Cause error
+}
+
+func (err CompressionError) Error() string {
+	return fmt.Sprintf("%q chunk: %s", err.Sig.String(), err.Cause.Error())
+}
+
+func (err CompressionError) Unwrap() error {
+	return err.Cause
+}
+
 // DataError wraps an error that occurred while encoding or decoding byte data.
 type DataError struct {
 	// Offset is the byte offset where the error occurred.
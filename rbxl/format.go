@@ -2,10 +2,52 @@
 // format.
 package rbxl
 
+import (
+	"io"
+
+	"github.com/robloxapi/rbxfile"
+)
+
+func init() {
+	rbxfile.RegisterFormatEncoder("rbxl", func(w io.Writer, root *rbxfile.Root) (warn, err error) {
+		return Encoder{Mode: Place}.Encode(w, root)
+	})
+	rbxfile.RegisterFormatEncoder("rbxm", func(w io.Writer, root *rbxfile.Root) (warn, err error) {
+		return Encoder{Mode: Model}.Encode(w, root)
+	})
+	rbxfile.RegisterFormatDecoder("rbxl", func(r io.Reader) (root *rbxfile.Root, warn, err error) {
+		return Decoder{}.Decode(r)
+	})
+}
+
 // Mode indicates how the codec formats data.
+//
+// UniqueId properties are present only in the place format; when encoding in
+// Model mode, they are omitted.
 type Mode uint8
 
 const (
 	Place Mode = iota // Data is handled as a Roblox place (RBXL) file.
 	Model             // Data is handled as a Roblox model (RBXM) file.
 )
+
+// MetadataPosition indicates where the META chunk is written within a file.
+type MetadataPosition uint8
+
+const (
+	MetadataFirst MetadataPosition = iota // Before all other chunks.
+	MetadataLast                          // After the PRNT chunk, before the END chunk.
+)
+
+// MetadataCompression is the key of a Root.Metadata entry that records the
+// compression method of a file. It is set by a Decoder when
+// RecordCompression is true, and read by an Encoder when
+// CompressionMetadata is true, so that decoding then encoding preserves the
+// original method. The value is one of CompressionLZ4 or CompressionNone.
+const MetadataCompression = "__compression"
+
+// Values of the MetadataCompression entry.
+const (
+	CompressionLZ4  = "lz4"  // Chunks are compressed with LZ4.
+	CompressionNone = "none" // Chunks are not compressed.
+)
@@ -0,0 +1,204 @@
+package rbxl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/robloxapi/rbxfile"
+	"github.com/robloxapi/rbxfile/errors"
+)
+
+// Layout relates the instances and properties of a decoded root to the chunks
+// of the file from which they were decoded. This allows a file to be
+// re-encoded partially: after a property of an instance is modified,
+// UpdateProperty rewrites only the one chunk containing that property, while
+// every other chunk is written exactly as it was read.
+//
+// The mapping is coarse; it relates each instance to an INST chunk, and each
+// property of an instance to a PROP chunk. Only changes to the values of
+// existing properties can be written. The following are not reflected when
+// the layout is written:
+//
+//   - Adding, removing, or reparenting instances.
+//   - Changing the ClassName or IsService of an instance.
+//   - Adding a property that is not present in the file.
+//   - Changing Root.Metadata.
+//
+// Because a PROP chunk contains a value for every instance of a class, a
+// property removed from an instance is written as the default value of the
+// type. A property must otherwise retain its type. Reference properties must
+// refer to instances within the layout. SharedString properties cannot be
+// updated.
+//
+// A layout reflects the decoded root as it was when decoded. Decoder options
+// that transform values, such as Schema or PropertyFilter, do not change the
+// chunks of the layout.
+type Layout struct {
+	// Raw is the file from which the root was decoded. Each chunk retains its
+	// compressed content, so that an unmodified layout is written exactly as
+	// it was read.
+	Raw RawModel
+
+	// Instances maps each decoded instance to the index within Raw.Chunks of
+	// its INST chunk.
+	Instances map[*rbxfile.Instance]int
+
+	// Properties maps the index of an INST chunk to the properties of its
+	// class, which map a property name to the index within Raw.Chunks of its
+	// PROP chunk.
+	Properties map[int]map[string]int
+
+	// groups maps the index of an INST chunk to its decoded chunk.
+	groups map[int]*chunkInstance
+	// props maps the index of a PROP chunk to its decoded chunk.
+	props map[int]*chunkProperty
+	// instList maps a reference number to its instance.
+	instList []*rbxfile.Instance
+	// refs maps an instance to its reference number.
+	refs map[*rbxfile.Instance]int
+}
+
+// DecodeLayout decodes r like Decode, and also returns the layout of the
+// decoded file.
+//
+// Returns ErrXML if the data is in the legacy XML format.
+func (d Decoder) DecodeLayout(r io.Reader) (root *rbxfile.Root, layout *Layout, warn, err error) {
+	if r == nil {
+		return nil, nil, nil, errors.New("nil reader")
+	}
+	// The data is decoded twice: once raw, and once into the root.
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	raw, warn, err := d.DecodeRaw(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, warn, err
+	}
+
+	d.instances = map[int32]*rbxfile.Instance{}
+	root = new(rbxfile.Root)
+	f, w, err := d.decodeInto(bytes.NewReader(data), root)
+	warn = errors.Union(warn, w)
+	if err != nil {
+		return nil, nil, warn, err
+	}
+	if len(f.Chunks) != len(raw.Chunks) {
+		return nil, nil, warn, errors.New("layout does not match decoded chunks")
+	}
+
+	layout = &Layout{
+		Raw:        raw,
+		Instances:  map[*rbxfile.Instance]int{},
+		Properties: map[int]map[string]int{},
+		groups:     map[int]*chunkInstance{},
+		props:      map[int]*chunkProperty{},
+		instList:   make([]*rbxfile.Instance, f.InstanceCount),
+		refs:       make(map[*rbxfile.Instance]int, len(d.instances)),
+	}
+	for ref, inst := range d.instances {
+		if ref >= 0 && int(ref) < len(layout.instList) {
+			layout.instList[ref] = inst
+			layout.refs[inst] = int(ref)
+		}
+	}
+	classes := map[int32]int{}
+	for i, chunk := range f.Chunks {
+		switch chunk := chunk.(type) {
+		case *chunkInstance:
+			classes[chunk.ClassID] = i
+			layout.groups[i] = chunk
+			layout.Properties[i] = map[string]int{}
+			for _, ref := range chunk.InstanceIDs {
+				if inst := d.instances[ref]; inst != nil {
+					layout.Instances[inst] = i
+				}
+			}
+		case *chunkProperty:
+			group, ok := classes[chunk.ClassID]
+			if !ok {
+				continue
+			}
+			layout.Properties[group][chunk.PropertyName] = i
+			layout.props[i] = chunk
+		}
+	}
+	return root, layout, warn, nil
+}
+
+// UpdateProperty rewrites the PROP chunk containing the given property of
+// inst, using the current values of the property for every instance of the
+// class. An error is returned if the property cannot be written, in which
+// case the layout is not modified.
+func (l *Layout) UpdateProperty(inst *rbxfile.Instance, name string) error {
+	group, ok := l.Instances[inst]
+	if !ok {
+		return errors.New("instance is not within layout")
+	}
+	index, ok := l.Properties[group][name]
+	if !ok {
+		return fmt.Errorf("property %s.%s is not within layout", inst.ClassName, name)
+	}
+	instChunk := l.groups[group]
+	old := l.props[index]
+	if old.Properties == nil {
+		return fmt.Errorf("property %s.%s has no value data", inst.ClassName, name)
+	}
+
+	propChunk := &chunkProperty{
+		compressed:   old.compressed,
+		ClassID:      old.ClassID,
+		PropertyName: old.PropertyName,
+	}
+	propType := old.Properties.Type()
+	var optionType typeID
+	switch propType {
+	case typeSharedString:
+		return fmt.Errorf("property %s.%s: cannot update SharedString property", inst.ClassName, name)
+	case typeOptional:
+		optionType = old.Properties.(*arrayOptional).Values.Type()
+		propChunk.Properties = &arrayOptional{
+			Values:  newArray(optionType, len(instChunk.InstanceIDs)),
+			Present: make(arrayBool, len(instChunk.InstanceIDs)),
+		}
+	default:
+		propChunk.Properties = newArray(propType, len(instChunk.InstanceIDs))
+	}
+
+	for _, ref := range instChunk.InstanceIDs {
+		value, ok := l.instList[ref].Properties[name]
+		if !ok {
+			continue
+		}
+		if t := fromValueType(value.Type()); t != propType {
+			return fmt.Errorf("property %s.%s in instance #%d: type %s does not match %s", inst.ClassName, name, ref, t, propType)
+		}
+		switch value := value.(type) {
+		case rbxfile.ValueOptional:
+			if t := fromValueType(value.ValueType()); t != optionType {
+				return fmt.Errorf("property %s.%s in instance #%d: optional type %s does not match %s", inst.ClassName, name, ref, t, optionType)
+			}
+		case rbxfile.ValueReference:
+			if _, ok := l.refs[value.Instance]; value.Instance != nil && !ok {
+				return fmt.Errorf("property %s.%s in instance #%d: referent is not within layout", inst.ClassName, name, ref)
+			}
+		}
+	}
+	robloxCodec{}.setPropertyValues(propChunk, instChunk, l.instList, l.refs, nil)
+
+	var payload bytes.Buffer
+	if _, err := propChunk.WriteTo(&payload); err != nil {
+		return err
+	}
+	chunk := &l.Raw.Chunks[index]
+	chunk.Payload = payload.Bytes()
+	chunk.Stored = nil
+	l.props[index] = propChunk
+	return nil
+}
+
+// WriteTo writes the file described by the layout to w.
+func (l *Layout) WriteTo(w io.Writer) (n int64, err error) {
+	return l.Raw.WriteTo(w)
+}
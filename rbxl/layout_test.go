@@ -0,0 +1,78 @@
+package rbxl
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/robloxapi/rbxfile"
+)
+
+func TestLayout(t *testing.T) {
+	file := encodeRoot(t, Encoder{}, newEncodeTestRoot(10))
+	root, layout, _, err := Decoder{}.DecodeLayout(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := layout.WriteTo(&buf); err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), file) {
+		t.Errorf("unmodified layout does not match file")
+	}
+
+	part := root.Instances[0].Children[3]
+	part.Properties["Transparency"] = rbxfile.ValueFloat(0.25)
+	if err := layout.UpdateProperty(part, "Transparency"); err != nil {
+		t.Fatalf("update error: %s", err)
+	}
+	changed := layout.Properties[layout.Instances[part]]["Transparency"]
+
+	buf.Reset()
+	if _, err := layout.WriteTo(&buf); err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+	got, _, err := Decoder{}.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	for i, p := range got.Instances[0].Children {
+		want := rbxfile.ValueFloat(0.5)
+		if i == 3 {
+			want = 0.25
+		}
+		if v := p.Properties["Transparency"]; v != want {
+			t.Errorf("part %d: expected Transparency %v, got %v", i, want, v)
+		}
+	}
+
+	// Every other chunk is written as it was read.
+	original, _, err := Decoder{}.DecodeRaw(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("raw decode error: %s", err)
+	}
+	written, _, err := Decoder{}.DecodeRaw(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("raw decode error: %s", err)
+	}
+	if len(written.Chunks) != len(original.Chunks) {
+		t.Fatalf("expected %d chunks, got %d", len(original.Chunks), len(written.Chunks))
+	}
+	for i, c := range written.Chunks {
+		if same := bytes.Equal(c.Stored, original.Chunks[i].Stored) && bytes.Equal(c.Payload, original.Chunks[i].Payload); same == (i == changed) {
+			t.Errorf("chunk %d: expected changed to be %t", i, i == changed)
+		}
+	}
+
+	part.Properties["Transparency"] = rbxfile.ValueDouble(1)
+	if err := layout.UpdateProperty(part, "Transparency"); err == nil {
+		t.Errorf("expected error for mismatched type")
+	}
+	if err := layout.UpdateProperty(part, "Anchored"); err == nil {
+		t.Errorf("expected error for property not within layout")
+	}
+	if err := layout.UpdateProperty(rbxfile.NewInstance("Part"), "Name"); err == nil {
+		t.Errorf("expected error for instance not within layout")
+	}
+}
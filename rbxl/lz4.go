@@ -0,0 +1,186 @@
+package rbxl
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// lz4FrameMagic is the magic number at the start of an lz4 frame.
+const lz4FrameMagic = 0x184D2204
+
+// isLZ4Frame returns whether data begins with the lz4 frame magic number.
+func isLZ4Frame(data []byte) bool {
+	return len(data) >= 4 && binary.LittleEndian.Uint32(data) == lz4FrameMagic
+}
+
+var errLZ4FrameTruncated = errors.New("lz4 frame: unexpected end of data")
+
+// decodeLZ4Frame decodes the lz4 frame in data into dst, which must have the
+// length of the decompressed content. Both independent and linked blocks are
+// supported. Checksums are not verified, and content following the end mark,
+// such as the content checksum, is ignored.
+func decodeLZ4Frame(dst, data []byte) error {
+	if len(data) < 4 {
+		return errLZ4FrameTruncated
+	}
+	data = data[4:] // magic
+	if len(data) < 2 {
+		return errLZ4FrameTruncated
+	}
+	flg, bd := data[0], data[1]
+	if flg>>6 != 1 {
+		return fmt.Errorf("lz4 frame: unsupported version %d", flg>>6)
+	}
+	independent := flg&0x20 != 0
+	blockChecksum := flg&0x10 != 0
+	contentSize := flg&0x08 != 0
+	dictID := flg&0x01 != 0
+	if dictID {
+		return errors.New("lz4 frame: dictionaries are not supported")
+	}
+	var blockMax int
+	switch bd >> 4 & 0x7 {
+	case 4:
+		blockMax = 64 << 10
+	case 5:
+		blockMax = 256 << 10
+	case 6:
+		blockMax = 1 << 20
+	case 7:
+		blockMax = 4 << 20
+	default:
+		return fmt.Errorf("lz4 frame: invalid block maximum size")
+	}
+	data = data[2:]
+	if contentSize {
+		if len(data) < 8 {
+			return errLZ4FrameTruncated
+		}
+		if n := binary.LittleEndian.Uint64(data); n != uint64(len(dst)) {
+			return fmt.Errorf("lz4 frame: content size %d does not match decompressed length %d", n, len(dst))
+		}
+		data = data[8:]
+	}
+	if len(data) < 1 {
+		return errLZ4FrameTruncated
+	}
+	data = data[1:] // header checksum
+
+	var pos int
+	for {
+		if len(data) < 4 {
+			return errLZ4FrameTruncated
+		}
+		size := binary.LittleEndian.Uint32(data)
+		data = data[4:]
+		if size == 0 {
+			break // end mark
+		}
+		raw := size&0x80000000 != 0
+		size &^= 0x80000000
+		if uint64(len(data)) < uint64(size) {
+			return errLZ4FrameTruncated
+		}
+		block := data[:size]
+		data = data[size:]
+		if blockChecksum {
+			if len(data) < 4 {
+				return errLZ4FrameTruncated
+			}
+			data = data[4:]
+		}
+
+		// A block decompresses to at most blockMax bytes. Only the last
+		// block is expected to be shorter, but this is not enforced.
+		end := len(dst)
+		if end-pos > blockMax {
+			end = pos + blockMax
+		}
+		if raw {
+			if len(block) > end-pos {
+				return fmt.Errorf("lz4 frame: uncompressed block of length %d exceeds %d", len(block), end-pos)
+			}
+			pos += copy(dst[pos:], block)
+			continue
+		}
+		// Matches within a linked block may refer to the content of
+		// previous blocks.
+		base := 0
+		if independent {
+			base = pos
+		}
+		n, err := decodeLZ4Block(dst[:end], base, pos, block)
+		if err != nil {
+			return fmt.Errorf("lz4 frame: %w", err)
+		}
+		pos = n
+	}
+	if pos != len(dst) {
+		return fmt.Errorf("lz4 frame: decompressed %d bytes, expected %d", pos, len(dst))
+	}
+	return nil
+}
+
+var errLZ4Block = errors.New("corrupt block")
+
+// decodeLZ4Block decodes the lz4 block src into dst, starting at pos, and
+// returns the position following the decoded content. Matches may refer to
+// content of dst from base onward. The content must fit within dst.
+func decodeLZ4Block(dst []byte, base, pos int, src []byte) (int, error) {
+	i := 0
+	// length reads the extension bytes of a literal or match length.
+	length := func(n int) (int, bool) {
+		if n != 15 {
+			return n, true
+		}
+		for i < len(src) {
+			b := src[i]
+			i++
+			n += int(b)
+			if n > len(dst) {
+				return 0, false
+			}
+			if b != 255 {
+				return n, true
+			}
+		}
+		return 0, false
+	}
+	for {
+		if i >= len(src) {
+			return 0, errLZ4Block
+		}
+		token := src[i]
+		i++
+		literals, ok := length(int(token >> 4))
+		if !ok || literals > len(src)-i || literals > len(dst)-pos {
+			return 0, errLZ4Block
+		}
+		pos += copy(dst[pos:], src[i:i+literals])
+		i += literals
+		if i == len(src) {
+			// The last sequence has only literals.
+			return pos, nil
+		}
+		if len(src)-i < 2 {
+			return 0, errLZ4Block
+		}
+		offset := int(binary.LittleEndian.Uint16(src[i:]))
+		i += 2
+		match, ok := length(int(token & 0xF))
+		if !ok || offset == 0 || offset > pos-base {
+			return 0, errLZ4Block
+		}
+		match += 4
+		if match > len(dst)-pos {
+			return 0, errLZ4Block
+		}
+		// The match may overlap the content being written, so it is copied
+		// byte by byte.
+		for j := 0; j < match; j++ {
+			dst[pos+j] = dst[pos-offset+j]
+		}
+		pos += match
+	}
+}
@@ -0,0 +1,111 @@
+package rbxl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/robloxapi/rbxfile"
+)
+
+// lz4FrameFile is an lz4 frame with a content size, containing "hello" as an
+// uncompressed block.
+const lz4FrameFile = "\x04\x22\x4d\x18" + // magic
+	"\x68\x40" + // FLG (version 1, independent, content size), BD (64 KB)
+	"\x05\x00\x00\x00\x00\x00\x00\x00" + // content size
+	"\x00" + // header checksum
+	"\x05\x00\x00\x80hello" + // uncompressed block
+	"\x00\x00\x00\x00" // end mark
+
+// lz4IndependentFrame was produced by "lz4 -B4 -BI --no-frame-crc", and
+// contains lz4IndependentContent as one compressed block.
+const lz4IndependentFrame = "\x04\x22\x4d\x18\x60\x40\x82\x10\x00\x00\x00\x6f\x68\x65\x6c\x6c\x6f\x20\x06\x00\x00\x50\x65\x6c\x6c\x6f\x21\x00\x00\x00\x00"
+
+const lz4IndependentContent = "hello hello hello hello hello!"
+
+// lz4LinkedFrame was produced by "lz4 -B4 -BD --content-size -9", and contains
+// lz4LinkedPattern repeated to a length of 70000 bytes, as two linked blocks
+// of at most 64 KB. The second block begins with a match into the first.
+const lz4LinkedFrame = "\x04\x22\x4d\x18\x4c\x40\x70\x11\x01\x00\x00\x00\x00\x00\x64\x3b\x01\x00\x00\xff\x21\xa5\x4d\xca\x18\x25\x30\xbb\x1d\x6d\x13\x2c" +
+	"\xde\xd6\x23\x7b\x2e\xd9\x1e\x3f\x72\x1f\xcb\x19\x71\x17\x44\x94\xd6\x49\x3c\x9d\x5c\x34\x60\xbe\x31\x20\x1e\x69\xfe\xda\xa0\xee" +
+	"\xe8\xb9\x99\x7f\x5c\x30\x00\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff" +
+	"\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff" +
+	"\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff" +
+	"\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff" +
+	"\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff" +
+	"\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff" +
+	"\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff" +
+	"\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff" +
+	"\xff\xff\xff\xff\xff\xff\xff\xb8\x50\xde\xd6\x23\x7b\x2e\x1b\x00\x00\x00\x0f\x30\x00\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff" +
+	"\xff\xff\xff\xff\xff\xff\x69\x50\xde\xd6\x23\x7b\x2e\x00\x00\x00\x00\x59\x1c\x3c\x4c"
+
+const lz4LinkedPattern = "\xa5\x4d\xca\x18\x25\x30\xbb\x1d\x6d\x13\x2c\xde\xd6\x23\x7b\x2e\xd9\x1e\x3f\x72\x1f\xcb\x19\x71\x17\x44\x94\xd6\x49\x3c\x9d\x5c" +
+	"\x34\x60\xbe\x31\x20\x1e\x69\xfe\xda\xa0\xee\xe8\xb9\x99\x7f\x5c"
+
+func lz4LinkedContent() []byte {
+	return bytes.Repeat([]byte(lz4LinkedPattern), 70000/len(lz4LinkedPattern)+1)[:70000]
+}
+
+func TestDecodeLZ4Frame(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		frame string
+		want  []byte
+	}{
+		{"uncompressed", lz4FrameFile, []byte("hello")},
+		{"independent", lz4IndependentFrame, []byte(lz4IndependentContent)},
+		{"linked", lz4LinkedFrame, lz4LinkedContent()},
+	} {
+		if !isLZ4Frame([]byte(test.frame)) {
+			t.Fatalf("%s: frame not detected", test.name)
+		}
+		dst := make([]byte, len(test.want))
+		if err := decodeLZ4Frame(dst, []byte(test.frame)); err != nil {
+			t.Fatalf("%s: decode error: %s", test.name, err)
+		}
+		if !bytes.Equal(dst, test.want) {
+			t.Errorf("%s: unexpected content", test.name)
+		}
+
+		// Every truncation of the frame before the end mark fails without
+		// panicking.
+		end := strings.LastIndex(test.frame, "\x00\x00\x00\x00") + 4
+		for i := 0; i < end; i++ {
+			if err := decodeLZ4Frame(make([]byte, len(test.want)), []byte(test.frame[:i])); err == nil {
+				t.Errorf("%s: length %d: expected error", test.name, i)
+			}
+		}
+	}
+
+	// The second block cannot be decoded if the frame is marked as having
+	// independent blocks.
+	frame := []byte(lz4LinkedFrame)
+	frame[4] |= 0x20
+	if err := decodeLZ4Frame(make([]byte, 70000), frame); err == nil {
+		t.Errorf("expected error for match outside of independent block")
+	}
+}
+
+// lz4FrameChunkFile contains a Folder whose Name PROP chunk is compressed as an
+// lz4 frame, produced by "lz4 -B4 -BD --content-size", rather than as a raw
+// block.
+const lz4FrameChunkFile = "<roblox!\x89\xff\r\n\x1a\n\x00\x00\x01\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00" +
+	"INST\x00\x00\x00\x00\x17\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x06\x00\x00\x00Folder\x00\x01\x00\x00\x00\x00\x00\x00\x00" +
+	"PROP\x3d\x00\x00\x00\x2f\x00\x00\x00\x00\x00\x00\x00" +
+	"\x04\x22\x4d\x18\x6c\x40\x2f\x00\x00\x00\x00\x00\x00\x00\x58\x22\x00\x00\x00\xff\x08\x00\x00\x00\x00\x04\x00\x00\x00\x4e\x61\x6d" +
+	"\x65\x01\x1e\x00\x00\x00\x68\x65\x6c\x6c\x6f\x20\x06\x00\x00\x50\x65\x6c\x6c\x6f\x21\x00\x00\x00\x00\x2e\x6c\xdd\x76" +
+	"PRNT\x00\x00\x00\x00\r\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01" +
+	"END\x00\x00\x00\x00\x00\t\x00\x00\x00\x00\x00\x00\x00</roblox>"
+
+func TestDecodeLZ4FrameChunk(t *testing.T) {
+	root, warn, err := Decoder{}.Decode(strings.NewReader(lz4FrameChunkFile))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if warn != nil {
+		t.Errorf("unexpected warning: %s", warn)
+	}
+	if name, _ := root.Instances[0].Properties["Name"].(rbxfile.ValueString); string(name) != lz4IndependentContent {
+		t.Errorf("unexpected Name %q", name)
+	}
+}
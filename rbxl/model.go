@@ -1,13 +1,18 @@
 package rbxl
 
 import (
+	"bytes"
+	"crypto/md5"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"unicode"
 
 	"github.com/anaminus/parse"
 	"github.com/bkaradzic/go-lz4"
+	"github.com/robloxapi/rbxfile"
+	"golang.org/x/crypto/blake2b"
 )
 
 ////////////////////////////////////////////////////////////////
@@ -143,6 +148,44 @@ type rawChunk struct {
 	signature uint32
 	compressed
 	payload []byte
+	// size is the length of the payload as stored in the stream, set by
+	// Decode.
+	size uint32
+	// length is the uncompressed length of the payload declared by the
+	// chunk header, set by Decode. It may exceed the length of a truncated
+	// payload.
+	length uint32
+
+	// endLimit, if greater than 0, is the maximum length of the payload of
+	// an END chunk. If endReject is true, then a larger END chunk causes
+	// Decode to fail. Otherwise, the payload is truncated to the limit, and
+	// truncated is set.
+	endLimit  uint32
+	endReject bool
+	truncated bool
+	// endSize is the size of a truncated END chunk, as reported by
+	// EndChunkSizeError.
+	endSize uint32
+
+	// captureRaw sets whether the compressed payload is retained by a
+	// CompressionError.
+	captureRaw bool
+
+	// pending is the compressed payload that has been read, but not yet
+	// decompressed, prefixed with the decompressed length.
+	pending []byte
+
+	// stored, if not nil, is the compressed payload as it appears in the
+	// stream. It is retained by decompress if keepStored is true, and is
+	// written by WriteTo instead of compressing the payload.
+	stored     []byte
+	keepStored bool
+
+	// budget, if not nil, is the remaining number of payload bytes that may
+	// be allocated, which is reduced by read. budgetLimit is the original
+	// number, for reporting.
+	budget      *int64
+	budgetLimit int64
 }
 
 func (c rawChunk) Signature() sig {
@@ -151,6 +194,19 @@ func (c rawChunk) Signature() sig {
 
 // Reads out a raw chunk from a stream, decompressing the chunk if necessary.
 func (c *rawChunk) Decode(fr *parse.BinaryReader) bool {
+	if c.read(fr) {
+		return true
+	}
+	if err := c.decompress(); err != nil {
+		fr.Add(0, err)
+		return true
+	}
+	return false
+}
+
+// read reads out a raw chunk from a stream. The payload of a compressed chunk
+// is left pending until decompress is called.
+func (c *rawChunk) read(fr *parse.BinaryReader) bool {
 	if fr.Number(&c.signature) {
 		return true
 	}
@@ -169,16 +225,31 @@ func (c *rawChunk) Decode(fr *parse.BinaryReader) bool {
 	if fr.Number(&reserved) {
 		return true
 	}
+	c.length = decompressedLength
+
+	if c.signature == sigEND && c.endLimit > 0 && (decompressedLength > c.endLimit || compressedLength > c.endLimit) {
+		return c.decodeLargeEnd(fr, compressedLength, decompressedLength)
+	}
+
+	if c.budget != nil {
+		if int64(decompressedLength) > *c.budget {
+			fr.Add(0, DecompressedSizeError{Size: decompressedLength, Limit: c.budgetLimit})
+			return true
+		}
+		*c.budget -= int64(decompressedLength)
+	}
 
 	c.payload = make([]byte, decompressedLength)
 	// If compressed length is 0, then the data is not compressed.
 	if compressedLength == 0 {
 		c.compressed = false
+		c.size = decompressedLength
 		if fr.Bytes(c.payload) {
 			return true
 		}
 	} else {
 		c.compressed = true
+		c.size = compressedLength
 
 		// Prepare compressed data for reading by lz4, which requires the
 		// uncompressed length before the compressed data.
@@ -188,20 +259,111 @@ func (c *rawChunk) Decode(fr *parse.BinaryReader) bool {
 		if fr.Bytes(compressedData[4:]) {
 			return true
 		}
+		c.pending = compressedData
+	}
 
-		// ROBLOX ERROR: "Malformed data ([true decompressed length] != [given
-		// decompressed length])". lz4 already does some kind of size
-		// validation, though the error message isn't the same.
+	return false
+}
 
-		if _, err := lz4.Decode(c.payload, compressedData); err != nil {
-			fr.Add(0, fmt.Errorf("lz4: %s", err.Error()))
-			return true
+// decompress decompresses the pending payload of the chunk, if any. It does
+// not depend on the stream, so chunks may be decompressed concurrently.
+func (c *rawChunk) decompress() error {
+	compressedData := c.pending
+	if compressedData == nil {
+		return nil
+	}
+	c.pending = nil
+	if c.keepStored {
+		c.stored = compressedData[4:]
+	}
+
+	// ROBLOX ERROR: "Malformed data ([true decompressed length] != [given
+	// decompressed length])". lz4 already does some kind of size validation,
+	// though the error message isn't the same.
+
+	// Some tools produce the lz4 frame format rather than a raw block.
+	if isLZ4Frame(compressedData[4:]) {
+		if err := decodeLZ4Frame(c.payload, compressedData[4:]); err != nil {
+			return c.compressionError(err, compressedData[4:])
 		}
+		return nil
 	}
 
+	if _, err := lz4.Decode(c.payload, compressedData); err != nil {
+		return c.compressionError(fmt.Errorf("lz4: %s", err.Error()), compressedData[4:])
+	}
+	return nil
+}
+
+// compressionError returns a CompressionError with the given cause, retaining
+// raw if enabled.
+func (c *rawChunk) compressionError(cause error, raw []byte) error {
+	err := CompressionError{Sig: sig(c.signature), Cause: cause}
+	if c.captureRaw {
+		err.Raw = raw
+	}
+	return err
+}
+
+// decodeLargeEnd handles an END chunk whose payload exceeds endLimit, without
+// allocating the entire payload. A truncated uncompressed payload retains the
+// first endLimit bytes, while a truncated compressed payload is discarded.
+func (c *rawChunk) decodeLargeEnd(fr *parse.BinaryReader, compressedLength, decompressedLength uint32) bool {
+	exceeded := decompressedLength
+	if compressedLength > exceeded {
+		exceeded = compressedLength
+	}
+	if c.endReject {
+		fr.Add(0, EndChunkSizeError{Size: exceeded, Limit: c.endLimit})
+		return true
+	}
+	c.truncated = true
+	c.endSize = exceeded
+	size := decompressedLength
+	if compressedLength != 0 {
+		c.compressed = true
+		size = compressedLength
+	} else {
+		c.compressed = false
+		c.payload = make([]byte, c.endLimit)
+		if fr.Bytes(c.payload) {
+			return true
+		}
+	}
+	c.size = size
+	remaining := int64(size) - int64(len(c.payload))
+	buf := make([]byte, 4096)
+	for remaining > 0 {
+		n := int64(len(buf))
+		if n > remaining {
+			n = remaining
+		}
+		if fr.Bytes(buf[:n]) {
+			return true
+		}
+		remaining -= n
+	}
 	return false
 }
 
+// compressPayload returns payload compressed as the content of a compressed
+// chunk.
+func compressPayload(payload []byte) ([]byte, error) {
+	compressedData, err := lz4.Encode(nil, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// lz4 sanity check
+	if binary.LittleEndian.Uint32(compressedData[:4]) != uint32(len(payload)) {
+		panic("lz4 uncompressed length does not match payload length")
+	}
+
+	// lz4 prepends the length of the uncompressed payload, so it must be
+	// excluded.
+	return compressedData[4:], nil
+}
+
 // Writes a raw chunk payload to a stream, compressing if necessary.
 func (c *rawChunk) WriteTo(fw *parse.BinaryWriter) bool {
 	if fw.Number(c.signature) {
@@ -209,21 +371,16 @@ func (c *rawChunk) WriteTo(fw *parse.BinaryWriter) bool {
 	}
 
 	if c.compressed {
-		var compressedData []byte
-		compressedData, err := lz4.Encode(compressedData, c.payload)
-		if fw.Add(0, err) {
-			return true
+		compressedPayload := c.stored
+		if compressedPayload == nil {
+			var err error
+			compressedPayload, err = compressPayload(c.payload)
+			if fw.Add(0, err) {
+				return true
+			}
 		}
 
-		// lz4 sanity check
-		if binary.LittleEndian.Uint32(compressedData[:4]) != uint32(len(c.payload)) {
-			panic("lz4 uncompressed length does not match payload length")
-		}
-
-		// Compressed length; lz4 prepends the length of the uncompressed
-		// payload, so it must be excluded.
-		compressedPayload := compressedData[4:]
-
+		// Compressed length
 		if fw.Number(uint32(len(compressedPayload))) {
 			return true
 		}
@@ -508,12 +665,41 @@ type chunkParent struct {
 	// array. The length of this array should be equal to the length of
 	// Children.
 	Parents []int32
+
+	// raw is the undecoded content of a chunk with an unrecognized version.
+	raw []byte
 }
 
 func (chunkParent) Signature() sig {
 	return sigPRNT
 }
 
+// ParentLinkDecoder decodes the parent links of a parent chunk. r reads the
+// content of the chunk that follows the version byte. The returned arrays pair
+// each child instance ID with the instance ID of its parent, where -1
+// indicates no parent.
+type ParentLinkDecoder func(r io.Reader) (children, parents []int32, err error)
+
+// parentLinkDecoders maps a parent chunk version to a decoder.
+var parentLinkDecoders = map[uint8]ParentLinkDecoder{
+	0: decodeParentLinks,
+}
+
+// RegisterParentLinkDecoder registers dec as the decoder of parent chunks with
+// the given version, replacing any existing decoder. If dec is nil, then the
+// decoder for the version is removed. Parent chunks with a version that has
+// no decoder produce an error.
+//
+// RegisterParentLinkDecoder is not safe to call concurrently with decoding,
+// and should be called during initialization.
+func RegisterParentLinkDecoder(version uint8, dec ParentLinkDecoder) {
+	if dec == nil {
+		delete(parentLinkDecoders, version)
+		return
+	}
+	parentLinkDecoders[version] = dec
+}
+
 func (c *chunkParent) Decode(r io.Reader) (n int64, err error) {
 	fr := parse.NewBinaryReader(r)
 
@@ -521,46 +707,68 @@ func (c *chunkParent) Decode(r io.Reader) (n int64, err error) {
 		return fr.End()
 	}
 
+	content, failed := fr.All()
+	if failed {
+		return fr.End()
+	}
+
+	dec, ok := parentLinkDecoders[c.Version]
+	if !ok {
+		// Retain content so that the chunk can be reencoded, and the codec can
+		// report the unrecognized version.
+		c.raw = content
+		return fr.End()
+	}
+
+	c.Children, c.Parents, err = dec(bytes.NewReader(content))
+	fr.Add(0, err)
+	return fr.End()
+}
+
+// decodeParentLinks decodes the links of a version 0 parent chunk.
+func decodeParentLinks(r io.Reader) (children, parents []int32, err error) {
+	fr := parse.NewBinaryReader(r)
+
 	var instanceCount uint32
 	if fr.Number(&instanceCount) {
-		return fr.End()
+		return nil, nil, fr.Err()
 	}
 
-	c.Children = make([]int32, instanceCount)
+	children = make([]int32, instanceCount)
 	if instanceCount > 0 {
 		raw := make([]byte, instanceCount*4)
 		if fr.Bytes(raw) {
-			return fr.End()
+			return nil, nil, fr.Err()
 		}
 
 		values, err := refArrayFromBytes(raw, int(instanceCount))
-		if fr.Add(0, err) {
-			return fr.End()
+		if err != nil {
+			return nil, nil, err
 		}
 
 		for i, v := range values {
-			c.Children[i] = int32(v)
+			children[i] = int32(v)
 		}
 	}
 
-	c.Parents = make([]int32, instanceCount)
+	parents = make([]int32, instanceCount)
 	if instanceCount > 0 {
 		raw := make([]byte, instanceCount*4)
 		if fr.Bytes(raw) {
-			return fr.End()
+			return nil, nil, fr.Err()
 		}
 
 		values, err := refArrayFromBytes(raw, int(instanceCount))
-		if fr.Add(0, err) {
-			return fr.End()
+		if err != nil {
+			return nil, nil, err
 		}
 
 		for i, v := range values {
-			c.Parents[i] = int32(v)
+			parents[i] = int32(v)
 		}
 	}
 
-	return fr.End()
+	return children, parents, nil
 }
 
 func (c *chunkParent) WriteTo(w io.Writer) (n int64, err error) {
@@ -570,6 +778,11 @@ func (c *chunkParent) WriteTo(w io.Writer) (n int64, err error) {
 		return fw.End()
 	}
 
+	if c.raw != nil {
+		fw.Bytes(c.raw)
+		return fw.End()
+	}
+
 	var instanceCount = len(c.Children)
 	if len(c.Parents) != instanceCount {
 		fw.Add(0, errParentArray{Children: instanceCount, Parent: len(c.Parents)})
@@ -616,6 +829,44 @@ func (chunkProperty) Signature() sig {
 	return sigPROP
 }
 
+// DataType returns the name and rbxfile.Type of the values in the chunk. For
+// optional values, typ is the inner type, and name is prefixed with
+// "Optional". Returns "Invalid" and rbxfile.TypeInvalid if the chunk has no
+// values.
+func (c *chunkProperty) DataType() (name string, typ rbxfile.Type) {
+	if c.Properties == nil {
+		return typeInvalid.String(), rbxfile.TypeInvalid
+	}
+	t := c.Properties.Type()
+	if a, ok := c.Properties.(*arrayOptional); ok && a.Values != nil {
+		t = a.Values.Type()
+		return "Optional" + t.String(), t.ValueType()
+	}
+	return t.String(), t.ValueType()
+}
+
+// SizeKind returns the kind of size of the values in the chunk, which is one
+// of "constant", "array", "conditional", "optional", "other", or "invalid".
+func (c *chunkProperty) SizeKind() string {
+	if c.Properties == nil {
+		return "invalid"
+	}
+	switch size := c.Properties.Type().Size(); {
+	case size > 0:
+		return "constant"
+	case size == zArray:
+		return "array"
+	case size == zCond:
+		return "conditional"
+	case size == zOpt:
+		return "optional"
+	case size == zOther:
+		return "other"
+	default:
+		return "invalid"
+	}
+}
+
 func (c *chunkProperty) Decode(r io.Reader, groupLookup map[int32]*chunkInstance) (n int64, err error) {
 	fr := parse.NewBinaryReader(r)
 
@@ -644,11 +895,25 @@ func (c *chunkProperty) Decode(r io.Reader, groupLookup map[int32]*chunkInstance
 	}
 
 	if c.Properties, _, err = typeArrayFromBytes(rawBytes, len(inst.InstanceIDs)); err != nil {
-		if c.Properties != nil {
-			fr.Add(0, ValueError{Type: byte(c.Properties.Type()), Cause: err})
-		} else {
+		if c.Properties == nil {
 			fr.Add(0, err)
+			return fr.End()
 		}
+		// Locate the failing value within the payload, which starts with
+		// the class ID, the property name, and the type.
+		perr := errPropertyValue{
+			Property: c.PropertyName,
+			Index:    -1,
+			Offset:   4 + 4 + len(c.PropertyName) + zb,
+		}
+		var ierr indexError
+		if errors.As(err, &ierr) {
+			perr.Index = ierr.Index
+			perr.Offset += ierr.Offset
+			err = ierr.Cause
+		}
+		perr.Cause = ValueError{Type: byte(c.Properties.Type()), Cause: err}
+		fr.Add(0, perr)
 		return fr.End()
 	}
 
@@ -745,8 +1010,15 @@ const sigSSTR = 0x52_54_53_53 // RTSS
 type chunkSharedStrings struct {
 	compressed
 
+	// Version is the version of the chunk. Only version 0 is known, where each
+	// entry is a 16-byte hash followed by the value.
 	Version uint32
 	Values  []sharedString
+
+	// unknown is set when the chunk has an unknown version, in which case raw
+	// is the content of the chunk following the version, and may be empty.
+	unknown bool
+	raw     []byte
 }
 
 type sharedString struct {
@@ -754,6 +1026,21 @@ type sharedString struct {
 	Value []byte
 }
 
+// verifyHash returns whether the hash of the shared string matches its value.
+// The hash is accepted if it is the MD5 of the value, or the first 16 bytes of
+// the BLAKE2b-256 of the value. A zero hash, which is written by newer versions
+// of Roblox, is always accepted.
+func (ss sharedString) verifyHash() bool {
+	if ss.Hash == ([16]byte{}) {
+		return true
+	}
+	if md5.Sum(ss.Value) == ss.Hash {
+		return true
+	}
+	sum := blake2b.Sum256(ss.Value)
+	return bytes.Equal(sum[:16], ss.Hash[:])
+}
+
 func (chunkSharedStrings) Signature() sig {
 	return sigSSTR
 }
@@ -764,7 +1051,13 @@ func (c *chunkSharedStrings) Decode(r io.Reader) (n int64, err error) {
 	if fr.Number(&c.Version) {
 		return fr.End()
 	}
-	// TODO: validate version?
+	if c.Version != 0 {
+		// Retain content so that the chunk can be reencoded, and the codec can
+		// report the unrecognized version.
+		c.unknown = true
+		c.raw, _ = fr.All()
+		return fr.End()
+	}
 
 	var length uint32
 	if fr.Number(&length) {
@@ -774,7 +1067,7 @@ func (c *chunkSharedStrings) Decode(r io.Reader) (n int64, err error) {
 
 	for i := range c.Values {
 		if fr.Bytes(c.Values[i].Hash[:]) {
-			fr.End()
+			return fr.End()
 		}
 		var value string
 		if readString(fr, &value) {
@@ -794,16 +1087,21 @@ func (c *chunkSharedStrings) WriteTo(w io.Writer) (n int64, err error) {
 		return fw.End()
 	}
 
+	if c.unknown {
+		fw.Bytes(c.raw)
+		return fw.End()
+	}
+
 	if fw.Number(uint32(len(c.Values))) {
 		return fw.End()
 	}
 
 	for _, ss := range c.Values {
 		if fw.Bytes(ss.Hash[:]) {
-			fw.End()
+			return fw.End()
 		}
 		if writeString(fw, string(ss.Value)) {
-			fw.End()
+			return fw.End()
 		}
 	}
 
@@ -0,0 +1,59 @@
+package rbxl
+
+import (
+	"io"
+
+	"github.com/robloxapi/rbxfile"
+)
+
+// PlaceRoot wraps a root decoded as a place. The top-level instances of a
+// place are the services of a DataModel.
+type PlaceRoot struct {
+	*rbxfile.Root
+}
+
+// Service returns the first top-level instance whose ClassName is name.
+// Returns nil if no such instance exists.
+func (p PlaceRoot) Service(name string) *rbxfile.Instance {
+	for _, inst := range p.Instances {
+		if inst.ClassName == name {
+			return inst
+		}
+	}
+	return nil
+}
+
+// GetOrCreateService returns the first top-level instance whose ClassName is
+// name. If no such instance exists, then a new service of the class is created
+// and appended to the top-level instances.
+func (p PlaceRoot) GetOrCreateService(name string) *rbxfile.Instance {
+	if inst := p.Service(name); inst != nil {
+		return inst
+	}
+	inst := rbxfile.NewInstance(name)
+	inst.IsService = true
+	p.Instances = append(p.Instances, inst)
+	return inst
+}
+
+// ModelRoot wraps a root decoded as a model. The top-level instances of a
+// model may be of any class.
+type ModelRoot struct {
+	*rbxfile.Root
+}
+
+// DecodePlace decodes data from r as a place, regardless of the decoder's
+// Mode.
+func (d Decoder) DecodePlace(r io.Reader) (place PlaceRoot, warn, err error) {
+	d.Mode = Place
+	root, warn, err := d.Decode(r)
+	return PlaceRoot{Root: root}, warn, err
+}
+
+// DecodeModel decodes data from r as a model, regardless of the decoder's
+// Mode.
+func (d Decoder) DecodeModel(r io.Reader) (model ModelRoot, warn, err error) {
+	d.Mode = Model
+	root, warn, err := d.Decode(r)
+	return ModelRoot{Root: root}, warn, err
+}
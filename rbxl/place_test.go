@@ -0,0 +1,68 @@
+package rbxl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/robloxapi/rbxfile"
+)
+
+func TestDecodePlace(t *testing.T) {
+	workspace := rbxfile.NewInstance("Workspace")
+	workspace.IsService = true
+	workspace.Children = append(workspace.Children, rbxfile.NewInstance("Part"))
+	buf := encodeRoot(t, Encoder{Mode: Place}, &rbxfile.Root{Instances: []*rbxfile.Instance{workspace}})
+
+	// The mode of the decoder is overridden.
+	place, _, err := Decoder{Mode: Model}.DecodePlace(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	ws := place.Service("Workspace")
+	if ws == nil || !ws.IsService || len(ws.Children) != 1 {
+		t.Fatalf("unexpected Workspace %v", ws)
+	}
+	if place.Service("Lighting") != nil {
+		t.Errorf("unexpected Lighting service")
+	}
+
+	lighting := place.GetOrCreateService("Lighting")
+	if lighting == nil || lighting.ClassName != "Lighting" || !lighting.IsService {
+		t.Fatalf("unexpected created service %v", lighting)
+	}
+	if len(place.Instances) != 2 || place.Instances[1] != lighting {
+		t.Errorf("expected service to be added to the root, got %v", place.Instances)
+	}
+	if place.GetOrCreateService("Lighting") != lighting || place.GetOrCreateService("Workspace") != ws {
+		t.Errorf("expected existing services to be returned")
+	}
+	if len(place.Instances) != 2 {
+		t.Errorf("expected no additional services, got %v", place.Instances)
+	}
+
+	if _, _, err := (Decoder{}).DecodePlace(strings.NewReader("not a place")); err == nil {
+		t.Errorf("expected error for invalid data")
+	}
+}
+
+func TestDecodeModel(t *testing.T) {
+	part := rbxfile.NewInstance("Part")
+	part.Properties["Name"] = rbxfile.ValueString("Part")
+	buf := encodeRoot(t, Encoder{Mode: Model}, &rbxfile.Root{Instances: []*rbxfile.Instance{part}})
+
+	model, _, err := Decoder{Mode: Place}.DecodeModel(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if len(model.Instances) != 1 || model.Instances[0].ClassName != "Part" || model.Instances[0].IsService {
+		t.Fatalf("unexpected instances %v", model.Instances)
+	}
+	if v, ok := model.Instances[0].Properties["Name"].(rbxfile.ValueString); !ok || string(v) != "Part" {
+		t.Errorf("unexpected Name %#v", model.Instances[0].Properties["Name"])
+	}
+
+	if _, _, err := (Decoder{}).DecodeModel(strings.NewReader("not a model")); err == nil {
+		t.Errorf("expected error for invalid data")
+	}
+}
@@ -0,0 +1,146 @@
+package rbxl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/anaminus/parse"
+	"github.com/robloxapi/rbxfile/errors"
+)
+
+// RawModel is a low-level representation of the binary format, consisting of
+// the header and the uncompressed payload of each chunk. It may be used to
+// inspect a file chunk by chunk, or to craft a file that an Encoder would not
+// produce, such as for testing decoders.
+//
+// WriteTo writes the model exactly as given, without validation. For the
+// result to be decodable, the model must satisfy the following:
+//
+//   - Header.Version is 0.
+//   - Header.ClassCount is the number of INST chunks, and
+//     Header.InstanceCount is the total number of instances within them.
+//   - An INST chunk for a class appears before any PROP chunk that refers
+//     to it, and before the PRNT chunk.
+//   - The last chunk is an uncompressed END chunk with the payload
+//     "</roblox>".
+type RawModel struct {
+	Header Header
+
+	// Chunks is the list of chunks, in order.
+	Chunks []RawChunk
+
+	// Trailing is the bytes that appear after the END chunk.
+	Trailing []byte
+}
+
+// RawChunk is a chunk of the binary format with an uncompressed payload.
+type RawChunk struct {
+	// Signature identifies the type of the chunk, such as "INST" or "END\x00".
+	Signature [4]byte
+
+	// Compressed is whether the payload is compressed when written.
+	Compressed bool
+
+	// Payload is the uncompressed content of the chunk.
+	Payload []byte
+
+	// Stored, if not nil, is the compressed content of the chunk as read from
+	// a file. When Compressed is true, Stored is written instead of
+	// compressing Payload, so that the chunk is written exactly as it was
+	// read. Stored must be set to nil if Payload is modified.
+	Stored []byte
+}
+
+// NewRawChunk returns a RawChunk with the given signature and payload. A
+// signature shorter than 4 bytes is padded with zeros, so "END" may be used
+// for the END chunk. Panics if signature is longer than 4 bytes.
+func NewRawChunk(signature string, compressed bool, payload []byte) RawChunk {
+	if len(signature) > 4 {
+		panic("chunk signature longer than 4 bytes")
+	}
+	c := RawChunk{Compressed: compressed, Payload: payload}
+	copy(c.Signature[:], signature)
+	return c
+}
+
+// NewEndChunk returns a valid END chunk.
+func NewEndChunk() RawChunk {
+	return NewRawChunk("END", false, []byte("</roblox>"))
+}
+
+// WriteTo writes the model to w according to the binary format.
+func (m RawModel) WriteTo(w io.Writer) (n int64, err error) {
+	fw := parse.NewBinaryWriter(w)
+	if fw.Bytes([]byte(robloxSig + binaryMarker + binaryHeader)) {
+		return fw.End()
+	}
+	if fw.Number(m.Header.Version) {
+		return fw.End()
+	}
+	if fw.Number(m.Header.ClassCount) {
+		return fw.End()
+	}
+	if fw.Number(m.Header.InstanceCount) {
+		return fw.End()
+	}
+	if fw.Bytes(m.Header.Reserved[:]) {
+		return fw.End()
+	}
+	for _, c := range m.Chunks {
+		raw := rawChunk{
+			signature:  binary.LittleEndian.Uint32(c.Signature[:]),
+			compressed: compressed(c.Compressed),
+			payload:    c.Payload,
+			stored:     c.Stored,
+		}
+		if raw.WriteTo(fw) {
+			return fw.End()
+		}
+	}
+	fw.Bytes(m.Trailing)
+	return fw.End()
+}
+
+// DecodeRaw decodes the binary format from r into a RawModel. Each chunk is
+// decompressed, but its payload is otherwise left as-is. The compressed
+// content of each chunk is retained in Stored. The reserved bytes of the
+// header are not retained.
+//
+// Returns ErrXML if the data is in the legacy XML format.
+func (d Decoder) DecodeRaw(r io.Reader) (m RawModel, warn, err error) {
+	if r == nil {
+		return m, nil, errors.New("nil reader")
+	}
+
+	d.keepStored = true
+	f, buf, warn, err := d.decode(r, true)
+	if err != nil {
+		return m, warn, err
+	}
+	if buf != nil {
+		return m, warn, ErrXML
+	}
+
+	m.Header = Header{
+		Version:       f.Version,
+		ClassCount:    f.ClassCount,
+		InstanceCount: f.InstanceCount,
+	}
+	m.Chunks = make([]RawChunk, len(f.Chunks))
+	for i, chunk := range f.Chunks {
+		var payload bytes.Buffer
+		if _, err := chunk.WriteTo(&payload); err != nil {
+			return RawModel{}, warn, ChunkError{Index: i, Sig: chunk.Signature(), Cause: err}
+		}
+		c := &m.Chunks[i]
+		binary.LittleEndian.PutUint32(c.Signature[:], uint32(chunk.Signature()))
+		c.Compressed = chunk.Compressed()
+		c.Payload = payload.Bytes()
+		if chunk, ok := chunk.(*chunkUnknown); ok {
+			c.Stored = chunk.stored
+		}
+	}
+	m.Trailing = f.Trailing
+	return m, warn, nil
+}
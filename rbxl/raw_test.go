@@ -0,0 +1,36 @@
+package rbxl
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRawModel(t *testing.T) {
+	m := RawModel{Chunks: []RawChunk{NewEndChunk()}}
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+	if _, _, err := (Decoder{}).Decode(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Errorf("decode error: %s", err)
+	}
+
+	want := encodeRoot(t, Encoder{Uncompressed: true}, newEncodeTestRoot(10))
+	m, _, err := Decoder{}.DecodeRaw(bytes.NewReader(want))
+	if err != nil {
+		t.Fatalf("raw decode error: %s", err)
+	}
+	if m.Header.ClassCount != 3 || m.Header.InstanceCount != 21 {
+		t.Errorf("unexpected header %+v", m.Header)
+	}
+	if n := len(m.Chunks); n == 0 || m.Chunks[n-1].Signature != NewEndChunk().Signature {
+		t.Errorf("expected last chunk to be END")
+	}
+	buf.Reset()
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("raw model does not round trip")
+	}
+}
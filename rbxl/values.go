@@ -61,44 +61,46 @@ const (
 type typeID byte
 
 const (
-	typeInvalid            typeID = 0x0
-	typeString             typeID = 0x1
-	typeBool               typeID = 0x2
-	typeInt                typeID = 0x3
-	typeFloat              typeID = 0x4
-	typeDouble             typeID = 0x5
-	typeUDim               typeID = 0x6
-	typeUDim2              typeID = 0x7
-	typeRay                typeID = 0x8
-	typeFaces              typeID = 0x9
-	typeAxes               typeID = 0xA
-	typeBrickColor         typeID = 0xB
-	typeColor3             typeID = 0xC
-	typeVector2            typeID = 0xD
-	typeVector3            typeID = 0xE
-	typeVector2int16       typeID = 0xF
-	typeCFrame             typeID = 0x10
-	typeCFrameQuat         typeID = 0x11
-	typeToken              typeID = 0x12
-	typeReference          typeID = 0x13
-	typeVector3int16       typeID = 0x14
-	typeNumberSequence     typeID = 0x15
-	typeColorSequence      typeID = 0x16
-	typeNumberRange        typeID = 0x17
-	typeRect               typeID = 0x18
-	typePhysicalProperties typeID = 0x19
-	typeColor3uint8        typeID = 0x1A
-	typeInt64              typeID = 0x1B
-	typeSharedString       typeID = 0x1C
-	typeSignedString       typeID = 0x1D //TODO
-	typeOptional           typeID = 0x1E
-	typeUniqueId           typeID = 0x1F
-	typeFont               typeID = 0x20
+	typeInvalid              typeID = 0x0
+	typeString               typeID = 0x1
+	typeBool                 typeID = 0x2
+	typeInt                  typeID = 0x3
+	typeFloat                typeID = 0x4
+	typeDouble               typeID = 0x5
+	typeUDim                 typeID = 0x6
+	typeUDim2                typeID = 0x7
+	typeRay                  typeID = 0x8
+	typeFaces                typeID = 0x9
+	typeAxes                 typeID = 0xA
+	typeBrickColor           typeID = 0xB
+	typeColor3               typeID = 0xC
+	typeVector2              typeID = 0xD
+	typeVector3              typeID = 0xE
+	typeVector2int16         typeID = 0xF
+	typeCFrame               typeID = 0x10
+	typeCFrameQuat           typeID = 0x11
+	typeToken                typeID = 0x12
+	typeReference            typeID = 0x13
+	typeVector3int16         typeID = 0x14
+	typeNumberSequence       typeID = 0x15
+	typeColorSequence        typeID = 0x16
+	typeNumberRange          typeID = 0x17
+	typeRect                 typeID = 0x18
+	typePhysicalProperties   typeID = 0x19
+	typeColor3uint8          typeID = 0x1A
+	typeInt64                typeID = 0x1B
+	typeSharedString         typeID = 0x1C
+	typeSignedString         typeID = 0x1D //TODO
+	typeOptional             typeID = 0x1E
+	typeUniqueId             typeID = 0x1F
+	typeFont                 typeID = 0x20
+	typeSecurityCapabilities typeID = 0x21
 )
 
-// Valid returns whether the type has a valid value.
+// Valid returns whether the type has a valid value. Types registered with
+// RegisterValueType are valid.
 func (t typeID) Valid() bool {
-	return typeString <= t && t <= typeFont && t != typeSignedString
+	return t.builtin() || t.custom()
 }
 
 // Size returns the number of bytes required to hold a value of the type.
@@ -170,7 +172,12 @@ func (t typeID) Size() int {
 		return zUniqueId
 	case typeFont:
 		return zFont
+	case typeSecurityCapabilities:
+		return zSecurityCapabilities
 	default:
+		if t.custom() {
+			return zOther
+		}
 		return zInvalid
 	}
 }
@@ -281,7 +288,12 @@ func (t typeID) String() string {
 		return "UniqueId"
 	case typeFont:
 		return "Font"
+	case typeSecurityCapabilities:
+		return "SecurityCapabilities"
 	default:
+		if t.custom() {
+			return "Custom(0x" + strconv.FormatUint(uint64(t), 16) + ")"
+		}
 		return "Invalid"
 	}
 }
@@ -351,7 +363,12 @@ func (t typeID) ValueType() rbxfile.Type {
 		return rbxfile.TypeUniqueId
 	case typeFont:
 		return rbxfile.TypeFont
+	case typeSecurityCapabilities:
+		return rbxfile.TypeSecurityCapabilities
 	default:
+		if factory := customValueTypes[t]; factory != nil {
+			return factory().Type()
+		}
 		return rbxfile.TypeInvalid
 	}
 }
@@ -399,7 +416,7 @@ func fromValueType(t rbxfile.Type) typeID {
 		return typeCFrame
 	case rbxfile.TypeToken:
 		return typeToken
-	case rbxfile.TypeReference:
+	case rbxfile.TypeReference, rbxfile.TypeContentObject:
 		return typeReference
 	case rbxfile.TypeVector3int16:
 		return typeVector3int16
@@ -425,8 +442,10 @@ func fromValueType(t rbxfile.Type) typeID {
 		return typeUniqueId
 	case rbxfile.TypeFont:
 		return typeFont
+	case rbxfile.TypeSecurityCapabilities:
+		return typeSecurityCapabilities
 	default:
-		return typeInvalid
+		return customTypeOf(t)
 	}
 }
 
@@ -515,6 +534,11 @@ func newValue(typ typeID) value {
 		return new(valueUniqueId)
 	case typeFont:
 		return new(valueFont)
+	case typeSecurityCapabilities:
+		return new(valueSecurityCapabilities)
+	}
+	if typ.custom() {
+		return &valueCustom{id: typ}
 	}
 	return nil
 }
@@ -2334,3 +2358,31 @@ func (v valueFont) Dump(w *bufio.Writer, indent int) {
 }
 
 ////////////////////////////////////////////////////////////////
+
+const zSecurityCapabilities = zu64
+
+type valueSecurityCapabilities uint64
+
+func (valueSecurityCapabilities) Type() typeID {
+	return typeSecurityCapabilities
+}
+
+func (v valueSecurityCapabilities) BytesLen() int {
+	return zSecurityCapabilities
+}
+
+func (v valueSecurityCapabilities) Bytes(b []byte) []byte {
+	return appendUint64(b, be, uint64(v))
+}
+
+func (v *valueSecurityCapabilities) FromBytes(b []byte) (n int, err error) {
+	if n, err = checkLengthConst(v, b); err != nil {
+		return n, err
+	}
+	*v = valueSecurityCapabilities(binary.BigEndian.Uint64(b))
+	return n, nil
+}
+
+func (v valueSecurityCapabilities) Dump(w *bufio.Writer, indent int) {
+	w.Write(strconv.AppendUint(nil, uint64(v), 10))
+}
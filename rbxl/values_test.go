@@ -0,0 +1,220 @@
+package rbxl
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/robloxapi/rbxfile"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+// valueBytesTests are the exact bytes produced by encoding a single value of
+// each type. Roblox mixes byte orders between types, so these guard against
+// accidentally changing the byte order of a type.
+var valueBytesTests = []struct {
+	value value
+	bytes string
+}{
+	// Length is little-endian.
+	{ptr(valueString("ab")), "020000006162"},
+	{ptr(valueBool(true)), "01"},
+	// Zigzag, big-endian.
+	{ptr(valueInt(0x01020304)), "02040608"},
+	// Roblox float, big-endian.
+	{ptr(valueFloat(1)), "7f000000"},
+	// IEEE 754, little-endian.
+	{ptr(valueDouble(1)), "000000000000f03f"},
+	// Roblox float and zigzag, big-endian.
+	{ptr(valueUDim{Scale: 1, Offset: 0x01020304}), "7f00000002040608"},
+	{ptr(valueUDim2{ScaleX: 1, ScaleY: 2, OffsetX: 0x01020304, OffsetY: -1}), "7f000000800000000204060800000001"},
+	// IEEE 754, little-endian.
+	{ptr(valueRay{OriginX: 1, OriginY: 2, OriginZ: 3, DirectionX: -1, DirectionY: -2, DirectionZ: -3}), "0000803f0000004000004040000080bf000000c0000040c0"},
+	{ptr(valueFaces{Right: true, Back: true, Bottom: true}), "15"},
+	{ptr(valueAxes{X: true, Z: true}), "05"},
+	// Big-endian.
+	{ptr(valueBrickColor(0x01020304)), "01020304"},
+	{ptr(valueColor3{R: 1, G: 0.5, B: -1}), "7f0000007e0000007f000001"},
+	{ptr(valueVector2{X: 1, Y: -1}), "7f0000007f000001"},
+	{ptr(valueVector3{X: 1, Y: 2, Z: -1}), "7f000000800000007f000001"},
+	// Little-endian.
+	{ptr(valueVector2int16{X: 0x0102, Y: -2}), "0201feff"},
+	{ptr(valueCFrame{Special: 0x02, Position: valueVector3{X: 1, Y: 2, Z: 3}}), "027f0000008000000080800000"},
+	{ptr(valueCFrame{Rotation: [9]float32{1, 0, 0, 0, 1, 0, 0, 0, 1}, Position: valueVector3{X: 1, Y: 2, Z: 3}}), "000000803f0000000000000000000000000000803f0000000000000000000000000000803f7f0000008000000080800000"},
+	{ptr(valueCFrameQuat{Special: 0x02, Position: valueVector3{X: 1, Y: 2, Z: 3}}), "027f0000008000000080800000"},
+	{ptr(valueCFrameQuat{QX: 0, QY: 0, QZ: 0, QW: 1, Position: valueVector3{X: 1, Y: 2, Z: 3}}), "000000000000000000000000000000803f7f0000008000000080800000"},
+	// Big-endian.
+	{ptr(valueToken(0x01020304)), "01020304"},
+	// Zigzag, big-endian.
+	{ptr(valueReference(0x01020304)), "02040608"},
+	{ptr(valueVector3int16{X: 0x0102, Y: -2, Z: 3}), "0201feff0300"},
+	// Length and IEEE 754, little-endian.
+	{ptr(valueNumberSequence{{Time: 0, Value: 1, Envelope: 0}, {Time: 1, Value: 2, Envelope: 0.5}}), "02000000000000000000803f000000000000803f000000400000003f"},
+	{ptr(valueColorSequence{{Time: 0, Value: valueColor3{R: 1, G: 0.5, B: 0}, Envelope: 0}}), "01000000000000000000803f0000003f0000000000000000"},
+	// IEEE 754, little-endian.
+	{ptr(valueNumberRange{Min: 1, Max: 2}), "0000803f00000040"},
+	{ptr(valueRect{Min: valueVector2{X: 1, Y: 2}, Max: valueVector2{X: 3, Y: 4}}), "7f000000800000008080000081000000"},
+	{ptr(valuePhysicalProperties{}), "00"},
+	{ptr(valuePhysicalProperties{CustomPhysics: 1, Density: 1, Friction: 0.5, Elasticity: 2, FrictionWeight: 1, ElasticityWeight: -1}), "010000803f0000003f000000400000803f000080bf"},
+	{ptr(valueColor3uint8{R: 1, G: 2, B: 3}), "010203"},
+	// Zigzag, big-endian.
+	{ptr(valueInt64(0x0102030405060708)), "020406080a0c0e10"},
+	// Big-endian.
+	{ptr(valueSharedString(0x01020304)), "01020304"},
+	// Index and time big-endian, then zigzag random, big-endian.
+	{ptr(valueUniqueId{Random: 0x0102030405060708, Time: 0x11121314, Index: 0x21222324}), "2122232411121314020406080a0c0e10"},
+	// Weight is little-endian.
+	{ptr(valueFont{Family: valueString("ab"), Weight: 0x0190, Style: 1, CachedFaceId: valueString("c")}), "0200000061629001010100000063"},
+	// Big-endian.
+	{ptr(valueSecurityCapabilities(0x0102030405060708)), "0102030405060708"},
+}
+
+func TestValueBytes(t *testing.T) {
+	for _, test := range valueBytesTests {
+		want, err := hex.DecodeString(test.bytes)
+		if err != nil {
+			t.Fatalf("%s: bad test bytes: %s", test.value.Type(), err)
+		}
+		if n := test.value.BytesLen(); n != len(want) {
+			t.Errorf("%s: expected length %d, got %d", test.value.Type(), len(want), n)
+		}
+		if got := test.value.Bytes(nil); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected bytes %x, got %x", test.value.Type(), want, got)
+		}
+
+		v := newValue(test.value.Type())
+		n, err := v.FromBytes(want)
+		if err != nil {
+			t.Errorf("%s: decode error: %s", test.value.Type(), err)
+			continue
+		}
+		if n != len(want) {
+			t.Errorf("%s: expected to read %d bytes, read %d", test.value.Type(), len(want), n)
+		}
+		if !reflect.DeepEqual(v, test.value) {
+			t.Errorf("%s: expected decoded value %#v, got %#v", test.value.Type(), test.value, v)
+		}
+	}
+}
+
+// arrayBytesTests are the exact bytes produced by encoding an array of two
+// values, matching the layout written by Roblox Studio. Each field of an
+// interleaved type is written for every value before the next field, and the
+// bytes of each field are themselves interleaved across values.
+var arrayBytesTests = []struct {
+	array array
+	bytes string
+}{
+	{arrayVector2{{X: 1, Y: 2}, {X: 3, Y: 4}}, "7f800080000000008081000000000000"},
+	{arrayVector3{{X: 1, Y: 2, Z: -1}, {X: 3, Y: 4, Z: 5}}, "7f8000800000000080810000000000007f81004000000100"},
+	{arrayColor3{{R: 1, G: 0.5, B: 0}, {R: 0, G: 0.25, B: 1}}, "7f000000000000007e7d000000000000007f000000000000"},
+	{arrayUDim2{{ScaleX: 1, ScaleY: 2, OffsetX: 0x01020304, OffsetY: -1}, {ScaleX: 0.5, ScaleY: 0, OffsetX: 10, OffsetY: 20}}, "7f7e000000000000800000000000000002000400060008140000000000000128"},
+	{arrayRect{{Min: valueVector2{X: 1, Y: 2}, Max: valueVector2{X: 3, Y: 4}}, {Min: valueVector2{X: -1, Y: -2}, Max: valueVector2{X: -3, Y: -4}}}, "7f7f000000000001808000000000000180808080000000018181000000000001"},
+	// Not interleaved; each value is written in full, little-endian.
+	{arrayVector2int16{{X: 0x0102, Y: -2}, {X: 3, Y: 4}}, "0201feff03000400"},
+	{arrayVector3int16{{X: 0x0102, Y: -2, Z: 3}, {X: -1, Y: 0, Z: 5}}, "0201feff0300ffff00000500"},
+}
+
+func TestArrayBytes(t *testing.T) {
+	for _, test := range arrayBytesTests {
+		want, err := hex.DecodeString(test.bytes)
+		if err != nil {
+			t.Fatalf("%s: bad test bytes: %s", test.array.Type(), err)
+		}
+		got, err := arrayToBytes(nil, test.array)
+		if err != nil {
+			t.Errorf("%s: encode error: %s", test.array.Type(), err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected bytes %x, got %x", test.array.Type(), want, got)
+		}
+
+		a := newArray(test.array.Type(), test.array.Len())
+		if _, err := arrayFromBytes(want, a); err != nil {
+			t.Errorf("%s: decode error: %s", test.array.Type(), err)
+			continue
+		}
+		if !reflect.DeepEqual(a, test.array) {
+			t.Errorf("%s: expected decoded array %#v, got %#v", test.array.Type(), test.array, a)
+		}
+	}
+}
+
+func TestEncodeValue(t *testing.T) {
+	values := []rbxfile.Value{
+		rbxfile.ValueString("a"),
+		rbxfile.ValueBinaryString("\x00\x01"),
+		rbxfile.ValueProtectedString("print()"),
+		rbxfile.ValueContent("rbxassetid://1"),
+		rbxfile.ValueBool(true),
+		rbxfile.ValueInt(-2),
+		rbxfile.ValueFloat(0.5),
+		rbxfile.ValueDouble(1.25),
+		rbxfile.ValueUDim{Scale: 1, Offset: -3},
+		rbxfile.ValueUDim2{X: rbxfile.ValueUDim{Scale: 1, Offset: 2}, Y: rbxfile.ValueUDim{Scale: 3, Offset: 4}},
+		rbxfile.ValueRay{Origin: rbxfile.ValueVector3{X: 1}, Direction: rbxfile.ValueVector3{Z: -1}},
+		rbxfile.ValueFaces{Right: true, Front: true},
+		rbxfile.ValueAxes{Y: true},
+		rbxfile.ValueBrickColor(194),
+		rbxfile.ValueColor3{R: 1, G: 0.5},
+		rbxfile.ValueVector2{X: 1, Y: 2},
+		rbxfile.ValueVector3{X: 1, Y: 2, Z: 3},
+		rbxfile.ValueVector2int16{X: -1, Y: 2},
+		rbxfile.ValueCFrame{Position: rbxfile.ValueVector3{X: 1}, Rotation: [9]float32{1, 0, 0, 0, 1, 0, 0, 0, 1}},
+		rbxfile.ValueCFrame{Rotation: [9]float32{0.5, 0, 0, 0, 1, 0, 0, 0, 2}},
+		rbxfile.ValueToken(3),
+		rbxfile.ValueVector3int16{X: 1, Y: -2, Z: 3},
+		rbxfile.ValueNumberSequence{{Time: 0, Value: 1}, {Time: 1, Value: 2, Envelope: 0.5}},
+		rbxfile.ValueColorSequence{{Time: 0, Value: rbxfile.ValueColor3{R: 1}}, {Time: 1, Value: rbxfile.ValueColor3{B: 1}}},
+		rbxfile.ValueNumberRange{Min: 1, Max: 2},
+		rbxfile.ValueRect{Min: rbxfile.ValueVector2{X: 1, Y: 2}, Max: rbxfile.ValueVector2{X: 3, Y: 4}},
+		rbxfile.ValuePhysicalProperties{CustomPhysics: true, Density: 1, Friction: 0.5, Elasticity: 2, FrictionWeight: 1, ElasticityWeight: 1},
+		rbxfile.ValueColor3uint8{R: 1, G: 2, B: 3},
+		rbxfile.ValueInt64(-1 << 40),
+		rbxfile.ValueUniqueId{Random: -5, Time: 6, Index: 7},
+		rbxfile.ValueFont{Family: rbxfile.ValueContent("rbxasset://fonts/families/Arial.json"), Weight: 700, Style: 1, CachedFaceId: rbxfile.ValueContent("rbxasset://fonts/Arial.ttf")},
+		rbxfile.ValueSecurityCapabilities(0x0102),
+	}
+	for _, v := range values {
+		b, err := EncodeValue(v)
+		if err != nil {
+			t.Errorf("%s: encode error: %s", v.Type(), err)
+			continue
+		}
+		got, n, err := DecodeValue(v.Type(), append(b, 0xFF))
+		if err != nil {
+			t.Errorf("%s: decode error: %s", v.Type(), err)
+			continue
+		}
+		if n != len(b) {
+			t.Errorf("%s: expected to read %d bytes, read %d", v.Type(), len(b), n)
+		}
+		if !reflect.DeepEqual(got, v) {
+			t.Errorf("%s: expected decoded value %#v, got %#v", v.Type(), v, got)
+		}
+	}
+
+	// The representation matches that of the value layer.
+	if b, _ := EncodeValue(rbxfile.ValueVector3{X: 1, Y: 2, Z: -1}); hex.EncodeToString(b) != "7f000000800000007f000001" {
+		t.Errorf("unexpected Vector3 bytes %x", b)
+	}
+
+	for _, v := range []rbxfile.Value{
+		rbxfile.ValueReference{},
+		rbxfile.ValueSharedString("a"),
+		rbxfile.Some(rbxfile.ValueInt(1)),
+		rbxfile.ValueContentObject{},
+	} {
+		if _, err := EncodeValue(v); err == nil {
+			t.Errorf("%s: expected encode error", v.Type())
+		}
+		if _, _, err := DecodeValue(v.Type(), []byte{0, 0, 0, 0}); err == nil {
+			t.Errorf("%s: expected decode error", v.Type())
+		}
+	}
+	if _, _, err := DecodeValue(rbxfile.TypeVector3, []byte{0}); err == nil {
+		t.Errorf("expected error for short data")
+	}
+}
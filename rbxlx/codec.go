@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"sort"
 	"strconv"
 	"strings"
@@ -31,12 +32,166 @@ type robloxCodec struct {
 	// encoding.
 	ExcludeMetadata bool
 
+	// Color3Packed determines how Color3 values are encoded. If true, a value
+	// is encoded as a single integer, with each component packed into a byte.
+	// If false, each component is encoded as a separate float tag.
+	Color3Packed bool
+
 	// DiscardInvalidProperties determines how invalid properties are decoded.
 	// If true, when the parser successfully decodes a property, but fails to
 	// decode its value or a component, then the entire property is discarded.
 	// If false, then as much information as possible is retained; any value or
 	// component that fails will be emitted as the zero value for the type.
 	DiscardInvalidProperties bool
+
+	// MergeDuplicateProperties determines how an Item containing more than
+	// one Properties tag is decoded. If true, then the properties of each tag
+	// are decoded, with later properties overriding earlier ones. If false,
+	// then only the first Properties tag is decoded. A property defined more
+	// than once within the decoded tags always takes its last definition. In
+	// either case, a warning is emitted for each duplicate.
+	MergeDuplicateProperties bool
+
+	// DropUnnamedProperties determines whether properties with an empty name
+	// are dropped. Such properties appear only in hand-crafted or corrupted
+	// files. If false, they are decoded under the empty key of the Properties
+	// of the instance. In either case, a warning is emitted.
+	DropUnnamedProperties bool
+
+	// OnBinaryString, if not nil, is called for each BinaryString property
+	// instead of decoding it into a ValueBinaryString. r streams the
+	// base64-decoded bytes of the property, and is valid only for the duration
+	// of the call. The property is not set on inst.
+	OnBinaryString func(inst *rbxfile.Instance, prop string, r io.Reader)
+
+	// NonFinite determines how Float and Double properties with non-finite
+	// values are handled.
+	NonFinite NonFinite
+
+	// ExternalReferences, if not nil, is used to resolve references that do
+	// not refer to an instance within the decoded document.
+	ExternalReferences rbxfile.References
+
+	// Dropped, if not nil, receives each property that is dropped while
+	// decoding.
+	Dropped *[]rbxfile.DroppedProperty
+
+	// ClassRemap maps the class name of a decoded instance to a new class
+	// name.
+	ClassRemap map[string]string
+
+	// ItemMetadata determines whether comments and unrecognized tags within
+	// an Item tag are decoded into the Metadata of the instance.
+	ItemMetadata bool
+
+	// ReferenceStyle determines how Reference values are encoded and decoded.
+	ReferenceStyle ReferenceStyle
+
+	// PreferCDATA determines whether the content of ProtectedString and
+	// BinaryString values is encoded as a CDATA section. If false, a CDATA
+	// section is used only when necessary.
+	PreferCDATA bool
+}
+
+// finiteValue applies the NonFinite mode of the codec to value, if it is a
+// Float or Double, or a value with float components, such as a Vector3 or
+// NumberSequence. Returns false if the property should be skipped.
+func (c robloxCodec) finiteValue(value rbxfile.Value) (v rbxfile.Value, ok bool) {
+	if c.NonFinite == NonFiniteAllow {
+		return value, true
+	}
+	finite := true
+	fix := func(f, max float64) float64 {
+		if !math.IsNaN(f) && !math.IsInf(f, 0) {
+			return f
+		}
+		finite = false
+		switch {
+		case c.NonFinite != NonFiniteClamp:
+			return 0
+		case math.IsInf(f, 1):
+			return max
+		case math.IsInf(f, -1):
+			return -max
+		default:
+			return 0
+		}
+	}
+	f32 := func(fs ...*float32) {
+		for _, f := range fs {
+			*f = float32(fix(float64(*f), math.MaxFloat32))
+		}
+	}
+
+	switch value := value.(type) {
+	case rbxfile.ValueFloat:
+		f32((*float32)(&value))
+		v = value
+	case rbxfile.ValueDouble:
+		v = rbxfile.ValueDouble(fix(float64(value), math.MaxFloat64))
+	case rbxfile.ValueUDim:
+		f32(&value.Scale)
+		v = value
+	case rbxfile.ValueUDim2:
+		f32(&value.X.Scale, &value.Y.Scale)
+		v = value
+	case rbxfile.ValueRay:
+		f32(&value.Origin.X, &value.Origin.Y, &value.Origin.Z)
+		f32(&value.Direction.X, &value.Direction.Y, &value.Direction.Z)
+		v = value
+	case rbxfile.ValueColor3:
+		f32(&value.R, &value.G, &value.B)
+		v = value
+	case rbxfile.ValueVector2:
+		f32(&value.X, &value.Y)
+		v = value
+	case rbxfile.ValueVector3:
+		f32(&value.X, &value.Y, &value.Z)
+		v = value
+	case rbxfile.ValueCFrame:
+		f32(&value.Position.X, &value.Position.Y, &value.Position.Z)
+		for i := range value.Rotation {
+			f32(&value.Rotation[i])
+		}
+		v = value
+	case rbxfile.ValueNumberSequence:
+		value = value.Copy().(rbxfile.ValueNumberSequence)
+		for i := range value {
+			f32(&value[i].Time, &value[i].Value, &value[i].Envelope)
+		}
+		v = value
+	case rbxfile.ValueColorSequence:
+		value = value.Copy().(rbxfile.ValueColorSequence)
+		for i := range value {
+			f32(&value[i].Time, &value[i].Value.R, &value[i].Value.G, &value[i].Value.B, &value[i].Envelope)
+		}
+		v = value
+	case rbxfile.ValueNumberRange:
+		f32(&value.Min, &value.Max)
+		v = value
+	case rbxfile.ValueRect:
+		f32(&value.Min.X, &value.Min.Y, &value.Max.X, &value.Max.Y)
+		v = value
+	case rbxfile.ValuePhysicalProperties:
+		f32(&value.Density, &value.Friction, &value.Elasticity, &value.FrictionWeight, &value.ElasticityWeight)
+		v = value
+	case rbxfile.ValueOptional:
+		inner := value.Value()
+		if inner == nil {
+			return value, true
+		}
+		if inner, ok = c.finiteValue(inner); !ok {
+			return nil, false
+		}
+		return rbxfile.Some(inner), true
+	}
+	if finite {
+		return value, true
+	}
+	if c.NonFinite == NonFiniteSkip {
+		return nil, false
+	}
+	return v, true
 }
 
 func (c robloxCodec) Decode(document *documentRoot) (root *rbxfile.Root, err error) {
@@ -61,8 +216,34 @@ type rdecoder struct {
 	root       *rbxfile.Root
 	err        error
 	instLookup rbxfile.References
-	propRefs   []rbxfile.PropRef
+	propRefs   []propRef
 	stringRefs []rbxfile.PropRef
+
+	// names interns class and property names, which are otherwise allocated
+	// for each instance.
+	names map[string]string
+
+	// remapped is the set of classes that have been remapped.
+	remapped map[string]bool
+}
+
+// propRef is an unresolved reference along with the position of the tag
+// from which it was decoded.
+type propRef struct {
+	rbxfile.PropRef
+	pos tagPosition
+}
+
+// intern returns the interned string equal to s.
+func (dec *rdecoder) intern(s string) string {
+	if v, ok := dec.names[s]; ok {
+		return v
+	}
+	if dec.names == nil {
+		dec.names = map[string]string{}
+	}
+	dec.names[s] = s
+	return s
 }
 
 func (dec *rdecoder) decode() error {
@@ -75,7 +256,41 @@ func (dec *rdecoder) decode() error {
 
 	dec.root = new(rbxfile.Root)
 	dec.root.Instances, _ = dec.getItems(nil, dec.document.Root.Tags)
+	dec.finish()
+	return nil
+}
+
+// newStreamDecoder returns a decoder for a document whose Item tags are
+// decoded as they are read. The returned function is passed to readFrom to
+// consume each top-level Item tag, decoding it into an instance and passing
+// the instance to fn. Once the document has been read, finish must be called
+// to decode the remaining tags of the document.
+func (c robloxCodec) newStreamDecoder(document *documentRoot, fn func(inst *rbxfile.Instance) error) (dec *rdecoder, onChild func(tag *documentTag) (bool, error)) {
+	dec = &rdecoder{
+		document:   document,
+		codec:      c,
+		root:       new(rbxfile.Root),
+		instLookup: make(rbxfile.References),
+	}
+	onChild = func(tag *documentTag) (bool, error) {
+		if tag.StartName != "Item" {
+			return false, nil
+		}
+		instances, _ := dec.getItems(nil, []*documentTag{tag})
+		for _, inst := range instances {
+			dec.root.Instances = append(dec.root.Instances, inst)
+			if err := fn(inst); err != nil {
+				return true, err
+			}
+		}
+		return true, nil
+	}
+	return dec, onChild
+}
 
+// finish decodes the tags of the document other than Item tags, and resolves
+// the references and shared strings of decoded instances.
+func (dec *rdecoder) finish() {
 	for _, tag := range dec.document.Root.Tags {
 		switch tag.StartName {
 		case "Meta":
@@ -113,27 +328,68 @@ func (dec *rdecoder) decode() error {
 		}
 	}
 
-	for _, propRef := range dec.propRefs {
-		dec.instLookup.Resolve(propRef)
+	for _, ref := range dec.propRefs {
+		propRef := ref.PropRef
+		if dec.instLookup.Resolve(propRef) {
+			continue
+		}
+		if dec.codec.ExternalReferences.Resolve(propRef) {
+			continue
+		}
+		if dec.codec.ReferenceStyle == ReferencePath {
+			if referent := resolvePath(dec.root.Instances, propRef.Reference); referent != nil {
+				propRef.Instance.Properties[propRef.Property] = propRef.Value(referent)
+				continue
+			}
+		}
+		if dec.codec.ExternalReferences != nil {
+			dec.document.Warnings = dec.document.Warnings.Append(fmt.Errorf("%s: property %s.%s has unresolved reference %q", ref.pos, propRef.Instance.ClassName, propRef.Property, propRef.Reference))
+		}
 	}
-
-	return nil
 }
 
 func (dec *rdecoder) getItems(parent *rbxfile.Instance, tags []*documentTag) (instances []*rbxfile.Instance, properties map[string]rbxfile.Value) {
 	properties = make(map[string]rbxfile.Value)
 	hasProps := false
+	seen := map[string]bool{}
+	comments := 0
 
 	for _, tag := range tags {
+		if parent != nil && dec.codec.ItemMetadata {
+			switch {
+			case tag.Comment:
+				dec.setMetadata(parent, MetadataComment+strconv.Itoa(comments), tag.Text)
+				comments++
+				continue
+			case tag.StartName != "Item" && tag.StartName != "Properties":
+				if parent.Metadata != nil {
+					if _, ok := parent.Metadata[tag.StartName]; ok {
+						dec.document.Warnings = dec.document.Warnings.Append(fmt.Errorf("%s: item %s has duplicate tag %s", tag.TagPosition, parent.ClassName, tag.StartName))
+					}
+				}
+				dec.setMetadata(parent, tag.StartName, getContent(tag))
+				continue
+			}
+		}
 		switch tag.StartName {
 		case "Item":
 			className, ok := tag.AttrValue("class")
 			if !ok {
-				dec.document.Warnings = dec.document.Warnings.Append(errors.New("item with missing class attribute"))
+				dec.document.Warnings = dec.document.Warnings.Append(fmt.Errorf("%s: item with missing class attribute", tag.TagPosition))
 				continue
 			}
 
-			instance := rbxfile.NewInstance(className)
+			if to, ok := dec.codec.ClassRemap[className]; ok {
+				if !dec.remapped[className] {
+					if dec.remapped == nil {
+						dec.remapped = map[string]bool{}
+					}
+					dec.remapped[className] = true
+					dec.document.Warnings = dec.document.Warnings.Append(fmt.Errorf("%s: remapped class %s to %s", tag.TagPosition, className, to))
+				}
+				className = to
+			}
+			instance := rbxfile.NewInstance(dec.intern(className))
 			referent, ok := tag.AttrValue("referent")
 			if ok && len(referent) > 0 {
 				instance.Reference = referent
@@ -152,15 +408,34 @@ func (dec *rdecoder) getItems(parent *rbxfile.Instance, tags []*documentTag) (in
 			instances = append(instances, instance)
 
 		case "Properties":
-			if hasProps || parent == nil {
+			if parent == nil {
 				continue
 			}
+			if hasProps {
+				dec.document.Warnings = dec.document.Warnings.Append(fmt.Errorf("%s: item %s has duplicate Properties tag", tag.TagPosition, parent.ClassName))
+				if !dec.codec.MergeDuplicateProperties {
+					continue
+				}
+			}
 			hasProps = true
 
 			for _, property := range tag.Tags {
+				if name, ok := property.AttrValue("name"); ok {
+					if seen[name] {
+						dec.document.Warnings = dec.document.Warnings.Append(fmt.Errorf("%s: item %s has duplicate property %q", property.TagPosition, parent.ClassName, name))
+					}
+					seen[name] = true
+					if name == "" {
+						dec.document.Warnings = dec.document.Warnings.Append(fmt.Errorf("%s: item %s: %w", property.TagPosition, parent.ClassName, rbxfile.ErrEmptyPropertyName))
+						if dec.codec.DropUnnamedProperties {
+							dec.drop(parent, name, "empty name")
+							continue
+						}
+					}
+				}
 				name, value, ok := dec.getProperty(property, parent)
 				if ok {
-					properties[name] = value
+					properties[dec.intern(name)] = value
 				}
 			}
 		}
@@ -169,10 +444,19 @@ func (dec *rdecoder) getItems(parent *rbxfile.Instance, tags []*documentTag) (in
 	return instances, properties
 }
 
+// setMetadata sets key to value in the Metadata of inst.
+func (dec *rdecoder) setMetadata(inst *rbxfile.Instance, key, value string) {
+	if inst.Metadata == nil {
+		inst.Metadata = map[string]string{}
+	}
+	inst.Metadata[key] = value
+}
+
 // DecodeProperties decodes a list of tags as properties to a given instance.
 // Returns a list of unresolved references.
 func (c robloxCodec) DecodeProperties(tags []*documentTag, inst *rbxfile.Instance, refs rbxfile.References) (propRefs []rbxfile.PropRef) {
 	dec := &rdecoder{
+		document:   &documentRoot{},
 		codec:      c,
 		instLookup: refs,
 	}
@@ -184,7 +468,17 @@ func (c robloxCodec) DecodeProperties(tags []*documentTag, inst *rbxfile.Instanc
 		}
 	}
 
-	return dec.propRefs
+	for _, ref := range dec.propRefs {
+		propRefs = append(propRefs, ref.PropRef)
+	}
+	return propRefs
+}
+
+// drop reports a property of instance dropped while decoding.
+func (dec *rdecoder) drop(instance *rbxfile.Instance, name, reason string) {
+	if dec.codec.Dropped != nil {
+		*dec.codec.Dropped = append(*dec.codec.Dropped, rbxfile.DroppedProperty{Class: instance.ClassName, Property: name, Reason: reason})
+	}
 }
 
 func (dec *rdecoder) getProperty(tag *documentTag, instance *rbxfile.Instance) (name string, value rbxfile.Value, ok bool) {
@@ -195,9 +489,15 @@ func (dec *rdecoder) getProperty(tag *documentTag, instance *rbxfile.Instance) (
 
 	// Guess property type from tag name.
 	valueType, optional := dec.codec.GetCanonType(tag.StartName)
+	if valueType == rbxfile.TypeInvalid {
+		dec.drop(instance, name, fmt.Sprintf("unknown type %q", tag.StartName))
+		return "", nil, false
+	}
 	if optional {
+		optTag := tag
 		tag, ok = dec.getOptional(tag, valueType)
 		if !ok {
+			dec.drop(instance, name, fmt.Sprintf("invalid %s value", optTag.StartName))
 			return "", nil, false
 		}
 		if tag == nil {
@@ -205,18 +505,53 @@ func (dec *rdecoder) getProperty(tag *documentTag, instance *rbxfile.Instance) (
 		}
 	}
 
+	if valueType == rbxfile.TypeBinaryString && !optional && dec.codec.OnBinaryString != nil {
+		r := base64.NewDecoder(base64.StdEncoding, strings.NewReader(getContent(tag)))
+		dec.codec.OnBinaryString(instance, name, r)
+		return "", nil, false
+	}
+
+	if valueType == rbxfile.TypeContent && !optional {
+		if subtag := contentObjectTag(tag); subtag != nil {
+			if ref := getContent(subtag); !rbxfile.IsEmptyReference(ref) {
+				dec.propRefs = append(dec.propRefs, propRef{
+					PropRef: rbxfile.PropRef{
+						Instance:      instance,
+						Property:      name,
+						Reference:     ref,
+						ContentObject: true,
+					},
+					pos: tag.TagPosition,
+				})
+				return "", nil, false
+			}
+			return name, rbxfile.ValueContentObject{}, true
+		}
+	}
+
 	value, ok = dec.getValue(tag, valueType)
 	if !ok {
+		dec.drop(instance, name, fmt.Sprintf("invalid %s value", tag.StartName))
+		return "", nil, false
+	}
+	if v, ok := dec.codec.finiteValue(value); ok {
+		value = v
+	} else {
+		dec.document.Warnings = dec.document.Warnings.Append(fmt.Errorf("%s: property %s.%s has non-finite value %s, property skipped", tag.TagPosition, instance.ClassName, name, value))
+		dec.drop(instance, name, fmt.Sprintf("non-finite value %s", value))
 		return "", nil, false
 	}
 
 	switch value := value.(type) {
 	case rbxfile.ValueReference:
 		if ref := getContent(tag); !rbxfile.IsEmptyReference(ref) {
-			dec.propRefs = append(dec.propRefs, rbxfile.PropRef{
-				Instance:  instance,
-				Property:  name,
-				Reference: ref,
+			dec.propRefs = append(dec.propRefs, propRef{
+				PropRef: rbxfile.PropRef{
+					Instance:  instance,
+					Property:  name,
+					Reference: ref,
+				},
+				pos: tag.TagPosition,
 			})
 			return "", nil, false
 		}
@@ -236,6 +571,15 @@ func (dec *rdecoder) getProperty(tag *documentTag, instance *rbxfile.Instance) (
 	return name, value, ok
 }
 
+// contentObjectTag returns the Ref subtag of a Content tag that refers to an
+// instance, or nil if the tag has another form.
+func contentObjectTag(tag *documentTag) *documentTag {
+	if len(tag.Tags) == 0 || tag.Tags[0].StartName != "Ref" {
+		return nil
+	}
+	return tag.Tags[0]
+}
+
 func (dec *rdecoder) getOptional(tag *documentTag, valueType rbxfile.Type) (subtag *documentTag, ok bool) {
 	if len(tag.Tags) == 0 {
 		return nil, true
@@ -264,7 +608,7 @@ func (robloxCodec) GetCanonTag(valueType rbxfile.Type, optional bool) (canonTag
 		canonTag = "CoordinateFrame"
 	case rbxfile.TypeColor3:
 		canonTag = "Color3"
-	case rbxfile.TypeContent:
+	case rbxfile.TypeContent, rbxfile.TypeContentObject:
 		canonTag = "Content"
 	case rbxfile.TypeDouble:
 		canonTag = "double"
@@ -316,6 +660,8 @@ func (robloxCodec) GetCanonTag(valueType rbxfile.Type, optional bool) (canonTag
 		canonTag = "UniqueId"
 	case rbxfile.TypeFont:
 		canonTag = "Font"
+	case rbxfile.TypeSecurityCapabilities:
+		canonTag = "SecurityCapabilities"
 	}
 	if optional {
 		canonTag = "Optional" + canonTag
@@ -323,7 +669,37 @@ func (robloxCodec) GetCanonTag(valueType rbxfile.Type, optional bool) (canonTag
 	return canonTag
 }
 
-// GetCanonType converts a string from a tag name to a rbxfile.Type.
+// typeAliases maps a lowercase tag name to a type, in addition to the tag
+// names known by GetCanonType.
+var typeAliases = map[string]rbxfile.Type{}
+
+// RegisterTypeAlias registers tag as an alternative name for a property tag of
+// type t, allowing properties with tags of the name to be decoded as t. Tag
+// names are case-insensitive. Like the built-in names, the tag may be prefixed
+// with "Optional" to decode an Optional value of t. The encoder continues to
+// use the canonical name of t. Panics if t is TypeInvalid, if tag begins with
+// "Optional", or if tag is a built-in name.
+//
+// RegisterTypeAlias is not safe to call concurrently with decoding, and should
+// be called during initialization.
+func RegisterTypeAlias(tag string, t rbxfile.Type) {
+	if t == rbxfile.TypeInvalid {
+		panic("rbxlx: invalid type")
+	}
+	tag = strings.ToLower(tag)
+	if strings.HasPrefix(tag, "optional") {
+		panic(fmt.Sprintf("rbxlx: tag %s cannot begin with Optional", tag))
+	}
+	if _, ok := typeAliases[tag]; !ok {
+		if canon, _ := (robloxCodec{}).GetCanonType(tag); canon != rbxfile.TypeInvalid {
+			panic(fmt.Sprintf("rbxlx: cannot register built-in tag %s", tag))
+		}
+	}
+	typeAliases[tag] = t
+}
+
+// GetCanonType converts a string from a tag name to a rbxfile.Type. Aliases
+// registered with RegisterTypeAlias are used for names that are not built-in.
 func (robloxCodec) GetCanonType(valueType string) (canonType rbxfile.Type, optional bool) {
 	valueType = strings.ToLower(valueType)
 	if strings.HasPrefix(valueType, "optional") {
@@ -395,6 +771,10 @@ func (robloxCodec) GetCanonType(valueType string) (canonType rbxfile.Type, optio
 		canonType = rbxfile.TypeUniqueId
 	case "font":
 		canonType = rbxfile.TypeFont
+	case "securitycapabilities":
+		canonType = rbxfile.TypeSecurityCapabilities
+	default:
+		canonType = typeAliases[valueType]
 	}
 	return canonType, optional
 }
@@ -446,8 +826,13 @@ func (dec *rdecoder) getValue(tag *documentTag, valueType rbxfile.Type) (value r
 		return rbxfile.ValueBool(false), true
 
 	case rbxfile.TypeBrickColor:
-		v, err := strconv.ParseUint(getContent(tag), 10, 32)
+		content := getContent(tag)
+		v, err := strconv.ParseUint(content, 10, 32)
 		if err != nil && !errors.Is(err, strconv.ErrRange) {
+			// Some files store the name of the color instead of the index.
+			if v, ok := rbxfile.BrickColorFromName(content); ok {
+				return v, true
+			}
 			if dec.codec.DiscardInvalidProperties {
 				return nil, false
 			}
@@ -521,7 +906,7 @@ func (dec *rdecoder) getValue(tag *documentTag, valueType rbxfile.Type) (value r
 		for _, subtag := range tag.Tags {
 			switch subtag.StartName {
 			case "binary":
-				dec.document.Warnings = dec.document.Warnings.Append(errors.New("not reading binary data"))
+				dec.document.Warnings = dec.document.Warnings.Append(fmt.Errorf("%s: not reading binary data", subtag.TagPosition))
 				return rbxfile.ValueContent(nil), true
 			case "hash":
 				// Ignored.
@@ -974,6 +1359,16 @@ func (dec *rdecoder) getValue(tag *documentTag, valueType rbxfile.Type) (value r
 			return nil, false
 		}
 		return rbxfile.ValueFont{}, true
+
+	case rbxfile.TypeSecurityCapabilities:
+		v, err := strconv.ParseUint(getContent(tag), 10, 64)
+		if err != nil && !errors.Is(err, strconv.ErrRange) {
+			if dec.codec.DiscardInvalidProperties {
+				return nil, false
+			}
+			return rbxfile.ValueSecurityCapabilities(0), true
+		}
+		return rbxfile.ValueSecurityCapabilities(v), true
 	}
 	return nil, false
 }
@@ -1080,15 +1475,90 @@ func getContent(tag *documentTag) string {
 	return tag.Text
 }
 
+// instanceName returns the Name property of inst.
+func instanceName(inst *rbxfile.Instance) string {
+	name, _ := inst.Properties["Name"].(rbxfile.ValueString)
+	return string(name)
+}
+
+// resolvePath returns the instance identified by path, being the names of the
+// instance and its ancestors starting from a top-level instance in insts,
+// separated by ".". Returns nil if path does not identify exactly one
+// instance.
+func resolvePath(insts []*rbxfile.Instance, path string) (inst *rbxfile.Instance) {
+	if path == "" {
+		return nil
+	}
+	for _, name := range strings.Split(path, ".") {
+		var match *rbxfile.Instance
+		for _, child := range insts {
+			if instanceName(child) != name {
+				continue
+			}
+			if match != nil {
+				return nil
+			}
+			match = child
+		}
+		if match == nil {
+			return nil
+		}
+		inst = match
+		insts = match.Children
+	}
+	return inst
+}
+
 type rencoder struct {
 	root          *rbxfile.Root
 	codec         robloxCodec
 	document      *documentRoot
 	refs          rbxfile.References
+	parents       map[*rbxfile.Instance]*rbxfile.Instance
+	referents     map[string]bool
 	sharedStrings map[string][]byte
 	err           error
 }
 
+// referencePath returns the path of inst, as resolved by resolvePath. Returns
+// false if inst is not within the tree, or if the path does not identify inst
+// unambiguously, including when the path is the referent of an instance.
+func (enc *rencoder) referencePath(inst *rbxfile.Instance) (path string, ok bool) {
+	if enc.parents == nil {
+		enc.parents = map[*rbxfile.Instance]*rbxfile.Instance{}
+		enc.referents = map[string]bool{}
+		var walk func(parent *rbxfile.Instance, insts []*rbxfile.Instance)
+		walk = func(parent *rbxfile.Instance, insts []*rbxfile.Instance) {
+			for _, child := range insts {
+				enc.parents[child] = parent
+				enc.referents[child.Reference] = true
+				walk(child, child.Children)
+			}
+		}
+		walk(nil, enc.root.Instances)
+	}
+
+	var names []string
+	for i := inst; i != nil; i = enc.parents[i] {
+		if _, ok := enc.parents[i]; !ok {
+			return "", false
+		}
+		name := instanceName(i)
+		if name == "" || strings.Contains(name, ".") {
+			return "", false
+		}
+		names = append(names, name)
+	}
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+	path = strings.Join(names, ".")
+	if rbxfile.IsEmptyReference(path) || enc.referents[path] || resolvePath(enc.root.Instances, path) != inst {
+		return "", false
+	}
+	return path, true
+}
+
 func (c robloxCodec) Encode(root *rbxfile.Root) (document *documentRoot, err error) {
 	enc := &rencoder{
 		root:          root,
@@ -1213,30 +1683,90 @@ func (enc *rencoder) encodeInstance(instance *rbxfile.Instance, parent *document
 		item.SetAttrValue("referent", "")
 	}
 	parent.Tags = append(parent.Tags, item)
+	if !enc.codec.ExcludeMetadata {
+		item.Tags = append(item.Tags, enc.encodeMetadata(instance)...)
+	}
 
 	for _, child := range instance.Children {
 		enc.encodeInstance(child, item)
 	}
 }
 
+// encodeMetadata returns the tags that encode the Metadata of instance.
+// Comments are ordered by index, followed by other tags ordered by name.
+func (enc *rencoder) encodeMetadata(instance *rbxfile.Instance) (tags []*documentTag) {
+	if len(instance.Metadata) == 0 {
+		return nil
+	}
+	type comment struct {
+		index int
+		text  string
+	}
+	var comments []comment
+	keys := make([]string, 0, len(instance.Metadata))
+	for key, value := range instance.Metadata {
+		if strings.HasPrefix(key, MetadataComment) {
+			i, err := strconv.Atoi(key[len(MetadataComment):])
+			if err == nil && !strings.Contains(value, "-->") {
+				comments = append(comments, comment{index: i, text: value})
+				continue
+			}
+		} else if isMetadataName(key) {
+			keys = append(keys, key)
+			continue
+		}
+		if enc.document != nil {
+			enc.document.Warnings = enc.document.Warnings.Append(fmt.Errorf("instance %s has invalid metadata key %q, metadata skipped", instance.ClassName, key))
+		}
+	}
+	sort.Slice(comments, func(i, j int) bool { return comments[i].index < comments[j].index })
+	sort.Strings(keys)
+	for _, c := range comments {
+		tags = append(tags, &documentTag{Comment: true, Text: c.text})
+	}
+	for _, key := range keys {
+		tags = append(tags, &documentTag{StartName: key, Text: instance.Metadata[key]})
+	}
+	return tags
+}
+
+// isMetadataName returns whether name can be encoded as the name of a metadata
+// tag.
+func isMetadataName(name string) bool {
+	if name == "" || name == "Item" || name == "Properties" {
+		return false
+	}
+	for _, c := range []byte(name) {
+		if !isNameByte(c, nameTag) {
+			return false
+		}
+	}
+	return true
+}
+
 func (c robloxCodec) EncodeProperties(instance *rbxfile.Instance) (properties []*documentTag) {
 	enc := &rencoder{codec: c}
 	return enc.encodeProperties(instance)
 }
 
 func (enc *rencoder) encodeProperties(instance *rbxfile.Instance) (properties []*documentTag) {
-	// Sort properties by name
-	sorted := make([]string, 0, len(instance.Properties))
-	for name := range instance.Properties {
-		sorted = append(sorted, name)
-	}
-	sort.Strings(sorted)
-
-	for _, name := range sorted {
-		value := instance.Properties[name]
+	for _, prop := range instance.SortedProperties() {
+		value := prop.Value
+		if w, ok := value.(interface{ Unwrap() rbxfile.Value }); ok {
+			// A wrapped value, such as rbxl.RawValue, is encoded as the value
+			// it wraps.
+			value = w.Unwrap()
+		}
+		value, ok := enc.codec.finiteValue(value)
+		if !ok {
+			if enc.document != nil {
+				enc.document.Warnings = enc.document.Warnings.Append(fmt.Errorf("property %s.%s has non-finite value %s, property skipped", instance.ClassName, prop.Name, prop.Value))
+			}
+			continue
+		}
 		tag := enc.encodeProperty(value)
 		if tag != nil {
-			tag.Attr = []documentAttr{{Name: "name", Value: name}}
+			tag.Attr = []documentAttr{{Name: "name", Value: prop.Name}}
 			properties = append(properties, tag)
 		}
 	}
@@ -1274,7 +1804,7 @@ func (enc *rencoder) encodeProperty(value rbxfile.Value) *documentTag {
 			StartName: "BinaryString",
 			NoIndent:  true,
 		}
-		encodeContent(tag, buf.String())
+		encodeContent(tag, buf.String(), enc.codec.PreferCDATA)
 		return tag
 
 	case rbxfile.ValueBool:
@@ -1317,6 +1847,16 @@ func (enc *rencoder) encodeProperty(value rbxfile.Value) *documentTag {
 		}
 
 	case rbxfile.ValueColor3:
+		if enc.codec.Color3Packed {
+			return &documentTag{
+				StartName: "Color3",
+				NoIndent:  true,
+				Text: strconv.FormatUint(0xFF<<24|
+					uint64(packColorComponent(value.R))<<16|
+					uint64(packColorComponent(value.G))<<8|
+					uint64(packColorComponent(value.B)), 10),
+			}
+		}
 		return &documentTag{
 			StartName: "Color3",
 			Tags: []*documentTag{
@@ -1389,7 +1929,7 @@ func (enc *rencoder) encodeProperty(value rbxfile.Value) *documentTag {
 			StartName: "ProtectedString",
 			NoIndent:  true,
 		}
-		encodeContent(tag, string(value))
+		encodeContent(tag, string(value), enc.codec.PreferCDATA)
 		return tag
 
 	case rbxfile.ValueRay:
@@ -1415,6 +1955,13 @@ func (enc *rencoder) encodeProperty(value rbxfile.Value) *documentTag {
 			},
 		}
 
+	case rbxfile.ValueContentObject:
+		return &documentTag{
+			StartName: "Content",
+			NoIndent:  true,
+			Tags:      []*documentTag{enc.encodeProperty(rbxfile.ValueReference(value))},
+		}
+
 	case rbxfile.ValueReference:
 		tag := &documentTag{
 			StartName: "Ref",
@@ -1422,11 +1969,20 @@ func (enc *rencoder) encodeProperty(value rbxfile.Value) *documentTag {
 		}
 
 		referent := value.Instance
-		if referent != nil {
-			tag.Text = enc.refs.Get(referent)
-		} else {
+		if referent == nil {
 			tag.Text = "null"
+			return tag
+		}
+		if enc.codec.ReferenceStyle == ReferencePath {
+			if path, ok := enc.referencePath(referent); ok {
+				tag.Text = path
+				return tag
+			}
+			if enc.document != nil {
+				enc.document.Warnings = enc.document.Warnings.Append(fmt.Errorf("reference to %s %q cannot be encoded as an unambiguous path, encoded as referent", referent.ClassName, instanceName(referent)))
+			}
 		}
+		tag.Text = enc.refs.Get(referent)
 		return tag
 
 	case rbxfile.ValueString:
@@ -1622,7 +2178,7 @@ func (enc *rencoder) encodeProperty(value rbxfile.Value) *documentTag {
 			StartName: "SharedString",
 			NoIndent:  true,
 		}
-		encodeContent(tag, buf.String())
+		encodeContent(tag, buf.String(), false)
 		return tag
 
 	case rbxfile.ValueOptional:
@@ -1667,6 +2223,13 @@ func (enc *rencoder) encodeProperty(value rbxfile.Value) *documentTag {
 			parent.Tags = append(parent.Tags, cachedFaceId)
 		}
 		return parent
+
+	case rbxfile.ValueSecurityCapabilities:
+		return &documentTag{
+			StartName: "SecurityCapabilities",
+			NoIndent:  true,
+			Text:      strconv.FormatUint(uint64(value), 10),
+		}
 	}
 
 	return nil
@@ -1706,6 +2269,18 @@ func (l *lineSplit) Write(p []byte) (n int, err error) {
 	return
 }
 
+// packColorComponent converts a color component from the range [0, 1] to a
+// byte, clamping out-of-range values.
+func packColorComponent(c float32) uint8 {
+	switch {
+	case !(c > 0):
+		return 0
+	case c >= 1:
+		return 255
+	}
+	return uint8(c*255 + 0.5)
+}
+
 func encodeFloat(f float32) string {
 	return fixFloatExp(strconv.FormatFloat(float64(f), 'g', 9, 32), 3)
 }
@@ -1729,8 +2304,20 @@ func encodeDouble(f float64) string {
 	return strconv.FormatFloat(f, 'g', 9, 64)
 }
 
-func encodeContent(tag *documentTag, text string) {
-	if strings.Index(text, "]]>") >= 0 {
+// encodeContent sets the content of tag to text. If cdata is true, then text
+// is encoded as a CDATA section, unless it contains the end of a CDATA
+// section, in which case it is encoded as text.
+func encodeContent(tag *documentTag, text string, cdata bool) {
+	hasEnd := strings.Index(text, "]]>") >= 0
+	if cdata {
+		if hasEnd {
+			tag.Text = text
+		} else {
+			tag.CData = []byte(text)
+		}
+		return
+	}
+	if hasEnd {
 		tag.CData = []byte(text)
 		return
 	}
@@ -67,6 +67,23 @@ type documentTag struct {
 
 	// Tags is a list of child tags within the tag.
 	Tags []*documentTag
+
+	// TagPosition is the location of the start of the tag within the source
+	// document. Set only when decoding.
+	TagPosition tagPosition
+}
+
+// tagPosition is a location within a document.
+type tagPosition struct {
+	// Line is the line number, starting at 1. Zero indicates an unknown
+	// position.
+	Line int
+	// Offset is the byte offset from the start of the document.
+	Offset int64
+}
+
+func (p tagPosition) String() string {
+	return "line " + strconv.Itoa(p.Line) + " (offset " + strconv.FormatInt(p.Offset, 10) + ")"
 }
 
 // AttrValue returns the value of the first attribute of the given name, and
@@ -234,6 +251,10 @@ type decoder struct {
 	n        int64
 	err      error
 	line     int
+
+	// onChild, if not nil, is called with each complete child tag of the
+	// root tag. If it returns true, then the tag is not retained by the root.
+	onChild func(tag *documentTag) (consumed bool, err error)
 }
 
 // Creates a SyntaxError with the current line number.
@@ -455,6 +476,7 @@ func (d *decoder) decodeTag(root bool) (tag *documentTag, err error) {
 		}
 	}
 
+	tag.TagPosition = tagPosition{Line: d.line, Offset: d.n - int64(len(d.nextByte))}
 	startTagState := d.decodeStartTag(tag)
 	if startTagState < 0 {
 		return nil, d.err
@@ -579,9 +601,20 @@ func (d *decoder) decodeTag(root bool) (tag *documentTag, err error) {
 		if err != nil {
 			return nil, err
 		}
-		if subtag != nil {
-			tag.Tags = append(tag.Tags, subtag)
+		if subtag == nil {
+			continue
 		}
+		if root && d.onChild != nil {
+			consumed, err := d.onChild(subtag)
+			if err != nil {
+				d.err = err
+				return nil, err
+			}
+			if consumed {
+				continue
+			}
+		}
+		tag.Tags = append(tag.Tags, subtag)
 	}
 	if len(tag.Tags) > 0 {
 		nocontent = false
@@ -940,6 +973,13 @@ func isNameByte(c byte, t int) bool {
 
 // ReadFrom decode data from r into the Document.
 func (doc *documentRoot) ReadFrom(r io.Reader) (n int64, err error) {
+	return doc.readFrom(r, nil)
+}
+
+// readFrom implements ReadFrom. If onChild is not nil, then it is called with
+// each child tag of the root tag as soon as the child has been decoded. A
+// child consumed by onChild is not retained by the root tag.
+func (doc *documentRoot) readFrom(r io.Reader, onChild func(tag *documentTag) (consumed bool, err error)) (n int64, err error) {
 	if r == nil {
 		return 0, fmt.Errorf("reader is nil")
 	}
@@ -952,6 +992,7 @@ func (doc *documentRoot) ReadFrom(r io.Reader) (n int64, err error) {
 		doc:      doc,
 		nextByte: make([]byte, 0, 9),
 		line:     1,
+		onChild:  onChild,
 	}
 	if rb, ok := r.(io.ByteReader); ok {
 		d.r = rb
@@ -1030,7 +1071,8 @@ func (e *encoder) encodeTag(tag *documentTag, noTags bool, noindent bool) int {
 		if !e.writeString("<!--") {
 			return -1
 		}
-		if !e.encodeText(tag) {
+		// The content of a comment is not escaped.
+		if !e.writeString(tag.Text) {
 			return -1
 		}
 		if !e.writeString("-->") {
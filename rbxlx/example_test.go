@@ -0,0 +1,31 @@
+package rbxlx_test
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/robloxapi/rbxfile/rbxlx"
+)
+
+func ExampleDecoder_Decode_brickColorName() {
+	const file = `<roblox version="4">
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<BrickColor name="BrickColor">Bright red</BrickColor>
+			<BrickColor name="Color">194</BrickColor>
+		</Properties>
+	</Item>
+</roblox>`
+
+	root, _, err := rbxlx.Decoder{}.Decode(strings.NewReader(file))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, prop := range root.Instances[0].SortedProperties() {
+		fmt.Printf("%s = %s\n", prop.Name, prop.Value)
+	}
+	// Output:
+	// BrickColor = 21
+	// Color = 194
+}
@@ -1,12 +1,53 @@
 package rbxlx
 
 import (
+	"errors"
 	"fmt"
 	"io"
 
 	"github.com/robloxapi/rbxfile"
 )
 
+func init() {
+	rbxfile.RegisterFormatEncoder("rbxlx", func(w io.Writer, root *rbxfile.Root) (warn, err error) {
+		return Encoder{}.Encode(w, root)
+	})
+	rbxfile.RegisterFormatDecoder("rbxlx", func(r io.Reader) (root *rbxfile.Root, warn, err error) {
+		return Decoder{}.Decode(r)
+	})
+}
+
+// NonFinite specifies how float values that are not finite, being NaN or an
+// infinity, are handled. This applies to Float and Double values, and to the
+// float components of other values, such as Vector3 and NumberSequence. Such
+// values are written by strconv in forms that Roblox may not read back.
+type NonFinite uint8
+
+const (
+	NonFiniteAllow NonFinite = iota // Values are encoded and decoded as-is.
+	NonFiniteZero                   // Values are replaced with zero.
+	NonFiniteClamp                  // Infinities are clamped to the largest finite value of the type, and NaN is replaced with zero.
+	NonFiniteSkip                   // The property is skipped if any component is not finite, and a warning is emitted.
+)
+
+// ReferenceStyle specifies how Reference values are encoded and decoded.
+type ReferenceStyle uint8
+
+const (
+	// ReferenceGUID encodes a reference as the referent string of the target
+	// instance. This is the form written by Roblox.
+	ReferenceGUID ReferenceStyle = iota
+	// ReferencePath encodes a reference as the path of the target instance,
+	// being the names of the target and its ancestors, separated by ".". A
+	// reference whose path cannot identify the target unambiguously is
+	// encoded as a referent string instead.
+	ReferencePath
+)
+
+// MetadataComment is the prefix of each key in the Metadata of an instance that
+// holds the content of a comment. See Decoder.ItemMetadata.
+const MetadataComment = "#comment"
+
 // Decoder decodes a stream of bytes into a rbxfile.Root according to the rbxlx
 // format.
 type Decoder struct {
@@ -16,6 +57,95 @@ type Decoder struct {
 	// If false, then as much information as possible is retained; any value or
 	// component that fails will be emitted as the zero value for the type.
 	DiscardInvalidProperties bool
+
+	// MergeDuplicateProperties determines how an Item containing more than
+	// one Properties tag is decoded. If true, then the properties of each tag
+	// are decoded, with later properties overriding earlier ones. If false,
+	// then only the first Properties tag is decoded. A property defined more
+	// than once within the decoded tags always takes its last definition. In
+	// either case, a warning is emitted for each duplicate.
+	MergeDuplicateProperties bool
+
+	// DropUnnamedProperties determines whether properties with an empty name
+	// are dropped. Such properties appear only in hand-crafted or corrupted
+	// files. If false, they are decoded under the empty key of the Properties
+	// of the instance. In either case, a warning wrapping
+	// rbxfile.ErrEmptyPropertyName is emitted.
+	DropUnnamedProperties bool
+
+	// OnBinaryString, if not nil, is called for each BinaryString property
+	// instead of decoding it into a ValueBinaryString. r streams the
+	// base64-decoded bytes of the property, and is valid only for the duration
+	// of the call. The property is not set on inst.
+	//
+	// The base64 text of the property is read into the document in full
+	// before the call, so the text itself is not streamed. This avoids
+	// retaining the decoded bytes as a value, but does not avoid holding the
+	// text. With DecodeStream, only the text of the current top-level Item is
+	// held at once.
+	OnBinaryString func(inst *rbxfile.Instance, prop string, r io.Reader)
+
+	// NonFinite determines how properties with non-finite values are decoded,
+	// including values with non-finite components.
+	NonFinite NonFinite
+
+	// PropertyFilter, if not nil, is called for each property of a decoded
+	// instance. The returned value replaces the property. If false is
+	// returned, then the property is removed.
+	PropertyFilter func(class, prop string, v rbxfile.Value) (rbxfile.Value, bool)
+
+	// ReferenceStyle determines how Reference values are decoded. With
+	// ReferencePath, a reference that does not match the referent of an
+	// instance is resolved as a path, as written by an Encoder with
+	// ReferencePath, if the path identifies exactly one instance within the
+	// document. Paths are resolved after ExternalReferences. Defaults to
+	// ReferenceGUID, where only referents are resolved.
+	ReferenceStyle ReferenceStyle
+
+	// ExternalReferences, if not nil, is a lookup of instances outside of the
+	// decoded document, such as those of a previously decoded file. After
+	// references are resolved against the instances of the document, each
+	// remaining reference is resolved against ExternalReferences, and then as
+	// a path if ReferenceStyle is ReferencePath. A reference that still cannot
+	// be resolved is set to nil, and a warning is emitted.
+	//
+	// ExternalReferences is not modified by the decoder.
+	ExternalReferences rbxfile.References
+
+	// Dropped, if not nil, receives each property that is dropped while
+	// decoding, such as a property of an unknown type, or, when
+	// DiscardInvalidProperties is true, a property with an invalid value.
+	Dropped *[]rbxfile.DroppedProperty
+
+	// ClassRemap, if not nil, maps the class name of a decoded instance to a
+	// new class name, such as to replace a deprecated class. Properties are
+	// left as-is, and PropertyFilter receives the new class name. A warning
+	// is emitted once for each remapped class.
+	ClassRemap map[string]string
+
+	// ItemMetadata determines whether comments and unrecognized tags within
+	// an Item tag are retained in the Metadata of the decoded instance. An
+	// unrecognized tag is stored under its name, mapped to its content. The
+	// content of each comment is stored under MetadataComment followed by the
+	// index of the comment within the Item, such as "#comment0". If false,
+	// such content is discarded.
+	ItemMetadata bool
+}
+
+// codec returns a codec configured by the decoder.
+func (d Decoder) codec() robloxCodec {
+	return robloxCodec{
+		DiscardInvalidProperties: d.DiscardInvalidProperties,
+		MergeDuplicateProperties: d.MergeDuplicateProperties,
+		DropUnnamedProperties:    d.DropUnnamedProperties,
+		OnBinaryString:           d.OnBinaryString,
+		NonFinite:                d.NonFinite,
+		ReferenceStyle:           d.ReferenceStyle,
+		ExternalReferences:       d.ExternalReferences,
+		Dropped:                  d.Dropped,
+		ClassRemap:               d.ClassRemap,
+		ItemMetadata:             d.ItemMetadata,
+	}
 }
 
 // Decode reads data from r and decodes it into root.
@@ -24,16 +154,85 @@ func (d Decoder) Decode(r io.Reader) (root *rbxfile.Root, warn, err error) {
 	if _, err = document.ReadFrom(r); err != nil {
 		return nil, document.Warnings.Return(), fmt.Errorf("error parsing document: %w", err)
 	}
-	codec := robloxCodec{
-		DiscardInvalidProperties: d.DiscardInvalidProperties,
-	}
-	root, err = codec.Decode(document)
+	root, err = d.codec().Decode(document)
 	if err != nil {
 		return nil, document.Warnings.Return(), fmt.Errorf("error decoding data: %w", err)
 	}
+	if d.PropertyFilter != nil {
+		rbxfile.WalkValues(root, func(inst *rbxfile.Instance, prop string, v rbxfile.Value) (rbxfile.Value, bool) {
+			return d.PropertyFilter(inst.ClassName, prop, v)
+		})
+	}
 	return root, document.Warnings.Return(), nil
 }
 
+// DecodeGzip is like Decode, but the data is first decompressed if it begins
+// with the gzip signature, as with files stored with a .rbxlx.gz extension.
+// Data without the signature is decoded as-is.
+func (d Decoder) DecodeGzip(r io.Reader) (root *rbxfile.Root, warn, err error) {
+	return rbxfile.DecodeGzip(r, d.Decode)
+}
+
+// DecodeStream reads data from r like Decode, but without retaining the
+// entire document. Each top-level instance is passed to fn, along with its
+// descendants, as soon as the closing tag of its Item has been read, after
+// which the tags of the Item are discarded. If fn returns an error, then
+// decoding stops, and the error is returned. Returns the metadata of the
+// document.
+//
+// Reference and SharedString properties may refer to content that appears
+// later in the document, so they are absent from the instances passed to fn.
+// Once the entire document has been read, these properties are resolved and
+// set on the previously passed instances. The decoder retains each instance
+// until then.
+//
+// Memory use is lower than that of Decode, which holds the tags of the entire
+// document at once.
+func (d Decoder) DecodeStream(r io.Reader, fn func(inst *rbxfile.Instance) error) (metadata map[string]string, warn, err error) {
+	if fn == nil {
+		return nil, nil, errors.New("nil function")
+	}
+	filter := func(inst *rbxfile.Instance) {}
+	if d.PropertyFilter != nil {
+		filter = func(inst *rbxfile.Instance) {
+			rbxfile.WalkValues(&rbxfile.Root{Instances: []*rbxfile.Instance{inst}}, func(inst *rbxfile.Instance, prop string, v rbxfile.Value) (rbxfile.Value, bool) {
+				return d.PropertyFilter(inst.ClassName, prop, v)
+			})
+		}
+	}
+	document := new(documentRoot)
+	dec, onChild := d.codec().newStreamDecoder(document, func(inst *rbxfile.Instance) error {
+		filter(inst)
+		return fn(inst)
+	})
+	if _, err = document.readFrom(r, onChild); err != nil {
+		return nil, document.Warnings.Return(), fmt.Errorf("error parsing document: %w", err)
+	}
+	if document.Root == nil {
+		return nil, document.Warnings.Return(), errors.New("error decoding data: no root tag")
+	}
+	dec.finish()
+	if d.PropertyFilter != nil {
+		// Filter the properties resolved by finish.
+		refs := dec.stringRefs
+		for _, ref := range dec.propRefs {
+			refs = append(refs, ref.PropRef)
+		}
+		for _, ref := range refs {
+			v, ok := ref.Instance.Properties[ref.Property]
+			if !ok {
+				continue
+			}
+			if v, ok = d.PropertyFilter(ref.Instance.ClassName, ref.Property, v); ok {
+				ref.Instance.Properties[ref.Property] = v
+			} else {
+				delete(ref.Instance.Properties, ref.Property)
+			}
+		}
+	}
+	return dec.root.Metadata, document.Warnings.Return(), nil
+}
+
 // Encoder encodes a rbxfile.Root into a stream of bytes according to the rbxlx
 // format.
 type Encoder struct {
@@ -46,9 +245,33 @@ type Encoder struct {
 	ExcludeExternal bool
 
 	// ExcludeMetadata determines whether <Meta> tags should be excluded while
-	// encoding.
+	// encoding. If true, the Metadata of each instance, which is otherwise
+	// encoded as described by Decoder.ItemMetadata, is also excluded.
 	ExcludeMetadata bool
 
+	// Color3Packed determines how Color3 values are encoded. If true, a value
+	// is encoded as a single integer, with each component packed into a byte.
+	// If false, each component is encoded as a separate float tag.
+	Color3Packed bool
+
+	// NonFinite determines how properties with non-finite values are encoded,
+	// including values with non-finite components.
+	NonFinite NonFinite
+
+	// ReferenceStyle determines how Reference values are encoded. Defaults to
+	// ReferenceGUID. Roblox does not read references encoded with
+	// ReferencePath; such files can be read only by decoders that resolve
+	// paths, such as Decoder with ReferencePath. A warning is emitted for each
+	// reference that falls back to a referent string.
+	ReferenceStyle ReferenceStyle
+
+	// PreferCDATA determines whether the content of ProtectedString and
+	// BinaryString values is always encoded as a CDATA section, as Studio does
+	// with script source. This avoids escaping characters such as "<" and "&".
+	// Content that contains the end of a CDATA section ("]]>") is encoded as
+	// escaped text instead.
+	PreferCDATA bool
+
 	// Prefix is a string that appears at the start of each line in the
 	// document. The prefix is added after each newline. Newlines are added
 	// automatically when either Prefix or Indent is not empty.
@@ -70,6 +293,11 @@ type Encoder struct {
 	// ExcludeRoot determines whether the root tag should be excluded when
 	// encoding. This can be combined with Prefix to write documents in-line.
 	ExcludeRoot bool
+
+	// Minify determines whether the document is written without any
+	// whitespace between tags. If true, Prefix and Indent are ignored, and the
+	// document is written on a single line.
+	Minify bool
 }
 
 // Encode formats root, writing the result to w.
@@ -78,21 +306,40 @@ func (e Encoder) Encode(w io.Writer, root *rbxfile.Root) (warn, err error) {
 		ExcludeReferent: e.ExcludeReferent,
 		ExcludeExternal: e.ExcludeExternal,
 		ExcludeMetadata: e.ExcludeMetadata,
+		Color3Packed:    e.Color3Packed,
+		NonFinite:       e.NonFinite,
+		PreferCDATA:     e.PreferCDATA,
+		ReferenceStyle:  e.ReferenceStyle,
 	}
 	document, err := codec.Encode(root)
 	if err != nil {
 		return document.Warnings.Return(), fmt.Errorf("error encoding data: %w", err)
 	}
-	document.Prefix = e.Prefix
-	if e.Indent == "" && !e.NoDefaultIndent {
+	switch {
+	case e.Minify:
+		document.Prefix = ""
+		document.Indent = ""
+	case e.Indent == "" && !e.NoDefaultIndent:
+		document.Prefix = e.Prefix
 		document.Indent = "\t"
-	} else {
+	default:
+		document.Prefix = e.Prefix
 		document.Indent = e.Indent
 	}
 	document.Suffix = e.Suffix
 	document.ExcludeRoot = e.ExcludeRoot
-	if _, err = document.WriteTo(w); err != nil {
+	// Writing the document resets its warnings; retain those of the codec.
+	codecWarnings := document.Warnings
+	_, err = document.WriteTo(w)
+	document.Warnings = append(codecWarnings, document.Warnings...)
+	if err != nil {
 		return document.Warnings.Return(), fmt.Errorf("error encoding format: %w", err)
 	}
 	return document.Warnings.Return(), nil
 }
+
+// EncodeGzip is like Encode, but the output is compressed with gzip. The
+// result can be decoded with Decoder.DecodeGzip.
+func (e Encoder) EncodeGzip(w io.Writer, root *rbxfile.Root) (warn, err error) {
+	return rbxfile.EncodeGzip(w, root, e.Encode)
+}
@@ -0,0 +1,1081 @@
+package rbxlx
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/robloxapi/rbxfile"
+	rbxerrors "github.com/robloxapi/rbxfile/errors"
+)
+
+func TestDecoderDropped(t *testing.T) {
+	const file = `<roblox version="4">
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<Vector3 name="Size"><X>1</X><Y>2</Y></Vector3>
+			<Unknown name="Foo">bar</Unknown>
+			<string name="Name">Part</string>
+		</Properties>
+	</Item>
+</roblox>`
+
+	var dropped []rbxfile.DroppedProperty
+	root, _, err := Decoder{DiscardInvalidProperties: true, Dropped: &dropped}.Decode(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if n := len(root.Instances[0].Properties); n != 1 {
+		t.Errorf("expected 1 property, got %d", n)
+	}
+	want := []rbxfile.DroppedProperty{
+		{Class: "Part", Property: "Size", Reason: `invalid Vector3 value`},
+		{Class: "Part", Property: "Foo", Reason: `unknown type "Unknown"`},
+	}
+	if !reflect.DeepEqual(dropped, want) {
+		t.Errorf("expected dropped properties %v, got %v", want, dropped)
+	}
+}
+
+// newBenchmarkFile returns a document containing n parts.
+func newBenchmarkFile(b *testing.B, n int) string {
+	root := &rbxfile.Root{}
+	for i := 0; i < n; i++ {
+		part := rbxfile.NewInstance("Part")
+		part.Properties["Name"] = rbxfile.ValueString("Part")
+		part.Properties["Anchored"] = rbxfile.ValueBool(true)
+		part.Properties["Size"] = rbxfile.ValueVector3{X: 1, Y: 2, Z: 3}
+		part.Properties["Transparency"] = rbxfile.ValueFloat(0.5)
+		root.Instances = append(root.Instances, part)
+	}
+	var buf strings.Builder
+	if _, err := (Encoder{}).Encode(&buf, root); err != nil {
+		b.Fatalf("encode error: %s", err)
+	}
+	return buf.String()
+}
+
+func BenchmarkDecode(b *testing.B) {
+	file := newBenchmarkFile(b, 10000)
+
+	// Report the memory retained by a decoded root, which includes the names
+	// of each property.
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	decoded, _, _ := Decoder{}.Decode(strings.NewReader(file))
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(decoded)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Decoder{}.Decode(strings.NewReader(file))
+	}
+	b.ReportMetric(float64(int64(after.HeapAlloc)-int64(before.HeapAlloc)), "retained-B")
+}
+
+// BenchmarkDecodeStream compares the peak memory of decoding a document with
+// and without retaining its tags.
+func BenchmarkDecodeStream(b *testing.B) {
+	file := newBenchmarkFile(b, 10000)
+	// The difference between samples may be negative.
+	heap := func() int64 {
+		var m runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&m)
+		return int64(m.HeapAlloc)
+	}
+
+	b.Run("DOM", func(b *testing.B) {
+		// The document and the decoded root are held at once.
+		before := heap()
+		document := new(documentRoot)
+		document.ReadFrom(strings.NewReader(file))
+		root, _ := Decoder{}.codec().Decode(document)
+		peak := heap() - before
+		runtime.KeepAlive(document)
+		runtime.KeepAlive(root)
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			Decoder{}.Decode(strings.NewReader(file))
+		}
+		b.ReportMetric(float64(peak), "peak-B")
+	})
+
+	b.Run("Stream", func(b *testing.B) {
+		// The heap is sampled periodically while decoding.
+		before := heap()
+		var peak int64
+		n := 0
+		Decoder{}.DecodeStream(strings.NewReader(file), func(inst *rbxfile.Instance) error {
+			if n++; n%1000 == 0 {
+				if h := heap() - before; h > peak {
+					peak = h
+				}
+			}
+			return nil
+		})
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			Decoder{}.DecodeStream(strings.NewReader(file), func(inst *rbxfile.Instance) error { return nil })
+		}
+		b.ReportMetric(float64(peak), "peak-B")
+	})
+}
+
+func TestDecodeStream(t *testing.T) {
+	const file = `<roblox version="4">
+	<Meta name="ExplicitAutoJoints">true</Meta>
+	<Item class="ObjectValue" referent="RBX0">
+		<Properties>
+			<Ref name="Value">RBX2</Ref>
+			<SharedString name="Data">yuZpQdnvvUBOTYh1jqZ2cA==</SharedString>
+		</Properties>
+		<Item class="Folder" referent="RBX1">
+			<Properties>
+				<string name="Name">Child</string>
+			</Properties>
+		</Item>
+	</Item>
+	<Item class="Part" referent="RBX2">
+		<Properties>
+			<string name="Name">Part</string>
+		</Properties>
+	</Item>
+	<SharedStrings>
+		<SharedString md5="yuZpQdnvvUBOTYh1jqZ2cA==">dGVzdA==</SharedString>
+	</SharedStrings>
+</roblox>`
+
+	var insts []*rbxfile.Instance
+	metadata, warn, err := Decoder{}.DecodeStream(strings.NewReader(file), func(inst *rbxfile.Instance) error {
+		if _, ok := inst.Properties["Value"]; ok {
+			t.Errorf("reference resolved before end of document")
+		}
+		insts = append(insts, inst)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if warn != nil {
+		t.Errorf("unexpected warning: %s", warn)
+	}
+	if metadata["ExplicitAutoJoints"] != "true" {
+		t.Errorf("unexpected metadata %v", metadata)
+	}
+	if len(insts) != 2 || insts[0].ClassName != "ObjectValue" || insts[1].ClassName != "Part" || len(insts[0].Children) != 1 {
+		t.Fatalf("unexpected instances")
+	}
+	if v, ok := insts[0].Properties["Value"].(rbxfile.ValueReference); !ok || v.Instance != insts[1] {
+		t.Errorf("unexpected Value %#v", insts[0].Properties["Value"])
+	}
+	if v, ok := insts[0].Properties["Data"].(rbxfile.ValueSharedString); !ok || string(v) != "test" {
+		t.Errorf("unexpected Data %#v", insts[0].Properties["Data"])
+	}
+
+	// The result matches that of Decode.
+	root, _, err := Decoder{}.Decode(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if !reflect.DeepEqual(root, &rbxfile.Root{Instances: insts, Metadata: metadata}) {
+		t.Errorf("streamed instances differ from decoded instances")
+	}
+
+	// An error from the function stops decoding.
+	stop := errors.New("stop")
+	n := 0
+	_, _, err = Decoder{}.DecodeStream(strings.NewReader(file), func(inst *rbxfile.Instance) error {
+		n++
+		return stop
+	})
+	if !errors.Is(err, stop) || n != 1 {
+		t.Errorf("expected stop error after 1 instance, got %v after %d", err, n)
+	}
+}
+
+func TestGzip(t *testing.T) {
+	root := &rbxfile.Root{}
+	part := rbxfile.NewInstance("Part")
+	part.Properties["Name"] = rbxfile.ValueString("Part")
+	root.Instances = append(root.Instances, part)
+
+	var plain, zipped bytes.Buffer
+	if _, err := (Encoder{}).Encode(&plain, root); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	if _, err := (Encoder{}).EncodeGzip(&zipped, root); err != nil {
+		t.Fatalf("gzip encode error: %s", err)
+	}
+	if !bytes.HasPrefix(zipped.Bytes(), []byte("\x1f\x8b")) {
+		t.Fatalf("expected gzip signature")
+	}
+
+	want, _, err := Decoder{}.Decode(bytes.NewReader(plain.Bytes()))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	for _, data := range [][]byte{zipped.Bytes(), plain.Bytes()} {
+		got, _, err := Decoder{}.DecodeGzip(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("gzip decode error: %s", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("decoded root differs")
+		}
+	}
+
+	if _, _, err := (Decoder{}).DecodeGzip(bytes.NewReader([]byte("\x1f\x8bcorrupt"))); err == nil {
+		t.Errorf("expected error for corrupt gzip data")
+	}
+}
+
+func TestBytecode(t *testing.T) {
+	script := rbxfile.NewInstance("ModuleScript")
+	script.Properties["Source"] = rbxfile.ValueProtectedString("return 'hello'")
+	script.Properties["Bytecode"] = rbxfile.ValueBinaryString("\x05\x03\x00print\x00hello\xff\xfe\x1b\x00\x00\x01")
+	root := &rbxfile.Root{Instances: []*rbxfile.Instance{script}}
+
+	var buf bytes.Buffer
+	if _, err := (Encoder{}).Encode(&buf, root); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	if !strings.Contains(buf.String(), `<BinaryString name="Bytecode">`) {
+		t.Errorf("expected Bytecode as BinaryString tag")
+	}
+	got, warn, err := Decoder{}.Decode(&buf)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if warn != nil {
+		t.Errorf("unexpected warning: %s", warn)
+	}
+	if !reflect.DeepEqual(got.Instances[0].Properties, script.Properties) {
+		t.Errorf("expected properties %v, got %v", script.Properties, got.Instances[0].Properties)
+	}
+}
+
+func TestEmptyPropertyName(t *testing.T) {
+	const file = `<roblox version="4">
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<string name="Name">Part</string>
+			<string name="">unnamed</string>
+		</Properties>
+	</Item>
+</roblox>`
+
+	for _, drop := range []bool{false, true} {
+		root, warn, err := Decoder{DropUnnamedProperties: drop}.Decode(strings.NewReader(file))
+		if err != nil {
+			t.Fatalf("drop %t: decode error: %s", drop, err)
+		}
+		var empty bool
+		if errs, ok := warn.(rbxerrors.Errors); ok {
+			for _, err := range errs {
+				empty = empty || errors.Is(err, rbxfile.ErrEmptyPropertyName)
+			}
+		}
+		if !empty {
+			t.Errorf("drop %t: expected empty name warning, got %v", drop, warn)
+		}
+		v, ok := root.Instances[0].Properties[""]
+		if drop {
+			if ok {
+				t.Errorf("drop %t: expected property to be dropped", drop)
+			}
+			continue
+		}
+		if !ok || v.String() != "unnamed" {
+			t.Fatalf("drop %t: expected unnamed property, got %v", drop, v)
+		}
+
+		// The property survives a round trip.
+		var buf bytes.Buffer
+		if _, err := (Encoder{}).Encode(&buf, root); err != nil {
+			t.Fatalf("encode error: %s", err)
+		}
+		if !strings.Contains(buf.String(), `<string name="">unnamed</string>`) {
+			t.Errorf("expected unnamed property in output")
+		}
+		again, _, err := Decoder{}.Decode(&buf)
+		if err != nil {
+			t.Fatalf("decode error: %s", err)
+		}
+		if !reflect.DeepEqual(again.Instances[0].Properties, root.Instances[0].Properties) {
+			t.Errorf("expected properties %v, got %v", root.Instances[0].Properties, again.Instances[0].Properties)
+		}
+	}
+}
+
+func TestDuplicateProperties(t *testing.T) {
+	const file = `<roblox version="4">
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<string name="Name">First</string>
+			<string name="Name">Second</string>
+			<bool name="Anchored">false</bool>
+		</Properties>
+		<Properties>
+			<bool name="Anchored">true</bool>
+			<float name="Transparency">0.5</float>
+		</Properties>
+	</Item>
+</roblox>`
+
+	for _, test := range []struct {
+		merge    bool
+		anchored bool
+		props    int
+	}{
+		{merge: false, anchored: false, props: 2},
+		{merge: true, anchored: true, props: 3},
+	} {
+		root, warn, err := Decoder{MergeDuplicateProperties: test.merge}.Decode(strings.NewReader(file))
+		if err != nil {
+			t.Fatalf("merge %t: decode error: %s", test.merge, err)
+		}
+		props := root.Instances[0].Properties
+		// The last definition of a property within a tag is retained.
+		if props["Name"].String() != "Second" {
+			t.Errorf("merge %t: expected Name Second, got %v", test.merge, props["Name"])
+		}
+		if props["Anchored"] != rbxfile.ValueBool(test.anchored) {
+			t.Errorf("merge %t: expected Anchored %t, got %v", test.merge, test.anchored, props["Anchored"])
+		}
+		if len(props) != test.props {
+			t.Errorf("merge %t: expected %d properties, got %d", test.merge, test.props, len(props))
+		}
+		if warn == nil || !strings.Contains(warn.Error(), "duplicate Properties tag") || !strings.Contains(warn.Error(), `duplicate property "Name"`) {
+			t.Errorf("merge %t: expected duplicate warnings, got %v", test.merge, warn)
+		}
+	}
+}
+
+func TestDecoderExternalReferences(t *testing.T) {
+	const fileA = `<roblox version="4">
+	<Item class="Folder" referent="RBXA0">
+		<Item class="Part" referent="RBXA1"/>
+	</Item>
+</roblox>`
+	const fileB = `<roblox version="4">
+	<Item class="ObjectValue" referent="RBXB0">
+		<Properties>
+			<Ref name="Value">RBXA1</Ref>
+		</Properties>
+	</Item>
+	<Item class="ObjectValue" referent="RBXB1">
+		<Properties>
+			<Ref name="Value">RBXZ</Ref>
+		</Properties>
+	</Item>
+</roblox>`
+
+	rootA, _, err := Decoder{}.Decode(strings.NewReader(fileA))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	refs := rbxfile.References{}
+	for _, inst := range rootA.Instances {
+		refs[inst.Reference] = inst
+		for _, child := range inst.Children {
+			refs[child.Reference] = child
+		}
+	}
+
+	rootB, warn, err := Decoder{ExternalReferences: refs}.Decode(strings.NewReader(fileB))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	part := rootA.Instances[0].Children[0]
+	if v, ok := rootB.Instances[0].Properties["Value"].(rbxfile.ValueReference); !ok || v.Instance != part {
+		t.Errorf("expected reference to external Part, got %#v", rootB.Instances[0].Properties["Value"])
+	}
+	if v, ok := rootB.Instances[1].Properties["Value"].(rbxfile.ValueReference); ok && v.Instance != nil {
+		t.Errorf("expected nil reference, got %#v", v)
+	}
+	if warn == nil || !strings.Contains(warn.Error(), `unresolved reference "RBXZ"`) {
+		t.Errorf("expected unresolved reference warning, got %v", warn)
+	}
+	if len(refs) != 2 {
+		t.Errorf("ExternalReferences was modified")
+	}
+}
+
+func TestReferencePath(t *testing.T) {
+	model := rbxfile.NewInstance("Model")
+	model.Properties["Name"] = rbxfile.ValueString("Model")
+	target := rbxfile.NewInstance("Part")
+	target.Properties["Name"] = rbxfile.ValueString("Target")
+	value := rbxfile.NewInstance("ObjectValue")
+	value.Properties["Name"] = rbxfile.ValueString("Value")
+	value.Properties["Value"] = rbxfile.ValueReference{Instance: target}
+	model.Children = []*rbxfile.Instance{target, value}
+	root := &rbxfile.Root{Instances: []*rbxfile.Instance{model}}
+
+	var buf bytes.Buffer
+	warn, err := Encoder{ReferenceStyle: ReferencePath}.Encode(&buf, root)
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	if warn != nil {
+		t.Errorf("unexpected warning: %s", warn)
+	}
+	if !strings.Contains(buf.String(), `<Ref name="Value">Model.Target</Ref>`) {
+		t.Fatalf("reference not encoded as path:\n%s", buf.String())
+	}
+
+	for _, style := range []ReferenceStyle{ReferenceGUID, ReferencePath} {
+		got, _, err := Decoder{ReferenceStyle: style}.Decode(strings.NewReader(buf.String()))
+		if err != nil {
+			t.Fatalf("style %d: decode error: %s", style, err)
+		}
+		var want *rbxfile.Instance
+		if style == ReferencePath {
+			want = got.Instances[0].Children[0]
+		}
+		v, _ := got.Instances[0].Children[1].Properties["Value"].(rbxfile.ValueReference)
+		if v.Instance != want {
+			t.Errorf("style %d: expected referent %v, got %v", style, want, v.Instance)
+		}
+	}
+}
+
+func TestReferencePathAmbiguous(t *testing.T) {
+	model := rbxfile.NewInstance("Model")
+	model.Properties["Name"] = rbxfile.ValueString("Model")
+	var parts []*rbxfile.Instance
+	for i := 0; i < 2; i++ {
+		part := rbxfile.NewInstance("Part")
+		part.Properties["Name"] = rbxfile.ValueString("Part")
+		parts = append(parts, part)
+	}
+	value := rbxfile.NewInstance("ObjectValue")
+	value.Properties["Value"] = rbxfile.ValueReference{Instance: parts[1]}
+	model.Children = append(parts, value)
+	root := &rbxfile.Root{Instances: []*rbxfile.Instance{model}}
+
+	var buf bytes.Buffer
+	warn, err := Encoder{ReferenceStyle: ReferencePath}.Encode(&buf, root)
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	if warn == nil || !strings.Contains(warn.Error(), "cannot be encoded as an unambiguous path") {
+		t.Errorf("expected ambiguous path warning, got %v", warn)
+	}
+	if strings.Contains(buf.String(), "Model.Part</Ref>") {
+		t.Fatalf("ambiguous reference encoded as path:\n%s", buf.String())
+	}
+
+	// The referent string is resolved regardless of the style.
+	for _, style := range []ReferenceStyle{ReferenceGUID, ReferencePath} {
+		got, _, err := Decoder{ReferenceStyle: style}.Decode(strings.NewReader(buf.String()))
+		if err != nil {
+			t.Fatalf("style %d: decode error: %s", style, err)
+		}
+		children := got.Instances[0].Children
+		if v, ok := children[2].Properties["Value"].(rbxfile.ValueReference); !ok || v.Instance != children[1] {
+			t.Errorf("style %d: reference not resolved to second Part", style)
+		}
+	}
+}
+
+func TestWarningPositions(t *testing.T) {
+	const file = `<roblox version="4">
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<Ref name="Target">RBX1</Ref>
+		</Properties>
+	</Item>
+</roblox>`
+
+	_, warn, err := Decoder{ExternalReferences: rbxfile.References{}}.Decode(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	const expected = `line 4 (offset 76): property Part.Target has unresolved reference "RBX1"`
+	if warn == nil || !strings.Contains(warn.Error(), expected) {
+		t.Errorf("expected warning %q, got %v", expected, warn)
+	}
+}
+
+func TestEmptySequences(t *testing.T) {
+	root := &rbxfile.Root{}
+	for _, values := range [][2]rbxfile.Value{
+		{rbxfile.ValueNumberSequence(nil), rbxfile.ValueColorSequence(nil)},
+		{rbxfile.ValueNumberSequence{}, rbxfile.ValueColorSequence{}},
+	} {
+		inst := rbxfile.NewInstance("ParticleEmitter")
+		inst.Properties["Size"] = values[0]
+		inst.Properties["Color"] = values[1]
+		root.Instances = append(root.Instances, inst)
+	}
+	var buf bytes.Buffer
+	if _, err := (Encoder{}).Encode(&buf, root); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	got, _, err := Decoder{}.Decode(&buf)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	for i, inst := range got.Instances {
+		if v, ok := inst.Properties["Size"].(rbxfile.ValueNumberSequence); !ok || v == nil || len(v) != 0 {
+			t.Errorf("instance %d: expected empty NumberSequence, got %#v", i, inst.Properties["Size"])
+		}
+		if v, ok := inst.Properties["Color"].(rbxfile.ValueColorSequence); !ok || v == nil || len(v) != 0 {
+			t.Errorf("instance %d: expected empty ColorSequence, got %#v", i, inst.Properties["Color"])
+		}
+	}
+}
+
+func TestNonFinite(t *testing.T) {
+	nan, inf := float32(math.NaN()), float32(math.Inf(1))
+	part := rbxfile.NewInstance("Part")
+	part.Properties["Name"] = rbxfile.ValueString("Part")
+	part.Properties["Transparency"] = rbxfile.ValueFloat(nan)
+	part.Properties["Size"] = rbxfile.ValueVector3{X: inf, Y: 1, Z: 1}
+	part.Properties["Sequence"] = rbxfile.ValueNumberSequence{{Time: 0, Value: nan}, {Time: 1, Value: 1}}
+	part.Properties["Pivot"] = rbxfile.Some(rbxfile.ValueCFrame{
+		Position: rbxfile.ValueVector3{Y: nan},
+		Rotation: [9]float32{1, 0, 0, 0, 1, 0, 0, 0, 1},
+	})
+	root := &rbxfile.Root{Instances: []*rbxfile.Instance{part}}
+
+	const max = math.MaxFloat32
+	for _, test := range []struct {
+		mode  NonFinite
+		props map[string]rbxfile.Value
+	}{
+		{mode: NonFiniteZero, props: map[string]rbxfile.Value{
+			"Name":         rbxfile.ValueString("Part"),
+			"Transparency": rbxfile.ValueFloat(0),
+			"Size":         rbxfile.ValueVector3{X: 0, Y: 1, Z: 1},
+			"Sequence":     rbxfile.ValueNumberSequence{{Time: 0, Value: 0}, {Time: 1, Value: 1}},
+			"Pivot": rbxfile.Some(rbxfile.ValueCFrame{
+				Rotation: [9]float32{1, 0, 0, 0, 1, 0, 0, 0, 1},
+			}),
+		}},
+		{mode: NonFiniteClamp, props: map[string]rbxfile.Value{
+			"Name":         rbxfile.ValueString("Part"),
+			"Transparency": rbxfile.ValueFloat(0),
+			"Size":         rbxfile.ValueVector3{X: max, Y: 1, Z: 1},
+			"Sequence":     rbxfile.ValueNumberSequence{{Time: 0, Value: 0}, {Time: 1, Value: 1}},
+			"Pivot": rbxfile.Some(rbxfile.ValueCFrame{
+				Rotation: [9]float32{1, 0, 0, 0, 1, 0, 0, 0, 1},
+			}),
+		}},
+		{mode: NonFiniteSkip, props: map[string]rbxfile.Value{
+			"Name": rbxfile.ValueString("Part"),
+		}},
+	} {
+		var buf bytes.Buffer
+		warn, err := Encoder{NonFinite: test.mode}.Encode(&buf, root)
+		if err != nil {
+			t.Fatalf("mode %d: encode error: %s", test.mode, err)
+		}
+		if test.mode == NonFiniteSkip {
+			if warn == nil || strings.Count(warn.Error(), "non-finite value") != 4 {
+				t.Errorf("mode %d: expected 4 warnings, got %v", test.mode, warn)
+			}
+		}
+		got, _, err := Decoder{}.Decode(&buf)
+		if err != nil {
+			t.Fatalf("mode %d: decode error: %s", test.mode, err)
+		}
+		if props := got.Instances[0].Properties; !reflect.DeepEqual(props, test.props) {
+			t.Errorf("mode %d: expected %v, got %v", test.mode, test.props, props)
+		}
+	}
+	// Values of the encoded root are not modified.
+	if v := part.Properties["Sequence"].(rbxfile.ValueNumberSequence); !math.IsNaN(float64(v[0].Value)) {
+		t.Errorf("encoding modified the root")
+	}
+
+	const file = `<roblox version="4">
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<Vector3 name="Size"><X>INF</X><Y>1</Y><Z>1</Z></Vector3>
+			<float name="Transparency">0.5</float>
+		</Properties>
+	</Item>
+</roblox>`
+	got, warn, err := Decoder{NonFinite: NonFiniteSkip}.Decode(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if _, ok := got.Instances[0].Properties["Size"]; ok {
+		t.Errorf("expected Size to be skipped")
+	}
+	if got.Instances[0].Properties["Transparency"] != rbxfile.ValueFloat(0.5) {
+		t.Errorf("unexpected Transparency %v", got.Instances[0].Properties["Transparency"])
+	}
+	if warn == nil || !strings.Contains(warn.Error(), "property Part.Size has non-finite value") {
+		t.Errorf("expected non-finite warning, got %v", warn)
+	}
+}
+
+func TestDecoderPropertyFilter(t *testing.T) {
+	const file = `<roblox version="4">
+	<Item class="Script" referent="RBX0">
+		<Properties>
+			<string name="Name">A</string>
+			<ProtectedString name="Source"><![CDATA[print('A')]]></ProtectedString>
+			<Ref name="Target">RBX1</Ref>
+		</Properties>
+		<Item class="ModuleScript" referent="RBX1">
+			<Properties>
+				<ProtectedString name="Source"><![CDATA[return nil]]></ProtectedString>
+			</Properties>
+		</Item>
+	</Item>
+</roblox>`
+	var filtered []string
+	d := Decoder{PropertyFilter: func(class, prop string, v rbxfile.Value) (rbxfile.Value, bool) {
+		filtered = append(filtered, class+"."+prop)
+		switch v := v.(type) {
+		case rbxfile.ValueProtectedString:
+			return nil, false
+		case rbxfile.ValueString:
+			return rbxfile.ValueString(class + "." + string(v)), true
+		}
+		return v, true
+	}}
+	root, _, err := d.Decode(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	script := root.Instances[0]
+	for _, inst := range []*rbxfile.Instance{script, script.Children[0]} {
+		if _, ok := inst.Properties["Source"]; ok {
+			t.Errorf("%s: expected Source to be removed", inst.ClassName)
+		}
+	}
+	if v, ok := script.Properties["Name"].(rbxfile.ValueString); !ok || string(v) != "Script.A" {
+		t.Errorf("unexpected Name %#v", script.Properties["Name"])
+	}
+	// References are passed to the filter after they are resolved.
+	if v, ok := script.Properties["Target"].(rbxfile.ValueReference); !ok || v.Instance != script.Children[0] {
+		t.Errorf("unexpected Target %#v", script.Properties["Target"])
+	}
+	if len(filtered) != 4 {
+		t.Errorf("expected 4 filtered properties, got %v", filtered)
+	}
+}
+
+func TestDecoderOnBinaryString(t *testing.T) {
+	const file = `<roblox version="4">
+	<Item class="Folder" referent="RBX0">
+		<Properties>
+			<string name="Name">Folder</string>
+			<BinaryString name="Tags">aGVsbG8=</BinaryString>
+			<BinaryString name="AttributesSerialize"></BinaryString>
+		</Properties>
+	</Item>
+</roblox>`
+
+	got := map[string]string{}
+	d := Decoder{OnBinaryString: func(inst *rbxfile.Instance, prop string, r io.Reader) {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Errorf("%s: read error: %s", prop, err)
+		}
+		got[inst.ClassName+"."+prop] = string(b)
+	}}
+	want := map[string]string{"Folder.Tags": "hello", "Folder.AttributesSerialize": ""}
+
+	root, _, err := d.Decode(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected binary strings %q, got %q", want, got)
+	}
+	props := root.Instances[0].Properties
+	if _, ok := props["Tags"]; ok {
+		t.Errorf("expected Tags to be omitted")
+	}
+	if v := props["Name"]; v == nil || v.String() != "Folder" {
+		t.Errorf("unexpected Name %#v", v)
+	}
+
+	got = map[string]string{}
+	if _, _, err := d.DecodeStream(strings.NewReader(file), func(inst *rbxfile.Instance) error { return nil }); err != nil {
+		t.Fatalf("stream decode error: %s", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("stream: expected binary strings %q, got %q", want, got)
+	}
+}
+
+func TestEncoderPreferCDATA(t *testing.T) {
+	const source = "if a < b and c > d then\n\tprint(\"&amp;\")\nend"
+	script := rbxfile.NewInstance("Script")
+	script.Properties["Source"] = rbxfile.ValueProtectedString(source)
+	script.Properties["Tags"] = rbxfile.ValueBinaryString("a<b")
+	root := &rbxfile.Root{Instances: []*rbxfile.Instance{script}}
+
+	for _, prefer := range []bool{false, true} {
+		var buf bytes.Buffer
+		if _, err := (Encoder{PreferCDATA: prefer}).Encode(&buf, root); err != nil {
+			t.Fatalf("prefer %t: encode error: %s", prefer, err)
+		}
+		cdata := strings.Contains(buf.String(), "<![CDATA["+source+"]]>")
+		if cdata != prefer {
+			t.Errorf("prefer %t: source encoded as CDATA %t:\n%s", prefer, cdata, buf.String())
+		}
+		got, _, err := Decoder{}.Decode(&buf)
+		if err != nil {
+			t.Fatalf("prefer %t: decode error: %s", prefer, err)
+		}
+		props := got.Instances[0].Properties
+		if v, ok := props["Source"].(rbxfile.ValueProtectedString); !ok || string(v) != source {
+			t.Errorf("prefer %t: unexpected Source %#v", prefer, props["Source"])
+		}
+		if v, ok := props["Tags"].(rbxfile.ValueBinaryString); !ok || string(v) != "a<b" {
+			t.Errorf("prefer %t: unexpected Tags %#v", prefer, props["Tags"])
+		}
+	}
+}
+
+func TestEncoderMinify(t *testing.T) {
+	model := rbxfile.NewInstance("Model")
+	model.Properties["Name"] = rbxfile.ValueString("Model")
+	part := rbxfile.NewInstance("Part")
+	part.Properties["Size"] = rbxfile.ValueVector3{X: 1, Y: 2, Z: 3}
+	model.Children = append(model.Children, part)
+	root := &rbxfile.Root{Instances: []*rbxfile.Instance{model}}
+
+	var indented, minified bytes.Buffer
+	if _, err := (Encoder{}).Encode(&indented, root); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	// Prefix and Indent are ignored.
+	if _, err := (Encoder{Minify: true, Prefix: "#", Indent: "  "}).Encode(&minified, root); err != nil {
+		t.Fatalf("minify encode error: %s", err)
+	}
+	if s := minified.String(); strings.ContainsAny(s, "\n\t#") || strings.Contains(s, "  ") {
+		t.Errorf("expected document without whitespace between tags:\n%s", s)
+	}
+	if minified.Len() >= indented.Len() {
+		t.Errorf("minified document (%d bytes) not smaller than indented document (%d bytes)", minified.Len(), indented.Len())
+	}
+
+	got, _, err := Decoder{}.Decode(&minified)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if len(got.Instances) != 1 || len(got.Instances[0].Children) != 1 {
+		t.Fatalf("unexpected tree %v", got.Instances)
+	}
+	if v := got.Instances[0].Properties["Name"]; v == nil || v.String() != "Model" {
+		t.Errorf("unexpected Name %#v", v)
+	}
+	if v, ok := got.Instances[0].Children[0].Properties["Size"].(rbxfile.ValueVector3); !ok || v != (rbxfile.ValueVector3{X: 1, Y: 2, Z: 3}) {
+		t.Errorf("unexpected Size %#v", got.Instances[0].Children[0].Properties["Size"])
+	}
+}
+
+func TestColor3Tags(t *testing.T) {
+	// Properties as written by Studio. Color3 uses the component form, while
+	// Color3uint8 uses the packed form.
+	const properties = `<Color3 name="Color">
+				<R>1</R>
+				<G>0.5</G>
+				<B>0</B>
+			</Color3>
+			<Color3uint8 name="Color3uint8">4294934528</Color3uint8>`
+	const file = `<roblox version="4">
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			` + properties + `
+		</Properties>
+	</Item>
+</roblox>`
+
+	root, _, err := Decoder{}.Decode(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	props := root.Instances[0].Properties
+	if v, ok := props["Color"].(rbxfile.ValueColor3); !ok || v != (rbxfile.ValueColor3{R: 1, G: 0.5, B: 0}) {
+		t.Errorf("Color: unexpected value %#v", props["Color"])
+	}
+	if v, ok := props["Color3uint8"].(rbxfile.ValueColor3uint8); !ok || v != (rbxfile.ValueColor3uint8{R: 255, G: 128, B: 0}) {
+		t.Errorf("Color3uint8: unexpected value %#v", props["Color3uint8"])
+	}
+
+	var buf strings.Builder
+	if _, err := (Encoder{}).Encode(&buf, root); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	if !strings.Contains(buf.String(), properties) {
+		t.Errorf("encoded properties differ from Studio:\n%s", buf.String())
+	}
+}
+
+func TestEncoderColor3Packed(t *testing.T) {
+	part := rbxfile.NewInstance("Part")
+	part.Properties["Color"] = rbxfile.ValueColor3{R: 1, G: 0.5, B: 0}
+	// Components outside of [0, 1] are clamped.
+	part.Properties["Clamped"] = rbxfile.ValueColor3{R: 2, G: -1, B: float32(math.NaN())}
+	root := &rbxfile.Root{Instances: []*rbxfile.Instance{part}}
+
+	var buf strings.Builder
+	if _, err := (Encoder{Color3Packed: true}).Encode(&buf, root); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	for _, tag := range []string{
+		`<Color3 name="Color">4294934528</Color3>`,
+		`<Color3 name="Clamped">4294901760</Color3>`,
+	} {
+		if !strings.Contains(buf.String(), tag) {
+			t.Errorf("expected %s in document:\n%s", tag, buf.String())
+		}
+	}
+
+	got, _, err := Decoder{}.Decode(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	props := got.Instances[0].Properties
+	if v, ok := props["Color"].(rbxfile.ValueColor3); !ok || v != (rbxfile.ValueColor3{R: 1, G: 128.0 / 255, B: 0}) {
+		t.Errorf("Color: unexpected value %#v", props["Color"])
+	}
+	if v, ok := props["Clamped"].(rbxfile.ValueColor3); !ok || v != (rbxfile.ValueColor3{R: 1, G: 0, B: 0}) {
+		t.Errorf("Clamped: unexpected value %#v", props["Clamped"])
+	}
+}
+
+func TestDecoderClassRemap(t *testing.T) {
+	const file = `<roblox version="4">
+	<Item class="Hint" referent="RBX0">
+		<Properties>
+			<string name="Text">hello</string>
+		</Properties>
+		<Item class="ObjectValue" referent="RBX1">
+			<Properties>
+				<Ref name="Value">RBX0</Ref>
+			</Properties>
+		</Item>
+	</Item>
+	<Item class="Hint" referent="RBX2"/>
+</roblox>`
+	root, warn, err := Decoder{ClassRemap: map[string]string{"Hint": "Message"}}.Decode(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if warn == nil || warn.Error() != "line 2 (offset 22): remapped class Hint to Message" {
+		t.Errorf("expected one remap warning, got %v", warn)
+	}
+	if len(root.Instances) != 2 || root.Instances[0].ClassName != "Message" || root.Instances[1].ClassName != "Message" {
+		t.Fatalf("classes were not remapped")
+	}
+	msg := root.Instances[0]
+	if text, ok := msg.Properties["Text"].(rbxfile.ValueString); !ok || string(text) != "hello" {
+		t.Errorf("unexpected Text %#v", msg.Properties["Text"])
+	}
+	if len(msg.Children) != 1 {
+		t.Fatalf("unexpected children")
+	}
+	if ref, ok := msg.Children[0].Properties["Value"].(rbxfile.ValueReference); !ok || ref.Instance != msg {
+		t.Errorf("reference does not refer to remapped instance")
+	}
+}
+
+// itemMetadataFile contains an Item with comments and custom tags.
+const itemMetadataFile = `<roblox version="4">
+	<Item class="Folder" referent="RBX0">
+		<!-- generated by build -->
+		<Properties>
+			<string name="Name">Assets</string>
+		</Properties>
+		<SourceHash>abc123</SourceHash>
+		<!-- a < b && c -->
+		<Item class="Folder" referent="RBX1">
+			<Origin>pipeline</Origin>
+		</Item>
+	</Item>
+</roblox>`
+
+func TestDecoderItemMetadata(t *testing.T) {
+	root, _, err := Decoder{}.Decode(strings.NewReader(itemMetadataFile))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if md := root.Instances[0].Metadata; md != nil {
+		t.Errorf("expected no metadata, got %v", md)
+	}
+
+	root, _, err = Decoder{ItemMetadata: true}.Decode(strings.NewReader(itemMetadataFile))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	want := map[string]string{
+		"#comment0":  " generated by build ",
+		"#comment1":  " a < b && c ",
+		"SourceHash": "abc123",
+	}
+	folder := root.Instances[0]
+	if !reflect.DeepEqual(folder.Metadata, want) {
+		t.Errorf("expected metadata %v, got %v", want, folder.Metadata)
+	}
+	if len(folder.Children) != 1 || folder.Children[0].Metadata["Origin"] != "pipeline" {
+		t.Fatalf("unexpected child metadata")
+	}
+
+	var buf bytes.Buffer
+	if _, err := (Encoder{}).Encode(&buf, root); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	root, _, err = Decoder{ItemMetadata: true}.Decode(&buf)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if !reflect.DeepEqual(root.Instances[0].Metadata, want) {
+		t.Errorf("metadata does not round trip: got %v", root.Instances[0].Metadata)
+	}
+	if name, ok := root.Instances[0].Properties["Name"].(rbxfile.ValueString); !ok || string(name) != "Assets" {
+		t.Errorf("unexpected Name %#v", root.Instances[0].Properties["Name"])
+	}
+}
+
+// capabilitiesFile is a hand-written document containing a sandboxed Model
+// with the Capabilities property enabling RunClientScript, CreateInstances and
+// Basic.
+const capabilitiesFile = `<roblox version="4">
+	<Item class="Model" referent="RBX0">
+		<Properties>
+			<SecurityCapabilities name="Capabilities">193</SecurityCapabilities>
+			<bool name="Sandboxed">true</bool>
+		</Properties>
+	</Item>
+</roblox>`
+
+func TestDecoderSecurityCapabilities(t *testing.T) {
+	root, warn, err := Decoder{}.Decode(strings.NewReader(capabilitiesFile))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if warn != nil {
+		t.Errorf("unexpected warning: %s", warn)
+	}
+	caps, ok := root.Instances[0].Properties["Capabilities"].(rbxfile.ValueSecurityCapabilities)
+	if !ok {
+		t.Fatalf("unexpected Capabilities %#v", root.Instances[0].Properties["Capabilities"])
+	}
+	want := rbxfile.ValueSecurityCapabilities(0).
+		With(rbxfile.SecurityCapabilityRunClientScript).
+		With(rbxfile.SecurityCapabilityCreateInstances).
+		With(rbxfile.SecurityCapabilityBasic)
+	if caps != want {
+		t.Errorf("expected Capabilities %d, got %d", want, caps)
+	}
+
+	var buf bytes.Buffer
+	if _, err := (Encoder{}).Encode(&buf, root); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	if !strings.Contains(buf.String(), `<SecurityCapabilities name="Capabilities">193</SecurityCapabilities>`) {
+		t.Errorf("unexpected encoding:\n%s", buf.String())
+	}
+}
+
+// contentObjectFile contains a Content property in object form, referring to
+// an EditableImage.
+const contentObjectFile = `<roblox version="4">
+	<Item class="ImageLabel" referent="RBX0">
+		<Properties>
+			<Content name="ImageContent"><Ref>RBX1</Ref></Content>
+			<Content name="Image"><url>rbxassetid://1</url></Content>
+			<Content name="ResampleContent"><Ref>null</Ref></Content>
+		</Properties>
+		<Item class="EditableImage" referent="RBX1"/>
+	</Item>
+</roblox>`
+
+func TestDecoderContentObject(t *testing.T) {
+	root, warn, err := Decoder{}.Decode(strings.NewReader(contentObjectFile))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if warn != nil {
+		t.Errorf("unexpected warning: %s", warn)
+	}
+	check := func(root *rbxfile.Root) {
+		t.Helper()
+		label := root.Instances[0]
+		if len(label.Children) != 1 {
+			t.Fatalf("unexpected children")
+		}
+		if v, ok := label.Properties["ImageContent"].(rbxfile.ValueContentObject); !ok || v.Instance != label.Children[0] {
+			t.Errorf("unexpected ImageContent %#v", label.Properties["ImageContent"])
+		}
+		if v, ok := label.Properties["Image"].(rbxfile.ValueContent); !ok || string(v) != "rbxassetid://1" {
+			t.Errorf("unexpected Image %#v", label.Properties["Image"])
+		}
+		if v, ok := label.Properties["ResampleContent"].(rbxfile.ValueContentObject); !ok || v.Instance != nil {
+			t.Errorf("unexpected ResampleContent %#v", label.Properties["ResampleContent"])
+		}
+	}
+	check(root)
+	check(root.Copy())
+
+	var buf bytes.Buffer
+	if _, err := (Encoder{}).Encode(&buf, root); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	root, _, err = Decoder{}.Decode(&buf)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	check(root)
+}
+
+func TestRegisterTypeAlias(t *testing.T) {
+	RegisterTypeAlias("TestVec3", rbxfile.TypeVector3)
+	RegisterTypeAlias("TestCF", rbxfile.TypeCFrame)
+	const file = `<roblox version="4">
+	<Item class="Part" referent="RBX0">
+		<Properties>
+			<testvec3 name="Size">
+				<X>1</X>
+				<Y>2</Y>
+				<Z>3</Z>
+			</testvec3>
+			<OptionalTestCF name="Pivot"></OptionalTestCF>
+		</Properties>
+	</Item>
+</roblox>`
+	root, _, err := Decoder{}.Decode(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	props := root.Instances[0].Properties
+	if v, ok := props["Size"].(rbxfile.ValueVector3); !ok || v != (rbxfile.ValueVector3{X: 1, Y: 2, Z: 3}) {
+		t.Errorf("Size: unexpected value %#v", props["Size"])
+	}
+	if v, ok := props["Pivot"].(rbxfile.ValueOptional); !ok || v.ValueType() != rbxfile.TypeCFrame || v.Value() != nil {
+		t.Errorf("Pivot: unexpected value %#v", props["Pivot"])
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("expected panic for built-in tag")
+			}
+		}()
+		RegisterTypeAlias("CoordinateFrame", rbxfile.TypeVector3)
+	}()
+}
@@ -11,6 +11,18 @@ type PropRef struct {
 	Instance  *Instance
 	Property  string
 	Reference string
+
+	// ContentObject indicates whether the property is resolved to a
+	// ValueContentObject rather than a ValueReference.
+	ContentObject bool
+}
+
+// Value returns the value of the property when resolved to referent.
+func (propRef PropRef) Value(referent *Instance) Value {
+	if propRef.ContentObject {
+		return ValueContentObject{Instance: referent}
+	}
+	return ValueReference{Instance: referent}
 }
 
 // References is a mapping of reference strings to Instances.
@@ -27,9 +39,7 @@ func (refs References) Resolve(propRef PropRef) bool {
 		return false
 	}
 	referent := refs[propRef.Reference]
-	propRef.Instance.Properties[propRef.Property] = ValueReference{
-		Instance: referent,
-	}
+	propRef.Instance.Properties[propRef.Property] = propRef.Value(referent)
 	return referent != nil && !IsEmptyReference(propRef.Reference)
 }
 
@@ -0,0 +1,76 @@
+package rbxfile
+
+import "sort"
+
+// SchemaConflict describes a property that was observed with more than one
+// type across instances of the same class.
+type SchemaConflict struct {
+	Class    string
+	Property string
+	// Types lists each observed type, in the order first observed.
+	Types []Type
+}
+
+// InferSchema walks each instance in root, and returns, per class name, a
+// mapping of each observed property name to its type. This can be used to
+// build a partial API from sample data.
+//
+// If a property of a class is observed with more than one type, then the
+// schema contains the type that was observed first, and the property is
+// reported in conflicts. Conflicts are sorted by class, then by property.
+func InferSchema(root *Root) (schema map[string]map[string]Type, conflicts []SchemaConflict) {
+	schema = map[string]map[string]Type{}
+	types := map[[2]string][]Type{}
+	var walk func(insts []*Instance)
+	walk = func(insts []*Instance) {
+		for _, inst := range insts {
+			props := schema[inst.ClassName]
+			if props == nil {
+				props = map[string]Type{}
+				schema[inst.ClassName] = props
+			}
+			for _, prop := range inst.SortedProperties() {
+				if prop.Value == nil {
+					continue
+				}
+				typ := prop.Value.Type()
+				if _, ok := props[prop.Name]; !ok {
+					props[prop.Name] = typ
+				}
+				key := [2]string{inst.ClassName, prop.Name}
+				if !hasType(types[key], typ) {
+					types[key] = append(types[key], typ)
+				}
+			}
+			walk(inst.Children)
+		}
+	}
+	walk(root.Instances)
+
+	for key, seen := range types {
+		if len(seen) > 1 {
+			conflicts = append(conflicts, SchemaConflict{
+				Class:    key[0],
+				Property: key[1],
+				Types:    seen,
+			})
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		if conflicts[i].Class != conflicts[j].Class {
+			return conflicts[i].Class < conflicts[j].Class
+		}
+		return conflicts[i].Property < conflicts[j].Property
+	})
+	return schema, conflicts
+}
+
+// hasType returns whether types contains t.
+func hasType(types []Type, t Type) bool {
+	for _, typ := range types {
+		if typ == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package rbxfile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInferSchema(t *testing.T) {
+	newPart := func(props map[string]Value) *Instance {
+		part := NewInstance("Part")
+		for name, v := range props {
+			part.Properties[name] = v
+		}
+		return part
+	}
+	model := NewInstance("Model")
+	model.Properties["Name"] = ValueString("Model")
+	model.Children = []*Instance{
+		newPart(map[string]Value{"Name": ValueString("A"), "Size": ValueVector3{}, "Value": ValueInt(1)}),
+		newPart(map[string]Value{"Name": ValueString("B"), "Value": ValueFloat(1), "Color": ValueColor3{}}),
+		newPart(map[string]Value{"Value": ValueInt(2), "Color": ValueColor3uint8{}, "Missing": nil}),
+	}
+	folder := NewInstance("Folder")
+	root := &Root{Instances: []*Instance{model, folder}}
+
+	schema, conflicts := InferSchema(root)
+	want := map[string]map[string]Type{
+		"Model": {"Name": TypeString},
+		"Part": {
+			"Name":  TypeString,
+			"Size":  TypeVector3,
+			"Value": TypeInt,
+			"Color": TypeColor3,
+		},
+		"Folder": {},
+	}
+	if !reflect.DeepEqual(schema, want) {
+		t.Errorf("expected schema %v, got %v", want, schema)
+	}
+	wantConflicts := []SchemaConflict{
+		{Class: "Part", Property: "Color", Types: []Type{TypeColor3, TypeColor3uint8}},
+		{Class: "Part", Property: "Value", Types: []Type{TypeInt, TypeFloat}},
+	}
+	if !reflect.DeepEqual(conflicts, wantConflicts) {
+		t.Errorf("expected conflicts %v, got %v", wantConflicts, conflicts)
+	}
+
+	schema, conflicts = InferSchema(&Root{})
+	if len(schema) != 0 || conflicts != nil {
+		t.Errorf("empty root: unexpected schema %v, conflicts %v", schema, conflicts)
+	}
+}
@@ -0,0 +1,21 @@
+package rbxfile
+
+// DedupeScripts returns the number of ProtectedString values within the tree
+// that are byte-identical to another ProtectedString value visited before it.
+// This is the number of script sources that could be stored once and shared.
+// The tree is not modified.
+func DedupeScripts(root *Root) int {
+	n := 0
+	seen := map[string]struct{}{}
+	WalkValues(root, func(inst *Instance, prop string, v Value) (Value, bool) {
+		if s, ok := v.(ValueProtectedString); ok {
+			if _, ok := seen[string(s)]; ok {
+				n++
+			} else {
+				seen[string(s)] = struct{}{}
+			}
+		}
+		return v, true
+	})
+	return n
+}
@@ -0,0 +1,33 @@
+package rbxfile
+
+import (
+	"testing"
+)
+
+func TestDedupeScripts(t *testing.T) {
+	newScript := func(source string) *Instance {
+		script := NewInstance("Script")
+		script.Properties["Source"] = ValueProtectedString(source)
+		return script
+	}
+	folder := NewInstance("Folder")
+	folder.Properties["Name"] = ValueString("print('hello')")
+	folder.Children = []*Instance{
+		newScript("print('hello')"),
+		newScript("print('hello')"),
+		newScript("print('world')"),
+		newScript(""),
+		newScript(""),
+	}
+	root := &Root{Instances: []*Instance{folder, newScript("print('hello')")}}
+
+	if n := DedupeScripts(root); n != 3 {
+		t.Errorf("expected 3 duplicates, got %d", n)
+	}
+	if len(folder.Children) != 5 || folder.Children[1].Properties["Source"].String() != "print('hello')" {
+		t.Errorf("tree was modified")
+	}
+	if n := DedupeScripts(&Root{}); n != 0 {
+		t.Errorf("expected 0 duplicates in empty root, got %d", n)
+	}
+}
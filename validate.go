@@ -0,0 +1,123 @@
+package rbxfile
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/robloxapi/rbxfile/errors"
+)
+
+// Structural problems reported by Root.Validate.
+var (
+	// ErrCyclicParent indicates that an instance is a descendant of itself.
+	ErrCyclicParent = errors.New("instance is a descendant of itself")
+	// ErrSharedInstance indicates that an instance appears more than once
+	// within the tree.
+	ErrSharedInstance = errors.New("instance appears more than once")
+	// ErrDuplicateReference indicates that the Reference of an instance is
+	// shared by another instance.
+	ErrDuplicateReference = errors.New("duplicate reference")
+	// ErrNilInstance indicates a nil instance within a list of children.
+	ErrNilInstance = errors.New("nil instance")
+	// ErrNilValue indicates a property with a nil value.
+	ErrNilValue = errors.New("nil value")
+)
+
+// ValidationError describes a structural problem with an instance within a
+// Root.
+type ValidationError struct {
+	// Path identifies the instance. Each instance from the root is
+	// identified by its Name property, or by its ClassName if it has no
+	// name, separated by periods. For ErrNilInstance, Path identifies the
+	// parent, and is empty for a nil root instance.
+	Path string
+
+	// Property is the name of the property, for ErrNilValue.
+	Property string
+
+	// Reference is the reference, for ErrDuplicateReference.
+	Reference string
+
+	// Cause is one of the errors listed above.
+	Cause error
+}
+
+func (err ValidationError) Error() string {
+	var s strings.Builder
+	if err.Path != "" {
+		s.WriteString(err.Path)
+		s.WriteString(": ")
+	}
+	switch {
+	case err.Property != "":
+		fmt.Fprintf(&s, "property %s: ", err.Property)
+	case err.Reference != "":
+		fmt.Fprintf(&s, "reference %q: ", err.Reference)
+	}
+	s.WriteString(err.Cause.Error())
+	return s.String()
+}
+
+func (err ValidationError) Unwrap() error {
+	return err.Cause
+}
+
+// Validate returns each structural problem within the tree that may cause
+// encoding to fail, or to produce an unexpected result. Each problem is a
+// ValidationError. Problems are reported in depth-first order. Returns nil if
+// the tree is valid.
+//
+// An instance that is a descendant of itself, or that appears more than once,
+// is reported, and its descendants are not traversed again.
+func (root *Root) Validate() []error {
+	var errs []error
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[*Instance]int{}
+	refs := map[string]struct{}{}
+	var path []string
+	var walk func(insts []*Instance)
+	walk = func(insts []*Instance) {
+		for _, inst := range insts {
+			if inst == nil {
+				errs = append(errs, ValidationError{Path: strings.Join(path, "."), Cause: ErrNilInstance})
+				continue
+			}
+			path = append(path, inst.validationName())
+			switch state[inst] {
+			case visiting:
+				errs = append(errs, ValidationError{Path: strings.Join(path, "."), Cause: ErrCyclicParent})
+			case visited:
+				errs = append(errs, ValidationError{Path: strings.Join(path, "."), Cause: ErrSharedInstance})
+			default:
+				state[inst] = visiting
+				if !IsEmptyReference(inst.Reference) {
+					if _, ok := refs[inst.Reference]; ok {
+						errs = append(errs, ValidationError{Path: strings.Join(path, "."), Reference: inst.Reference, Cause: ErrDuplicateReference})
+					}
+					refs[inst.Reference] = struct{}{}
+				}
+				for _, prop := range inst.SortedProperties() {
+					if prop.Value == nil {
+						errs = append(errs, ValidationError{Path: strings.Join(path, "."), Property: prop.Name, Cause: ErrNilValue})
+					}
+				}
+				walk(inst.Children)
+				state[inst] = visited
+			}
+			path = path[:len(path)-1]
+		}
+	}
+	walk(root.Instances)
+	return errs
+}
+
+// validationName returns the name identifying inst within a path.
+func (inst *Instance) validationName() string {
+	if name, ok := inst.Properties["Name"].(ValueString); ok && len(name) > 0 {
+		return string(name)
+	}
+	return inst.ClassName
+}
@@ -0,0 +1,71 @@
+package rbxfile
+
+import (
+	"testing"
+
+	"github.com/robloxapi/rbxfile/errors"
+)
+
+func TestRootValidate(t *testing.T) {
+	named := func(class, name string) *Instance {
+		inst := NewInstance(class)
+		inst.Properties["Name"] = ValueString(name)
+		return inst
+	}
+
+	model := named("Model", "Model")
+	part := named("Part", "Part")
+	model.Children = append(model.Children, part)
+	if errs := (&Root{Instances: []*Instance{model}}).Validate(); errs != nil {
+		t.Errorf("unexpected errors for valid tree: %v", errs)
+	}
+
+	tests := []struct {
+		name  string
+		root  func() *Root
+		path  string
+		cause error
+	}{
+		{"cycle", func() *Root {
+			model, part := named("Model", "Model"), named("Part", "Part")
+			model.Children = []*Instance{part}
+			part.Children = []*Instance{model}
+			return &Root{Instances: []*Instance{model}}
+		}, "Model.Part.Model", ErrCyclicParent},
+		{"shared", func() *Root {
+			model, part := named("Model", "Model"), NewInstance("Part")
+			model.Children = []*Instance{part}
+			return &Root{Instances: []*Instance{model, part}}
+		}, "Part", ErrSharedInstance},
+		{"reference", func() *Root {
+			a, b := named("Part", "A"), named("Part", "B")
+			a.Reference, b.Reference = "RBX1", "RBX1"
+			return &Root{Instances: []*Instance{a, b}}
+		}, "B", ErrDuplicateReference},
+		{"nil child", func() *Root {
+			model := named("Model", "Model")
+			model.Children = []*Instance{nil}
+			return &Root{Instances: []*Instance{model}}
+		}, "Model", ErrNilInstance},
+		{"nil root instance", func() *Root {
+			return &Root{Instances: []*Instance{nil}}
+		}, "", ErrNilInstance},
+		{"nil value", func() *Root {
+			model, part := named("Model", "Model"), named("Part", "Part")
+			part.Properties["Size"] = nil
+			model.Children = []*Instance{part}
+			return &Root{Instances: []*Instance{model}}
+		}, "Model.Part", ErrNilValue},
+	}
+	for _, test := range tests {
+		errs := test.root().Validate()
+		if len(errs) != 1 {
+			t.Errorf("%s: expected 1 error, got %v", test.name, errs)
+			continue
+		}
+		var verr ValidationError
+		if !errors.As(errs[0], &verr) || !errors.Is(errs[0], test.cause) || verr.Path != test.path {
+			t.Errorf("%s: unexpected error %v", test.name, errs[0])
+		}
+	}
+}
@@ -2,6 +2,7 @@ package rbxfile
 
 import (
 	"encoding/binary"
+	"fmt"
 	"strconv"
 	"strings"
 )
@@ -54,6 +55,8 @@ const (
 	TypeOptional
 	TypeUniqueId
 	TypeFont
+	TypeSecurityCapabilities
+	TypeContentObject
 )
 
 // TypeFromString returns a Type from its string representation. TypeInvalid
@@ -68,39 +71,41 @@ func TypeFromString(s string) Type {
 }
 
 var typeStrings = map[Type]string{
-	TypeString:             "String",
-	TypeBinaryString:       "BinaryString",
-	TypeProtectedString:    "ProtectedString",
-	TypeContent:            "Content",
-	TypeBool:               "Bool",
-	TypeInt:                "Int",
-	TypeFloat:              "Float",
-	TypeDouble:             "Double",
-	TypeUDim:               "UDim",
-	TypeUDim2:              "UDim2",
-	TypeRay:                "Ray",
-	TypeFaces:              "Faces",
-	TypeAxes:               "Axes",
-	TypeBrickColor:         "BrickColor",
-	TypeColor3:             "Color3",
-	TypeVector2:            "Vector2",
-	TypeVector3:            "Vector3",
-	TypeCFrame:             "CFrame",
-	TypeToken:              "Token",
-	TypeReference:          "Reference",
-	TypeVector3int16:       "Vector3int16",
-	TypeVector2int16:       "Vector2int16",
-	TypeNumberSequence:     "NumberSequence",
-	TypeColorSequence:      "ColorSequence",
-	TypeNumberRange:        "NumberRange",
-	TypeRect:               "Rect",
-	TypePhysicalProperties: "PhysicalProperties",
-	TypeColor3uint8:        "Color3uint8",
-	TypeInt64:              "Int64",
-	TypeSharedString:       "SharedString",
-	TypeOptional:           "Optional",
-	TypeUniqueId:           "UniqueId",
-	TypeFont:               "Font",
+	TypeString:               "String",
+	TypeBinaryString:         "BinaryString",
+	TypeProtectedString:      "ProtectedString",
+	TypeContent:              "Content",
+	TypeBool:                 "Bool",
+	TypeInt:                  "Int",
+	TypeFloat:                "Float",
+	TypeDouble:               "Double",
+	TypeUDim:                 "UDim",
+	TypeUDim2:                "UDim2",
+	TypeRay:                  "Ray",
+	TypeFaces:                "Faces",
+	TypeAxes:                 "Axes",
+	TypeBrickColor:           "BrickColor",
+	TypeColor3:               "Color3",
+	TypeVector2:              "Vector2",
+	TypeVector3:              "Vector3",
+	TypeCFrame:               "CFrame",
+	TypeToken:                "Token",
+	TypeReference:            "Reference",
+	TypeVector3int16:         "Vector3int16",
+	TypeVector2int16:         "Vector2int16",
+	TypeNumberSequence:       "NumberSequence",
+	TypeColorSequence:        "ColorSequence",
+	TypeNumberRange:          "NumberRange",
+	TypeRect:                 "Rect",
+	TypePhysicalProperties:   "PhysicalProperties",
+	TypeColor3uint8:          "Color3uint8",
+	TypeInt64:                "Int64",
+	TypeSharedString:         "SharedString",
+	TypeOptional:             "Optional",
+	TypeUniqueId:             "UniqueId",
+	TypeFont:                 "Font",
+	TypeSecurityCapabilities: "SecurityCapabilities",
+	TypeContentObject:        "ContentObject",
 }
 
 // Value holds a value of a particular Type.
@@ -129,39 +134,41 @@ func NewValue(typ Type) Value {
 type valueGenerator func() Value
 
 var valueGenerators = map[Type]valueGenerator{
-	TypeString:             newValueString,
-	TypeBinaryString:       newValueBinaryString,
-	TypeProtectedString:    newValueProtectedString,
-	TypeContent:            newValueContent,
-	TypeBool:               newValueBool,
-	TypeInt:                newValueInt,
-	TypeFloat:              newValueFloat,
-	TypeDouble:             newValueDouble,
-	TypeUDim:               newValueUDim,
-	TypeUDim2:              newValueUDim2,
-	TypeRay:                newValueRay,
-	TypeFaces:              newValueFaces,
-	TypeAxes:               newValueAxes,
-	TypeBrickColor:         newValueBrickColor,
-	TypeColor3:             newValueColor3,
-	TypeVector2:            newValueVector2,
-	TypeVector3:            newValueVector3,
-	TypeCFrame:             newValueCFrame,
-	TypeToken:              newValueToken,
-	TypeReference:          newValueReference,
-	TypeVector3int16:       newValueVector3int16,
-	TypeVector2int16:       newValueVector2int16,
-	TypeNumberSequence:     newValueNumberSequence,
-	TypeColorSequence:      newValueColorSequence,
-	TypeNumberRange:        newValueNumberRange,
-	TypeRect:               newValueRect,
-	TypePhysicalProperties: newValuePhysicalProperties,
-	TypeColor3uint8:        newValueColor3uint8,
-	TypeInt64:              newValueInt64,
-	TypeSharedString:       newValueSharedString,
-	TypeOptional:           newValueOptional,
-	TypeUniqueId:           newValueUniqueId,
-	TypeFont:               newValueFont,
+	TypeString:               newValueString,
+	TypeBinaryString:         newValueBinaryString,
+	TypeProtectedString:      newValueProtectedString,
+	TypeContent:              newValueContent,
+	TypeBool:                 newValueBool,
+	TypeInt:                  newValueInt,
+	TypeFloat:                newValueFloat,
+	TypeDouble:               newValueDouble,
+	TypeUDim:                 newValueUDim,
+	TypeUDim2:                newValueUDim2,
+	TypeRay:                  newValueRay,
+	TypeFaces:                newValueFaces,
+	TypeAxes:                 newValueAxes,
+	TypeBrickColor:           newValueBrickColor,
+	TypeColor3:               newValueColor3,
+	TypeVector2:              newValueVector2,
+	TypeVector3:              newValueVector3,
+	TypeCFrame:               newValueCFrame,
+	TypeToken:                newValueToken,
+	TypeReference:            newValueReference,
+	TypeVector3int16:         newValueVector3int16,
+	TypeVector2int16:         newValueVector2int16,
+	TypeNumberSequence:       newValueNumberSequence,
+	TypeColorSequence:        newValueColorSequence,
+	TypeNumberRange:          newValueNumberRange,
+	TypeRect:                 newValueRect,
+	TypePhysicalProperties:   newValuePhysicalProperties,
+	TypeColor3uint8:          newValueColor3uint8,
+	TypeInt64:                newValueInt64,
+	TypeSharedString:         newValueSharedString,
+	TypeOptional:             newValueOptional,
+	TypeUniqueId:             newValueUniqueId,
+	TypeFont:                 newValueFont,
+	TypeSecurityCapabilities: newValueSecurityCapabilities,
+	TypeContentObject:        newValueContentObject,
 }
 
 func joinstr(a ...string) string {
@@ -715,6 +722,9 @@ func (t ValueNumberSequenceKeypoint) String() string {
 	)
 }
 
+// ValueNumberSequence is a sequence of number keypoints. Codecs treat a nil
+// sequence the same as an empty sequence; an empty sequence is encoded with a
+// length of zero, and is always decoded as a non-nil, empty slice.
 type ValueNumberSequence []ValueNumberSequenceKeypoint
 
 func newValueNumberSequence() Value {
@@ -738,6 +748,14 @@ func (t ValueNumberSequence) Copy() Value {
 	return c
 }
 
+// Validate returns an error if the sequence is not valid according to Roblox.
+// A valid sequence has at least two keypoints, the first having a Time of 0,
+// the last having a Time of 1, and each having a Time greater than or equal to
+// the previous. The first violation is reported.
+func (t ValueNumberSequence) Validate() error {
+	return validateSequence(len(t), func(i int) float32 { return t[i].Time })
+}
+
 ////////////////
 
 type ValueColorSequenceKeypoint struct {
@@ -760,6 +778,9 @@ func (t ValueColorSequenceKeypoint) String() string {
 	)
 }
 
+// ValueColorSequence is a sequence of color keypoints. Codecs treat a nil
+// sequence the same as an empty sequence; an empty sequence is encoded with a
+// length of zero, and is always decoded as a non-nil, empty slice.
 type ValueColorSequence []ValueColorSequenceKeypoint
 
 func newValueColorSequence() Value {
@@ -783,6 +804,33 @@ func (t ValueColorSequence) Copy() Value {
 	return c
 }
 
+// Validate returns an error if the sequence is not valid according to Roblox.
+// A valid sequence has at least two keypoints, the first having a Time of 0,
+// the last having a Time of 1, and each having a Time greater than or equal to
+// the previous. The first violation is reported.
+func (t ValueColorSequence) Validate() error {
+	return validateSequence(len(t), func(i int) float32 { return t[i].Time })
+}
+
+// validateSequence validates the times of a sequence of n keypoints.
+func validateSequence(n int, time func(i int) float32) error {
+	if n < 2 {
+		return fmt.Errorf("sequence has %d keypoints, expected at least 2", n)
+	}
+	if t := time(0); t != 0 {
+		return fmt.Errorf("first keypoint has time %g, expected 0", t)
+	}
+	for i := 1; i < n; i++ {
+		if time(i) < time(i-1) {
+			return fmt.Errorf("keypoint %d has time %g, which is less than previous time %g", i, time(i), time(i-1))
+		}
+	}
+	if t := time(n - 1); t != 1 {
+		return fmt.Errorf("last keypoint has time %g, expected 1", t)
+	}
+	return nil
+}
+
 ////////////////
 
 type ValueNumberRange struct {
@@ -809,14 +857,45 @@ func (t ValueNumberRange) Copy() Value {
 
 ////////////////
 
+// ValueRect is a rectangle bounded by two corners.
+//
+// The type was originally named Rect2D, and was renamed to Rect. The rbxlx
+// format continues to use "Rect2D" as the tag name, and both names are
+// accepted when decoding. Elsewhere, including the binary format, the type is
+// named Rect.
 type ValueRect struct {
 	Min, Max ValueVector2
 }
 
+// NewRect returns a Rect with the given corners.
+func NewRect(minX, minY, maxX, maxY float32) ValueRect {
+	return ValueRect{
+		Min: ValueVector2{X: minX, Y: minY},
+		Max: ValueVector2{X: maxX, Y: maxY},
+	}
+}
+
 func newValueRect() Value {
 	return *new(ValueRect)
 }
 
+// Width returns the distance between the X components of Min and Max. The
+// result is negative if Max.X is less than Min.X.
+func (t ValueRect) Width() float32 {
+	return t.Max.X - t.Min.X
+}
+
+// Height returns the distance between the Y components of Min and Max. The
+// result is negative if Max.Y is less than Min.Y.
+func (t ValueRect) Height() float32 {
+	return t.Max.Y - t.Min.Y
+}
+
+// Area returns the product of the Width and Height.
+func (t ValueRect) Area() float32 {
+	return t.Width() * t.Height()
+}
+
 func (ValueRect) Type() Type {
 	return TypeRect
 }
@@ -1186,3 +1265,125 @@ func (t ValueFont) Copy() Value {
 		CachedFaceId: t.Family.Copy().(ValueContent),
 	}
 }
+
+////////////////
+
+// SecurityCapability is an item of the SecurityCapability enum.
+type SecurityCapability uint
+
+const (
+	SecurityCapabilityRunClientScript SecurityCapability = iota
+	SecurityCapabilityRunServerScript
+	SecurityCapabilityAccessOutsideWrite
+	SecurityCapabilityAssetRequire
+	SecurityCapabilityLoadString
+	SecurityCapabilityScriptGlobals
+	SecurityCapabilityCreateInstances
+	SecurityCapabilityBasic
+	SecurityCapabilityAudio
+	SecurityCapabilityDataStore
+	SecurityCapabilityNetwork
+	SecurityCapabilityPhysics
+	SecurityCapabilityUI
+	SecurityCapabilityCSG
+	SecurityCapabilityChat
+	SecurityCapabilityAnimation
+	SecurityCapabilityAvatar
+)
+
+var securityCapabilityStrings = [...]string{
+	SecurityCapabilityRunClientScript:    "RunClientScript",
+	SecurityCapabilityRunServerScript:    "RunServerScript",
+	SecurityCapabilityAccessOutsideWrite: "AccessOutsideWrite",
+	SecurityCapabilityAssetRequire:       "AssetRequire",
+	SecurityCapabilityLoadString:         "LoadString",
+	SecurityCapabilityScriptGlobals:      "ScriptGlobals",
+	SecurityCapabilityCreateInstances:    "CreateInstances",
+	SecurityCapabilityBasic:              "Basic",
+	SecurityCapabilityAudio:              "Audio",
+	SecurityCapabilityDataStore:          "DataStore",
+	SecurityCapabilityNetwork:            "Network",
+	SecurityCapabilityPhysics:            "Physics",
+	SecurityCapabilityUI:                 "UI",
+	SecurityCapabilityCSG:                "CSG",
+	SecurityCapabilityChat:               "Chat",
+	SecurityCapabilityAnimation:          "Animation",
+	SecurityCapabilityAvatar:             "Avatar",
+}
+
+func (c SecurityCapability) String() string {
+	if c < SecurityCapability(len(securityCapabilityStrings)) {
+		return securityCapabilityStrings[c]
+	}
+	return "<invalid>"
+}
+
+// ValueSecurityCapabilities is a set of security capabilities, where each bit
+// corresponds to an item of the SecurityCapability enum. Bit n is set if the
+// capability whose enum value is n is enabled.
+type ValueSecurityCapabilities uint64
+
+func newValueSecurityCapabilities() Value {
+	return *new(ValueSecurityCapabilities)
+}
+
+func (ValueSecurityCapabilities) Type() Type {
+	return TypeSecurityCapabilities
+}
+
+func (t ValueSecurityCapabilities) String() string {
+	return strconv.FormatUint(uint64(t), 10)
+}
+
+func (t ValueSecurityCapabilities) Copy() Value {
+	return t
+}
+
+// Has returns whether the given capability is enabled.
+func (t ValueSecurityCapabilities) Has(capability SecurityCapability) bool {
+	return capability < 64 && t&(1<<capability) != 0
+}
+
+// With returns t with the given capability enabled.
+func (t ValueSecurityCapabilities) With(capability SecurityCapability) ValueSecurityCapabilities {
+	if capability >= 64 {
+		return t
+	}
+	return t | 1<<capability
+}
+
+// Without returns t with the given capability disabled.
+func (t ValueSecurityCapabilities) Without(capability SecurityCapability) ValueSecurityCapabilities {
+	if capability >= 64 {
+		return t
+	}
+	return t &^ (1 << capability)
+}
+
+////////////////
+
+// ValueContentObject is a Content value that refers to an instance rather than
+// to an asset, as created by Content.fromObject. Like ValueReference, the
+// referent is resolved through a PropRef when decoded.
+type ValueContentObject struct {
+	*Instance
+}
+
+func newValueContentObject() Value {
+	return *new(ValueContentObject)
+}
+
+func (ValueContentObject) Type() Type {
+	return TypeContentObject
+}
+
+func (t ValueContentObject) String() string {
+	if t.Instance == nil {
+		return "<nil>"
+	}
+	return t.Reference
+}
+
+func (t ValueContentObject) Copy() Value {
+	return t
+}